@@ -0,0 +1,444 @@
+package main
+
+import (
+	"flowa/pkg/ast"
+	"flowa/pkg/eval"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Coverage is recorded with eval.Hooks while tests run. Every file the
+// tests import is registered when it is parsed (test files themselves
+// are left out), and statements and branches are counted by source
+// position: each test imports its files afresh, so the same statement
+// arrives as a different AST node every time.
+
+type coverPos struct{ line, col int }
+
+// coverSite is a conditional and how often each of its ways was taken.
+type coverSite struct {
+	kind   string // "if", "elif", "while", "for" or "match"
+	counts []int
+}
+
+type fileCoverage struct {
+	path       string
+	source     []string
+	statements map[coverPos]int
+	branches   map[coverPos]*coverSite
+}
+
+type coverRef struct {
+	file *fileCoverage
+	pos  coverPos
+}
+
+type coverage struct {
+	mu         sync.Mutex
+	files      map[string]*fileCoverage
+	statements map[ast.Statement]coverRef
+	branches   map[ast.Node]coverRef
+}
+
+func newCoverage() *coverage {
+	return &coverage{
+		files:      map[string]*fileCoverage{},
+		statements: map[ast.Statement]coverRef{},
+		branches:   map[ast.Node]coverRef{},
+	}
+}
+
+func (c *coverage) hooks() *eval.Hooks {
+	return &eval.Hooks{Statement: c.statement, Branch: c.branch, Load: c.load}
+}
+
+func (c *coverage) load(path string, program *ast.Program) {
+	path = filepath.Clean(path)
+	if strings.HasSuffix(filepath.Base(path), "_test.flowa") {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file := c.files[path]
+	if file == nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		file = &fileCoverage{
+			path:       path,
+			source:     strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"),
+			statements: map[coverPos]int{},
+			branches:   map[coverPos]*coverSite{},
+		}
+		c.files[path] = file
+	}
+
+	position := func(node ast.Node) coverPos {
+		tok := nodeToken(node)
+		return coverPos{tok.Line, tok.Column}
+	}
+	addStatements := func(stmts []ast.Statement) {
+		for _, stmt := range stmts {
+			pos := position(stmt)
+			c.statements[stmt] = coverRef{file, pos}
+			if _, ok := file.statements[pos]; !ok {
+				file.statements[pos] = 0
+			}
+		}
+	}
+	addBranch := func(node ast.Node, kind string, ways int) {
+		pos := position(node)
+		c.branches[node] = coverRef{file, pos}
+		if _, ok := file.branches[pos]; !ok {
+			file.branches[pos] = &coverSite{kind: kind, counts: make([]int, ways)}
+		}
+	}
+
+	addStatements(program.Statements)
+	inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStatement:
+			addStatements(n.Statements)
+		case *ast.IfExpression:
+			addBranch(n, n.Token.Literal, 2)
+		case *ast.WhileStatement:
+			addBranch(n, "while", 2)
+		case *ast.ForStatement:
+			addBranch(n, "for", 2)
+		case *ast.MatchStatement:
+			addBranch(n, "match", len(n.Cases)+1)
+		}
+		return true
+	})
+}
+
+func (c *coverage) statement(frame *eval.Frame, stmt ast.Statement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ref, ok := c.statements[stmt]; ok {
+		ref.file.statements[ref.pos]++
+	}
+}
+
+func (c *coverage) branch(frame *eval.Frame, node ast.Node, branch int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ref, ok := c.branches[node]; ok {
+		if site := ref.file.branches[ref.pos]; branch < len(site.counts) {
+			site.counts[branch]++
+		}
+	}
+}
+
+// coverageSummary is the coverage of one file, or of all of them when
+// File is empty.
+type coverageSummary struct {
+	File              string `json:"file,omitempty"`
+	Statements        int    `json:"statements"`
+	StatementsCovered int    `json:"statements_covered"`
+	Branches          int    `json:"branches"`
+	BranchesCovered   int    `json:"branches_covered"`
+}
+
+func (s coverageSummary) statementPercent() float64 {
+	return percent(s.StatementsCovered, s.Statements)
+}
+
+func (s coverageSummary) branchPercent() float64 {
+	return percent(s.BranchesCovered, s.Branches)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func (f *fileCoverage) summary() coverageSummary {
+	s := coverageSummary{File: f.path}
+	for _, count := range f.statements {
+		s.Statements++
+		if count > 0 {
+			s.StatementsCovered++
+		}
+	}
+	for _, site := range f.branches {
+		for _, count := range site.counts {
+			s.Branches++
+			if count > 0 {
+				s.BranchesCovered++
+			}
+		}
+	}
+	return s
+}
+
+// sortedFiles returns the covered files by path.
+func (c *coverage) sortedFiles() []*fileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := make([]*fileCoverage, 0, len(c.files))
+	for _, f := range c.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files
+}
+
+// summaries returns one summary per file followed by the total.
+func (c *coverage) summaries() []coverageSummary {
+	files := c.sortedFiles()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []coverageSummary
+	var total coverageSummary
+	for _, f := range files {
+		s := f.summary()
+		out = append(out, s)
+		total.Statements += s.Statements
+		total.StatementsCovered += s.StatementsCovered
+		total.Branches += s.Branches
+		total.BranchesCovered += s.BranchesCovered
+	}
+	return append(out, total)
+}
+
+func (c *coverage) writeSummary(w io.Writer) {
+	summaries := c.summaries()
+	if len(summaries) == 1 {
+		fmt.Fprintln(w, "coverage: no files were imported by the tests")
+		return
+	}
+	for _, s := range summaries {
+		name := s.File
+		if name == "" {
+			name = "total"
+		}
+		fmt.Fprintf(w, "coverage: %-40s %5.1f%% of statements (%d/%d), %5.1f%% of branches (%d/%d)\n",
+			name, s.statementPercent(), s.StatementsCovered, s.Statements, s.branchPercent(), s.BranchesCovered, s.Branches)
+	}
+}
+
+// lineCounts returns, for every line a statement starts on, how often
+// the most frequently run of them ran.
+func (f *fileCoverage) lineCounts() map[int]int {
+	lines := map[int]int{}
+	for pos, count := range f.statements {
+		if prev, ok := lines[pos.line]; !ok || count > prev {
+			lines[pos.line] = count
+		}
+	}
+	return lines
+}
+
+// sortedBranches returns the branch positions of f in source order.
+func (f *fileCoverage) sortedBranches() []coverPos {
+	positions := make([]coverPos, 0, len(f.branches))
+	for pos := range f.branches {
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].line != positions[j].line {
+			return positions[i].line < positions[j].line
+		}
+		return positions[i].col < positions[j].col
+	})
+	return positions
+}
+
+// writeLCOV writes the coverage as an LCOV tracefile, as read by
+// genhtml, Codecov and most CI coverage tools.
+func (c *coverage) writeLCOV(w io.Writer) error {
+	files := c.sortedFiles()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "TN:\nSF:%s\n", f.path)
+
+		found, hit := 0, 0
+		for i, pos := range f.sortedBranches() {
+			site := f.branches[pos]
+			reached := false
+			for _, count := range site.counts {
+				reached = reached || count > 0
+			}
+			for way, count := range site.counts {
+				taken := "-"
+				if reached {
+					taken = fmt.Sprint(count)
+				}
+				fmt.Fprintf(&b, "BRDA:%d,%d,%d,%s\n", pos.line, i, way, taken)
+				found++
+				if count > 0 {
+					hit++
+				}
+			}
+		}
+		fmt.Fprintf(&b, "BRF:%d\nBRH:%d\n", found, hit)
+
+		counts := f.lineCounts()
+		lines := make([]int, 0, len(counts))
+		for line := range counts {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		hit = 0
+		for _, line := range lines {
+			fmt.Fprintf(&b, "DA:%d,%d\n", line, counts[line])
+			if counts[line] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeCoverageReports writes the HTML and LCOV files opts asks for.
+func writeCoverageReports(c *coverage, opts testOptions) error {
+	write := func(path string, fn func(io.Writer) error) error {
+		if path == "" {
+			return nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if err := write(opts.coverHTML, c.writeHTML); err != nil {
+		return err
+	}
+	return write(opts.lcov, c.writeLCOV)
+}
+
+type htmlCoverLine struct {
+	Number int
+	Count  string
+	Class  string // "covered", "uncovered", "partial" or ""
+	Note   string // untaken branches
+	Source string
+}
+
+type htmlCoverFile struct {
+	ID      string
+	Summary coverageSummary
+	Lines   []htmlCoverLine
+}
+
+// writeHTML writes a single page with the summary and every covered file,
+// each source line marked as run, not run or only partly branched.
+func (c *coverage) writeHTML(w io.Writer) error {
+	var files []htmlCoverFile
+	for i, f := range c.sortedFiles() {
+		c.mu.Lock()
+		page := htmlCoverFile{ID: fmt.Sprintf("file%d", i), Summary: f.summary()}
+		counts := f.lineCounts()
+		notes := map[int][]string{}
+		for _, pos := range f.sortedBranches() {
+			if note := untakenBranches(f.branches[pos]); note != "" {
+				notes[pos.line] = append(notes[pos.line], note)
+			}
+		}
+		for n, src := range f.source {
+			line := htmlCoverLine{Number: n + 1, Source: src}
+			if count, ok := counts[n+1]; ok {
+				line.Count = fmt.Sprintf("%d×", count)
+				switch {
+				case count == 0:
+					line.Class = "uncovered"
+				case len(notes[n+1]) > 0:
+					line.Class = "partial"
+				default:
+					line.Class = "covered"
+				}
+			} else if len(notes[n+1]) > 0 {
+				line.Class = "partial" // an elif
+			}
+			line.Note = strings.Join(notes[n+1], "; ")
+			page.Lines = append(page.Lines, line)
+		}
+		c.mu.Unlock()
+		files = append(files, page)
+	}
+	summaries := c.summaries()
+	return coverageTemplate.Execute(w, map[string]interface{}{
+		"Files": files,
+		"Total": summaries[len(summaries)-1],
+	})
+}
+
+// untakenBranches describes the ways of a conditional that never ran.
+func untakenBranches(site *coverSite) string {
+	var names []string
+	for way, count := range site.counts {
+		if count > 0 {
+			continue
+		}
+		switch {
+		case site.kind == "match" && way == len(site.counts)-1:
+			names = append(names, "no case matched")
+		case site.kind == "match":
+			names = append(names, fmt.Sprintf("case %d", way+1))
+		case site.kind == "while" || site.kind == "for":
+			names = append(names, []string{"body", "loop exit"}[way])
+		default:
+			names = append(names, []string{"true", "false"}[way])
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return site.kind + " never took: " + strings.Join(names, ", ")
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"pct": func(n, total int) string { return fmt.Sprintf("%.1f%%", percent(n, total)) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flowa coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table.summary td, table.summary th { padding: 2px 12px; text-align: left; }
+table.source { border-collapse: collapse; font-family: monospace; font-size: 13px; margin-bottom: 3em; }
+table.source td { padding: 0 8px; white-space: pre; vertical-align: top; }
+td.num, td.count { color: #888; text-align: right; }
+tr.covered td.src { background: #dfd; }
+tr.uncovered td.src { background: #fdd; }
+tr.partial td.src { background: #ffd; }
+td.note { color: #a60; font-family: sans-serif; }
+</style>
+</head>
+<body>
+<h1>Flowa coverage</h1>
+<table class="summary">
+<tr><th>File</th><th>Statements</th><th>Branches</th></tr>
+{{range .Files}}<tr><td><a href="#{{.ID}}">{{.Summary.File}}</a></td><td>{{pct .Summary.StatementsCovered .Summary.Statements}} ({{.Summary.StatementsCovered}}/{{.Summary.Statements}})</td><td>{{pct .Summary.BranchesCovered .Summary.Branches}} ({{.Summary.BranchesCovered}}/{{.Summary.Branches}})</td></tr>
+{{end}}<tr><th>Total</th><th>{{pct .Total.StatementsCovered .Total.Statements}}</th><th>{{pct .Total.BranchesCovered .Total.Branches}}</th></tr>
+</table>
+{{range .Files}}
+<h2 id="{{.ID}}">{{.Summary.File}}</h2>
+<table class="source">
+{{range .Lines}}<tr class="{{.Class}}"><td class="num">{{.Number}}</td><td class="count">{{.Count}}</td><td class="src">{{.Source}}</td><td class="note">{{.Note}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
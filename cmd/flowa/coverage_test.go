@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flowa/pkg/eval"
+	"os"
+	"strings"
+	"testing"
+)
+
+const coverLibSource = `def sign(x):
+    if x < 0:
+        return -1
+    elif x == 0:
+        return 0
+    return 1
+
+def total(xs):
+    t = 0
+    for x in xs:
+        t = t + x
+    return t
+
+def never():
+    return 42
+`
+
+const coverTestSource = `from "mathlib.flowa" import sign, total
+
+def test_sign():
+    assert sign(-3) == -1
+    assert sign(5) == 1
+
+def test_total():
+    assert total([1, 2]) == 3
+`
+
+func runCovered(t *testing.T) *coverage {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	for name, src := range map[string]string{"mathlib.flowa": coverLibSource, "math_test.flowa": coverTestSource} {
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	program, errs, err := parseProgramFromFile("math_test.flowa")
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("parsing: %v %v", err, errs)
+	}
+
+	cov := newCoverage()
+	eval.SetHooks(cov.hooks())
+	results := executeTests(discoverTests("math_test.flowa", program, nil), 2, func(TestResult) {})
+	eval.SetHooks(nil)
+	for _, r := range results {
+		if !r.Passed {
+			t.Fatalf("%s failed: %s", r.Name, r.Message)
+		}
+	}
+	return cov
+}
+
+func TestCoverageSummary(t *testing.T) {
+	cov := runCovered(t)
+	summaries := cov.summaries()
+	if len(summaries) != 2 {
+		t.Fatalf("expected mathlib.flowa and a total, got %+v", summaries)
+	}
+	expected := coverageSummary{File: "mathlib.flowa", Statements: 12, StatementsCovered: 10, Branches: 6, BranchesCovered: 5}
+	if summaries[0] != expected {
+		t.Errorf("unexpected summary %+v", summaries[0])
+	}
+
+	var out bytes.Buffer
+	cov.writeSummary(&out)
+	if !strings.Contains(out.String(), "total") || !strings.Contains(out.String(), "83.3% of statements (10/12)") {
+		t.Errorf("unexpected summary output:\n%s", out.String())
+	}
+}
+
+func TestCoverageReports(t *testing.T) {
+	cov := runCovered(t)
+
+	var lcov bytes.Buffer
+	if err := cov.writeLCOV(&lcov); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"SF:mathlib.flowa\n",
+		"BRDA:4,1,0,0\n", // the elif was never true
+		"BRDA:10,2,0,2\n",
+		"DA:5,0\n",
+		"DA:11,2\n",
+		"LF:12\nLH:10\nend_of_record\n",
+	} {
+		if !strings.Contains(lcov.String(), want) {
+			t.Errorf("LCOV is missing %q:\n%s", want, lcov.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := cov.writeHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<tr class="uncovered"><td class="num">15</td>`,
+		`<tr class="partial"><td class="num">4</td>`,
+		"elif never took: true",
+		`<tr class="covered"><td class="num">11</td><td class="count">2×</td>`,
+	} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("HTML is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flowa/pkg/eval"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// runDAP speaks the Debug Adapter Protocol over stdin and stdout so
+// editors can drive the debugger. The program's output is sent to the
+// editor as output events.
+func runDAP() {
+	out := os.Stdout
+	// Anything the interpreter prints directly must not corrupt the
+	// protocol stream
+	os.Stdout = os.Stderr
+	if err := newDAPServer(os.Stdin, out).serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+type dapMessage struct {
+	Seq     int    `json:"seq"`
+	Type    string `json:"type"`
+	Command string `json:"command,omitempty"`
+	Event   string `json:"event,omitempty"`
+
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	RequestSeq int         `json:"request_seq,omitempty"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// The single thread reported to the editor. Whichever goroutine hits a
+// breakpoint is shown as this thread.
+const dapThreadID = 1
+
+type (
+	dapSource struct {
+		Name string `json:"name,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+	dapBreakpoint struct {
+		ID       int    `json:"id,omitempty"`
+		Verified bool   `json:"verified"`
+		Line     int    `json:"line,omitempty"`
+		Message  string `json:"message,omitempty"`
+	}
+	dapStackFrame struct {
+		ID     int        `json:"id"`
+		Name   string     `json:"name"`
+		Source *dapSource `json:"source,omitempty"`
+		Line   int        `json:"line"`
+		Column int        `json:"column"`
+	}
+	dapScope struct {
+		Name               string `json:"name"`
+		VariablesReference int    `json:"variablesReference"`
+		Expensive          bool   `json:"expensive"`
+	}
+	dapVariable struct {
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+		Type               string `json:"type,omitempty"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+)
+
+var dapStepModes = map[string]stepMode{"continue": stepContinue, "next": stepOver, "stepIn": stepIn, "stepOut": stepOut}
+
+type dapServer struct {
+	in    *bufio.Reader
+	out   io.Writer
+	outMu sync.Mutex
+	seq   int
+
+	d       *debugger
+	started bool
+	resume  chan stepMode
+
+	// State of the current stop; variable references are only valid
+	// until the program resumes
+	mu     sync.Mutex
+	stop   *debugStop
+	frames []*eval.Frame
+	refs   []interface{} // *eval.Environment or a container value
+}
+
+func newDAPServer(in io.Reader, out io.Writer) *dapServer {
+	return &dapServer{in: bufio.NewReader(in), out: out, resume: make(chan stepMode)}
+}
+
+// serve handles requests until the editor disconnects or closes the
+// stream.
+func (s *dapServer) serve() error {
+	for {
+		body, err := readFrame(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req dapMessage
+		if err := json.Unmarshal(body, &req); err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		result, err := s.handle(&req)
+		resp := dapMessage{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: err == nil, Body: result}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		if err := s.send(resp); err != nil {
+			return err
+		}
+
+		switch req.Command {
+		case "launch":
+			if err == nil {
+				s.event("initialized", nil)
+			}
+		case "configurationDone":
+			s.start()
+		case "continue", "next", "stepIn", "stepOut":
+			// Resume only after answering, so a following stopped
+			// event can't overtake the response
+			if err == nil {
+				s.resume <- dapStepModes[req.Command]
+			}
+		case "disconnect", "terminate":
+			return nil
+		}
+	}
+}
+
+func (s *dapServer) send(msg dapMessage) error {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	return writeFrame(s.out, msg)
+}
+
+func (s *dapServer) event(name string, body interface{}) {
+	s.send(dapMessage{Type: "event", Event: name, Body: body})
+}
+
+// dapOutput turns the program's output into output events.
+type dapOutput struct{ s *dapServer }
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]string{"category": "stdout", "output": string(p)})
+	return len(p), nil
+}
+
+func (s *dapServer) handle(req *dapMessage) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsSetVariable":              true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if s.d != nil {
+			return nil, errors.New("a program is already running")
+		}
+		d, parserErrors, err := newDebugger(args.Program)
+		if err != nil {
+			return nil, err
+		}
+		if len(parserErrors) > 0 {
+			return nil, fmt.Errorf("%s: %s", args.Program, parserErrors[0])
+		}
+		d.entry = args.StopOnEntry
+		if !args.StopOnEntry {
+			d.mode = stepContinue
+		}
+		d.env.SetOutput(dapOutput{s})
+		d.onStop = s.stopped
+		s.d = d
+		return nil, nil
+
+	case "setBreakpoints":
+		var args struct {
+			Source      dapSource `json:"source"`
+			Breakpoints []struct {
+				Line      int    `json:"line"`
+				Condition string `json:"condition"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if s.d == nil {
+			return nil, errors.New("no program launched")
+		}
+		result := make([]dapBreakpoint, len(args.Breakpoints))
+		sameFile := samePath(args.Source.Path, s.d.file)
+		if sameFile {
+			s.d.clearBreakpoints()
+		}
+		for i, b := range args.Breakpoints {
+			result[i] = dapBreakpoint{Line: b.Line}
+			if !sameFile {
+				result[i].Message = "breakpoints are only supported in the launched program"
+				continue
+			}
+			bp, err := s.d.setBreakpoint(b.Line, b.Condition)
+			if err != nil {
+				result[i].Message = err.Error()
+				continue
+			}
+			result[i].ID, result[i].Verified = bp.ID, true
+		}
+		return map[string]interface{}{"breakpoints": result}, nil
+
+	case "configurationDone":
+		if s.d == nil {
+			return nil, errors.New("no program launched")
+		}
+		return nil, nil
+
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThreadID, "name": "main"}},
+		}, nil
+
+	case "stackTrace":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		frames := []dapStackFrame{}
+		for i, f := range s.frames {
+			line, inFile := s.d.frameLine(f)
+			frame := dapStackFrame{ID: i + 1, Name: f.Name, Line: line, Column: 1}
+			if inFile {
+				abs, _ := filepath.Abs(s.d.file)
+				frame.Source = &dapSource{Name: filepath.Base(s.d.file), Path: abs}
+				frame.Column = nodeToken(f.Statement()).Column
+			}
+			frames = append(frames, frame)
+		}
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		frame, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		scopes := []dapScope{}
+		for _, scope := range s.d.scopes(frame) {
+			scopes = append(scopes, dapScope{Name: scope.Name, VariablesReference: s.ref(scope.Env)})
+		}
+		return map[string]interface{}{"scopes": scopes}, nil
+
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.refs) {
+			return nil, errors.New("unknown variables reference")
+		}
+		vars := []dapVariable{}
+		switch ref := s.refs[args.VariablesReference-1].(type) {
+		case *eval.Environment:
+			for _, v := range s.d.variables(ref) {
+				vars = append(vars, s.variable(v.Name, v.Value))
+			}
+		case eval.Object:
+			for _, v := range children(ref) {
+				vars = append(vars, s.variable(v.Name, v.Value))
+			}
+		}
+		return map[string]interface{}{"variables": vars}, nil
+
+	case "setVariable":
+		var args struct {
+			VariablesReference int    `json:"variablesReference"`
+			Name               string `json:"name"`
+			Value              string `json:"value"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var env *eval.Environment
+		if args.VariablesReference >= 1 && args.VariablesReference <= len(s.refs) {
+			env, _ = s.refs[args.VariablesReference-1].(*eval.Environment)
+		}
+		if env == nil {
+			return nil, errors.New("only variables in a scope can be changed")
+		}
+		val, err := s.d.setVariable(env, args.Name, args.Value)
+		if err != nil {
+			return nil, err
+		}
+		v := s.variable(args.Name, val)
+		return map[string]interface{}{"value": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			FrameID    int    `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		frame, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		val, err := s.d.evaluate(args.Expression, frame.Env)
+		if err != nil {
+			return nil, err
+		}
+		v := s.variable("", val)
+		return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+
+	case "continue", "next", "stepIn", "stepOut":
+		s.mu.Lock()
+		stopped := s.stop != nil
+		s.mu.Unlock()
+		if !stopped {
+			return nil, errors.New("the program is not stopped")
+		}
+		if req.Command == "continue" {
+			return map[string]bool{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+
+	case "pause":
+		if s.d == nil {
+			return nil, errors.New("no program launched")
+		}
+		s.d.requestPause()
+		return nil, nil
+
+	case "disconnect", "terminate":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+// start runs the program once the editor has sent its configuration.
+func (s *dapServer) start() {
+	if s.d == nil || s.started {
+		return
+	}
+	s.started = true
+	go func() {
+		code := 0
+		if errObj, ok := s.d.run().(*eval.ErrorObj); ok {
+			s.event("output", map[string]string{"category": "stderr", "output": errObj.Message + "\n"})
+			code = 1
+		}
+		s.event("exited", map[string]int{"exitCode": code})
+		s.event("terminated", nil)
+	}()
+}
+
+// stopped runs on the program's goroutine and waits for the editor to
+// resume it.
+func (s *dapServer) stopped(stop *debugStop) stepMode {
+	s.mu.Lock()
+	s.stop, s.frames, s.refs = stop, stack(stop.Frame), nil
+	s.mu.Unlock()
+
+	body := map[string]interface{}{"reason": stop.Reason, "threadId": dapThreadID, "allThreadsStopped": true}
+	if stop.Message != "" {
+		body["text"] = stop.Message
+	}
+	s.event("stopped", body)
+	mode := <-s.resume
+
+	s.mu.Lock()
+	s.stop, s.frames, s.refs = nil, nil, nil
+	s.mu.Unlock()
+	return mode
+}
+
+func (s *dapServer) frame(id int) (*eval.Frame, error) {
+	if s.stop == nil {
+		return nil, errors.New("the program is not stopped")
+	}
+	if id == 0 {
+		id = 1
+	}
+	if id < 1 || id > len(s.frames) {
+		return nil, fmt.Errorf("unknown frame %d", id)
+	}
+	return s.frames[id-1], nil
+}
+
+// ref hands out a variables reference for a scope or container value.
+func (s *dapServer) ref(target interface{}) int {
+	s.refs = append(s.refs, target)
+	return len(s.refs)
+}
+
+func (s *dapServer) variable(name string, val eval.Object) dapVariable {
+	v := dapVariable{Name: name, Value: val.Inspect(), Type: val.Type()}
+	if len(children(val)) > 0 {
+		v.VariablesReference = s.ref(val)
+	}
+	return v
+}
+
+// children lists the elements or fields of a container value.
+func children(val eval.Object) []debugVar {
+	var vars []debugVar
+	switch val := val.(type) {
+	case *eval.Array:
+		for i, el := range val.Elements {
+			vars = append(vars, debugVar{fmt.Sprintf("[%d]", i), el})
+		}
+	case *eval.Tuple:
+		for i, el := range val.Elements {
+			vars = append(vars, debugVar{fmt.Sprintf("[%d]", i), el})
+		}
+	case *eval.Map:
+		for key, el := range val.Pairs {
+			vars = append(vars, debugVar{key.Inspect(), el})
+		}
+		sortVars(vars)
+	case *eval.StructInstance:
+		for name, el := range val.Fields {
+			vars = append(vars, debugVar{name, el})
+		}
+		sortVars(vars)
+	}
+	return vars
+}
+
+func sortVars(vars []debugVar) {
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flowa/pkg/ast"
+	"flowa/pkg/eval"
+	"flowa/pkg/lexer"
+	"flowa/pkg/parser"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/term"
+)
+
+// The debugger runs a program with eval.Hooks installed and stops before
+// statements of the debugged file. Front ends decide what happens at a
+// stop: the terminal UI below reads commands, the debug adapter in
+// dap.go answers an editor. Only one goroutine is stopped at a time;
+// others wait at their next statement until it resumes.
+
+type stepMode int
+
+const (
+	stepContinue stepMode = iota // run to the next breakpoint
+	stepIn                       // stop at the next statement anywhere
+	stepOver                     // stop at the next statement of this frame or a caller
+	stepOut                      // stop once this frame has returned
+)
+
+type breakpoint struct {
+	ID        int
+	Line      int
+	Condition string
+	Hits      int
+
+	cond *ast.Program
+}
+
+// debugStop describes where and why the program stopped.
+type debugStop struct {
+	Frame   *eval.Frame
+	Line    int
+	Reason  string // "entry", "breakpoint", "step" or "pause"
+	Message string // e.g. a failed breakpoint condition
+}
+
+type debugger struct {
+	file       string
+	lines      []string
+	program    *ast.Program
+	env        *eval.Environment
+	statements map[ast.Statement]bool // statements of the debugged file
+	stmtLines  map[int]bool
+	builtins   map[string]bool
+
+	mu          sync.Mutex
+	breakpoints map[int]*breakpoint // by line
+	nextID      int
+	mode        stepMode
+	from        *eval.Frame // where the current step started
+	entry       bool
+	pause       bool
+
+	stopMu     sync.Mutex // held while a goroutine is stopped
+	evaluating atomic.Bool
+
+	// onStop is called on the stopped goroutine and returns how to go on
+	onStop func(stop *debugStop) stepMode
+}
+
+func newDebugger(file string) (*debugger, []string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := parser.New(lexer.New(string(data)))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	d := &debugger{
+		file:        file,
+		lines:       strings.Split(string(data), "\n"),
+		program:     program,
+		env:         eval.NewEnvironment(),
+		statements:  map[ast.Statement]bool{},
+		stmtLines:   map[int]bool{},
+		builtins:    map[string]bool{},
+		breakpoints: map[int]*breakpoint{},
+		mode:        stepIn,
+		entry:       true,
+	}
+	add := func(stmts []ast.Statement) {
+		for _, stmt := range stmts {
+			d.statements[stmt] = true
+			d.stmtLines[nodeToken(stmt).Line] = true
+		}
+	}
+	add(program.Statements)
+	inspect(program, func(n ast.Node) bool {
+		if block, ok := n.(*ast.BlockStatement); ok {
+			add(block.Statements)
+		}
+		return true
+	})
+	for _, name := range d.env.Names() {
+		d.builtins[name] = true
+	}
+	return d, nil, nil
+}
+
+// run evaluates the program to the end.
+func (d *debugger) run() eval.Object {
+	eval.SetHooks(&eval.Hooks{Statement: d.statement})
+	defer eval.SetHooks(nil)
+	return eval.Eval(d.program, d.env)
+}
+
+func (d *debugger) statement(frame *eval.Frame, stmt ast.Statement) {
+	if d.evaluating.Load() || !d.statements[stmt] {
+		return
+	}
+	d.stopMu.Lock()
+	defer d.stopMu.Unlock()
+
+	stop := d.shouldStop(frame, nodeToken(stmt).Line)
+	if stop == nil {
+		return
+	}
+	mode := d.onStop(stop)
+
+	d.mu.Lock()
+	d.mode, d.from = mode, frame
+	d.mu.Unlock()
+}
+
+func (d *debugger) shouldStop(frame *eval.Frame, line int) *debugStop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stop := &debugStop{Frame: frame, Line: line}
+	switch {
+	case d.entry:
+		d.entry = false
+		stop.Reason = "entry"
+		return stop
+	case d.pause:
+		d.pause = false
+		stop.Reason = "pause"
+		return stop
+	case d.mode == stepIn,
+		d.mode == stepOver && (frame == d.from || calledFrom(d.from, frame)),
+		d.mode == stepOut && calledFrom(d.from, frame):
+		stop.Reason = "step"
+		return stop
+	}
+
+	bp := d.breakpoints[line]
+	if bp == nil {
+		return nil
+	}
+	if bp.cond != nil {
+		ok, err := d.conditionHolds(bp, frame.Env)
+		if err != nil {
+			stop.Message = fmt.Sprintf("breakpoint condition %q failed: %v", bp.Condition, err)
+		} else if !ok {
+			return nil
+		}
+	}
+	bp.Hits++
+	stop.Reason = "breakpoint"
+	return stop
+}
+
+// calledFrom reports whether caller is on frame's call stack.
+func calledFrom(frame, caller *eval.Frame) bool {
+	for f := frame; f != nil; f = f.Caller {
+		if f.Caller == caller {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *debugger) conditionHolds(bp *breakpoint, env *eval.Environment) (bool, error) {
+	result, err := d.evalProgram(bp.cond, env)
+	if err != nil {
+		return false, err
+	}
+	return result != nil && result != eval.NULL && result != eval.FALSE, nil
+}
+
+// setBreakpoint adds or replaces the breakpoint on line.
+func (d *debugger) setBreakpoint(line int, condition string) (*breakpoint, error) {
+	if !d.stmtLines[line] {
+		return nil, fmt.Errorf("no statement on line %d", line)
+	}
+	bp := &breakpoint{Line: line, Condition: condition}
+	if condition != "" {
+		p := parser.New(lexer.New(condition))
+		bp.cond = p.ParseProgram()
+		if errs := p.Errors(); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid condition: %s", errs[0])
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	bp.ID = d.nextID
+	d.breakpoints[line] = bp
+	return bp, nil
+}
+
+func (d *debugger) clearBreakpoint(line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.breakpoints[line]
+	delete(d.breakpoints, line)
+	return ok
+}
+
+func (d *debugger) clearBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = map[int]*breakpoint{}
+}
+
+func (d *debugger) breakpointList() []*breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]*breakpoint, 0, len(d.breakpoints))
+	for _, bp := range d.breakpoints {
+		list = append(list, bp)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Line < list[j].Line })
+	return list
+}
+
+// requestPause stops the program at the next statement it runs.
+func (d *debugger) requestPause() {
+	d.mu.Lock()
+	d.pause = true
+	d.mu.Unlock()
+}
+
+// evaluate runs src in env, as if it were a line of the program, without
+// stopping at breakpoints.
+func (d *debugger) evaluate(src string, env *eval.Environment) (eval.Object, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, errors.New(errs[0])
+	}
+	return d.evalProgram(program, env)
+}
+
+// evalProgram runs the statements one by one rather than evaluating the
+// program, which would record them as the frame's current statement.
+func (d *debugger) evalProgram(program *ast.Program, env *eval.Environment) (eval.Object, error) {
+	d.evaluating.Store(true)
+	defer d.evaluating.Store(false)
+	var result eval.Object = eval.NULL
+	for _, stmt := range program.Statements {
+		result = eval.Eval(stmt, env)
+		if rv, ok := result.(*eval.ReturnValue); ok {
+			result = rv.Value
+		}
+		if errObj, ok := result.(*eval.ErrorObj); ok {
+			return nil, errors.New(errObj.Message)
+		}
+	}
+	if result == nil {
+		result = eval.NULL
+	}
+	return result, nil
+}
+
+// setVariable evaluates src in env and assigns it to the variable name
+// where it is defined.
+func (d *debugger) setVariable(env *eval.Environment, name, src string) (eval.Object, error) {
+	if _, ok := env.Get(name); !ok {
+		return nil, fmt.Errorf("no variable %s in scope", name)
+	}
+	val, err := d.evaluate(src, env)
+	if err != nil {
+		return nil, err
+	}
+	env.Assign(name, val)
+	return val, nil
+}
+
+type debugScope struct {
+	Name string
+	Env  *eval.Environment
+}
+
+// scopes lists the environments visible from frame, innermost first.
+func (d *debugger) scopes(frame *eval.Frame) []debugScope {
+	var scopes []debugScope
+	for env := frame.Env; env != nil; env = env.Outer() {
+		name := "Enclosing"
+		switch {
+		case env.Outer() == nil:
+			name = "Globals"
+		case env == frame.Env:
+			name = "Locals"
+		}
+		scopes = append(scopes, debugScope{name, env})
+	}
+	return scopes
+}
+
+type debugVar struct {
+	Name  string
+	Value eval.Object
+}
+
+// variables lists the bindings made directly in env, leaving out the
+// builtins every program starts with.
+func (d *debugger) variables(env *eval.Environment) []debugVar {
+	var vars []debugVar
+	for _, name := range env.Names() {
+		if strings.HasPrefix(name, "__") || env.Outer() == nil && d.builtins[name] {
+			continue
+		}
+		if val, ok := env.Get(name); ok {
+			vars = append(vars, debugVar{name, val})
+		}
+	}
+	return vars
+}
+
+// stack returns frame and its callers, innermost first.
+func stack(frame *eval.Frame) []*eval.Frame {
+	var frames []*eval.Frame
+	for f := frame; f != nil; f = f.Caller {
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// frameLine returns the line frame is at and whether it is in the
+// debugged file; imported code has lines of its own.
+func (d *debugger) frameLine(frame *eval.Frame) (int, bool) {
+	stmt := frame.Statement()
+	if stmt == nil {
+		return 0, false
+	}
+	return nodeToken(stmt).Line, d.statements[stmt]
+}
+
+func (d *debugger) sourceLine(line int) string {
+	if line < 1 || line > len(d.lines) {
+		return ""
+	}
+	return strings.TrimRight(d.lines[line-1], "\r")
+}
+
+func runDebug(args []string) {
+	if len(args) == 1 && args[0] == "--dap" {
+		runDAP()
+		return
+	}
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Usage: flowa debug <file.flowa> | flowa debug --dap")
+		os.Exit(2)
+	}
+
+	d, parserErrors, err := newDebugger(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+	if len(parserErrors) != 0 {
+		printParserErrors(os.Stderr, parserErrors)
+		os.Exit(1)
+	}
+
+	ui := &debugTUI{d: d, out: os.Stdout, exit: os.Exit}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, debugPrompt)
+		ui.in, ui.out = &rawTerminal{fd: fd, t: t}, t
+	} else {
+		ui.in = &scanReader{scanner: bufio.NewScanner(os.Stdin), out: os.Stdout, prompt: debugPrompt}
+	}
+	fmt.Fprintf(os.Stdout, "Debugging %s. Type help for commands.\n", args[0])
+	os.Exit(ui.run())
+}
+
+const debugPrompt = "(debug) "
+
+// rawTerminal puts the terminal in raw mode only while reading
+// commands, so the program's own output is printed normally.
+type rawTerminal struct {
+	fd    int
+	t     *term.Terminal
+	state *term.State
+}
+
+func (r *rawTerminal) SetPrompt(prompt string) { r.t.SetPrompt(prompt) }
+
+func (r *rawTerminal) ReadLine() (string, error) {
+	if r.state == nil {
+		state, err := term.MakeRaw(r.fd)
+		if err != nil {
+			return "", err
+		}
+		r.state = state
+	}
+	return r.t.ReadLine()
+}
+
+func (r *rawTerminal) release() {
+	if r.state != nil {
+		term.Restore(r.fd, r.state)
+		r.state = nil
+	}
+}
+
+// debugTUI is the terminal front end of the debugger.
+type debugTUI struct {
+	d    *debugger
+	in   lineReader
+	out  io.Writer
+	exit func(code int)
+
+	stop     *debugStop
+	frames   []*eval.Frame
+	selected int
+	last     string
+}
+
+var debugCommands = []struct{ name, args, help string }{
+	{"continue, c", "", "run to the next breakpoint"},
+	{"next, n", "", "step over calls to the next line"},
+	{"step, s", "", "step into calls"},
+	{"out, o", "", "run until the current function returns"},
+	{"break, b", "[LINE [if COND]]", "set a breakpoint, or list them"},
+	{"clear", "LINE", "remove the breakpoint on LINE"},
+	{"list, l", "[LINE]", "show the source around the current or given line"},
+	{"where, bt", "", "show the call stack"},
+	{"up, down", "", "select the calling or called frame"},
+	{"vars", "", "show the variables of the selected frame"},
+	{"print, p", "EXPR", "evaluate EXPR in the selected frame"},
+	{"set", "NAME = EXPR", "change a variable"},
+	{"quit, q", "", "stop debugging"},
+}
+
+// run debugs the program and returns the exit code.
+func (ui *debugTUI) run() int {
+	ui.d.env.SetOutput(ui.out)
+	ui.d.onStop = ui.stopped
+	result := ui.d.run()
+	if errObj, ok := result.(*eval.ErrorObj); ok {
+		fmt.Fprintf(ui.out, "Program failed: %s\n", errObj.Message)
+		return 1
+	}
+	fmt.Fprintln(ui.out, "Program finished.")
+	return 0
+}
+
+func (ui *debugTUI) stopped(stop *debugStop) stepMode {
+	ui.stop, ui.frames, ui.selected = stop, stack(stop.Frame), 0
+	if raw, ok := ui.in.(*rawTerminal); ok {
+		defer raw.release()
+	}
+	if stop.Message != "" {
+		fmt.Fprintln(ui.out, stop.Message)
+	}
+	if stop.Reason == "breakpoint" {
+		fmt.Fprintf(ui.out, "Breakpoint at %s:%d\n", ui.d.file, stop.Line)
+	}
+	ui.printLocation()
+
+	for {
+		line, err := ui.in.ReadLine()
+		if err != nil {
+			ui.exit(0)
+			return stepContinue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = ui.last
+		}
+		ui.last = line
+		if mode, resume := ui.command(line); resume {
+			return mode
+		}
+	}
+}
+
+// command runs one command and reports whether to resume the program.
+func (ui *debugTUI) command(line string) (stepMode, bool) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	frame := ui.frames[ui.selected]
+
+	switch name {
+	case "":
+	case "c", "continue":
+		return stepContinue, true
+	case "n", "next":
+		return stepOver, true
+	case "s", "step":
+		return stepIn, true
+	case "o", "out", "finish":
+		return stepOut, true
+	case "b", "break":
+		ui.breakCommand(arg)
+	case "clear":
+		line, err := strconv.Atoi(arg)
+		if err != nil || !ui.d.clearBreakpoint(line) {
+			fmt.Fprintf(ui.out, "No breakpoint on line %s\n", arg)
+		}
+	case "l", "list":
+		line, _ := ui.d.frameLine(frame)
+		if arg != "" {
+			line, _ = strconv.Atoi(arg)
+		}
+		ui.list(line)
+	case "bt", "where", "stack":
+		for i, f := range ui.frames {
+			marker := " "
+			if i == ui.selected {
+				marker = "*"
+			}
+			fmt.Fprintf(ui.out, "%s #%d %s\n", marker, i, ui.describeFrame(f))
+		}
+	case "up":
+		if ui.selected+1 < len(ui.frames) {
+			ui.selected++
+		}
+		ui.printLocation()
+	case "down":
+		if ui.selected > 0 {
+			ui.selected--
+		}
+		ui.printLocation()
+	case "vars", "locals":
+		for _, scope := range ui.d.scopes(frame) {
+			vars := ui.d.variables(scope.Env)
+			if len(vars) == 0 {
+				continue
+			}
+			fmt.Fprintf(ui.out, "%s:\n", scope.Name)
+			for _, v := range vars {
+				fmt.Fprintf(ui.out, "  %s = %s\n", v.Name, summarize(v.Value))
+			}
+		}
+	case "p", "print":
+		val, err := ui.d.evaluate(arg, frame.Env)
+		if err != nil {
+			fmt.Fprintf(ui.out, "Error: %v\n", err)
+			break
+		}
+		fmt.Fprintln(ui.out, val.Inspect())
+	case "set":
+		target, src, ok := strings.Cut(arg, "=")
+		if !ok {
+			fmt.Fprintln(ui.out, "Usage: set NAME = EXPR")
+			break
+		}
+		val, err := ui.d.setVariable(frame.Env, strings.TrimSpace(target), strings.TrimSpace(src))
+		if err != nil {
+			fmt.Fprintf(ui.out, "Error: %v\n", err)
+			break
+		}
+		fmt.Fprintf(ui.out, "%s = %s\n", strings.TrimSpace(target), val.Inspect())
+	case "q", "quit":
+		ui.exit(0)
+	case "h", "help":
+		for _, c := range debugCommands {
+			fmt.Fprintf(ui.out, "  %-12s %-18s %s\n", c.name, c.args, c.help)
+		}
+	default:
+		fmt.Fprintf(ui.out, "Unknown command %s. Type help for a list.\n", name)
+	}
+	return stepContinue, false
+}
+
+func (ui *debugTUI) breakCommand(arg string) {
+	if arg == "" {
+		list := ui.d.breakpointList()
+		if len(list) == 0 {
+			fmt.Fprintln(ui.out, "No breakpoints.")
+		}
+		for _, bp := range list {
+			fmt.Fprintf(ui.out, "  line %d", bp.Line)
+			if bp.Condition != "" {
+				fmt.Fprintf(ui.out, " if %s", bp.Condition)
+			}
+			fmt.Fprintf(ui.out, " (hit %d times)\n", bp.Hits)
+		}
+		return
+	}
+	spec, condition, _ := strings.Cut(arg, " if ")
+	line, err := strconv.Atoi(strings.TrimSpace(spec))
+	if err != nil {
+		fmt.Fprintln(ui.out, "Usage: break LINE [if COND]")
+		return
+	}
+	if _, err := ui.d.setBreakpoint(line, strings.TrimSpace(condition)); err != nil {
+		fmt.Fprintf(ui.out, "Error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(ui.out, "Breakpoint on line %d\n", line)
+}
+
+func (ui *debugTUI) describeFrame(f *eval.Frame) string {
+	line, inFile := ui.d.frameLine(f)
+	if !inFile {
+		return fmt.Sprintf("%s (imported code, line %d)", f.Name, line)
+	}
+	return fmt.Sprintf("%s at %s:%d", f.Name, ui.d.file, line)
+}
+
+// printLocation shows the selected frame's current line.
+func (ui *debugTUI) printLocation() {
+	f := ui.frames[ui.selected]
+	line, inFile := ui.d.frameLine(f)
+	if !inFile {
+		fmt.Fprintf(ui.out, "> %s\n", ui.describeFrame(f))
+		return
+	}
+	fmt.Fprintf(ui.out, "> %s:%d in %s\n", ui.d.file, line, f.Name)
+	fmt.Fprintf(ui.out, "%5d  %s\n", line, ui.d.sourceLine(line))
+}
+
+// list prints the lines around line, marking the current line and
+// breakpoints.
+func (ui *debugTUI) list(line int) {
+	current, _ := ui.d.frameLine(ui.frames[ui.selected])
+	breakpoints := map[int]bool{}
+	for _, bp := range ui.d.breakpointList() {
+		breakpoints[bp.Line] = true
+	}
+	for n := max(1, line-5); n <= min(len(ui.d.lines), line+5); n++ {
+		marker := "  "
+		switch {
+		case n == current:
+			marker = "->"
+		case breakpoints[n]:
+			marker = "B "
+		}
+		fmt.Fprintf(ui.out, "%s %4d  %s\n", marker, n, ui.d.sourceLine(n))
+	}
+}
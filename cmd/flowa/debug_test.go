@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const debugSource = `def total(items):
+    sum = 0
+    for item in items:
+        sum = sum + item
+    return sum
+
+def report(xs):
+    t = total(xs)
+    print("total", t)
+    return t
+
+nums = [1, 2, 3]
+report(nums)
+print("done")
+`
+
+func writeDebugSource(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.flowa")
+	if err := os.WriteFile(path, []byte(debugSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDebuggerTUI(t *testing.T) {
+	path := writeDebugSource(t)
+	d, errs, err := newDebugger(path)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("newDebugger: %v %v", err, errs)
+	}
+
+	commands := []string{
+		"b 6",              // no statement there
+		"b 4 if item == 2", // conditional
+		"c",
+		"bt",
+		"set sum = 100",
+		"vars",
+		"up",
+		"p xs",
+		"out",
+		"s",
+		"p t",
+		"c",
+	}
+	var out bytes.Buffer
+	ui := &debugTUI{
+		d:    d,
+		in:   &scanReader{scanner: bufio.NewScanner(strings.NewReader(strings.Join(commands, "\n") + "\n")), out: io.Discard},
+		out:  &out,
+		exit: func(int) { t.Error("unexpected exit") },
+	}
+	if code := ui.run(); code != 0 {
+		t.Fatalf("exit code %d\n%s", code, out.String())
+	}
+
+	expected := []string{
+		"> " + path + ":1 in <module>",
+		"Error: no statement on line 6",
+		"Breakpoint on line 4",
+		"Breakpoint at " + path + ":4",
+		"    4          sum = sum + item",
+		"* #0 total at " + path + ":4\n  #1 report at " + path + ":8\n  #2 <module> at " + path + ":13",
+		"sum = 100",
+		"Locals:\n  item = 2\n  items = [1, 2, 3]\n  sum = 100\nGlobals:\n  nums = [1, 2, 3]",
+		"> " + path + ":8 in report",
+		"[1, 2, 3]",
+		"> " + path + ":9 in report",
+		// print is not a Flowa function, so step moves on to the next line
+		"total 105\n> " + path + ":10 in report",
+		"105",
+		"done\nProgram finished.",
+	}
+	got := out.String()
+	for _, want := range expected {
+		i := strings.Index(got, want)
+		if i < 0 {
+			t.Fatalf("expected %q in output:\n%s", want, out.String())
+		}
+		got = got[i+len(want):]
+	}
+}
+
+// dapClient drives an in-process debug adapter through a pair of pipes.
+type dapClient struct {
+	t      *testing.T
+	w      io.Writer
+	msgs   chan dapMessage
+	seq    int
+	events []dapMessage
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &dapClient{t: t, w: clientOut, msgs: make(chan dapMessage, 100)}
+	go func() {
+		newDAPServer(serverIn, serverOut).serve()
+		serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			body, err := readFrame(r)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			var msg dapMessage
+			json.Unmarshal(body, &msg)
+			c.msgs <- msg
+		}
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+	return c
+}
+
+func (c *dapClient) next() dapMessage {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("adapter closed the stream")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the adapter")
+	}
+	return dapMessage{}
+}
+
+// request sends a request and decodes the body of its response into
+// result, keeping events that arrive first.
+func (c *dapClient) request(command string, args, result interface{}) dapMessage {
+	c.t.Helper()
+	c.seq++
+	raw, _ := json.Marshal(args)
+	writeFrame(c.w, dapMessage{Seq: c.seq, Type: "request", Command: command, Arguments: raw})
+	for {
+		msg := c.next()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("response to %d, expected %d", msg.RequestSeq, c.seq)
+		}
+		if result != nil {
+			data, _ := json.Marshal(msg.Body)
+			if err := json.Unmarshal(data, result); err != nil {
+				c.t.Fatalf("decoding %s: %v", data, err)
+			}
+		}
+		return msg
+	}
+}
+
+// event waits for the named event, returning its body.
+func (c *dapClient) event(name string) map[string]interface{} {
+	c.t.Helper()
+	for {
+		var msg dapMessage
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Type == "event" && msg.Event == name {
+			body, _ := msg.Body.(map[string]interface{})
+			return body
+		}
+	}
+}
+
+func TestDAPSession(t *testing.T) {
+	path := writeDebugSource(t)
+	c := newDAPClient(t)
+
+	var caps map[string]bool
+	c.request("initialize", map[string]string{"adapterID": "flowa"}, &caps)
+	if !caps["supportsConditionalBreakpoints"] || !caps["supportsSetVariable"] {
+		t.Fatalf("unexpected capabilities %v", caps)
+	}
+	if resp := c.request("launch", map[string]string{"program": path + ".missing"}, nil); resp.Success {
+		t.Fatal("expected launching a missing file to fail")
+	}
+	c.request("launch", map[string]interface{}{"program": path}, nil)
+	c.event("initialized")
+
+	var bps struct {
+		Breakpoints []dapBreakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 4, "condition": "item == 2"}, {"line": 6}},
+	}, &bps)
+	if len(bps.Breakpoints) != 2 || !bps.Breakpoints[0].Verified || bps.Breakpoints[1].Verified {
+		t.Fatalf("unexpected breakpoints %+v", bps.Breakpoints)
+	}
+	c.request("configurationDone", nil, nil)
+
+	if stopped := c.event("stopped"); stopped["reason"] != "breakpoint" {
+		t.Fatalf("unexpected stop %v", stopped)
+	}
+
+	var trace struct {
+		StackFrames []dapStackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string]int{"threadId": dapThreadID}, &trace)
+	var names []string
+	for _, f := range trace.StackFrames {
+		names = append(names, fmt.Sprintf("%s:%d", f.Name, f.Line))
+	}
+	if strings.Join(names, " ") != "total:4 report:8 <module>:13" || trace.StackFrames[0].Column != 9 {
+		t.Fatalf("unexpected stack %+v", trace.StackFrames)
+	}
+
+	var scopes struct {
+		Scopes []dapScope `json:"scopes"`
+	}
+	c.request("scopes", map[string]int{"frameId": 1}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" {
+		t.Fatalf("unexpected scopes %+v", scopes.Scopes)
+	}
+	var vars struct {
+		Variables []dapVariable `json:"variables"`
+	}
+	c.request("variables", map[string]int{"variablesReference": scopes.Scopes[0].VariablesReference}, &vars)
+	if len(vars.Variables) != 3 || vars.Variables[1].Name != "items" || vars.Variables[1].VariablesReference == 0 {
+		t.Fatalf("unexpected locals %+v", vars.Variables)
+	}
+	c.request("variables", map[string]int{"variablesReference": vars.Variables[1].VariablesReference}, &vars)
+	if len(vars.Variables) != 3 || vars.Variables[2].Name != "[2]" || vars.Variables[2].Value != "3" {
+		t.Fatalf("unexpected elements %+v", vars.Variables)
+	}
+
+	c.request("setVariable", map[string]interface{}{"variablesReference": scopes.Scopes[0].VariablesReference, "name": "sum", "value": "100"}, nil)
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", map[string]interface{}{"expression": "sum + item", "frameId": 1}, &result)
+	if result.Result != "102" {
+		t.Errorf("expected 102, got %s", result.Result)
+	}
+	if resp := c.request("evaluate", map[string]interface{}{"expression": "missing", "frameId": 1}, nil); resp.Success {
+		t.Error("expected evaluating an unknown name to fail")
+	}
+
+	c.request("stepOut", map[string]int{"threadId": dapThreadID}, nil)
+	if stopped := c.event("stopped"); stopped["reason"] != "step" {
+		t.Fatalf("unexpected stop %v", stopped)
+	}
+	c.request("stackTrace", map[string]int{"threadId": dapThreadID}, &trace)
+	if trace.StackFrames[0].Name != "report" || trace.StackFrames[0].Line != 9 {
+		t.Fatalf("stepOut stopped at %+v", trace.StackFrames[0])
+	}
+
+	c.request("continue", map[string]int{"threadId": dapThreadID}, nil)
+	var output strings.Builder
+	for {
+		var msg dapMessage
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Event == "output" {
+			output.WriteString(msg.Body.(map[string]interface{})["output"].(string))
+		}
+		if msg.Event == "exited" {
+			if code := msg.Body.(map[string]interface{})["exitCode"]; code != 0.0 {
+				t.Errorf("unexpected exit code %v", code)
+			}
+			break
+		}
+	}
+	if output.String() != "total 105\ndone\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+	c.request("disconnect", nil, nil)
+}
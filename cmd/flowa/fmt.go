@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flowa/pkg/format"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runFmt implements `flowa fmt [--check] [--diff] [paths...]`. Files are
+// rewritten in place unless --check or --diff is given; --check exits 1
+// when any file isn't formatted, which is what CI wants.
+func runFmt(args []string) {
+	check, showDiff := false, false
+	var paths []string
+	for _, arg := range args {
+		switch arg {
+		case "--check":
+			check = true
+		case "--diff":
+			showDiff = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "Unknown flag for fmt: %s\n", arg)
+				fmt.Fprintln(os.Stderr, "Usage: flowa fmt [--check] [--diff] [files or directories...]")
+				os.Exit(2)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := flowaFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	unformatted, failed := 0, false
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			failed = true
+			continue
+		}
+		src := string(data)
+		out, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n", file)
+			printParserErrors(os.Stderr, strings.Split(err.Error(), "\n"))
+			failed = true
+			continue
+		}
+		if out == src {
+			continue
+		}
+
+		unformatted++
+		switch {
+		case showDiff:
+			fmt.Print(unifiedDiff(file, src, out))
+		case check:
+			fmt.Println(file)
+		default:
+			if err := os.WriteFile(file, []byte(out), 0o644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("formatted %s\n", file)
+		}
+	}
+
+	if failed {
+		os.Exit(2)
+	}
+	if check && unformatted > 0 {
+		fmt.Fprintf(os.Stderr, "%d file(s) need formatting; run 'flowa fmt'\n", unformatted)
+		os.Exit(1)
+	}
+}
+
+// flowaFiles expands directories into the .flowa files below them.
+func flowaFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && p != path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && strings.HasSuffix(p, ".flowa") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// unifiedDiff renders the changes from a to b in unified diff format
+// with three lines of context.
+func unifiedDiff(name, a, b string) string {
+	const context = 3
+	x := strings.SplitAfter(a, "\n")
+	y := strings.SplitAfter(b, "\n")
+	if x[len(x)-1] == "" {
+		x = x[:len(x)-1]
+	}
+	if y[len(y)-1] == "" {
+		y = y[:len(y)-1]
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte // ' ', '-' or '+'
+		text string
+		i, j int // line indexes in x and y before this edit
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s (formatted)\n", name, name)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// Grow the hunk while changes are within 2*context lines
+		start := max(k-context, 0)
+		end := k
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].op == ' ' && next-end < 2*context {
+				next++
+			}
+			if next < len(edits) && edits[next].op != ' ' {
+				end = next
+				continue
+			}
+			break
+		}
+		stop := min(end+context, len(edits))
+
+		oldLines, newLines := 0, 0
+		for _, e := range edits[start:stop] {
+			if e.op != '+' {
+				oldLines++
+			}
+			if e.op != '-' {
+				newLines++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", edits[start].i+1, oldLines, edits[start].j+1, newLines)
+		for _, e := range edits[start:stop] {
+			out.WriteByte(e.op)
+			out.WriteString(e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = stop
+	}
+	return out.String()
+}
@@ -12,9 +12,10 @@ type ProgramInsights struct {
 }
 
 type FunctionInfo struct {
-	Name       string
-	Parameters []string
-	IsAsync    bool
+	Name        string
+	Parameters  []string
+	IsAsync     bool
+	IsGenerator bool
 }
 
 type PipelineInfo struct {
@@ -31,9 +32,10 @@ func analyzeProgram(program *ast.Program) ProgramInsights {
 				params = append(params, p.Value)
 			}
 			insights.Functions = append(insights.Functions, FunctionInfo{
-				Name:       n.Name.String(),
-				Parameters: params,
-				IsAsync:    n.IsAsync,
+				Name:        n.Name.String(),
+				Parameters:  params,
+				IsAsync:     n.IsAsync,
+				IsGenerator: n.IsGenerator,
 			})
 		case *ast.PipelineExpression:
 			if _, nested := n.Left.(*ast.PipelineExpression); nested {
@@ -47,53 +49,194 @@ func analyzeProgram(program *ast.Program) ProgramInsights {
 	return insights
 }
 
+// walk calls visitor for node and everything below it.
 func walk(node ast.Node, visitor func(ast.Node)) {
-	if node == nil {
+	inspect(node, func(n ast.Node) bool {
+		visitor(n)
+		return true
+	})
+}
+
+// inspect traverses the tree in source order, calling f for each node;
+// children are skipped when f returns false. Identifiers are only
+// visited where they are read, never where they are bound, so a visitor
+// collecting *ast.Identifier sees the uses of each name.
+func inspect(node ast.Node, f func(ast.Node) bool) {
+	if node == nil || !f(node) {
 		return
 	}
 
-	visitor(node)
-
 	switch n := node.(type) {
 	case *ast.Program:
 		for _, stmt := range n.Statements {
-			walk(stmt, visitor)
+			inspect(stmt, f)
 		}
 	case *ast.BlockStatement:
 		for _, stmt := range n.Statements {
-			walk(stmt, visitor)
+			inspect(stmt, f)
 		}
 	case *ast.ExpressionStatement:
-		walk(n.Expression, visitor)
+		inspect(n.Expression, f)
 	case *ast.ReturnStatement:
-		walk(n.ReturnValue, visitor)
+		inspect(n.ReturnValue, f)
 	case *ast.FunctionStatement:
-		walk(n.Body, visitor)
+		for _, p := range n.ParameterPatterns {
+			inspect(p, f)
+		}
+		inspect(n.Body, f)
 	case *ast.AssignmentStatement:
-		walk(n.Value, visitor)
+		inspect(n.Value, f)
+	case *ast.DestructureStatement:
+		inspect(n.Target, f)
+		inspect(n.Value, f)
+	case *ast.WhileStatement:
+		inspect(n.Condition, f)
+		inspect(n.Body, f)
+	case *ast.ForStatement:
+		if n.Target != nil {
+			inspect(n.Target, f)
+		}
+		inspect(n.Value, f)
+		inspect(n.Body, f)
+	case *ast.ModuleStatement:
+		inspect(n.Body, f)
+	case *ast.ServiceStatement:
+		inspect(n.Body, f)
+	case *ast.ScheduleStatement:
+		inspect(n.Spec, f)
+		inspect(n.Body, f)
+	case *ast.RouteStatement:
+		inspect(n.Handler, f)
+	case *ast.MiddlewareStatement:
+		inspect(n.Middleware, f)
+	case *ast.DeferStatement:
+		inspect(n.Call, f)
+	case *ast.AssertStatement:
+		inspect(n.Condition, f)
+		inspect(n.Message, f)
 	case *ast.PrefixExpression:
-		walk(n.Right, visitor)
+		inspect(n.Right, f)
 	case *ast.InfixExpression:
-		walk(n.Left, visitor)
-		walk(n.Right, visitor)
+		inspect(n.Left, f)
+		inspect(n.Right, f)
 	case *ast.CallExpression:
-		walk(n.Function, visitor)
+		inspect(n.Function, f)
 		for _, arg := range n.Arguments {
-			walk(arg, visitor)
+			inspect(arg, f)
 		}
 	case *ast.PipelineExpression:
-		walk(n.Left, visitor)
-		walk(n.Right, visitor)
+		inspect(n.Left, f)
+		inspect(n.Right, f)
 	case *ast.IfExpression:
-		walk(n.Condition, visitor)
-		walk(n.Consequence, visitor)
+		inspect(n.Condition, f)
+		inspect(n.Consequence, f)
 		if n.Alternative != nil {
-			walk(n.Alternative, visitor)
+			inspect(n.Alternative, f)
 		}
 	case *ast.SpawnExpression:
-		walk(n.Call, visitor)
+		inspect(n.Call, f)
 	case *ast.AwaitExpression:
-		walk(n.Value, visitor)
+		inspect(n.Value, f)
+		if n.Timeout != nil {
+			inspect(n.Timeout, f)
+		}
+	case *ast.YieldExpression:
+		if n.Value != nil {
+			inspect(n.Value, f)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range n.Elements {
+			inspect(el, f)
+		}
+	case *ast.TupleLiteral:
+		for _, el := range n.Elements {
+			inspect(el, f)
+		}
+	case *ast.SetLiteral:
+		for _, el := range n.Elements {
+			inspect(el, f)
+		}
+	case *ast.MapLiteral:
+		for _, pair := range n.Pairs {
+			inspect(pair.Key, f)
+			inspect(pair.Value, f)
+		}
+	case *ast.SpreadExpression:
+		inspect(n.Value, f)
+	case *ast.ListComprehension:
+		inspectClauses(n.Clauses, f)
+		inspect(n.Element, f)
+	case *ast.MapComprehension:
+		inspectClauses(n.Clauses, f)
+		inspect(n.Key, f)
+		inspect(n.Value, f)
+	case *ast.SetComprehension:
+		inspectClauses(n.Clauses, f)
+		inspect(n.Element, f)
+	case *ast.MemberExpression:
+		inspect(n.Object, f)
+	case *ast.IndexExpression:
+		inspect(n.Left, f)
+		inspect(n.Index, f)
+	case *ast.SliceExpression:
+		inspect(n.Left, f)
+		if n.Start != nil {
+			inspect(n.Start, f)
+		}
+		if n.End != nil {
+			inspect(n.End, f)
+		}
+	case *ast.MatchStatement:
+		inspect(n.Subject, f)
+		for _, c := range n.Cases {
+			inspect(c.Pattern, f)
+			if c.Guard != nil {
+				inspect(c.Guard, f)
+			}
+			inspect(c.Body, f)
+		}
+	case *ast.SelectStatement:
+		for _, c := range n.Cases {
+			for _, expr := range []ast.Expression{c.Channel, c.Value, c.Timeout} {
+				if expr != nil {
+					inspect(expr, f)
+				}
+			}
+			inspect(c.Body, f)
+		}
+	case *ast.ValuePattern:
+		inspect(n.Value, f)
+	case *ast.ArrayPattern:
+		for _, el := range n.Elements {
+			inspect(el, f)
+		}
+	case *ast.TuplePattern:
+		for _, el := range n.Elements {
+			inspect(el, f)
+		}
+	case *ast.MapPattern:
+		for i := range n.Keys {
+			inspect(n.Keys[i], f)
+			inspect(n.Values[i], f)
+		}
+	case *ast.TypePattern:
+		inspect(n.Name, f)
+		for _, arg := range n.Args {
+			inspect(arg, f)
+		}
+		for _, kw := range n.Keywords {
+			inspect(kw.Pattern, f)
+		}
+	}
+}
+
+func inspectClauses(clauses []*ast.ComprehensionClause, f func(ast.Node) bool) {
+	for _, c := range clauses {
+		inspect(c.Target, f)
+		inspect(c.Iterable, f)
+		for _, cond := range c.Conditions {
+			inspect(cond, f)
+		}
 	}
 }
 
@@ -112,7 +255,7 @@ func describeExpression(expr ast.Expression) string {
 	switch n := expr.(type) {
 	case *ast.Identifier:
 		return n.Value
-	case *ast.IntegerLiteral:
+	case *ast.IntegerLiteral, *ast.FloatLiteral:
 		return n.TokenLiteral()
 	case *ast.CallExpression:
 		args := make([]string, 0, len(n.Arguments))
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"encoding/json"
+	"flowa/pkg/ast"
+	"flowa/pkg/eval"
+	"flowa/pkg/lexer"
+	"flowa/pkg/parser"
+	"flowa/pkg/token"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Lint rules and their default severities. A .flowalint file can turn any
+// of them "off" or change the severity; only errors fail the run.
+var lintRules = map[string]string{
+	"unused-variable":  "warning",
+	"unused-import":    "warning",
+	"shadowed-builtin": "warning",
+	"unreachable-code": "warning",
+	"route-handler":    "error",
+	"hardcoded-secret": "error",
+	"await-non-task":   "error",
+}
+
+// LintFinding is a single problem reported by `flowa lint`.
+type LintFinding struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// lintConfig is the contents of a .flowalint file:
+//
+//	{"rules": {"unused-variable": "off", "shadowed-builtin": "error"}}
+type lintConfig struct {
+	Rules map[string]string `json:"rules"`
+}
+
+func parseLintConfig(data []byte) (*lintConfig, error) {
+	cfg := &lintConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	for rule, severity := range cfg.Rules {
+		if _, ok := lintRules[rule]; !ok {
+			return nil, fmt.Errorf("unknown rule %q", rule)
+		}
+		switch severity {
+		case "off", "warning", "error":
+		default:
+			return nil, fmt.Errorf("rule %s: severity must be off, warning or error, got %q", rule, severity)
+		}
+	}
+	return cfg, nil
+}
+
+// findLintConfig looks for .flowalint in dir and each of its parents.
+func findLintConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, ".flowalint")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+func (c *lintConfig) severity(rule string) string {
+	if c != nil {
+		if severity, ok := c.Rules[rule]; ok {
+			return severity
+		}
+	}
+	return lintRules[rule]
+}
+
+// runLint implements `flowa lint [--json] [--config file] [paths...]`. It
+// exits 1 when any finding is an error and 2 when a file can't be read
+// or parsed.
+func runLint(args []string) {
+	asJSON := false
+	configPath := ""
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--json":
+			asJSON = true
+		case arg == "--config" && i+1 < len(args):
+			i++
+			configPath = args[i]
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(os.Stderr, "Unknown flag for lint: %s\n", arg)
+			fmt.Fprintln(os.Stderr, "Usage: flowa lint [--json] [--config file] [files or directories...]")
+			os.Exit(2)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var err error
+	if configPath == "" {
+		configPath, err = findLintConfig(".")
+	}
+	var cfg *lintConfig
+	if err == nil && configPath != "" {
+		var data []byte
+		if data, err = os.ReadFile(configPath); err == nil {
+			cfg, err = parseLintConfig(data)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %v", configPath, err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	files, err := flowaFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	findings := []LintFinding{}
+	failed := false
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			failed = true
+			continue
+		}
+		found, errs := lintSource(file, string(data), cfg)
+		if len(errs) > 0 {
+			fmt.Fprintf(os.Stderr, "%s:\n", file)
+			printParserErrors(os.Stderr, errs)
+			failed = true
+			continue
+		}
+		findings = append(findings, found...)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(findings)
+	} else {
+		printLintFindings(os.Stdout, findings)
+	}
+
+	if failed {
+		os.Exit(2)
+	}
+	for _, f := range findings {
+		if f.Severity == "error" {
+			os.Exit(1)
+		}
+	}
+}
+
+func printLintFindings(w io.Writer, findings []LintFinding) {
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s:%d:%d: %s: %s (%s)\n", f.File, f.Line, f.Column, f.Severity, f.Message, f.Rule)
+	}
+}
+
+// lintSource parses src and runs every enabled rule over it. Parser errors
+// are returned instead of findings.
+func lintSource(file, src string, cfg *lintConfig) ([]LintFinding, []string) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, p.Errors()
+	}
+
+	l := newLinter(program)
+	l.run(program)
+
+	var findings []LintFinding
+	for _, f := range l.findings {
+		severity := cfg.severity(f.Rule)
+		if severity == "off" {
+			continue
+		}
+		f.File = file
+		f.Severity = severity
+		findings = append(findings, f)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Line != findings[j].Line {
+			return findings[i].Line < findings[j].Line
+		}
+		return findings[i].Column < findings[j].Column
+	})
+	return findings, nil
+}
+
+// lintScope holds the names bound and read directly in a function body
+// (or at the top level of the file).
+type lintScope struct {
+	parent   *lintScope
+	assigns  map[string][]*ast.AssignmentStatement
+	params   map[string]bool
+	globals  map[string]bool // declared global or nonlocal
+	shadowed map[string]bool
+	reads    map[string]bool // includes reads from nested functions
+	awaits   []*ast.AwaitExpression
+}
+
+func newLintScope(parent *lintScope) *lintScope {
+	return &lintScope{
+		parent:   parent,
+		assigns:  map[string][]*ast.AssignmentStatement{},
+		params:   map[string]bool{},
+		globals:  map[string]bool{},
+		shadowed: map[string]bool{},
+		reads:    map[string]bool{},
+	}
+}
+
+type linter struct {
+	program  *ast.Program
+	builtins map[string]bool
+	funcs    map[string]*ast.FunctionStatement
+
+	// Set when the file pulls in names the linter can't see
+	opaqueImports bool
+
+	findings []LintFinding
+}
+
+func newLinter(program *ast.Program) *linter {
+	l := &linter{
+		program:  program,
+		builtins: map[string]bool{},
+		funcs:    map[string]*ast.FunctionStatement{},
+	}
+	for _, name := range eval.NewEnvironment().Names() {
+		l.builtins[name] = true
+	}
+	inspect(program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FunctionStatement:
+			l.funcs[n.Name.Value] = n
+		case *ast.ImportStatement:
+			l.opaqueImports = true
+		case *ast.FromImportStatement:
+			l.opaqueImports = l.opaqueImports || n.ImportAll
+		}
+		return true
+	})
+	return l
+}
+
+func (l *linter) report(node ast.Node, rule, format string, args ...interface{}) {
+	tok := nodeToken(node)
+	l.findings = append(l.findings, LintFinding{
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// nodeToken reads the node's Token field, which every AST node has.
+func nodeToken(node ast.Node) token.Token {
+	v := reflect.ValueOf(node)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if tok, ok := v.FieldByName("Token").Interface().(token.Token); ok {
+			return tok
+		}
+	}
+	return token.Token{}
+}
+
+func (l *linter) run(program *ast.Program) {
+	top := newLintScope(nil)
+	var imports []*ast.Identifier
+	for _, stmt := range program.Statements {
+		if imp, ok := stmt.(*ast.FromImportStatement); ok {
+			imports = append(imports, imp.Symbols...)
+		}
+	}
+	l.scan(program, top)
+	l.checkAwaits(top)
+
+	for _, sym := range imports {
+		if !top.reads[sym.Value] {
+			l.report(sym, "unused-import", "%s is imported but never used", sym.Value)
+		}
+	}
+}
+
+// scan visits the statements of one scope. Nested functions get their
+// own scope; what they read still counts as used in the enclosing one,
+// since closures capture it.
+func (l *linter) scan(node ast.Node, scope *lintScope) {
+	root := node
+	inspect(node, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FunctionStatement:
+			if n == root {
+				return true
+			}
+			l.bind(scope, n.Name)
+			l.lintFunction(n, scope)
+			return false
+		case *ast.Program:
+			l.checkUnreachable(n.Statements)
+		case *ast.BlockStatement:
+			l.checkUnreachable(n.Statements)
+		case *ast.Identifier:
+			scope.reads[n.Value] = true
+		case *ast.AssignmentStatement:
+			scope.assigns[n.Name.Value] = append(scope.assigns[n.Name.Value], n)
+			l.bind(scope, n.Name)
+		case *ast.ForStatement:
+			if n.Iterator != nil {
+				l.bind(scope, n.Iterator)
+			}
+		case *ast.TypeStatement:
+			l.bind(scope, n.Name)
+		case *ast.GlobalStatement:
+			for _, name := range n.Names {
+				scope.globals[name.Value] = true
+			}
+		case *ast.NonlocalStatement:
+			for _, name := range n.Names {
+				scope.globals[name.Value] = true
+			}
+		case *ast.RouteStatement:
+			l.checkHandler(n.Handler, 1, "route handler")
+		case *ast.MiddlewareStatement:
+			l.checkHandler(n.Middleware, -1, "middleware")
+		case *ast.CallExpression:
+			l.checkCall(n, scope)
+		case *ast.AwaitExpression:
+			scope.awaits = append(scope.awaits, n)
+		}
+		return true
+	})
+}
+
+func (l *linter) lintFunction(fn *ast.FunctionStatement, parent *lintScope) {
+	scope := newLintScope(parent)
+	for _, p := range fn.Parameters {
+		scope.params[p.Value] = true
+	}
+	l.scan(fn, scope)
+	l.checkAwaits(scope)
+
+	for name, assigns := range scope.assigns {
+		if scope.reads[name] || scope.globals[name] || scope.params[name] || strings.HasPrefix(name, "_") {
+			continue
+		}
+		l.report(assigns[0], "unused-variable", "%s is assigned but never used", name)
+	}
+	for name := range scope.reads {
+		parent.reads[name] = true
+	}
+}
+
+// bind reports the first binding of a builtin name in a scope.
+func (l *linter) bind(scope *lintScope, name *ast.Identifier) {
+	if !l.builtins[name.Value] || scope.shadowed[name.Value] {
+		return
+	}
+	scope.shadowed[name.Value] = true
+	l.report(name, "shadowed-builtin", "%s shadows the builtin %s", name.Value, name.Value)
+}
+
+func (l *linter) checkUnreachable(stmts []ast.Statement) {
+	for i, stmt := range stmts {
+		if _, ok := stmt.(*ast.ReturnStatement); ok && i+1 < len(stmts) {
+			l.report(stmts[i+1], "unreachable-code", "unreachable code after return")
+			return
+		}
+	}
+}
+
+// checkHandler verifies that a function referenced by a route exists and
+// accepts arity arguments (any number when arity is negative).
+func (l *linter) checkHandler(name *ast.Identifier, arity int, what string) {
+	fn, ok := l.funcs[name.Value]
+	if !ok {
+		if !l.opaqueImports && !l.builtins[name.Value] && !l.importedOrAssigned(name.Value) {
+			l.report(name, "route-handler", "%s %s is not defined", what, name.Value)
+		}
+		return
+	}
+	if arity >= 0 && len(fn.Parameters) != arity {
+		l.report(name, "route-handler", "%s %s takes %d parameter(s) but is called with %d", what, name.Value, len(fn.Parameters), arity)
+	}
+}
+
+// importedOrAssigned reports whether name is bound anywhere other than
+// by a def, so route checks stay quiet about handlers built at runtime.
+func (l *linter) importedOrAssigned(name string) bool {
+	found := false
+	inspect(l.program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.AssignmentStatement:
+			found = found || n.Name.Value == name
+		case *ast.FromImportStatement:
+			for _, sym := range n.Symbols {
+				found = found || sym.Value == name
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func (l *linter) checkCall(call *ast.CallExpression, scope *lintScope) {
+	switch callee := call.Function.(type) {
+	case *ast.Identifier:
+		if l.funcs[callee.Value] != nil {
+			return
+		}
+		switch callee.Value {
+		case "route":
+			// route(method, path, handler, middleware)
+			if len(call.Arguments) >= 3 {
+				if handler, ok := call.Arguments[2].(*ast.Identifier); ok {
+					l.checkHandler(handler, 1, "route handler")
+				}
+			}
+			if len(call.Arguments) == 4 {
+				middleware := []ast.Expression{call.Arguments[3]}
+				if arr, ok := call.Arguments[3].(*ast.ArrayLiteral); ok {
+					middleware = arr.Elements
+				}
+				for _, mw := range middleware {
+					if ident, ok := mw.(*ast.Identifier); ok {
+						l.checkHandler(ident, 2, "middleware")
+					}
+				}
+			}
+		case "use_middleware":
+			if len(call.Arguments) == 1 {
+				if ident, ok := call.Arguments[0].(*ast.Identifier); ok {
+					l.checkHandler(ident, 2, "middleware")
+				}
+			}
+		}
+	case *ast.MemberExpression:
+		obj, ok := callee.Object.(*ast.Identifier)
+		if !ok || obj.Value != "jwt" || callee.Property.Value != "sign" || len(call.Arguments) < 2 {
+			return
+		}
+		switch secret := call.Arguments[1].(type) {
+		case *ast.StringLiteral:
+			l.report(call, "hardcoded-secret", "jwt.sign secret is a string literal; load it with config.env instead")
+		case *ast.Identifier:
+			if l.stringConstant(secret.Value, scope) {
+				l.report(call, "hardcoded-secret", "jwt.sign secret comes from the string literal assigned to %s; load it with config.env instead", secret.Value)
+			}
+		}
+	}
+}
+
+// stringConstant reports whether every assignment to name visible from
+// scope is a string literal.
+func (l *linter) stringConstant(name string, scope *lintScope) bool {
+	for ; scope != nil; scope = scope.parent {
+		if scope.params[name] {
+			return false
+		}
+		assigns := scope.assigns[name]
+		if len(assigns) == 0 {
+			continue
+		}
+		for _, a := range assigns {
+			if _, ok := a.Value.(*ast.StringLiteral); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// checkAwaits flags awaits whose operand can't be a task: literals,
+// calls to functions in this file that aren't async, and names only ever
+// assigned such values.
+func (l *linter) checkAwaits(scope *lintScope) {
+	for _, aw := range scope.awaits {
+		if why := l.notTask(aw.Value, scope, 0); why != "" {
+			l.report(aw, "await-non-task", "await expects a task, got %s; use spawn or an async def", why)
+		}
+	}
+}
+
+func (l *linter) notTask(expr ast.Expression, scope *lintScope, depth int) string {
+	switch e := expr.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.DurationLiteral:
+		return "a number"
+	case *ast.StringLiteral:
+		return "a string"
+	case *ast.Boolean:
+		return "a boolean"
+	case *ast.NullLiteral:
+		return "null"
+	case *ast.ArrayLiteral, *ast.ListComprehension:
+		return "an array"
+	case *ast.TupleLiteral:
+		return "a tuple"
+	case *ast.MapLiteral, *ast.MapComprehension:
+		return "a map"
+	case *ast.SetLiteral, *ast.SetComprehension:
+		return "a set"
+	case *ast.CallExpression:
+		if ident, ok := e.Function.(*ast.Identifier); ok {
+			if fn := l.funcs[ident.Value]; fn != nil && !fn.IsAsync {
+				return "the result of " + ident.Value + "(), which is not async"
+			}
+		}
+	case *ast.Identifier:
+		if depth > 0 {
+			return ""
+		}
+		for s := scope; s != nil; s = s.parent {
+			if s.params[e.Value] || s.globals[e.Value] {
+				return ""
+			}
+			assigns := s.assigns[e.Value]
+			if len(assigns) == 0 {
+				continue
+			}
+			why := ""
+			for _, a := range assigns {
+				if why = l.notTask(a.Value, s, depth+1); why == "" {
+					return ""
+				}
+			}
+			return e.Value + " (" + why + ")"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func lintRulesFound(t *testing.T, src string, cfg *lintConfig) []string {
+	t.Helper()
+	findings, errs := lintSource("test.flowa", src, cfg)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, fmt.Sprintf("%d:%s", f.Line, f.Rule))
+	}
+	return got
+}
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			"unused variable",
+			"def f(x):\n    tmp = x * 2\n    _skip = 1\n    kept = 3\n    return kept\n",
+			[]string{"2:unused-variable"},
+		},
+		{
+			"closure reads count as uses",
+			"def f():\n    total = 0\n    def g():\n        return total\n    return g\n",
+			nil,
+		},
+		{
+			"global assignments are not unused",
+			"count = 0\ndef bump():\n    global count\n    count = count + 1\n",
+			nil,
+		},
+		{
+			"unused import",
+			"from \"lib.flowa\" import used, unused\nused()\n",
+			[]string{"1:unused-import"},
+		},
+		{
+			"shadowed builtin",
+			"json = 1\ndef print(x):\n    return x\nfor len in range(3):\n    json = 2\n",
+			[]string{"1:shadowed-builtin", "2:shadowed-builtin", "4:shadowed-builtin"},
+		},
+		{
+			"unreachable code",
+			"def f():\n    return 1\n    print(\"no\")\n    print(\"reported once\")\n",
+			[]string{"3:unreachable-code"},
+		},
+		{
+			"route handlers",
+			"def ok(req):\n    return 1\ndef two(req, extra):\n    return 1\nservice Api on \":8080\":\n    get \"/a\" -> ok\n    get \"/b\" -> two\n    post \"/c\" -> missing\n",
+			[]string{"7:route-handler", "8:route-handler"},
+		},
+		{
+			"route builtin and middleware",
+			"def h(req):\n    return 1\ndef mw(req):\n    return 1\nroute(\"GET\", \"/x\", h, [mw])\nuse_middleware(mw)\n",
+			[]string{"5:route-handler", "6:route-handler"},
+		},
+		{
+			"handlers from imports are not checked",
+			"import \"handlers.flowa\"\nservice Api on \":8080\":\n    get \"/a\" -> elsewhere\n",
+			nil,
+		},
+		{
+			"hardcoded secrets",
+			"key = \"hunter2\"\na = jwt.sign({}, key, \"1h\")\nb = jwt.sign({}, \"literal\", \"1h\")\nc = jwt.sign({}, config.env(\"KEY\", \"\"), \"1h\")\ndef sign(secret):\n    return jwt.sign({}, secret, \"1h\")\n",
+			[]string{"2:hardcoded-secret", "3:hardcoded-secret"},
+		},
+		{
+			"await non-task",
+			"def plain():\n    return 1\nasync def job():\n    return 2\na = await 5\nb = await plain()\nc = await job()\nt = spawn plain()\nd = await t\nn = [1]\ne = await n\ndef f(task):\n    return await task\n",
+			[]string{"5:await-non-task", "6:await-non-task", "11:await-non-task"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := lintRulesFound(t, tt.input, nil)
+		if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	cfg, err := parseLintConfig([]byte(`{"rules": {"unused-variable": "off", "shadowed-builtin": "error"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	findings, _ := lintSource("test.flowa", "print = 1\ndef f():\n    tmp = 1\n", cfg)
+	if len(findings) != 1 || findings[0].Rule != "shadowed-builtin" || findings[0].Severity != "error" {
+		t.Errorf("expected one shadowed-builtin error, got %+v", findings)
+	}
+
+	for _, bad := range []string{`{"rules": {"no-such-rule": "off"}}`, `{"rules": {"unused-import": "loud"}}`, `{`} {
+		if _, err := parseLintConfig([]byte(bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,951 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flowa/pkg/ast"
+	"flowa/pkg/eval"
+	"flowa/pkg/format"
+	"flowa/pkg/lexer"
+	"flowa/pkg/parser"
+	"flowa/pkg/token"
+	"flowa/pkg/version"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runLSP implements `flowa lsp`, a Language Server Protocol server that
+// speaks JSON-RPC over stdin and stdout.
+func runLSP(args []string) {
+	for _, arg := range args {
+		if arg != "--stdio" {
+			fmt.Fprintf(os.Stderr, "Unknown flag for lsp: %s\n", arg)
+			fmt.Fprintln(os.Stderr, "Usage: flowa lsp [--stdio]")
+			os.Exit(2)
+		}
+	}
+	if err := newLSPServer(os.Stdin, os.Stdout).serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "flowa lsp: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidParams  = -32602
+	rpcMethodNotFound = -32601
+)
+
+// The parts of the protocol the server uses. Lines and characters are
+// zero-based, and characters count UTF-16 code units.
+type (
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+	lspLocation struct {
+		URI   string   `json:"uri"`
+		Range lspRange `json:"range"`
+	}
+	lspTextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text,omitempty"`
+	}
+	lspPositionParams struct {
+		TextDocument lspTextDocument `json:"textDocument"`
+		Position     lspPosition     `json:"position"`
+	}
+	lspDiagnostic struct {
+		Range    lspRange `json:"range"`
+		Severity int      `json:"severity"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+	lspMarkup struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	lspHover struct {
+		Contents lspMarkup `json:"contents"`
+		Range    lspRange  `json:"range"`
+	}
+	lspCompletionItem struct {
+		Label         string `json:"label"`
+		Kind          int    `json:"kind"`
+		Detail        string `json:"detail,omitempty"`
+		Documentation string `json:"documentation,omitempty"`
+	}
+	lspSymbol struct {
+		Name           string      `json:"name"`
+		Detail         string      `json:"detail,omitempty"`
+		Kind           int         `json:"kind"`
+		Range          lspRange    `json:"range"`
+		SelectionRange lspRange    `json:"selectionRange"`
+		Children       []lspSymbol `json:"children,omitempty"`
+	}
+	lspTextEdit struct {
+		Range   lspRange `json:"range"`
+		NewText string   `json:"newText"`
+	}
+)
+
+// CompletionItemKind and SymbolKind values from the specification
+const (
+	completionMethod   = 2
+	completionFunction = 3
+	completionField    = 5
+	completionVariable = 6
+	completionModule   = 9
+	completionKeyword  = 14
+	completionStruct   = 22
+
+	symbolModule   = 2
+	symbolClass    = 5
+	symbolMethod   = 6
+	symbolField    = 8
+	symbolFunction = 12
+	symbolStruct   = 23
+)
+
+type lspServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*lspDocument
+
+	// builtins backs completion and hover for the standard library
+	builtins *eval.Environment
+	shutdown bool
+}
+
+func newLSPServer(in io.Reader, out io.Writer) *lspServer {
+	return &lspServer{
+		in:       bufio.NewReader(in),
+		out:      out,
+		docs:     map[string]*lspDocument{},
+		builtins: eval.NewEnvironment(),
+	}
+}
+
+var errExitWithoutShutdown = errors.New("exit before shutdown")
+
+// serve handles messages until the client sends `exit` or closes the
+// stream.
+func (s *lspServer) serve() error {
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		var rerr *rpcError
+		if errors.As(err, &rerr) {
+			s.write(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: rerr})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, err := s.handle(req)
+		if req.ID == nil {
+			// Notifications get no response
+			continue
+		}
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+		if err != nil {
+			if !errors.As(err, &rerr) {
+				rerr = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			}
+			resp.Error = rerr
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			return err
+		}
+		if err := s.write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// read decodes one JSON-RPC message.
+func (s *lspServer) read() (*rpcRequest, error) {
+	body, err := readFrame(s.in)
+	if err != nil {
+		return nil, err
+	}
+	req := &rpcRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, &rpcError{Code: rpcParseError, Message: err.Error()}
+	}
+	return req, nil
+}
+
+func (s *lspServer) write(msg interface{}) error {
+	return writeFrame(s.out, msg)
+}
+
+// readFrame reads one message body framed by a Content-Length header,
+// as used by both the language server and the debug adapter.
+func readFrame(in *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// writeFrame encodes msg as JSON behind a Content-Length header.
+func writeFrame(out io.Writer, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+func (s *lspServer) handle(req *rpcRequest) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // full text on every change
+				"definitionProvider":         true,
+				"referencesProvider":         true,
+				"hoverProvider":              true,
+				"documentSymbolProvider":     true,
+				"documentFormattingProvider": true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]string{"name": "flowa", "version": version.Version},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   lspTextDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			return nil, s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.publishDiagnostics(params.TextDocument.URI, []lspDiagnostic{})
+	case "textDocument/definition":
+		doc, pos, err := s.positionParams(req.Params)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return s.definition(doc, pos), nil
+	case "textDocument/references":
+		var params struct {
+			lspPositionParams
+			Context struct {
+				IncludeDeclaration bool `json:"includeDeclaration"`
+			} `json:"context"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc := s.docs[params.TextDocument.URI]
+		if doc == nil {
+			return nil, nil
+		}
+		return s.references(doc, params.Position, params.Context.IncludeDeclaration), nil
+	case "textDocument/hover":
+		doc, pos, err := s.positionParams(req.Params)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return s.hover(doc, pos), nil
+	case "textDocument/completion":
+		doc, pos, err := s.positionParams(req.Params)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return s.completion(doc, pos), nil
+	case "textDocument/documentSymbol":
+		doc, _, err := s.positionParams(req.Params)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return doc.symbols(doc.program.Statements), nil
+	case "textDocument/formatting":
+		doc, _, err := s.positionParams(req.Params)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return doc.formatting(), nil
+	}
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		return nil, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+func (s *lspServer) positionParams(raw json.RawMessage) (*lspDocument, lspPosition, error) {
+	var params lspPositionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, lspPosition{}, err
+	}
+	return s.docs[params.TextDocument.URI], params.Position, nil
+}
+
+func (s *lspServer) update(uri, text string) error {
+	doc := analyzeDocument(uri, text)
+	s.docs[uri] = doc
+	diagnostics := []lspDiagnostic{}
+	for _, d := range doc.errors {
+		start := doc.position(d.Line, d.Column)
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range:    lspRange{Start: start, End: doc.wordEnd(d.Line, d.Column)},
+			Severity: 1,
+			Source:   "flowa",
+			Message:  d.Message,
+		})
+	}
+	return s.publishDiagnostics(uri, diagnostics)
+}
+
+func (s *lspServer) publishDiagnostics(uri string, diagnostics []lspDiagnostic) error {
+	return s.write(rpcNotification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: map[string]interface{}{
+			"uri":         uri,
+			"diagnostics": diagnostics,
+		},
+	})
+}
+
+// What an identifier occurrence does with its name
+const (
+	identRead = iota
+	identAssign
+	identFunc
+	identType
+	identImport
+)
+
+type lspIdent struct {
+	name string // "json.encode" for members of a module
+	tok  token.Token
+	kind int
+}
+
+type lspDocument struct {
+	uri     string
+	text    string
+	lines   []string
+	program *ast.Program
+	errors  []parser.Diagnostic
+
+	idents  []lspIdent // in source order
+	funcs   map[string]*ast.FunctionStatement
+	types   map[string]*ast.TypeStatement
+	imports map[string]string // imported symbol -> path as written
+}
+
+func analyzeDocument(uri, text string) *lspDocument {
+	p := parser.New(lexer.New(text))
+	doc := &lspDocument{
+		uri:     uri,
+		text:    text,
+		lines:   strings.Split(text, "\n"),
+		program: p.ParseProgram(),
+		errors:  p.Diagnostics(),
+		funcs:   map[string]*ast.FunctionStatement{},
+		types:   map[string]*ast.TypeStatement{},
+		imports: map[string]string{},
+	}
+	add := func(id *ast.Identifier, kind int) {
+		if id != nil {
+			doc.idents = append(doc.idents, lspIdent{name: id.Value, tok: id.Token, kind: kind})
+		}
+	}
+
+	func() {
+		// A program with syntax errors may be missing pieces; whatever
+		// was collected before a nil node is still useful
+		defer func() { recover() }()
+		inspect(doc.program, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.Identifier:
+				add(n, identRead)
+			case *ast.AssignmentStatement:
+				add(n.Name, identAssign)
+			case *ast.FunctionStatement:
+				add(n.Name, identFunc)
+				doc.funcs[n.Name.Value] = n
+			case *ast.TypeStatement:
+				add(n.Name, identType)
+				doc.types[n.Name.Value] = n
+			case *ast.FromImportStatement:
+				for _, sym := range n.Symbols {
+					add(sym, identImport)
+					doc.imports[sym.Value] = n.Path.Value
+				}
+			case *ast.MemberExpression:
+				if obj, ok := n.Object.(*ast.Identifier); ok && n.Property != nil {
+					doc.idents = append(doc.idents, lspIdent{
+						name: obj.Value + "." + n.Property.Value,
+						tok:  n.Property.Token,
+						kind: identRead,
+					})
+				}
+			}
+			return true
+		})
+	}()
+
+	sort.SliceStable(doc.idents, func(i, j int) bool {
+		a, b := doc.idents[i].tok, doc.idents[j].tok
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return doc
+}
+
+// position converts a lexer position (1-based line, 1-based byte column)
+// to a protocol position.
+func (d *lspDocument) position(line, col int) lspPosition {
+	line = min(max(line, 1), len(d.lines))
+	text := d.lines[line-1]
+	col = min(max(col-1, 0), len(text))
+	return lspPosition{Line: line - 1, Character: utf16Len(text[:col])}
+}
+
+// column converts a protocol position to a 1-based byte column.
+func (d *lspDocument) column(pos lspPosition) int {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return 0
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return i + 1
+		}
+		units += utf16Len(string(r))
+	}
+	return len(text) + 1
+}
+
+// wordEnd is the end of the word starting at a lexer position, or the
+// following character if there is no word there.
+func (d *lspDocument) wordEnd(line, col int) lspPosition {
+	line = min(max(line, 1), len(d.lines))
+	text := d.lines[line-1]
+	end := min(max(col-1, 0), len(text))
+	for end < len(text) && isWordByte(text[end]) {
+		end++
+	}
+	if end == col-1 && end < len(text) {
+		end++
+	}
+	return d.position(line, end+1)
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *lspDocument) identRange(id lspIdent) lspRange {
+	return lspRange{
+		Start: d.position(id.tok.Line, id.tok.Column),
+		End:   d.position(id.tok.Line, id.tok.Column+len(id.tok.Literal)),
+	}
+}
+
+// identAt finds the identifier under the cursor, including a cursor
+// placed just after its last character.
+func (d *lspDocument) identAt(pos lspPosition) (lspIdent, bool) {
+	line, col := pos.Line+1, d.column(pos)
+	for _, id := range d.idents {
+		if id.tok.Line == line && id.tok.Column <= col && col <= id.tok.Column+len(id.tok.Literal) {
+			return id, true
+		}
+	}
+	return lspIdent{}, false
+}
+
+// declaration returns where name is defined in this document: a def or
+// type first, then a `from ... import`.
+func (d *lspDocument) declaration(name string) (lspIdent, bool) {
+	var imported *lspIdent
+	for i, id := range d.idents {
+		if id.name != name {
+			continue
+		}
+		switch id.kind {
+		case identFunc, identType:
+			return id, true
+		case identImport:
+			if imported == nil {
+				imported = &d.idents[i]
+			}
+		}
+	}
+	if imported != nil {
+		return *imported, true
+	}
+	return lspIdent{}, false
+}
+
+// nodeRange spans from a node's first token to the end of the last line
+// any token below it is on.
+func (d *lspDocument) nodeRange(node ast.Node) lspRange {
+	start := nodeToken(node)
+	last := start.Line
+	func() {
+		defer func() { recover() }()
+		inspect(node, func(n ast.Node) bool {
+			last = max(last, nodeToken(n).Line)
+			return true
+		})
+	}()
+	end := d.position(last, len(d.lines[min(max(last, 1), len(d.lines))-1])+1)
+	return lspRange{Start: d.position(start.Line, start.Column), End: end}
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// importedDocument loads the file a `from "path" import ...` in doc
+// refers to, preferring an open editor buffer. Paths are tried relative
+// to the importing file and then, as the interpreter does, to the
+// working directory.
+func (s *lspServer) importedDocument(doc *lspDocument, path string) *lspDocument {
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		if dir := uriToPath(doc.uri); dir != "" {
+			candidates = []string{filepath.Join(filepath.Dir(dir), path), path}
+		}
+	}
+	for _, candidate := range candidates {
+		abs, err := filepath.Abs(candidate)
+		if err != nil {
+			continue
+		}
+		uri := pathToURI(abs)
+		if open, ok := s.docs[uri]; ok {
+			return open
+		}
+		if data, err := os.ReadFile(abs); err == nil {
+			return analyzeDocument(uri, string(data))
+		}
+	}
+	return nil
+}
+
+// resolve follows name to its definition, through one import if needed.
+func (s *lspServer) resolve(doc *lspDocument, name string) (*lspDocument, lspIdent, bool) {
+	decl, ok := doc.declaration(name)
+	if !ok {
+		return nil, lspIdent{}, false
+	}
+	if decl.kind == identImport {
+		if target := s.importedDocument(doc, doc.imports[name]); target != nil {
+			if def, ok := target.declaration(name); ok && def.kind != identImport {
+				return target, def, true
+			}
+		}
+	}
+	return doc, decl, true
+}
+
+func (s *lspServer) definition(doc *lspDocument, pos lspPosition) interface{} {
+	id, ok := doc.identAt(pos)
+	if !ok {
+		return nil
+	}
+	target, def, ok := s.resolve(doc, id.name)
+	if !ok {
+		return nil
+	}
+	return lspLocation{URI: target.uri, Range: target.identRange(def)}
+}
+
+// references finds name in this document and in every open document that
+// defines or imports it.
+func (s *lspServer) references(doc *lspDocument, pos lspPosition, includeDeclaration bool) []lspLocation {
+	id, ok := doc.identAt(pos)
+	if !ok {
+		return nil
+	}
+	uris := make([]string, 0, len(s.docs))
+	for uri := range s.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	locations := []lspLocation{}
+	for _, uri := range uris {
+		other := s.docs[uri]
+		if other != doc {
+			if _, ok := other.declaration(id.name); !ok {
+				continue
+			}
+		}
+		for _, ref := range other.idents {
+			if ref.name != id.name {
+				continue
+			}
+			if !includeDeclaration && (ref.kind == identFunc || ref.kind == identType || ref.kind == identImport) {
+				continue
+			}
+			locations = append(locations, lspLocation{URI: uri, Range: other.identRange(ref)})
+		}
+	}
+	return locations
+}
+
+func (s *lspServer) hover(doc *lspDocument, pos lspPosition) interface{} {
+	id, ok := doc.identAt(pos)
+	if !ok {
+		return nil
+	}
+	var text string
+	if target, def, ok := s.resolve(doc, id.name); ok {
+		text = target.describe(def)
+	}
+	if text == "" {
+		text = s.describeBuiltin(id.name)
+	}
+	if text == "" {
+		return nil
+	}
+	return lspHover{
+		Contents: lspMarkup{Kind: "markdown", Value: text},
+		Range:    doc.identRange(id),
+	}
+}
+
+// describe renders the signature of a def or type, followed by the
+// comment block directly above it.
+func (d *lspDocument) describe(def lspIdent) string {
+	var sig string
+	var line int
+	if fn, ok := d.funcs[def.name]; ok && def.kind == identFunc {
+		sig, line = functionSignature(fn), fn.Token.Line
+	} else if ty, ok := d.types[def.name]; ok && def.kind == identType {
+		fields := make([]string, len(ty.Fields))
+		for i, f := range ty.Fields {
+			fields[i] = f.Value
+		}
+		sig, line = "type "+ty.Name.Value+"("+strings.Join(fields, ", ")+")", ty.Token.Line
+	} else {
+		return ""
+	}
+
+	var comments []string
+	for i := line - 2; i >= 0; i-- {
+		text := strings.TrimSpace(d.lines[i])
+		if !strings.HasPrefix(text, "#") {
+			break
+		}
+		comments = append([]string{strings.TrimSpace(strings.TrimPrefix(text, "#"))}, comments...)
+	}
+	out := "```flowa\n" + sig + "\n```"
+	if len(comments) > 0 {
+		out += "\n\n" + strings.Join(comments, "\n")
+	}
+	return out
+}
+
+func functionSignature(fn *ast.FunctionStatement) string {
+	params := make([]string, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		params[i] = p.Value
+		if i < len(fn.ParameterPatterns) && fn.ParameterPatterns[i] != nil {
+			params[i] = fn.ParameterPatterns[i].String()
+		}
+	}
+	sig := "def " + fn.Name.Value + "(" + strings.Join(params, ", ") + ")"
+	if fn.IsAsync {
+		sig = "async " + sig
+	}
+	return sig
+}
+
+func (s *lspServer) describeBuiltin(name string) string {
+	if doc, ok := builtinDocs[name]; ok {
+		return "```flowa\n" + doc.Signature + "\n```\n\n" + doc.Summary
+	}
+	obj, ok := s.builtins.Get(name)
+	if !ok {
+		return ""
+	}
+	if _, ok := obj.(*eval.StructInstance); ok {
+		members := memberNames(obj)
+		sort.Strings(members)
+		return "```flowa\nmodule " + name + "\n```\n\n" + strings.Join(members, ", ")
+	}
+	return "```flowa\n" + name + "\n```\n\nbuiltin " + strings.ToLower(obj.Type())
+}
+
+var memberPrefix = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\.([A-Za-z0-9_]*)$`)
+
+func (s *lspServer) completion(doc *lspDocument, pos lspPosition) []lspCompletionItem {
+	if pos.Line < 0 || pos.Line >= len(doc.lines) {
+		return nil
+	}
+	before := doc.lines[pos.Line][:doc.column(pos)-1]
+	items := []lspCompletionItem{}
+
+	if m := memberPrefix.FindStringSubmatch(before); m != nil {
+		obj, ok := s.builtins.Get(m[1])
+		if !ok {
+			return items
+		}
+		members := memberNames(obj)
+		sort.Strings(members)
+		for _, name := range members {
+			if !strings.HasPrefix(name, m[2]) {
+				continue
+			}
+			item := lspCompletionItem{Label: name, Kind: completionField}
+			if _, ok := memberValue(obj, name).(*eval.BuiltinFunction); ok {
+				item.Kind = completionMethod
+			}
+			if doc, ok := builtinDocs[m[1]+"."+name]; ok {
+				item.Detail, item.Documentation = doc.Signature, doc.Summary
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+
+	start := len(before)
+	for start > 0 && isWordByte(before[start-1]) && before[start-1] != '.' {
+		start--
+	}
+	word := before[start:]
+	seen := map[string]bool{}
+	addItem := func(item lspCompletionItem) {
+		if seen[item.Label] || !strings.HasPrefix(item.Label, word) {
+			return
+		}
+		seen[item.Label] = true
+		items = append(items, item)
+	}
+	for _, id := range doc.idents {
+		switch id.kind {
+		case identFunc:
+			addItem(lspCompletionItem{Label: id.name, Kind: completionFunction, Detail: functionSignature(doc.funcs[id.name])})
+		case identType:
+			addItem(lspCompletionItem{Label: id.name, Kind: completionStruct})
+		case identAssign, identImport:
+			addItem(lspCompletionItem{Label: id.name, Kind: completionVariable})
+		}
+	}
+	for _, name := range s.builtins.Names() {
+		obj, _ := s.builtins.Get(name)
+		item := lspCompletionItem{Label: name, Kind: completionVariable}
+		switch obj.(type) {
+		case *eval.BuiltinFunction:
+			item.Kind = completionFunction
+		case *eval.StructInstance:
+			item.Kind = completionModule
+		}
+		if doc, ok := builtinDocs[name]; ok {
+			item.Detail, item.Documentation = doc.Signature, doc.Summary
+		}
+		addItem(item)
+	}
+	for _, kw := range token.Keywords() {
+		addItem(lspCompletionItem{Label: kw, Kind: completionKeyword})
+	}
+	return items
+}
+
+func memberValue(obj eval.Object, name string) eval.Object {
+	if v, ok := obj.(*eval.StructInstance); ok {
+		return v.Fields[name]
+	}
+	return nil
+}
+
+func (d *lspDocument) symbols(stmts []ast.Statement) []lspSymbol {
+	out := []lspSymbol{}
+	for _, stmt := range stmts {
+		switch n := stmt.(type) {
+		case *ast.FunctionStatement:
+			out = append(out, lspSymbol{
+				Name:           n.Name.Value,
+				Detail:         functionSignature(n),
+				Kind:           symbolFunction,
+				Range:          d.nodeRange(n),
+				SelectionRange: d.identRange(lspIdent{name: n.Name.Value, tok: n.Name.Token}),
+				Children:       d.symbols(n.Body.Statements),
+			})
+		case *ast.TypeStatement:
+			sym := lspSymbol{
+				Name:           n.Name.Value,
+				Kind:           symbolStruct,
+				Range:          d.nodeRange(n),
+				SelectionRange: d.identRange(lspIdent{name: n.Name.Value, tok: n.Name.Token}),
+			}
+			for _, f := range n.Fields {
+				r := d.identRange(lspIdent{name: f.Value, tok: f.Token})
+				sym.Children = append(sym.Children, lspSymbol{Name: f.Value, Kind: symbolField, Range: r, SelectionRange: r})
+			}
+			out = append(out, sym)
+		case *ast.ServiceStatement:
+			sym := lspSymbol{
+				Name:           n.Name.Value,
+				Kind:           symbolClass,
+				Range:          d.nodeRange(n),
+				SelectionRange: d.identRange(lspIdent{name: n.Name.Value, tok: n.Name.Token}),
+				Children:       d.symbols(n.Body.Statements),
+			}
+			if n.Address != nil {
+				sym.Detail = "service on " + n.Address.Value
+			}
+			out = append(out, sym)
+		case *ast.RouteStatement:
+			r := d.nodeRange(n)
+			out = append(out, lspSymbol{
+				Name:           strings.ToUpper(n.Method) + " " + n.Path.Value,
+				Detail:         "-> " + n.Handler.Value,
+				Kind:           symbolMethod,
+				Range:          r,
+				SelectionRange: r,
+			})
+		case *ast.ModuleStatement:
+			out = append(out, lspSymbol{
+				Name:           n.Name.Value,
+				Kind:           symbolModule,
+				Range:          d.nodeRange(n),
+				SelectionRange: d.identRange(lspIdent{name: n.Name.Value, tok: n.Name.Token}),
+				Children:       d.symbols(n.Body.Statements),
+			})
+		}
+	}
+	return out
+}
+
+// formatting replaces the whole document with `flowa fmt` output. A file
+// with syntax errors is left alone.
+func (d *lspDocument) formatting() []lspTextEdit {
+	out, err := format.Source(d.text)
+	if err != nil || out == d.text {
+		return []lspTextEdit{}
+	}
+	last := len(d.lines) - 1
+	return []lspTextEdit{{
+		Range: lspRange{
+			End: lspPosition{Line: last, Character: utf16Len(d.lines[last])},
+		},
+		NewText: out,
+	}}
+}
@@ -0,0 +1,183 @@
+package main
+
+type builtinDoc struct {
+	Signature string
+	Summary   string
+}
+
+// builtinDocs is the hover text for builtins and module members, mostly
+// taken from API.md. Keep the two in sync when the standard library grows.
+var builtinDocs = map[string]builtinDoc{
+	"all_settled":             {"all_settled(tasks)", "`gather` returns every result in order; the first error cancels the rest and is returned."},
+	"assert.contains":         {"assert.contains(container, item, message)", "Fail unless `item in container`; `message` is optional."},
+	"assert.equal":            {"assert.equal(actual, expected, message)", "Fail unless the values are equal; `message` is optional."},
+	"assert.raises":           {"assert.raises(fn, text)", "Call `fn()`, fail unless it errors (with a message containing `text`, if given), and return the error message."},
+	"async_http_get":          {"async_http_get(url)", "Start fetching `url` and return a task."},
+	"atomic":                  {"atomic(n)", "Atomic integer: `a.get()`, `a.set(n)`, `a.add(n)`, `a.compare_and_swap(old, new)`."},
+	"auth.hash_password":      {"auth.hash_password(password)", "Hash password with bcrypt."},
+	"auth.verify_password":    {"auth.verify_password(hash, password)", "Verify password against hash."},
+	"base64.decode":           {"base64.decode(s)", "Standard alphabet; encode takes a string or Bytes, decode accepts missing padding"},
+	"base64.decode_bytes":     {"base64.decode_bytes(s)", "Decode to Bytes instead of a string"},
+	"base64.encode":           {"base64.encode(data)", "Standard alphabet; encode takes a string or Bytes, decode accepts missing padding"},
+	"base64.url_decode":       {"base64.url_decode(s)", "URL-safe alphabet, no padding"},
+	"base64.url_decode_bytes": {"base64.url_decode_bytes(s)", "Decode to Bytes instead of a string"},
+	"base64.url_encode":       {"base64.url_encode(s)", "URL-safe alphabet, no padding"},
+	"bytes":                   {"bytes(value)", "Create binary data from a string (UTF-8), an array of byte values (0-255) or a length (zero-filled)."},
+	"channel":                 {"channel(size)", "A channel for passing values between spawned tasks."},
+	"config.env":              {"config.env(key, default)", "Read environment variable."},
+	"crypto.compare":          {"crypto.compare(a, b)", "Constant-time string equality."},
+	"crypto.decrypt":          {"crypto.decrypt(key, ciphertext, aad)", "AES-GCM authenticated encryption."},
+	"crypto.encrypt":          {"crypto.encrypt(key, plaintext, aad)", "AES-GCM authenticated encryption."},
+	"crypto.generate_key":     {"crypto.generate_key(bits)", "Random AES key (128, 192 or 256 bits; default 256) as hex."},
+	"crypto.generate_keypair": {"crypto.generate_keypair()", "New Ed25519 key pair: `{\"public\": hex, \"private\": hex}`."},
+	"crypto.hmac":             {"crypto.hmac(key, data, algorithm)", "Hex HMAC of `data`."},
+	"crypto.hmac_verify":      {"crypto.hmac_verify(key, data, signature, algorithm)", "Check a hex HMAC signature in constant time."},
+	"crypto.sha256":           {"crypto.sha256(data)", "Hex digest of `data`."},
+	"crypto.sha512":           {"crypto.sha512(data)", "Hex digest of `data`."},
+	"crypto.sign":             {"crypto.sign(private_key, message)", "Ed25519 signatures (hex)."},
+	"crypto.verify":           {"crypto.verify(public_key, message, signature)", "Ed25519 signatures (hex)."},
+	"decimal.div":             {"decimal.div(a, b, places, mode)", "Divide with an explicit result scale and rounding mode."},
+	"decimal.new":             {"decimal.new(value)", "Create a decimal from a string (`\"12.50\"`, `\"1e-3\"`) or an integer."},
+	"decimal.round":           {"decimal.round(d, places, mode)", "Round to exactly `places` fractional digits."},
+	"decimal.to_int":          {"decimal.to_int(d)", "Truncate toward zero to an integer, or format as a string."},
+	"decimal.to_string":       {"decimal.to_string(d)", "Truncate toward zero to an integer, or format as a string."},
+	"enumerate":               {"enumerate(items)", "Lazy `(index, value)` tuples"},
+	"events.bridge":           {"events.bridge(pattern, conn)", "Forward matching events to a websocket connection as JSON text frames of the form `{\"topic\": \"chat.general\", \"payload\": ...}`."},
+	"events.emit":             {"events.emit(topic, payload)", "Deliver an event and wait for every handler."},
+	"events.emit_async":       {"events.emit_async(topic, payload)", "Run each handler as its own task and return the tasks right away."},
+	"events.off":              {"events.off(sub)", "Same as `sub.off()`."},
+	"events.on":               {"events.on(pattern, fn)", "Call `fn(payload, topic)` for each matching event; `once` unsubscribes after the first."},
+	"events.once":             {"events.once(pattern, fn)", "Call `fn(payload, topic)` for each matching event; `once` unsubscribes after the first."},
+	"events.subscribers":      {"events.subscribers(topic)", "Number of subscriptions an event on `topic` would reach."},
+	"filter":                  {"filter(items, fn)", "Array for arrays/tuples, lazy iterator otherwise"},
+	"first":                   {"first(items)", "First element, or `None` if empty."},
+	"float":                   {"float(value)", "Convert numbers or numeric strings."},
+	"fs.append":               {"fs.append(path, content)", "Append to a file."},
+	"fs.exists":               {"fs.exists(path)", "Whether the path exists."},
+	"fs.lines":                {"fs.lines(path)", "Lazy sequence of the lines of a file, read one at a time."},
+	"fs.read":                 {"fs.read(path)", "Contents of a file as a string."},
+	"fs.read_bytes":           {"fs.read_bytes(path)", "Contents of a file as Bytes."},
+	"fs.remove":               {"fs.remove(path)", "Delete a file."},
+	"fs.write":                {"fs.write(path, content)", "Write a file, replacing it."},
+	"fs.write_bytes":          {"fs.write_bytes(path, data)", "Write Bytes to a file, replacing it."},
+	"gather":                  {"gather(tasks)", "`gather` returns every result in order; the first error cancels the rest and is returned."},
+	"hex.decode":              {"hex.decode(s)", "Hexadecimal; `hex.decode_bytes(s)` returns Bytes"},
+	"hex.encode":              {"hex.encode(s)", "Hexadecimal; `hex.decode_bytes(s)` returns Bytes"},
+	"http.get":                {"http.get(url)", "Send a GET request and return the response."},
+	"http.post":               {"http.post(url, body)", "Send a POST request and return the response."},
+	"http_get":                {"http_get(url)", "Fetch `url` and return the response body."},
+	"inspect":                 {"inspect(value)", "Print a debug representation of `value` and return it unchanged."},
+	"int":                     {"int(value)", "Convert numbers or numeric strings."},
+	"iter":                    {"iter(items)", "Iterator over `items`"},
+	"json.decode":             {"json.decode(json_string)", "Parse JSON string to Flowa object."},
+	"json.encode":             {"json.encode(data)", "Convert Flowa objects to JSON string."},
+	"json_response":           {"json_response(data, status)", "JSON response for a route handler."},
+	"jwt.sign":                {"jwt.sign(payload, secret, expiresIn)", "Create signed JWT token."},
+	"jwt.verify":              {"jwt.verify(token, secret)", "Verify and decode JWT token."},
+	"last":                    {"last(items)", "Last element, or `None` if empty."},
+	"len":                     {"len(value)", "Number of elements in a string, array, map, set, tuple or lazy sequence."},
+	"list":                    {"list(items)", "Array of all values"},
+	"listen":                  {"listen(port)", "Serve the routes registered with `route` on `port`."},
+	"mail.queue":              {"mail.queue(config)", "Send email in background (async)."},
+	"mail.send":               {"mail.send(config)", "Send email via SMTP."},
+	"mail.send_template":      {"mail.send_template(template, data)", "Send email with template."},
+	"map":                     {"map(items, fn)", "Array for arrays/tuples, lazy iterator otherwise"},
+	"math.abs":                {"math.abs(x)", "Absolute value, same type as `x`"},
+	"math.acos":               {"math.acos(x)", "Returns a float."},
+	"math.asin":               {"math.asin(x)", "Returns a float."},
+	"math.atan":               {"math.atan(x)", "Returns a float."},
+	"math.atan2":              {"math.atan2(y, x)", "Returns a float."},
+	"math.ceil":               {"math.ceil(x)", "Returns an integer."},
+	"math.clamp":              {"math.clamp(x, lo, hi)", "`x` limited to `[lo, hi]`"},
+	"math.cos":                {"math.cos(x)", "Returns a float."},
+	"math.exp":                {"math.exp(x)", "Returns a float."},
+	"math.floor":              {"math.floor(x)", "Returns an integer."},
+	"math.hypot":              {"math.hypot(x, y)", "Returns a float."},
+	"math.is_inf":             {"math.is_inf(x)", "Returns a boolean."},
+	"math.is_nan":             {"math.is_nan(x)", "Returns a boolean."},
+	"math.log":                {"math.log(x)", "Returns a float."},
+	"math.log10":              {"math.log10(x)", "Returns a float."},
+	"math.log2":               {"math.log2(x)", "Returns a float."},
+	"math.pow":                {"math.pow(x, y)", "Exact integer for integer `x` and `y >= 0`, otherwise a float"},
+	"math.round":              {"math.round(x)", "Integer, rounding half to even"},
+	"math.sin":                {"math.sin(x)", "Returns a float."},
+	"math.sqrt":               {"math.sqrt(x)", "Returns a float."},
+	"math.tan":                {"math.tan(x)", "Returns a float."},
+	"math.trunc":              {"math.trunc(x)", "Returns an integer."},
+	"max":                     {"max(a, b, ...)", "Smallest or largest of the arguments, or of a single iterable: `max([4, 9, 2])` is `9`."},
+	"middleware.cors":         {"middleware.cors()", "Add CORS headers to response."},
+	"middleware.logger":       {"middleware.logger()", "Request logging middleware."},
+	"min":                     {"min(a, b, ...)", "Smallest or largest of the arguments, or of a single iterable: `max([4, 9, 2])` is `9`."},
+	"mutex":                   {"mutex()", "A lock: `m.lock()`, `m.unlock()`, `m.try_lock()` and `m.do(fn)`."},
+	"next":                    {"next(it, default)", "Next value, or `default` (`None`) when exhausted"},
+	"parallel_map":            {"parallel_map(items, fn, workers)", "A pool runs calls at most `size` at a time (default: number of CPUs, capped by the `FLOWA_MAX_WORKERS` environment variable)."},
+	"pool":                    {"pool(size)", "A pool runs calls at most `size` at a time (default: number of CPUs, capped by the `FLOWA_MAX_WORKERS` environment variable)."},
+	"print":                   {"print(value...)", "Print values to console."},
+	"push":                    {"push(items, value)", "New array with `value` appended."},
+	"puts":                    {"puts(value...)", "Alias of `print`."},
+	"race":                    {"race(tasks)", "`gather` returns every result in order; the first error cancels the rest and is returned."},
+	"random.choice":           {"random.choice(items)", "One element"},
+	"random.randint":          {"random.randint(a, b)", "Integer in `[a, b]`, both ends included"},
+	"random.random":           {"random.random()", "Float in `[0, 1)`"},
+	"random.sample":           {"random.sample(items, k)", "`k` elements at distinct positions"},
+	"random.seed":             {"random.seed(n)", "Reseeds the generator"},
+	"random.shuffle":          {"random.shuffle(items)", "New shuffled array; `items` is unchanged"},
+	"random.uniform":          {"random.uniform(a, b)", "Float between `a` and `b`"},
+	"range":                   {"range(stop)", "Lazy integer sequence."},
+	"re.compile":              {"re.compile(pattern, flags)", "Compile a pattern."},
+	"re.escape":               {"re.escape(s)", "Escape all metacharacters in `s`."},
+	"re.find_all":             {"re.find_all(pattern, s)", "All matches as an array: the matched strings, the group's value when the pattern has one group, or a tuple per match when it has several."},
+	"re.full_match":           {"re.full_match(pattern, s)", "Return a `Match` or `None`."},
+	"re.match":                {"re.match(pattern, s)", "Return a `Match` or `None`."},
+	"re.replace":              {"re.replace(pattern, s, repl, count)", "Replace matches (all of them unless `count` is given)."},
+	"re.search":               {"re.search(pattern, s)", "Return a `Match` or `None`."},
+	"re.split":                {"re.split(pattern, s, max_split)", "Split `s` around matches, at most `max_split` times when given."},
+	"reduce":                  {"reduce(items, fn, initial)", "Folded value"},
+	"response.bytes":          {"response.bytes(data, content_type, status)", "Send binary data."},
+	"response.html":           {"response.html(html, status)", "Create HTML response."},
+	"response.json":           {"response.json(data, status)", "Create JSON response."},
+	"response.redirect":       {"response.redirect(url, status)", "Create redirect response."},
+	"response.text":           {"response.text(text, status)", "Create plain text response."},
+	"rest":                    {"rest(items)", "Every element but the first, as a new array."},
+	"route":                   {"route(method, path, handler, middleware)", "Register `handler(req)` for `method` and `path` (`:name` segments become `req.params`)."},
+	"schedule.cron":           {"schedule.cron(spec, fn)", "Register `fn()` as a job and return it."},
+	"schedule.every":          {"schedule.every(interval, fn)", "Register `fn()` as a job and return it."},
+	"schedule.jobs":           {"schedule.jobs()", "All registered jobs."},
+	"secrets.choice":          {"secrets.choice(items)", "One element"},
+	"secrets.compare":         {"secrets.compare(a, b)", "Constant-time string equality"},
+	"secrets.randint":         {"secrets.randint(a, b)", "Integer in `[a, b]`"},
+	"secrets.token_hex":       {"secrets.token_hex(nbytes)", "Hex string of `nbytes` random bytes (default 32)"},
+	"secrets.token_urlsafe":   {"secrets.token_urlsafe(nbytes)", "URL-safe base64 string (default 32 bytes)"},
+	"set":                     {"set(items)", "A set of the unique elements of `items`; `set()` is empty."},
+	"take":                    {"take(items, n)", "First `n` values; lazy for non-arrays"},
+	"tap":                     {"tap(fn)", "Pipeline stage that calls `fn(value)` for its side effect and passes the value on."},
+	"time.date":               {"time.date(year, month, day, hour, minute, second, zone)", "Build a time."},
+	"time.duration":           {"time.duration(text)", "Parse a duration string such as `\"1h30m\"` or `\"2d\"`."},
+	"time.format":             {"time.format(t, layout)", "Format a time; `layout` defaults to RFC 3339."},
+	"time.from_unix":          {"time.from_unix(seconds)", "Convert to and from Unix timestamps."},
+	"time.in_zone":            {"time.in_zone(t, zone)", "The same instant shown in another time zone."},
+	"time.now":                {"time.now(zone)", "Current time, in the local zone unless `zone` is given (`\"UTC\"`, `\"Asia/Kolkata\"`, ...)."},
+	"time.parse":              {"time.parse(value, layout, zone)", "Parse a string."},
+	"time.since":              {"time.since(t)", "Duration elapsed since `t`, or remaining until `t`."},
+	"time.sleep":              {"time.sleep(duration)", "Pause the current task."},
+	"time.unix":               {"time.unix(t)", "Convert to and from Unix timestamps."},
+	"time.unix_ms":            {"time.unix_ms(t)", "Convert to and from Unix timestamps."},
+	"time.until":              {"time.until(t)", "Duration elapsed since `t`, or remaining until `t`."},
+	"url.decode":              {"url.decode(s)", "Query component escaping (`a b` → `a+b`)"},
+	"url.encode":              {"url.encode(s)", "Query component escaping (`a b` → `a+b`)"},
+	"url.parse":               {"url.parse(s)", "Map of `scheme`, `host`, `port`, `path`, `query` (a map) and `fragment`"},
+	"url.path_encode":         {"url.path_encode(s)", "Path segment escaping (`a b` → `a%20b`)"},
+	"url.query_decode":        {"url.query_decode(s)", "Map; repeated keys become arrays"},
+	"url.query_encode":        {"url.query_encode(map)", "`\"a=1&b=2\"`, keys sorted; array values repeat the key"},
+	"use_middleware":          {"use_middleware(fn)", "Register `fn(req, next)` to run before every route."},
+	"uuid.is_valid":           {"uuid.is_valid(s)", "Whether `s` is a UUID string"},
+	"uuid.v4":                 {"uuid.v4()", "Random UUID"},
+	"uuid.v7":                 {"uuid.v7()", "Time-ordered UUID; IDs sort by creation time, which suits database keys"},
+	"wait_group":              {"wait_group()", "Counts tasks in and out: `wg.add(n)`, `wg.done()`, `wg.wait()`."},
+	"websocket.close":         {"websocket.close(conn)", "Close WebSocket connection."},
+	"websocket.messages":      {"websocket.messages(conn)", "Iterate over incoming messages until the client disconnects."},
+	"websocket.read":          {"websocket.read(conn)", "Read next message from client (blocking)."},
+	"websocket.send":          {"websocket.send(conn, message)", "Send a message to the client."},
+	"websocket.upgrade":       {"websocket.upgrade(req)", "Upgrade HTTP connection to WebSocket."},
+	"zip":                     {"zip(a, b, ...)", "Lazy tuples, stops at the shortest input"},
+}
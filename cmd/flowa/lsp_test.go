@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flowa/pkg/format"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspClient drives an in-process server through a pair of pipes.
+type lspClient struct {
+	t      *testing.T
+	w      io.Writer
+	r      *bufio.Reader
+	nextID int
+	done   chan error
+
+	// Notifications received while waiting for responses
+	notifications []rpcNotification
+}
+
+func newLSPClient(t *testing.T) *lspClient {
+	t.Helper()
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &lspClient{t: t, w: clientOut, r: bufio.NewReader(clientIn), done: make(chan error, 1)}
+	go func() {
+		c.done <- newLSPServer(serverIn, serverOut).serve()
+		serverOut.Close()
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+
+	var init struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &init)
+	if init.Capabilities["hoverProvider"] != true {
+		t.Fatalf("expected hover support, got %v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func (c *lspClient) send(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (c *lspClient) receive() map[string]json.RawMessage {
+	c.t.Helper()
+	length := 0
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+			length, _ = strconv.Atoi(v)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatalf("reading body: %v", err)
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("decoding %s: %v", body, err)
+	}
+	return msg
+}
+
+func (c *lspClient) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// call sends a request and decodes the result of its response into result.
+func (c *lspClient) call(method string, params, result interface{}) {
+	c.t.Helper()
+	c.nextID++
+	id := c.nextID
+	c.send(map[string]interface{}{"id": id, "method": method, "params": params})
+	for {
+		msg := c.receive()
+		if _, ok := msg["method"]; ok {
+			var n rpcNotification
+			raw, _ := json.Marshal(msg)
+			json.Unmarshal(raw, &n)
+			c.notifications = append(c.notifications, n)
+			continue
+		}
+		if string(msg["id"]) != strconv.Itoa(id) {
+			c.t.Fatalf("response for id %s, expected %d", msg["id"], id)
+		}
+		if errMsg, ok := msg["error"]; ok {
+			c.t.Fatalf("%s failed: %s", method, errMsg)
+		}
+		if err := json.Unmarshal(msg["result"], result); err != nil {
+			c.t.Fatalf("decoding %s result %s: %v", method, msg["result"], err)
+		}
+		return
+	}
+}
+
+// open sends didOpen and returns the diagnostics published for uri.
+func (c *lspClient) open(uri, text string) []lspDiagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "flowa", "version": 1, "text": text},
+	})
+	msg := c.receive()
+	var n struct {
+		Method string `json:"method"`
+		Params struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		} `json:"params"`
+	}
+	raw, _ := json.Marshal(msg)
+	json.Unmarshal(raw, &n)
+	if n.Method != "textDocument/publishDiagnostics" || n.Params.URI != uri {
+		c.t.Fatalf("expected diagnostics for %s, got %s", uri, raw)
+	}
+	return n.Params.Diagnostics
+}
+
+func at(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+const lspSource = `from "lib.flowa" import slugify
+
+# Adds two numbers.
+def add(a, b):
+    return a + b
+
+type Point:
+    x
+    y
+
+def show(req):
+    total = add(1, 2)
+    return response.text(json.encode(slugify(total)))
+
+service Api on ":8080":
+    get "/show" -> show
+`
+
+func TestLSPDiagnostics(t *testing.T) {
+	c := newLSPClient(t)
+
+	diagnostics := c.open("file:///tmp/bad.flowa", "x = 1\ny = = 2\n")
+	if len(diagnostics) == 0 {
+		t.Fatal("expected a diagnostic for the stray =")
+	}
+	if d := diagnostics[0]; d.Severity != 1 || d.Range.Start != (lspPosition{1, 4}) || d.Range.End != (lspPosition{1, 5}) {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+
+	if diagnostics := c.open("file:///tmp/good.flowa", lspSource); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %+v", diagnostics)
+	}
+}
+
+func TestLSPNavigation(t *testing.T) {
+	dir := t.TempDir()
+	lib := "def slugify(text):\n    return text\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.flowa"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(filepath.Join(dir, "app.flowa"))
+
+	c := newLSPClient(t)
+	c.open(uri, lspSource)
+
+	// add(1, 2) on line 11 jumps to the def on line 3
+	var loc lspLocation
+	c.call("textDocument/definition", at(uri, 11, 13), &loc)
+	if loc.URI != uri || loc.Range.Start != (lspPosition{3, 4}) || loc.Range.End != (lspPosition{3, 7}) {
+		t.Errorf("definition of add: got %+v", loc)
+	}
+
+	// Imported symbols resolve into the other file
+	c.call("textDocument/definition", at(uri, 12, 42), &loc)
+	if loc.URI != pathToURI(filepath.Join(dir, "lib.flowa")) || loc.Range.Start != (lspPosition{0, 4}) {
+		t.Errorf("definition of slugify: got %+v", loc)
+	}
+
+	// Route handlers are references too
+	var refs []lspLocation
+	c.call("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": 10, "character": 5},
+		"context":      map[string]bool{"includeDeclaration": true},
+	}, &refs)
+	if len(refs) != 2 || refs[1].Range.Start != (lspPosition{15, 19}) {
+		t.Errorf("references to show: got %+v", refs)
+	}
+}
+
+func TestLSPHoverAndCompletion(t *testing.T) {
+	uri := "file:///tmp/app.flowa"
+	c := newLSPClient(t)
+	c.open(uri, lspSource+"x = json.\n")
+
+	tests := []struct {
+		line, character int
+		expected        string
+	}{
+		{11, 13, "def add(a, b)\n```\n\nAdds two numbers."},
+		{12, 21, "response.text(text, status)"},
+		{12, 32, "json.encode(data)"},
+		{6, 6, "type Point(x, y)"},
+	}
+	for _, tt := range tests {
+		var hover *lspHover
+		c.call("textDocument/hover", at(uri, tt.line, tt.character), &hover)
+		if hover == nil || !strings.Contains(hover.Contents.Value, tt.expected) {
+			t.Errorf("hover at %d:%d: expected %q, got %+v", tt.line, tt.character, tt.expected, hover)
+		}
+	}
+
+	var items []lspCompletionItem
+	c.call("textDocument/completion", at(uri, 16, 9), &items)
+	labels := map[string]lspCompletionItem{}
+	for _, item := range items {
+		labels[item.Label] = item
+	}
+	if item, ok := labels["encode"]; !ok || item.Kind != completionMethod || item.Detail != "json.encode(data)" {
+		t.Errorf("expected json members, got %+v", items)
+	}
+	if _, ok := labels["print"]; ok {
+		t.Errorf("member completion should not offer globals")
+	}
+
+	c.call("textDocument/completion", at(uri, 11, 5), &items)
+	found := false
+	for _, item := range items {
+		found = found || item.Label == "total"
+		if !strings.HasPrefix(item.Label, "t") {
+			t.Errorf("completion for prefix t offered %q", item.Label)
+		}
+	}
+	if !found {
+		t.Errorf("expected the local variable total, got %+v", items)
+	}
+}
+
+func TestLSPSymbolsAndFormatting(t *testing.T) {
+	uri := "file:///tmp/app.flowa"
+	c := newLSPClient(t)
+	c.open(uri, lspSource)
+
+	var symbols []lspSymbol
+	c.call("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}}, &symbols)
+	var names []string
+	for _, sym := range symbols {
+		names = append(names, sym.Name)
+	}
+	if strings.Join(names, " ") != "add Point show Api" {
+		t.Fatalf("unexpected symbols %v", names)
+	}
+	api := symbols[3]
+	if len(api.Children) != 1 || api.Children[0].Name != "GET /show" || api.Range.End.Line != 15 {
+		t.Errorf("unexpected service symbol %+v", api)
+	}
+
+	messy := "def f( a ):\n    return a+1\n"
+	c.open("file:///tmp/messy.flowa", messy)
+	var edits []lspTextEdit
+	c.call("textDocument/formatting", map[string]interface{}{"textDocument": map[string]string{"uri": "file:///tmp/messy.flowa"}}, &edits)
+	want, _ := format.Source(messy)
+	if len(edits) != 1 || edits[0].NewText != want || edits[0].Range.End != (lspPosition{2, 0}) {
+		t.Errorf("unexpected edits %+v", edits)
+	}
+
+	var result interface{}
+	c.call("shutdown", nil, &result)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("server exited with %v", err)
+	}
+}
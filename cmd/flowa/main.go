@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"flowa/pkg/ast"
 	"flowa/pkg/eval"
 	"flowa/pkg/lexer"
@@ -11,9 +10,13 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -43,7 +46,7 @@ func main() {
 
 	// If the first argument ends with .flowa, treat it as a file to run
 	if len(command) > 6 && command[len(command)-6:] == ".flowa" {
-		runFile(command)
+		runFile(command, nil)
 		return
 	}
 
@@ -52,11 +55,7 @@ func main() {
 	case "repl":
 		startREPL()
 	case "run":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: flowa run <file>")
-			os.Exit(1)
-		}
-		runFile(os.Args[2])
+		runCommand(os.Args[2:])
 	case "eval":
 		if len(os.Args) < 3 {
 			fmt.Println("Usage: flowa eval '<code>'")
@@ -81,6 +80,16 @@ func main() {
 			os.Exit(1)
 		}
 		printProgramAST(os.Args[2])
+	case "fmt":
+		runFmt(os.Args[2:])
+	case "lint":
+		runLint(os.Args[2:])
+	case "lsp":
+		runLSP(os.Args[2:])
+	case "test":
+		runTests(os.Args[2:])
+	case "debug":
+		runDebug(os.Args[2:])
 	case "version":
 		printVersion()
 	case "help":
@@ -101,6 +110,11 @@ func printUsage() {
 	fmt.Println("  flowa repl               Start interactive REPL")
 	fmt.Println("  flowa run <file>         Run a Flowa script (explicit)")
 	fmt.Println("  flowa eval '<code>'      Evaluate a Flowa expression")
+	fmt.Println("  flowa fmt [paths...]     Format Flowa source files")
+	fmt.Println("  flowa lint [paths...]    Report likely bugs in Flowa source files")
+	fmt.Println("  flowa lsp                Start the language server for editors")
+	fmt.Println("  flowa test [paths...]    Run test_* functions in *_test.flowa files")
+	fmt.Println("  flowa debug <file>       Step through a script with breakpoints")
 	fmt.Println("  flowa uninstall          Remove the Flowa binary from this machine")
 	fmt.Println("  flowa version            Show version information")
 	fmt.Println("  flowa help               Show this help message")
@@ -109,39 +123,8 @@ func printUsage() {
 	fmt.Println("  -h, --help               Show this help message")
 }
 
-func startREPL() {
-	scanner := bufio.NewScanner(os.Stdin)
-	env := eval.NewEnvironment()
-
-	fmt.Println("Flowa REPL v0.1 (MVP)")
-	fmt.Println("Type expressions or statements and press Enter")
-
-	for {
-		fmt.Print(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
-			return
-		}
-
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(os.Stdout, p.Errors())
-			continue
-		}
-
-		evaluated := eval.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(os.Stdout, evaluated.Inspect())
-			io.WriteString(os.Stdout, "\n")
-		}
-	}
-}
-
-func runFile(filename string) {
+// runFile runs a script; with prof set it is profiled as well.
+func runFile(filename string, prof *profileOptions) {
 	program, parserErrors, err := parseProgramFromFile(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
@@ -152,12 +135,46 @@ func runFile(filename string) {
 		os.Exit(1)
 	}
 
+	// Servers only stop on a signal, so the profile is written then too
+	var p *profiler
+	if prof != nil {
+		p = newProfiler(filename)
+		p.begin()
+	}
+	// The signal handler and the script both end up here; whichever is
+	// first writes the profile and picks the status, the other blocks
+	var once sync.Once
+	exit := func(code int) {
+		once.Do(func() {
+			if p != nil {
+				p.finish(prof)
+			}
+			os.Exit(code)
+		})
+	}
+
+	// On Ctrl-C or SIGTERM, let scheduled jobs finish before exiting with
+	// the status a shell reports for the signal
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-shutdown
+		eval.DefaultScheduler.Stop(10 * time.Second)
+		exit(128 + int(sig.(syscall.Signal)))
+	}()
+
 	env := eval.NewEnvironment()
 	evaluated := eval.Eval(program, env)
 	if evaluated != nil && evaluated.Type() == "ERROR" {
 		fmt.Fprintf(os.Stderr, "%s\n", evaluated.Inspect())
-		os.Exit(1)
+		exit(1)
 	}
+
+	// A script that only schedules jobs keeps running them
+	if eval.DefaultScheduler.Jobs() > 0 {
+		eval.DefaultScheduler.Wait()
+	}
+	exit(0)
 }
 
 func inspectFile(filename string) {
@@ -233,7 +250,11 @@ func printFunctionInsights(functions []FunctionInfo) {
 		if fn.IsAsync {
 			marker = "async def"
 		}
-		fmt.Printf("  · %s %s(%s)\n", marker, fn.Name, strings.Join(fn.Parameters, ", "))
+		suffix := ""
+		if fn.IsGenerator {
+			suffix = " (generator)"
+		}
+		fmt.Printf("  · %s %s(%s)%s\n", marker, fn.Name, strings.Join(fn.Parameters, ", "), suffix)
 	}
 }
 
@@ -260,11 +281,16 @@ func printHelp() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  flowa <file.flowa>      Run a Flowa script (shortcut for 'flowa run')")
-	fmt.Println("  flowa run <file>        Execute a script")
+	fmt.Println("  flowa run <file>        Execute a script (--profile, --pprof, --folded)")
 	fmt.Println("  flowa repl              Start the interactive REPL")
 	fmt.Println("  flowa inspect <file>    Summarize functions and pipelines")
 	fmt.Println("  flowa pipelines <file>  Render pipeline chains")
 	fmt.Println("  flowa ast <file>        Print the program AST")
+	fmt.Println("  flowa fmt [paths...]    Format source files (--check, --diff for CI)")
+	fmt.Println("  flowa lint [paths...]   Static checks (--json, configured by .flowalint)")
+	fmt.Println("  flowa lsp               Language server over stdio for editor integration")
+	fmt.Println("  flowa test [paths...]   Run tests (-v, --run, --parallel, --json, --junit, --cover)")
+	fmt.Println("  flowa debug <file>      Interactive debugger (--dap for editors)")
 	fmt.Println("  flowa uninstall         Remove the globally installed binary")
 	fmt.Println("  flowa version           Display build metadata")
 	fmt.Println("  flowa help              Show this help message")
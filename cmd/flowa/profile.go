@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"flowa/pkg/ast"
+	"flowa/pkg/eval"
+	"fmt"
+	"io"
+	"os"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The profiler runs a program with eval.Hooks installed and times every
+// call of a Flowa function and every |> stage. Each finished call adds
+// its self time to the stack it ran on; cumulative times, the report,
+// the pprof profile and the folded stacks are all derived from those
+// stacks, so recursion is not counted twice. The profiler's own work
+// around a call is charged to nobody. Allocations come from the Go
+// runtime's heap counters and include the interpreter's own work on
+// behalf of the call.
+
+const runUsage = "Usage: flowa run [--profile] [--pprof file] [--folded file] <file>"
+
+type profileOptions struct {
+	report bool   // print the report to stderr
+	pprof  string // write a gzipped pprof profile here
+	folded string // write folded stacks for flamegraph tools here
+}
+
+func runCommand(args []string) {
+	var opts profileOptions
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--profile":
+			opts.report = true
+		case arg == "--pprof" && i+1 < len(args):
+			i++
+			opts.pprof = args[i]
+		case arg == "--folded" && i+1 < len(args):
+			i++
+			opts.folded = args[i]
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(os.Stderr, "Unknown flag for run: %s\n", arg)
+			fmt.Fprintln(os.Stderr, runUsage)
+			os.Exit(2)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 1 {
+		fmt.Println(runUsage)
+		os.Exit(1)
+	}
+	if !opts.report && opts.pprof == "" && opts.folded == "" {
+		runFile(files[0], nil)
+		return
+	}
+	runFile(files[0], &opts)
+}
+
+// profileNode is a function or a pipeline stage, as it appears in
+// reports and stacks.
+type profileNode struct {
+	stage bool
+	name  string
+	line  int
+	col   int // stages only
+}
+
+// less orders functions before stages, then by name and position.
+func (n profileNode) less(o profileNode) bool {
+	switch {
+	case n.stage != o.stage:
+		return !n.stage
+	case n.name != o.name:
+		return n.name < o.name
+	case n.line != o.line:
+		return n.line < o.line
+	}
+	return n.col < o.col
+}
+
+func (n profileNode) label() string {
+	if n.stage {
+		return fmt.Sprintf("|> %s @%d:%d", n.name, n.line, n.col)
+	}
+	return n.name
+}
+
+// profileStats are the totals of one node or one stack.
+type profileStats struct {
+	calls      int64
+	cum        time.Duration
+	self       time.Duration
+	allocs     uint64
+	allocBytes uint64
+}
+
+// profileStack is the self time and allocations of the calls that ran
+// with the same nodes, outermost first, on the stack. Stacks form a tree,
+// so a call finds its stack among the children of its caller's.
+type profileStack struct {
+	nodes    []profileNode
+	children map[profileNode]*profileStack
+	profileStats
+}
+
+// profileCall is a call or stage that has not finished yet.
+type profileCall struct {
+	stack   *profileStack
+	entered time.Time // when the profiler was told of the call
+	start   time.Time // when the profiler handed over to it
+	heap    heapCounters
+	child   time.Duration
+	childH  heapCounters
+}
+
+type heapCounters struct{ objects, bytes uint64 }
+
+func (h heapCounters) sub(o heapCounters) heapCounters {
+	if o.objects > h.objects || o.bytes > h.bytes {
+		return heapCounters{}
+	}
+	return heapCounters{h.objects - o.objects, h.bytes - o.bytes}
+}
+
+func (h heapCounters) add(o heapCounters) heapCounters {
+	return heapCounters{h.objects + o.objects, h.bytes + o.bytes}
+}
+
+var heapMetrics = []string{"/gc/heap/allocs:objects", "/gc/heap/allocs:bytes"}
+
+func readHeap() heapCounters {
+	samples := []metrics.Sample{{Name: heapMetrics[0]}, {Name: heapMetrics[1]}}
+	metrics.Read(samples)
+	var h heapCounters
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		h.objects = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		h.bytes = samples[1].Value.Uint64()
+	}
+	return h
+}
+
+type profiler struct {
+	file string
+
+	mu     sync.Mutex
+	calls  map[*eval.Frame]*profileCall   // functions that are running
+	stages map[*eval.Frame][]*profileCall // stages running in each frame
+	nodes  map[profileNode]*profileStats
+	root   profileStack    // its children are calls made from the top level
+	stacks []*profileStack // every stack below root
+
+	start time.Time
+	wall  time.Duration
+	top   time.Duration // time spent in calls made from the top level
+}
+
+func newProfiler(file string) *profiler {
+	return &profiler{
+		file:   file,
+		calls:  map[*eval.Frame]*profileCall{},
+		stages: map[*eval.Frame][]*profileCall{},
+		nodes:  map[profileNode]*profileStats{},
+	}
+}
+
+func (p *profiler) begin() {
+	p.start = time.Now()
+	eval.SetHooks(&eval.Hooks{
+		Enter:      p.enter,
+		Leave:      p.leave,
+		EnterStage: p.enterStage,
+		LeaveStage: p.leaveStage,
+	})
+}
+
+// end stops recording; calls still running are left out.
+func (p *profiler) end() {
+	eval.SetHooks(nil)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.wall == 0 {
+		p.wall = time.Since(p.start)
+	}
+}
+
+// parent returns the innermost unfinished call or stage that frame runs
+// in, or nil at the top level.
+func (p *profiler) parent(frame *eval.Frame) *profileCall {
+	for ; frame != nil; frame = frame.Caller {
+		if stages := p.stages[frame]; len(stages) > 0 {
+			return stages[len(stages)-1]
+		}
+		if call := p.calls[frame]; call != nil {
+			return call
+		}
+	}
+	return nil
+}
+
+func (p *profiler) push(parent *profileCall, node profileNode, entered time.Time) *profileCall {
+	under := &p.root
+	if parent != nil {
+		under = parent.stack
+	}
+	stack := under.children[node]
+	if stack == nil {
+		stack = &profileStack{nodes: append(append([]profileNode(nil), under.nodes...), node)}
+		if under.children == nil {
+			under.children = map[profileNode]*profileStack{}
+		}
+		under.children[node] = stack
+		p.stacks = append(p.stacks, stack)
+	}
+	call := &profileCall{stack: stack, entered: entered, heap: readHeap()}
+	call.start = time.Now()
+	return call
+}
+
+// pop records a finished call and charges its time to parent.
+func (p *profiler) pop(call, parent *profileCall) {
+	elapsed := time.Since(call.start)
+	heap := readHeap().sub(call.heap)
+	self := elapsed - call.child
+	if self < 0 {
+		self = 0
+	}
+	selfHeap := heap.sub(call.childH)
+
+	s := call.stack
+	node := s.nodes[len(s.nodes)-1]
+	stats := p.nodes[node]
+	if stats == nil {
+		stats = &profileStats{}
+		p.nodes[node] = stats
+	}
+	stats.calls++
+	stats.allocs += selfHeap.objects
+	stats.allocBytes += selfHeap.bytes
+
+	s.calls++
+	s.self += self
+	s.allocs += selfHeap.objects
+	s.allocBytes += selfHeap.bytes
+
+	// The caller's self time leaves out the profiler's work too
+	spent := time.Since(call.entered)
+	if parent != nil {
+		parent.child += spent
+		parent.childH = parent.childH.add(heap)
+	} else {
+		p.top += spent
+	}
+}
+
+func functionNode(fn *eval.Function) profileNode {
+	node := profileNode{name: fn.Name}
+	if fn.Body != nil {
+		node.line = fn.Body.Token.Line
+	}
+	return node
+}
+
+func (p *profiler) enter(frame *eval.Frame) {
+	entered := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[frame] = p.push(p.parent(frame.Caller), functionNode(frame.Function), entered)
+}
+
+func (p *profiler) leave(frame *eval.Frame, result eval.Object) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call := p.calls[frame]
+	if call == nil {
+		return
+	}
+	delete(p.calls, frame)
+	p.pop(call, p.parent(frame.Caller))
+}
+
+func (p *profiler) enterStage(frame *eval.Frame, stage *ast.PipelineExpression) {
+	entered := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	node := profileNode{stage: true, name: stage.Right.String(), line: stage.Token.Line, col: stage.Token.Column}
+	p.stages[frame] = append(p.stages[frame], p.push(p.parent(frame), node, entered))
+}
+
+func (p *profiler) leaveStage(frame *eval.Frame, stage *ast.PipelineExpression, result eval.Object) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stages := p.stages[frame]
+	if len(stages) == 0 {
+		return
+	}
+	call := stages[len(stages)-1]
+	if len(stages) == 1 {
+		delete(p.stages, frame)
+	} else {
+		p.stages[frame] = stages[:len(stages)-1]
+	}
+	p.pop(call, p.parent(frame))
+}
+
+// summary returns per-node totals with cumulative time filled in: the
+// self time of every stack the node appears in, once per stack.
+func (p *profiler) summary() map[profileNode]*profileStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := map[profileNode]*profileStats{}
+	for node, stats := range p.nodes {
+		copied := *stats
+		out[node] = &copied
+	}
+	for _, s := range p.stacks {
+		seen := map[profileNode]bool{}
+		for _, node := range s.nodes {
+			if !seen[node] {
+				seen[node] = true
+				out[node].cum += s.self
+			}
+		}
+		out[s.nodes[len(s.nodes)-1]].self += s.self
+	}
+	return out
+}
+
+func (p *profiler) writeReport(w io.Writer) {
+	summary := p.summary()
+	var functions, stages []profileNode
+	for node := range summary {
+		if node.stage {
+			stages = append(stages, node)
+		} else {
+			functions = append(functions, node)
+		}
+	}
+	byCum := func(nodes []profileNode) {
+		sort.Slice(nodes, func(i, j int) bool {
+			a, b := summary[nodes[i]], summary[nodes[j]]
+			if a.cum != b.cum {
+				return a.cum > b.cum
+			}
+			return nodes[i].label() < nodes[j].label()
+		})
+	}
+	byCum(functions)
+	byCum(stages)
+
+	fmt.Fprintf(w, "\nProfile of %s: %s wall, %s at top level\n", p.file, formatDuration(p.wall), formatDuration(p.topSelf()))
+	row := func(name string, s *profileStats) {
+		fmt.Fprintf(w, "  %-32s %8d %10s %10s %10d %10s\n", name, s.calls, formatDuration(s.cum), formatDuration(s.self), s.allocs, formatBytes(s.allocBytes))
+	}
+	fmt.Fprintf(w, "\nFunctions (%d)\n", len(functions))
+	if len(functions) > 0 {
+		fmt.Fprintf(w, "  %-32s %8s %10s %10s %10s %10s\n", "function", "calls", "cum", "self", "allocs", "bytes")
+	}
+	for _, node := range functions {
+		row(fmt.Sprintf("%s (line %d)", node.name, node.line), summary[node])
+	}
+	fmt.Fprintf(w, "\nPipeline stages (%d)\n", len(stages))
+	if len(stages) > 0 {
+		fmt.Fprintf(w, "  %-32s %8s %10s %10s %10s %10s\n", "stage", "calls", "cum", "self", "allocs", "bytes")
+	}
+	for _, node := range stages {
+		row(fmt.Sprintf("%d:%d |> %s", node.line, node.col, node.name), summary[node])
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.1fµs", float64(d)/float64(time.Microsecond))
+	}
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// topSelf is the time spent at the top level outside any call.
+func (p *profiler) topSelf() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.top > p.wall {
+		return 0
+	}
+	return p.wall - p.top
+}
+
+// sortedStacks returns a copy of the recorded stacks in a stable order.
+func (p *profiler) sortedStacks() []profileStack {
+	p.mu.Lock()
+	stacks := make([]profileStack, len(p.stacks))
+	for i, s := range p.stacks {
+		stacks[i] = *s
+	}
+	p.mu.Unlock()
+	sort.Slice(stacks, func(i, j int) bool {
+		a, b := stacks[i].nodes, stacks[j].nodes
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k].less(b[k])
+			}
+		}
+		return len(a) < len(b)
+	})
+	return stacks
+}
+
+// folded is the stack in folded form: labels from the top level down,
+// separated by semicolons.
+func (s profileStack) folded() string {
+	parts := []string{"<module>"}
+	for _, node := range s.nodes {
+		parts = append(parts, strings.ReplaceAll(node.label(), ";", ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// writeFolded writes one "stack nanoseconds" line per stack, the input
+// format of flamegraph.pl and speedscope.
+func (p *profiler) writeFolded(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "<module> %d\n", p.topSelf().Nanoseconds()); err != nil {
+		return err
+	}
+	for _, s := range p.sortedStacks() {
+		if _, err := fmt.Fprintf(w, "%s %d\n", s.folded(), s.self.Nanoseconds()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePprof writes the stacks as a gzipped profile.proto message that
+// `go tool pprof` reads. Each stack is one sample valued with its self
+// time, allocated objects and allocated bytes.
+func (p *profiler) writePprof(w io.Writer) error {
+	var pb protoBuffer
+	strs := map[string]int64{}
+	str := func(s string) int64 {
+		if id, ok := strs[s]; ok {
+			return id
+		}
+		id := int64(len(strs))
+		strs[s] = id
+		return id
+	}
+	str("")
+
+	valueType := func(field int, typ, unit string) {
+		var vt protoBuffer
+		vt.int(1, str(typ))
+		vt.int(2, str(unit))
+		pb.bytes(field, vt.Bytes())
+	}
+	valueType(1, "time", "nanoseconds")
+	valueType(1, "alloc_objects", "count")
+	valueType(1, "alloc_space", "bytes")
+
+	// One function and one location per node. pprof drops <...> from
+	// names, so the top level is plain "module".
+	ids := map[profileNode]uint64{}
+	var locations protoBuffer
+	location := func(label string, node profileNode) uint64 {
+		if id, ok := ids[node]; ok {
+			return id
+		}
+		id := uint64(len(ids) + 1)
+		ids[node] = id
+
+		var fn protoBuffer
+		fn.uint(1, id)
+		fn.int(2, str(label))
+		fn.int(3, str(label))
+		fn.int(4, str(p.file))
+		fn.int(5, int64(node.line))
+		locations.bytes(5, fn.Bytes())
+
+		var line, loc protoBuffer
+		line.uint(1, id)
+		line.int(2, int64(node.line))
+		loc.uint(1, id)
+		loc.bytes(4, line.Bytes())
+		locations.bytes(4, loc.Bytes())
+		return id
+	}
+	module := location("module", profileNode{})
+	var top protoBuffer
+	top.packedUints(1, []uint64{module})
+	top.packedUints(2, []uint64{uint64(p.topSelf().Nanoseconds()), 0, 0})
+	pb.bytes(2, top.Bytes())
+
+	for _, s := range p.sortedStacks() {
+		var locIDs []uint64
+		for i := len(s.nodes) - 1; i >= 0; i-- { // leaf first
+			locIDs = append(locIDs, location(s.nodes[i].label(), s.nodes[i]))
+		}
+		locIDs = append(locIDs, module)
+		var sample protoBuffer
+		sample.packedUints(1, locIDs)
+		sample.packedUints(2, []uint64{uint64(s.self.Nanoseconds()), s.allocs, s.allocBytes})
+		pb.bytes(2, sample.Bytes())
+	}
+	pb.Write(locations.Bytes())
+
+	table := make([]string, len(strs))
+	for s, id := range strs {
+		table[id] = s
+	}
+	for _, s := range table {
+		pb.bytes(6, []byte(s))
+	}
+	pb.int(9, p.start.UnixNano())
+	pb.int(10, p.wall.Nanoseconds())
+	pb.int(14, str("time")) // default_sample_type
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(pb.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer appends protobuf wire-format fields.
+type protoBuffer struct{ bytes.Buffer }
+
+func (b *protoBuffer) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (b *protoBuffer) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protoBuffer) int(field int, v int64) { b.uint(field, uint64(v)) }
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protoBuffer) packedUints(field int, vs []uint64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	b.bytes(field, packed.Bytes())
+}
+
+// finish writes everything opts asks for once the program is done.
+func (p *profiler) finish(opts *profileOptions) {
+	p.end()
+	if opts.report {
+		p.writeReport(os.Stderr)
+	}
+	write := func(path string, fn func(io.Writer) error) {
+		f, err := os.Create(path)
+		if err == nil {
+			err = fn(f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing profile: %v\n", err)
+		}
+	}
+	if opts.pprof != "" {
+		write(opts.pprof, p.writePprof)
+	}
+	if opts.folded != "" {
+		write(opts.folded, p.writeFolded)
+	}
+}
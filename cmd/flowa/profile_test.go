@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flowa/pkg/eval"
+	"flowa/pkg/lexer"
+	"flowa/pkg/parser"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const profileSource = `def twice(x):
+    return x * 2
+
+def fact(n):
+    if n < 2:
+        return 1
+    return n * fact(n - 1)
+
+doubled = [1, 2, 3] |> map(twice)
+total = fact(5)
+`
+
+func profileRun(t *testing.T) *profiler {
+	t.Helper()
+	p := parser.New(lexer.New(profileSource))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parser errors: %v", errs)
+	}
+	prof := newProfiler("app.flowa")
+	prof.begin()
+	result := eval.Eval(program, eval.NewEnvironment())
+	prof.end()
+	if result != nil && result.Type() == "ERROR" {
+		t.Fatalf("eval error: %s", result.Inspect())
+	}
+	return prof
+}
+
+func TestProfilerCounts(t *testing.T) {
+	prof := profileRun(t)
+	summary := prof.summary()
+
+	calls := map[string]int64{}
+	for node, stats := range summary {
+		calls[node.label()] = stats.calls
+		if stats.self > stats.cum {
+			t.Errorf("%s: self %v exceeds cumulative %v", node.label(), stats.self, stats.cum)
+		}
+	}
+	expected := map[string]int64{"twice": 3, "fact": 5, "|> map(twice) @9:21": 1}
+	for label, n := range expected {
+		if calls[label] != n {
+			t.Errorf("%s: expected %d calls, got %d (all: %v)", label, n, calls[label], calls)
+		}
+	}
+
+	// Recursion counts once towards cumulative time
+	fact := profileNode{name: "fact", line: 4}
+	if summary[fact].cum > prof.wall {
+		t.Errorf("fact cumulative %v exceeds wall time %v", summary[fact].cum, prof.wall)
+	}
+
+	var report bytes.Buffer
+	prof.writeReport(&report)
+	for _, want := range []string{"Functions (2)", "fact (line 4)", "Pipeline stages (1)", "9:21 |> map(twice)"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, report.String())
+		}
+	}
+}
+
+func TestProfilerOutputs(t *testing.T) {
+	prof := profileRun(t)
+
+	var folded bytes.Buffer
+	if err := prof.writeFolded(&folded); err != nil {
+		t.Fatal(err)
+	}
+	var stacks []string
+	for _, line := range strings.Split(strings.TrimSpace(folded.String()), "\n") {
+		stacks = append(stacks, line[:strings.LastIndex(line, " ")])
+	}
+	got := strings.Join(stacks, "\n")
+	expected := strings.Join([]string{
+		"<module>",
+		"<module>;fact",
+		"<module>;fact;fact",
+		"<module>;fact;fact;fact",
+		"<module>;fact;fact;fact;fact",
+		"<module>;fact;fact;fact;fact;fact",
+		"<module>;|> map(twice) @9:21",
+		"<module>;|> map(twice) @9:21;twice",
+	}, "\n")
+	if got != expected {
+		t.Errorf("unexpected folded stacks:\n%s", got)
+	}
+
+	var pprof bytes.Buffer
+	if err := prof.writePprof(&pprof); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&pprof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"time", "nanoseconds", "alloc_space", "app.flowa", "module", "fact", "|> map(twice) @9:21"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("profile is missing string %q", want)
+		}
+	}
+}
+
+// The time reported for a function stays close to how long it takes
+// without the profiler; hooks used to make it dozens of times slower.
+func TestProfilerOverhead(t *testing.T) {
+	src := "def fib(n):\n    if n < 2:\n        return n\n    return fib(n - 1) + fib(n - 2)\n\nx = fib(18)\n"
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parser errors: %v", errs)
+	}
+
+	// The fastest of a few runs, to keep the test from being flaky
+	plain, profiled := time.Duration(1<<62), time.Duration(1<<62)
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		eval.Eval(program, eval.NewEnvironment())
+		plain = min(plain, time.Since(start))
+
+		prof := newProfiler("fib.flowa")
+		prof.begin()
+		eval.Eval(program, eval.NewEnvironment())
+		prof.end()
+		profiled = min(profiled, prof.summary()[profileNode{name: "fib", line: 1}].cum)
+	}
+	if profiled > 5*plain {
+		t.Errorf("fib took %v unprofiled but %v profiled", plain, profiled)
+	}
+}
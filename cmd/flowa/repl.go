@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"flowa/pkg/ast"
+	"flowa/pkg/eval"
+	"flowa/pkg/lexer"
+	"flowa/pkg/parser"
+	"flowa/pkg/token"
+	"flowa/pkg/version"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const CONTINUATION_PROMPT = "... "
+
+// historyLimit bounds the persistent REPL history.
+const historyLimit = 1000
+
+// lineReader is the input side of the REPL: an interactive terminal, or
+// plain lines when stdin is piped.
+type lineReader interface {
+	ReadLine() (string, error)
+	SetPrompt(prompt string)
+}
+
+// scanReader reads piped input line by line, echoing prompts so the
+// transcript reads like a session.
+type scanReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+	prompt  string
+}
+
+func (r *scanReader) SetPrompt(prompt string) { r.prompt = prompt }
+
+func (r *scanReader) ReadLine() (string, error) {
+	io.WriteString(r.out, r.prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// fileHistory keeps entered lines in memory for up/down navigation and
+// appends them to a file so they survive between sessions.
+type fileHistory struct {
+	entries []string // oldest first
+	path    string
+}
+
+// historyPath is $FLOWA_HISTORY, or ~/.flowa_history.
+func historyPath() string {
+	if path := os.Getenv("FLOWA_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".flowa_history")
+}
+
+func loadHistory(path string) *fileHistory {
+	h := &fileHistory{path: path}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > historyLimit {
+		h.entries = h.entries[len(h.entries)-historyLimit:]
+		// Rewrite the file so it doesn't grow without bound
+		os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0o600)
+	}
+	return h
+}
+
+func (h *fileHistory) Add(entry string) {
+	if strings.TrimSpace(entry) == "" {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == entry {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if h.path == "" {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, entry)
+}
+
+func (h *fileHistory) Len() int { return len(h.entries) }
+
+// At returns the idx-th most recent entry.
+func (h *fileHistory) At(idx int) string { return h.entries[len(h.entries)-1-idx] }
+
+// repl holds the state of one interactive session.
+type repl struct {
+	env      *eval.Environment
+	builtins map[string]eval.Object // bindings of a fresh environment, hidden from :env
+	out      io.Writer
+}
+
+func newREPL(out io.Writer) *repl {
+	r := &repl{out: out}
+	r.reset()
+	return r
+}
+
+func (r *repl) reset() {
+	r.env = eval.NewEnvironment()
+	r.builtins = make(map[string]eval.Object)
+	for _, name := range r.env.Names() {
+		r.builtins[name], _ = r.env.Get(name)
+	}
+}
+
+func startREPL() {
+	fmt.Println("Flowa REPL v" + version.Version)
+	fmt.Println("Blocks continue with '...'; finish them with an empty line. Type :help for commands.")
+
+	var in lineReader
+	r := newREPL(os.Stdout)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err == nil {
+			defer term.Restore(fd, state)
+			t := term.NewTerminal(struct {
+				io.Reader
+				io.Writer
+			}{os.Stdin, os.Stdout}, PROMPT)
+			t.History = loadHistory(historyPath())
+			t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+				if key != '\t' {
+					return "", 0, false
+				}
+				return r.complete(t, line, pos)
+			}
+			if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+				t.SetSize(width, height)
+			}
+			// Output goes through the terminal, which adds the "\r" that
+			// raw mode needs
+			in, r.out = t, t
+		}
+	}
+	if in == nil {
+		in = &scanReader{scanner: bufio.NewScanner(os.Stdin), out: os.Stdout, prompt: PROMPT}
+	}
+
+	r.run(in)
+}
+
+// run reads and evaluates input until EOF or :quit.
+func (r *repl) run(in lineReader) {
+	var lines []string
+	for {
+		if len(lines) == 0 {
+			in.SetPrompt(PROMPT)
+		} else {
+			in.SetPrompt(CONTINUATION_PROMPT)
+		}
+		line, err := in.ReadLine()
+		if err != nil {
+			if len(lines) > 0 && err == io.EOF {
+				r.evalSource(strings.Join(lines, "\n"))
+			}
+			io.WriteString(r.out, "\n")
+			return
+		}
+
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if !r.command(strings.TrimSpace(line)) {
+				return
+			}
+			continue
+		}
+
+		lines = append(lines, line)
+		source := strings.Join(lines, "\n")
+		if inputIncomplete(source) {
+			continue
+		}
+		lines = nil
+		r.evalSource(source)
+	}
+}
+
+// inputIncomplete reports whether src needs more lines before it can be
+// run: an open bracket or string, a trailing `:`, or an indented block
+// that hasn't been closed with an empty line yet.
+func inputIncomplete(src string) bool {
+	if strings.TrimSpace(src) == "" {
+		return false
+	}
+	if unterminatedString(src) {
+		return true
+	}
+
+	l := lexer.New(src)
+	depth := 0
+	indented := false
+	var last token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth--
+		case token.INDENT:
+			indented = true
+		}
+		if tok.Type != token.NEWLINE && tok.Type != token.INDENT && tok.Type != token.DEDENT {
+			last = tok
+		}
+	}
+	if depth > 0 || last.Type == token.COLON {
+		return true
+	}
+	// As in Python, an empty line ends a block
+	return indented && !strings.HasSuffix(src, "\n")
+}
+
+// unterminatedString reports whether src ends inside a string literal.
+func unterminatedString(src string) bool {
+	inString, inComment := false, false
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case inComment:
+			inComment = c != '\n'
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case c == '#' && !inString:
+			inComment = true
+		}
+	}
+	return inString
+}
+
+func (r *repl) evalSource(source string) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(r.out, p.Errors())
+		return
+	}
+	evaluated := eval.Eval(program, r.env)
+	if evaluated == nil || evaluated == eval.NULL || len(program.Statements) == 0 {
+		return
+	}
+	// Echo the value of a trailing expression, but not of `x = 1` or `def`
+	_, isExpr := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	if isExpr || evaluated.Type() == "ERROR" {
+		io.WriteString(r.out, evaluated.Inspect()+"\n")
+	}
+}
+
+var replCommands = []struct{ name, args, help string }{
+	{":load", "<file>", "run a file in the current session"},
+	{":ast", "<code>", "show how code parses"},
+	{":type", "<expr>", "evaluate expr and show its type"},
+	{":env", "", "list the bindings made in this session"},
+	{":reset", "", "start over with a fresh environment"},
+	{":help", "", "show this help"},
+	{":quit", "", "leave the REPL (or press Ctrl-D)"},
+}
+
+// command runs a `:` meta-command and reports whether the REPL should
+// keep going.
+func (r *repl) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case ":quit", ":q", ":exit":
+		return false
+	case ":help":
+		for _, c := range replCommands {
+			fmt.Fprintf(r.out, "  %-16s %s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+		}
+	case ":reset":
+		r.reset()
+		fmt.Fprintln(r.out, "Environment reset.")
+	case ":env":
+		for _, name := range r.env.Names() {
+			val, _ := r.env.Get(name)
+			if r.builtins[name] == val {
+				continue
+			}
+			fmt.Fprintf(r.out, "  %s = %s\n", name, summarize(val))
+		}
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.out, "Usage: :load <file>")
+			break
+		}
+		program, parserErrors, err := parseProgramFromFile(arg)
+		if err != nil {
+			fmt.Fprintf(r.out, "Error reading file: %v\n", err)
+			break
+		}
+		if len(parserErrors) != 0 {
+			printParserErrors(r.out, parserErrors)
+			break
+		}
+		if evaluated := eval.Eval(program, r.env); evaluated != nil && evaluated.Type() == "ERROR" {
+			fmt.Fprintln(r.out, evaluated.Inspect())
+			break
+		}
+		fmt.Fprintf(r.out, "Loaded %s\n", arg)
+	case ":ast", ":type":
+		if arg == "" {
+			fmt.Fprintf(r.out, "Usage: %s <code>\n", name)
+			break
+		}
+		p := parser.New(lexer.New(arg))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserErrors(r.out, p.Errors())
+			break
+		}
+		if name == ":ast" {
+			for _, stmt := range program.Statements {
+				fmt.Fprintf(r.out, "%T: %s\n", stmt, stmt.String())
+			}
+			break
+		}
+		evaluated := eval.Eval(program, r.env)
+		if evaluated == nil {
+			evaluated = eval.NULL
+		}
+		fmt.Fprintln(r.out, evaluated.Type())
+	default:
+		fmt.Fprintf(r.out, "Unknown command %s. Type :help for a list.\n", name)
+	}
+	return true
+}
+
+// summarize shortens a value's representation for :env.
+func summarize(val eval.Object) string {
+	s := val.Inspect()
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i] + " ..."
+	}
+	if len(s) > 60 {
+		s = s[:57] + "..."
+	}
+	return s
+}
+
+// complete handles Tab. At the start of a line it indents; otherwise it
+// completes the identifier or `module.member` before the cursor, listing
+// the candidates when there is more than one.
+func (r *repl) complete(w io.Writer, line string, pos int) (string, int, bool) {
+	before := line[:pos]
+	if strings.TrimSpace(before) == "" {
+		return before + "    " + line[pos:], pos + 4, true
+	}
+
+	start := pos
+	for start > 0 && isWordByte(before[start-1]) {
+		start--
+	}
+	word := before[start:]
+	candidates := r.completions(word)
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) > 1 && prefix == word {
+		fmt.Fprintln(w, strings.Join(candidates, "  "))
+		return "", 0, false
+	}
+	return line[:start] + prefix + line[pos:], start + len(prefix), true
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// completions returns the sorted candidates for word, which is either an
+// identifier prefix or a dotted path ending in a member prefix.
+func (r *repl) completions(word string) []string {
+	var names []string
+	var base string
+	if i := strings.LastIndexByte(word, '.'); i >= 0 {
+		base = word[:i+1]
+		obj, ok := r.lookup(word[:i])
+		if !ok {
+			return nil
+		}
+		names = memberNames(obj)
+	} else {
+		names = append(r.env.Names(), token.Keywords()...)
+	}
+
+	var out []string
+	for _, name := range names {
+		if strings.HasPrefix(base+name, word) {
+			out = append(out, base+name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// lookup resolves a dotted path such as `http` or `app.config`.
+func (r *repl) lookup(path string) (eval.Object, bool) {
+	parts := strings.Split(path, ".")
+	obj, ok := r.env.Get(parts[0])
+	for _, part := range parts[1:] {
+		if !ok {
+			return nil, false
+		}
+		switch v := obj.(type) {
+		case *eval.StructInstance:
+			obj, ok = v.Fields[part]
+		case *eval.Module:
+			obj, ok = v.Env.Get(part)
+		default:
+			return nil, false
+		}
+	}
+	return obj, ok
+}
+
+func memberNames(obj eval.Object) []string {
+	var names []string
+	switch v := obj.(type) {
+	case *eval.StructInstance:
+		for name := range v.Fields {
+			names = append(names, name)
+		}
+	case *eval.Module:
+		names = v.Env.Names()
+	case *eval.Map:
+		for key := range v.Pairs {
+			if s, ok := key.(*eval.String); ok {
+				names = append(names, s.Value)
+			}
+		}
+	}
+	return names
+}
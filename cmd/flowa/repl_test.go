@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInputIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"x = 1", false},
+		{"", false},
+		{"def add(a, b):", true},
+		{"def add(a, b):\n    return a + b", true},
+		{"def add(a, b):\n    return a + b\n", false},
+		{"nums = [1,", true},
+		{"nums = [1,\n  2]", false},
+		{`s = "open`, true},
+		{`s = "a \" quote"`, false},
+		{`x = 1  # "not a string`, false},
+		{"m = {\"a\": 1,", true},
+	}
+
+	for _, tt := range tests {
+		if got := inputIncomplete(tt.input); got != tt.expected {
+			t.Errorf("%q - expected %t, got %t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// lines feeds the REPL a fixed script.
+type lines struct{ input []string }
+
+func (l *lines) SetPrompt(string) {}
+func (l *lines) ReadLine() (string, error) {
+	if len(l.input) == 0 {
+		return "", io.EOF
+	}
+	line := l.input[0]
+	l.input = l.input[1:]
+	return line, nil
+}
+
+func TestREPLSession(t *testing.T) {
+	var out strings.Builder
+	r := newREPL(&out)
+	r.run(&lines{input: []string{
+		"def double(n):",
+		"    return n * 2",
+		"",
+		"double(21)",
+		"total = 0",
+		":type total",
+		":env",
+		":reset",
+		":env",
+		"double(1)",
+	}})
+
+	expected := "42\nINTEGER\n  double = function\n  total = 0\nEnvironment reset.\nERROR: identifier not found: double\n\n"
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected=%q\ngot=     %q", expected, out.String())
+	}
+}
+
+func TestREPLCompletion(t *testing.T) {
+	r := newREPL(&strings.Builder{})
+	r.evalSource("counter = 1")
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"coun", "counter"},
+		{"x = json.enc", "x = json.encode"},
+		{"", "    "},
+		{"nosuchname", "nosuchname"},
+	}
+
+	for _, tt := range tests {
+		var listed strings.Builder
+		line, _, ok := r.complete(&listed, tt.line, len(tt.line))
+		if !ok {
+			line = tt.line
+		}
+		if line != tt.expected {
+			t.Errorf("%q - expected %q, got %q", tt.line, tt.expected, line)
+		}
+	}
+
+	var listed strings.Builder
+	if _, _, ok := r.complete(&listed, "json.", 5); ok || !strings.Contains(listed.String(), "json.decode") {
+		t.Errorf("expected json members to be listed, got %q", listed.String())
+	}
+}
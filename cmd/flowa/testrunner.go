@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flowa/pkg/ast"
+	"flowa/pkg/eval"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tests live in files named *_test.flowa. Every top-level function whose
+// name starts with test_ is a test; top-level setup() and teardown()
+// functions run before and after each one. Each test gets a fresh
+// environment in which the whole file is evaluated again, so tests
+// cannot see each other's variables.
+
+const testUsage = "Usage: flowa test [-v] [--run regexp] [--parallel n] [--json] [--junit file] [--cover] [--coverhtml file] [--lcov file] [files or directories...]"
+
+// testCase is one test function together with the file that defines it.
+type testCase struct {
+	file     string
+	program  *ast.Program
+	fn       *ast.FunctionStatement
+	setup    *ast.FunctionStatement
+	teardown *ast.FunctionStatement
+}
+
+// TestResult is the outcome of a single test.
+type TestResult struct {
+	File     string  `json:"file"`
+	Name     string  `json:"name"`
+	Passed   bool    `json:"passed"`
+	Duration float64 `json:"duration_ms"`
+	Message  string  `json:"message,omitempty"`
+	Output   string  `json:"output,omitempty"`
+}
+
+type testOptions struct {
+	verbose  bool
+	filter   *regexp.Regexp
+	parallel int
+	asJSON   bool
+	junit    string
+
+	cover     bool // also set by coverHTML and lcov
+	coverHTML string
+	lcov      string
+}
+
+func runTests(args []string) {
+	opts := testOptions{parallel: 1}
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-v":
+			opts.verbose = true
+		case arg == "--json":
+			opts.asJSON = true
+		case arg == "--run" && i+1 < len(args):
+			i++
+			re, err := regexp.Compile(args[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid --run pattern: %v\n", err)
+				os.Exit(2)
+			}
+			opts.filter = re
+		case arg == "--parallel" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "Error: --parallel expects a positive number, got %q\n", args[i])
+				os.Exit(2)
+			}
+			opts.parallel = n
+		case arg == "--junit" && i+1 < len(args):
+			i++
+			opts.junit = args[i]
+		case arg == "--cover":
+			opts.cover = true
+		case arg == "--coverhtml" && i+1 < len(args):
+			i++
+			opts.coverHTML, opts.cover = args[i], true
+		case arg == "--lcov" && i+1 < len(args):
+			i++
+			opts.lcov, opts.cover = args[i], true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(os.Stderr, "Unknown flag for test: %s\n", arg)
+			fmt.Fprintln(os.Stderr, testUsage)
+			os.Exit(2)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := testFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	var cases []testCase
+	failed := false
+	for _, file := range files {
+		program, parserErrors, err := parseProgramFromFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			failed = true
+			continue
+		}
+		if len(parserErrors) != 0 {
+			fmt.Fprintf(os.Stderr, "%s:\n", file)
+			printParserErrors(os.Stderr, parserErrors)
+			failed = true
+			continue
+		}
+		cases = append(cases, discoverTests(file, program, opts.filter)...)
+	}
+	if failed {
+		os.Exit(2)
+	}
+	if len(cases) == 0 {
+		fmt.Println("no tests to run")
+		return
+	}
+
+	var cov *coverage
+	if opts.cover {
+		cov = newCoverage()
+		eval.SetHooks(cov.hooks())
+	}
+
+	start := time.Now()
+	var out io.Writer = os.Stdout
+	if opts.asJSON {
+		out = io.Discard
+	}
+	results := executeTests(cases, opts.parallel, func(r TestResult) {
+		printTestResult(out, r, opts.verbose)
+	})
+	elapsed := time.Since(start)
+	if cov != nil {
+		eval.SetHooks(nil)
+	}
+
+	if opts.asJSON {
+		passed, failures := countResults(results)
+		report := map[string]interface{}{
+			"passed":      passed,
+			"failed":      failures,
+			"duration_ms": milliseconds(elapsed),
+			"tests":       results,
+		}
+		if cov != nil {
+			report["coverage"] = cov.summaries()
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printTestSummary(os.Stdout, results, elapsed)
+		if cov != nil {
+			cov.writeSummary(os.Stdout)
+		}
+	}
+
+	if cov != nil {
+		if err := writeCoverageReports(cov, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing coverage report: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if opts.junit != "" {
+		if err := writeJUnit(opts.junit, results, elapsed); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JUnit report: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if _, failures := countResults(results); failures > 0 {
+		os.Exit(1)
+	}
+}
+
+// testFiles expands directories to the *_test.flowa files inside them.
+// Files named explicitly are run whatever their name.
+func testFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		found, err := flowaFiles([]string{path})
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range found {
+			if strings.HasSuffix(filepath.Base(file), "_test.flowa") {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// discoverTests returns the test_* functions of a file that match filter,
+// in source order.
+func discoverTests(file string, program *ast.Program, filter *regexp.Regexp) []testCase {
+	var tests []*ast.FunctionStatement
+	var setup, teardown *ast.FunctionStatement
+	for _, stmt := range program.Statements {
+		fn, ok := stmt.(*ast.FunctionStatement)
+		if !ok {
+			continue
+		}
+		switch name := fn.Name.Value; {
+		case name == "setup":
+			setup = fn
+		case name == "teardown":
+			teardown = fn
+		case strings.HasPrefix(name, "test_") && (filter == nil || filter.MatchString(name)):
+			tests = append(tests, fn)
+		}
+	}
+
+	cases := make([]testCase, len(tests))
+	for i, fn := range tests {
+		cases[i] = testCase{file: file, program: program, fn: fn, setup: setup, teardown: teardown}
+	}
+	return cases
+}
+
+// executeTests runs cases on up to parallel workers and hands each
+// result to report in discovery order as soon as it is available.
+func executeTests(cases []testCase, parallel int, report func(TestResult)) []TestResult {
+	results := make([]TestResult, len(cases))
+	done := make([]chan struct{}, len(cases))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	jobs := make(chan int)
+	for w := 0; w < parallel; w++ {
+		go func() {
+			for i := range jobs {
+				results[i] = runTestCase(cases[i])
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range cases {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for i := range cases {
+		<-done[i]
+		report(results[i])
+	}
+	return results
+}
+
+// syncBuffer collects a test's output; spawned tasks may print
+// concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func runTestCase(tc testCase) (result TestResult) {
+	// Routes, jobs and event subscriptions a test registers are its own,
+	// so tests running side by side never see each other's
+	var out syncBuffer
+	env := eval.NewIsolatedEnvironment()
+	env.SetOutput(&out)
+	env.Scheduler().Log = &out
+
+	start := time.Now()
+	result = TestResult{File: tc.file, Name: tc.fn.Name.Value}
+	defer func() {
+		if r := recover(); r != nil {
+			result.Message = fmt.Sprintf("panic: %v", r)
+		}
+		env.Scheduler().Stop(time.Second)
+		result.Passed = result.Message == ""
+		result.Duration = milliseconds(time.Since(start))
+		result.Output = out.String()
+	}()
+
+	if errObj, ok := eval.Eval(tc.program, env).(*eval.ErrorObj); ok {
+		result.Message = "loading " + tc.file + ": " + errObj.Message
+		return
+	}
+	if tc.setup != nil {
+		if msg := callTestFunction(tc.setup, env); msg != "" {
+			result.Message = "setup: " + msg
+			return
+		}
+	}
+	result.Message = callTestFunction(tc.fn, env)
+	if tc.teardown != nil {
+		if msg := callTestFunction(tc.teardown, env); msg != "" && result.Message == "" {
+			result.Message = "teardown: " + msg
+		}
+	}
+	return
+}
+
+// callTestFunction calls fn without arguments and returns the error
+// message it fails with.
+func callTestFunction(fn *ast.FunctionStatement, env *eval.Environment) string {
+	call := &ast.CallExpression{Token: fn.Token, Function: fn.Name}
+	if errObj, ok := eval.Eval(call, env).(*eval.ErrorObj); ok {
+		return errObj.Message
+	}
+	return ""
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func countResults(results []TestResult) (passed, failed int) {
+	for _, r := range results {
+		if r.Passed {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	return passed, failed
+}
+
+// printTestResult reports failures, and with verbose also passes, along
+// with the output the test printed.
+func printTestResult(w io.Writer, r TestResult, verbose bool) {
+	if r.Passed && !verbose {
+		return
+	}
+	status := "PASS"
+	if !r.Passed {
+		status = "FAIL"
+	}
+	fmt.Fprintf(w, "--- %s: %s (%s, %.1fms)\n", status, r.Name, r.File, r.Duration)
+	if r.Message != "" {
+		fmt.Fprintf(w, "    %s\n", r.Message)
+	}
+	if output := strings.TrimRight(r.Output, "\n"); output != "" {
+		for _, line := range strings.Split(output, "\n") {
+			fmt.Fprintf(w, "    | %s\n", line)
+		}
+	}
+}
+
+func printTestSummary(w io.Writer, results []TestResult, elapsed time.Duration) {
+	passed, failed := countResults(results)
+	if failed > 0 {
+		fmt.Fprintf(w, "FAIL: %d failed, %d passed (%.1fms)\n", failed, passed, milliseconds(elapsed))
+		return
+	}
+	fmt.Fprintf(w, "ok: %d passed (%.1fms)\n", passed, milliseconds(elapsed))
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes results as JUnit XML with one testsuite per file.
+func writeJUnit(path string, results []TestResult, elapsed time.Duration) error {
+	seconds := func(ms float64) string { return strconv.FormatFloat(ms/1000, 'f', 3, 64) }
+
+	report := junitTestSuites{Time: seconds(milliseconds(elapsed))}
+	suites := map[string]int{}
+	durations := map[string]float64{}
+	for _, r := range results {
+		i, ok := suites[r.File]
+		if !ok {
+			i = len(report.Suites)
+			suites[r.File] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: r.File})
+		}
+		suite := &report.Suites[i]
+		tc := junitTestCase{
+			Name:      r.Name,
+			Classname: strings.TrimSuffix(filepath.Base(r.File), ".flowa"),
+			Time:      seconds(r.Duration),
+			SystemOut: r.Output,
+		}
+		if !r.Passed {
+			tc.Failure = &junitFailure{Message: r.Message, Text: r.Message}
+			suite.Failures++
+			report.Failures++
+		}
+		suite.Tests++
+		report.Tests++
+		durations[r.File] += r.Duration
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for i := range report.Suites {
+		report.Suites[i].Time = seconds(durations[report.Suites[i].Name])
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0o644)
+}
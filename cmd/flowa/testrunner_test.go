@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/xml"
+	"flowa/pkg/eval"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const runnerSource = `calls = []
+
+def setup():
+    global calls
+    calls = push(calls, "setup")
+
+def teardown():
+    print("teardown after", len(calls))
+
+def test_isolated():
+    global calls
+    calls = push(calls, "test")
+    assert len(calls) == 2
+
+def test_fresh_state():
+    assert.equal(calls, ["setup"])
+
+def test_fails():
+    print("looking at", calls)
+    assert len(calls) == 5, "wrong count"
+
+def helper():
+    return 1
+`
+
+func TestRunnerDiscoveryAndIsolation(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"calls_test.flowa":         runnerSource,
+		"lib.flowa":                "def test_not_a_test():\n    assert False\n",
+		"nested/second_test.flowa": "def test_second():\n    assert 1 + 1 == 2\n",
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := testFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []testCase
+	for _, file := range files {
+		program, errs, err := parseProgramFromFile(file)
+		if err != nil || len(errs) > 0 {
+			t.Fatalf("parsing %s: %v %v", file, err, errs)
+		}
+		cases = append(cases, discoverTests(file, program, nil)...)
+	}
+
+	var reported []string
+	results := executeTests(cases, 3, func(r TestResult) {
+		reported = append(reported, r.Name)
+	})
+	if got := strings.Join(reported, " "); got != "test_isolated test_fresh_state test_fails test_second" {
+		t.Fatalf("unexpected tests or order: %s", got)
+	}
+
+	expected := []struct {
+		passed  bool
+		message string
+		output  string
+	}{
+		{true, "", "teardown after 2\n"},
+		{true, "", "teardown after 1\n"},
+		{false, "assertion failed: wrong count", "looking at [setup]\nteardown after 1\n"},
+		{true, "", ""},
+	}
+	for i, want := range expected {
+		r := results[i]
+		if r.Passed != want.passed || r.Message != want.message || r.Output != want.output {
+			t.Errorf("%s: expected %+v, got %+v", r.Name, want, r)
+		}
+	}
+
+	// Filtering by name
+	program, _, _ := parseProgramFromFile(files[0])
+	filtered := discoverTests(files[0], program, regexp.MustCompile("fresh|fails"))
+	if len(filtered) != 2 || filtered[0].fn.Name.Value != "test_fresh_state" {
+		t.Errorf("unexpected filtered tests %+v", filtered)
+	}
+
+	// Failing hooks fail the test
+	hooks := "def setup():\n    return 1 / 0\ndef test_x():\n    assert True\n"
+	if err := os.WriteFile(filepath.Join(dir, "hooks_test.flowa"), []byte(hooks), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	program, _, _ = parseProgramFromFile(filepath.Join(dir, "hooks_test.flowa"))
+	results = executeTests(discoverTests("hooks_test.flowa", program, nil), 1, func(TestResult) {})
+	if results[0].Passed || results[0].Message != "setup: division by zero" {
+		t.Errorf("expected a setup failure, got %+v", results[0])
+	}
+}
+
+const isolationSource = `from "greet.flowa" import greet
+
+def on_ping(payload, topic):
+    return payload
+
+def tick():
+    return 1
+
+def test_first():
+    greet("first")
+    events.on("ping", on_ping)
+    schedule.every("1h", tick)
+    assert.equal([events.subscribers("ping"), len(schedule.jobs())], [1, 1])
+
+def test_second():
+    greet("second")
+    events.on("ping", on_ping)
+    schedule.every("1h", tick)
+    assert.equal([events.subscribers("ping"), len(schedule.jobs())], [1, 1])
+`
+
+func TestRunnerIsolatesRegistrationsAndImports(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for name, src := range map[string]string{
+		"greet.flowa":      "print(\"loading greet\")\n\ndef greet(name):\n    print(\"hello\", name)\n",
+		"greet_test.flowa": isolationSource,
+	} {
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	program, errs, err := parseProgramFromFile("greet_test.flowa")
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("parsing: %v %v", err, errs)
+	}
+
+	for _, parallel := range []int{1, 2} {
+		results := executeTests(discoverTests("greet_test.flowa", program, nil), parallel, func(TestResult) {})
+		for i, name := range []string{"first", "second"} {
+			r := results[i]
+			if !r.Passed {
+				t.Errorf("parallel %d: %s failed: %s", parallel, r.Name, r.Message)
+			}
+			if want := "loading greet\nhello " + name + "\n"; r.Output != want {
+				t.Errorf("parallel %d: %s: expected output %q, got %q", parallel, r.Name, want, r.Output)
+			}
+		}
+	}
+	if n := eval.DefaultScheduler.Jobs(); n != 0 {
+		t.Errorf("tests registered %d jobs on the default scheduler", n)
+	}
+}
+
+func TestRunnerJUnit(t *testing.T) {
+	results := []TestResult{
+		{File: "a_test.flowa", Name: "test_one", Passed: true, Duration: 1.5},
+		{File: "a_test.flowa", Name: "test_two", Message: "assertion failed: x", Output: "hi\n"},
+		{File: "b_test.flowa", Name: "test_three", Passed: true},
+	}
+	path := filepath.Join(t.TempDir(), "report.xml")
+	if err := writeJUnit(path, results, 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, data)
+	}
+	if report.Tests != 3 || report.Failures != 1 || len(report.Suites) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	a := report.Suites[0]
+	if a.Name != "a_test.flowa" || a.Tests != 2 || a.Time != "0.002" {
+		t.Errorf("unexpected suite %+v", a)
+	}
+	two := a.Cases[1]
+	if two.Classname != "a_test" || two.Failure == nil || two.Failure.Message != "assertion failed: x" || two.SystemOut != "hi\n" {
+		t.Errorf("unexpected failing case %+v", two)
+	}
+}
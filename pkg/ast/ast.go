@@ -3,7 +3,9 @@ package ast
 import (
 	"bytes"
 	"flowa/pkg/token"
+	"math/big"
 	"strings"
+	"time"
 )
 
 type Node interface {
@@ -78,6 +80,15 @@ type FunctionStatement struct {
 	Parameters []*Identifier
 	Body       *BlockStatement
 	IsAsync    bool
+
+	// IsGenerator is set when the body contains a `yield`; calling
+	// the function then returns a generator instead of running it.
+	IsGenerator bool
+
+	// ParameterPatterns holds destructuring patterns for parameters
+	// such as `def f([a, b]):`, indexed like Parameters. Plain
+	// parameters have a nil entry; the slice is nil if none destructure.
+	ParameterPatterns []Pattern
 }
 
 func (fs *FunctionStatement) statementNode()       {}
@@ -116,6 +127,26 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+// DestructureStatement assigns the parts of a value to several names,
+// e.g. `a, b = f()` or `[x, *rest] = arr`.
+type DestructureStatement struct {
+	Token  token.Token // the first token of the target
+	Target Pattern
+	Value  Expression
+}
+
+func (ds *DestructureStatement) statementNode()       {}
+func (ds *DestructureStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DestructureStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ds.Target.String())
+	out.WriteString(" = ")
+	if ds.Value != nil {
+		out.WriteString(ds.Value.String())
+	}
+	return out.String()
+}
+
 type AssignmentStatement struct {
 	Token token.Token // the identifier token
 	Name  *Identifier
@@ -154,6 +185,7 @@ func (ws *WhileStatement) String() string {
 type ForStatement struct {
 	Token    token.Token // 'for'
 	Iterator *Identifier
+	Target   Pattern    // Set instead of Iterator for `for k, v in ...`
 	Value    Expression // The thing being iterated over (e.g. range(10))
 	Body     *BlockStatement
 }
@@ -163,7 +195,11 @@ func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
 func (fs *ForStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("for ")
-	out.WriteString(fs.Iterator.String())
+	if fs.Target != nil {
+		out.WriteString(fs.Target.String())
+	} else {
+		out.WriteString(fs.Iterator.String())
+	}
 	out.WriteString(" in ")
 	out.WriteString(fs.Value.String())
 	out.WriteString(":")
@@ -266,6 +302,27 @@ func (ss *ServiceStatement) String() string {
 	return out.String()
 }
 
+// ScheduleStatement registers a background job:
+// `every "5m":` or `cron "0 3 * * *":`
+type ScheduleStatement struct {
+	Token token.Token // 'every' or 'cron'
+	Kind  string
+	Spec  Expression
+	Body  *BlockStatement
+}
+
+func (ss *ScheduleStatement) statementNode()       {}
+func (ss *ScheduleStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *ScheduleStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ss.Kind)
+	out.WriteString(" ")
+	out.WriteString(ss.Spec.String())
+	out.WriteString(":")
+	out.WriteString(ss.Body.String())
+	return out.String()
+}
+
 type RouteStatement struct {
 	Token   token.Token // 'get', 'post', etc.
 	Method  string      // "GET", "POST", etc.
@@ -315,6 +372,59 @@ func (ds *DeferStatement) String() string {
 	return out.String()
 }
 
+// AssertStatement fails with an error unless Condition is truthy:
+// `assert total == 3, "message"`
+type AssertStatement struct {
+	Token     token.Token // 'assert'
+	Condition Expression
+	Message   Expression // Optional
+}
+
+func (as *AssertStatement) statementNode()       {}
+func (as *AssertStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssertStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("assert ")
+	if as.Condition != nil {
+		out.WriteString(as.Condition.String())
+	}
+	if as.Message != nil {
+		out.WriteString(", ")
+		out.WriteString(as.Message.String())
+	}
+	return out.String()
+}
+
+type GlobalStatement struct {
+	Token token.Token // 'global'
+	Names []*Identifier
+}
+
+func (gs *GlobalStatement) statementNode()       {}
+func (gs *GlobalStatement) TokenLiteral() string { return gs.Token.Literal }
+func (gs *GlobalStatement) String() string {
+	names := []string{}
+	for _, n := range gs.Names {
+		names = append(names, n.String())
+	}
+	return "global " + strings.Join(names, ", ")
+}
+
+type NonlocalStatement struct {
+	Token token.Token // 'nonlocal'
+	Names []*Identifier
+}
+
+func (ns *NonlocalStatement) statementNode()       {}
+func (ns *NonlocalStatement) TokenLiteral() string { return ns.Token.Literal }
+func (ns *NonlocalStatement) String() string {
+	names := []string{}
+	for _, n := range ns.Names {
+		names = append(names, n.String())
+	}
+	return "nonlocal " + strings.Join(names, ", ")
+}
+
 // Expressions
 
 type Identifier struct {
@@ -329,12 +439,33 @@ func (i *Identifier) String() string       { return i.Value }
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // Set instead of Value when the literal does not fit in an int64
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// FloatLiteral is a literal such as `1.5` or `2e-3`.
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
+// DurationLiteral is a literal such as `500ms`, `2s` or `1h30m`.
+type DurationLiteral struct {
+	Token token.Token
+	Value time.Duration
+}
+
+func (dl *DurationLiteral) expressionNode()      {}
+func (dl *DurationLiteral) TokenLiteral() string { return dl.Token.Literal }
+func (dl *DurationLiteral) String() string       { return dl.Token.Literal }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -373,6 +504,9 @@ func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
 	out.WriteString(pe.Operator)
+	if pe.Operator == "not" {
+		out.WriteString(" ")
+	}
 	out.WriteString(pe.Right.String())
 	out.WriteString(")")
 	return out.String()
@@ -473,8 +607,9 @@ func (se *SpawnExpression) String() string {
 }
 
 type AwaitExpression struct {
-	Token token.Token // 'await'
-	Value Expression
+	Token   token.Token // 'await'
+	Value   Expression
+	Timeout Expression // Optional: `await task timeout 2s`
 }
 
 func (ae *AwaitExpression) expressionNode()      {}
@@ -483,9 +618,29 @@ func (ae *AwaitExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("await ")
 	out.WriteString(ae.Value.String())
+	if ae.Timeout != nil {
+		out.WriteString(" timeout ")
+		out.WriteString(ae.Timeout.String())
+	}
 	return out.String()
 }
 
+// YieldExpression suspends a generator, handing Value (None when
+// omitted) to whoever is iterating it.
+type YieldExpression struct {
+	Token token.Token // 'yield'
+	Value Expression
+}
+
+func (ye *YieldExpression) expressionNode()      {}
+func (ye *YieldExpression) TokenLiteral() string { return ye.Token.Literal }
+func (ye *YieldExpression) String() string {
+	if ye.Value == nil {
+		return "yield"
+	}
+	return "yield " + ye.Value.String()
+}
+
 type ArrayLiteral struct {
 	Token    token.Token // '['
 	Elements []Expression
@@ -505,6 +660,34 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
+type TupleLiteral struct {
+	Token    token.Token // '(' or the first token of a bare `a, b` list
+	Elements []Expression
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) String() string {
+	elements := []string{}
+	for _, el := range tl.Elements {
+		elements = append(elements, el.String())
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// SpreadExpression expands a sequence in place: `[*a, *b]`, `f(*args)`
+type SpreadExpression struct {
+	Token token.Token // '*'
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) String() string       { return "*" + se.Value.String() }
+
 type MapLiteral struct {
 	Token token.Token // '{'
 	Pairs []MapPair
@@ -529,6 +712,92 @@ func (ml *MapLiteral) String() string {
 	return out.String()
 }
 
+// SetLiteral is `{a, b, c}`. An empty `{}` is always a map.
+type SetLiteral struct {
+	Token    token.Token // '{'
+	Elements []Expression
+}
+
+func (sl *SetLiteral) expressionNode()      {}
+func (sl *SetLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *SetLiteral) String() string {
+	elements := make([]string, len(sl.Elements))
+	for i, el := range sl.Elements {
+		elements[i] = el.String()
+	}
+	return "{" + strings.Join(elements, ", ") + "}"
+}
+
+// ComprehensionClause is one `for target in iterable` part of a
+// comprehension, followed by any `if` filters.
+type ComprehensionClause struct {
+	Token      token.Token // 'for'
+	Target     Pattern
+	Iterable   Expression
+	Conditions []Expression
+}
+
+func (cc *ComprehensionClause) String() string {
+	var out bytes.Buffer
+	out.WriteString("for ")
+	out.WriteString(cc.Target.String())
+	out.WriteString(" in ")
+	out.WriteString(cc.Iterable.String())
+	for _, cond := range cc.Conditions {
+		out.WriteString(" if ")
+		out.WriteString(cond.String())
+	}
+	return out.String()
+}
+
+func comprehensionString(open, element, close string, clauses []*ComprehensionClause) string {
+	parts := []string{element}
+	for _, c := range clauses {
+		parts = append(parts, c.String())
+	}
+	return open + strings.Join(parts, " ") + close
+}
+
+// ListComprehension is `[element for x in xs if cond]`.
+type ListComprehension struct {
+	Token   token.Token // '['
+	Element Expression
+	Clauses []*ComprehensionClause
+}
+
+func (lc *ListComprehension) expressionNode()      {}
+func (lc *ListComprehension) TokenLiteral() string { return lc.Token.Literal }
+func (lc *ListComprehension) String() string {
+	return comprehensionString("[", lc.Element.String(), "]", lc.Clauses)
+}
+
+// MapComprehension is `{key: value for x in xs}`.
+type MapComprehension struct {
+	Token   token.Token // '{'
+	Key     Expression
+	Value   Expression
+	Clauses []*ComprehensionClause
+}
+
+func (mc *MapComprehension) expressionNode()      {}
+func (mc *MapComprehension) TokenLiteral() string { return mc.Token.Literal }
+func (mc *MapComprehension) String() string {
+	return comprehensionString("{", mc.Key.String()+": "+mc.Value.String(), "}", mc.Clauses)
+}
+
+// SetComprehension is `{element for x in xs}`.
+type SetComprehension struct {
+	Token   token.Token // '{'
+	Element Expression
+	Clauses []*ComprehensionClause
+}
+
+func (sc *SetComprehension) expressionNode()      {}
+func (sc *SetComprehension) TokenLiteral() string { return sc.Token.Literal }
+func (sc *SetComprehension) String() string {
+	return comprehensionString("{", sc.Element.String(), "}", sc.Clauses)
+}
+
 type MemberExpression struct {
 	Token    token.Token // '.'
 	Object   Expression
@@ -561,3 +830,262 @@ func (ie *IndexExpression) String() string {
 	out.WriteString("]")
 	return out.String()
 }
+
+// SliceExpression is `left[start:end]`; either bound may be omitted.
+type SliceExpression struct {
+	Token token.Token // The '[' token
+	Left  Expression
+	Start Expression // nil when omitted
+	End   Expression // nil when omitted
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("]")
+	return out.String()
+}
+
+type MatchStatement struct {
+	Token   token.Token // 'match'
+	Subject Expression
+	Cases   []*MatchCase
+}
+
+func (ms *MatchStatement) statementNode()       {}
+func (ms *MatchStatement) TokenLiteral() string { return ms.Token.Literal }
+func (ms *MatchStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("match ")
+	out.WriteString(ms.Subject.String())
+	out.WriteString(":\n")
+	for _, c := range ms.Cases {
+		out.WriteString(c.String())
+	}
+	return out.String()
+}
+
+type MatchCase struct {
+	Token   token.Token // 'case'
+	Pattern Pattern
+	Guard   Expression // Optional `if` condition
+	Body    *BlockStatement
+}
+
+func (mc *MatchCase) TokenLiteral() string { return mc.Token.Literal }
+func (mc *MatchCase) String() string {
+	var out bytes.Buffer
+	out.WriteString("case ")
+	out.WriteString(mc.Pattern.String())
+	if mc.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(mc.Guard.String())
+	}
+	out.WriteString(":")
+	out.WriteString(mc.Body.String())
+	return out.String()
+}
+
+// SelectCase kinds
+const (
+	SelectRecv    = "recv"
+	SelectSend    = "send"
+	SelectTimeout = "timeout"
+	SelectDefault = "default"
+)
+
+type SelectStatement struct {
+	Token token.Token // 'select'
+	Cases []*SelectCase
+}
+
+func (ss *SelectStatement) statementNode()       {}
+func (ss *SelectStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *SelectStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("select:\n")
+	for _, c := range ss.Cases {
+		out.WriteString(c.String())
+	}
+	return out.String()
+}
+
+// SelectCase is one arm of a select statement:
+// `case x = ch.recv():`, `case ch.send(v):`, `case timeout 1s:` or `case _:`
+type SelectCase struct {
+	Token   token.Token // 'case'
+	Kind    string
+	Target  *Identifier // Optional name bound to a received value
+	Channel Expression
+	Value   Expression // Sent value
+	Timeout Expression
+	Body    *BlockStatement
+}
+
+func (sc *SelectCase) TokenLiteral() string { return sc.Token.Literal }
+func (sc *SelectCase) String() string {
+	var out bytes.Buffer
+	out.WriteString("case ")
+	switch sc.Kind {
+	case SelectRecv:
+		if sc.Target != nil {
+			out.WriteString(sc.Target.String())
+			out.WriteString(" = ")
+		}
+		out.WriteString(sc.Channel.String())
+		out.WriteString(".recv()")
+	case SelectSend:
+		out.WriteString(sc.Channel.String())
+		out.WriteString(".send(")
+		out.WriteString(sc.Value.String())
+		out.WriteString(")")
+	case SelectTimeout:
+		out.WriteString("timeout ")
+		out.WriteString(sc.Timeout.String())
+	case SelectDefault:
+		out.WriteString("_")
+	}
+	out.WriteString(":")
+	out.WriteString(sc.Body.String())
+	return out.String()
+}
+
+// Patterns
+
+// Pattern is the left-hand side of a `case` arm. Patterns are matched
+// structurally against a value and may bind names.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// WildcardPattern matches anything without binding: `_`
+type WildcardPattern struct {
+	Token token.Token
+}
+
+func (wp *WildcardPattern) patternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// CapturePattern matches anything and binds it to Name.
+type CapturePattern struct {
+	Token token.Token
+	Name  *Identifier
+}
+
+func (cp *CapturePattern) patternNode()         {}
+func (cp *CapturePattern) TokenLiteral() string { return cp.Token.Literal }
+func (cp *CapturePattern) String() string       { return cp.Name.String() }
+
+// ValuePattern matches values equal to a literal or dotted name.
+type ValuePattern struct {
+	Token token.Token
+	Value Expression
+}
+
+func (vp *ValuePattern) patternNode()         {}
+func (vp *ValuePattern) TokenLiteral() string { return vp.Token.Literal }
+func (vp *ValuePattern) String() string       { return vp.Value.String() }
+
+// StarPattern captures the remaining elements of a sequence: `*rest`
+type StarPattern struct {
+	Token token.Token // '*'
+	Name  *Identifier // nil for `*_`
+}
+
+func (sp *StarPattern) patternNode()         {}
+func (sp *StarPattern) TokenLiteral() string { return sp.Token.Literal }
+func (sp *StarPattern) String() string {
+	if sp.Name == nil {
+		return "*_"
+	}
+	return "*" + sp.Name.String()
+}
+
+type ArrayPattern struct {
+	Token    token.Token // '['
+	Elements []Pattern
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// TuplePattern matches sequences written as `a, b` or `(a, b)`.
+type TuplePattern struct {
+	Token    token.Token
+	Elements []Pattern
+}
+
+func (tp *TuplePattern) patternNode()         {}
+func (tp *TuplePattern) TokenLiteral() string { return tp.Token.Literal }
+func (tp *TuplePattern) String() string {
+	elements := []string{}
+	for _, el := range tp.Elements {
+		elements = append(elements, el.String())
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+type MapPattern struct {
+	Token  token.Token // '{'
+	Keys   []Expression
+	Values []Pattern
+}
+
+func (mp *MapPattern) patternNode()         {}
+func (mp *MapPattern) TokenLiteral() string { return mp.Token.Literal }
+func (mp *MapPattern) String() string {
+	pairs := make([]string, 0, len(mp.Keys))
+	for i, key := range mp.Keys {
+		pairs = append(pairs, key.String()+": "+mp.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// TypePattern matches instances of a `type` declaration, e.g.
+// `Point(x, y)` or `Point(x=0, y=y)`.
+type TypePattern struct {
+	Token    token.Token // the type name
+	Name     *Identifier
+	Args     []Pattern // Matched against fields in declaration order
+	Keywords []*KeywordPattern
+}
+
+type KeywordPattern struct {
+	Name    *Identifier
+	Pattern Pattern
+}
+
+func (tp *TypePattern) patternNode()         {}
+func (tp *TypePattern) TokenLiteral() string { return tp.Token.Literal }
+func (tp *TypePattern) String() string {
+	args := []string{}
+	for _, a := range tp.Args {
+		args = append(args, a.String())
+	}
+	for _, kw := range tp.Keywords {
+		args = append(args, kw.Name.String()+"="+kw.Pattern.String())
+	}
+	return tp.Name.String() + "(" + strings.Join(args, ", ") + ")"
+}
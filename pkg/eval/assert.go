@@ -0,0 +1,132 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"flowa/pkg/ast"
+)
+
+// comparisonOperators are the infix operators whose operands an
+// assert failure reports, so `assert total == 3` says what total was.
+var comparisonOperators = map[string]bool{
+	"==": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true,
+	"in": true, "not in": true,
+}
+
+func evalAssertStatement(node *ast.AssertStatement, env *Environment) Object {
+	var cond Object
+	detail := ""
+	if infix, ok := node.Condition.(*ast.InfixExpression); ok && comparisonOperators[infix.Operator] {
+		// Evaluate the operands once ourselves so they can be reported
+		left := Eval(infix.Left, env)
+		if isError(left) {
+			return left
+		}
+		right := Eval(infix.Right, env)
+		if isError(right) {
+			return right
+		}
+		cond = evalInfixExpression(infix.Operator, left, right)
+		detail = fmt.Sprintf(" (left: %s, right: %s)", left.Inspect(), right.Inspect())
+	} else {
+		cond = Eval(node.Condition, env)
+	}
+	if isError(cond) {
+		return cond
+	}
+	if isTruthy(cond) {
+		return NULL
+	}
+
+	if node.Message != nil {
+		msg := Eval(node.Message, env)
+		if isError(msg) {
+			return msg
+		}
+		return newError("assertion failed: %s", messageText(msg))
+	}
+	expr := node.Condition.String()
+	if strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+		expr = expr[1 : len(expr)-1]
+	}
+	return newError("assertion failed: %s%s", expr, detail)
+}
+
+// messageText renders a user-supplied message without quoting strings.
+func messageText(obj Object) string {
+	if s, ok := obj.(*String); ok {
+		return s.Value
+	}
+	return obj.Inspect()
+}
+
+// assertFailure builds the error for a failed assert.* check, using the
+// optional trailing message argument in place of the default text.
+func assertFailure(args []Object, n int, format string, a ...interface{}) Object {
+	if len(args) > n {
+		return newError("assertion failed: %s", messageText(args[n]))
+	}
+	return newError("assertion failed: "+format, a...)
+}
+
+func newAssertModule() *StructInstance {
+	return &StructInstance{
+		Name: "Assert",
+		Fields: map[string]Object{
+			// assert.equal(actual, expected[, message])
+			"equal": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) < 2 || len(args) > 3 {
+						return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+					}
+					if objectsEqual(args[0], args[1]) {
+						return NULL
+					}
+					return assertFailure(args, 2, "expected %s, got %s", args[1].Inspect(), args[0].Inspect())
+				},
+			},
+			// assert.contains(container, item[, message])
+			"contains": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) < 2 || len(args) > 3 {
+						return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+					}
+					found := evalMembershipExpression("in", args[1], args[0])
+					if isError(found) {
+						return found
+					}
+					if found == TRUE {
+						return NULL
+					}
+					return assertFailure(args, 2, "%s does not contain %s", args[0].Inspect(), args[1].Inspect())
+				},
+			},
+			// assert.raises(fn[, substring]) calls fn and returns the
+			// message of the error it fails with.
+			"raises": contextBuiltin(func(ctx context.Context, args ...Object) Object {
+				if len(args) < 1 || len(args) > 2 {
+					return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+				}
+				var want string
+				if len(args) == 2 {
+					s, ok := args[1].(*String)
+					if !ok {
+						return newError("assert.raises expects a STRING to match, got %s", args[1].Type())
+					}
+					want = s.Value
+				}
+				result := callFunction(ctx, args[0], nil)
+				errObj, ok := result.(*ErrorObj)
+				if !ok {
+					return newError("assertion failed: expected an error, got %s", result.Inspect())
+				}
+				if !strings.Contains(errObj.Message, want) {
+					return newError("assertion failed: expected an error containing %q, got %q", want, errObj.Message)
+				}
+				return &String{Value: errObj.Message}
+			}),
+		},
+	}
+}
@@ -22,18 +22,13 @@ func verifyPassword(hash, password string) bool {
 
 // --- JWT Helpers ---
 
-func signToken(payload map[string]interface{}, secret string, expiresIn string) (string, error) {
+func signToken(payload map[string]interface{}, secret string, expiresIn time.Duration) (string, error) {
 	claims := jwt.MapClaims{}
 	for k, v := range payload {
 		claims[k] = v
 	}
 
-	// Parse duration
-	duration, err := time.ParseDuration(expiresIn)
-	if err != nil {
-		return "", fmt.Errorf("invalid duration: %v", err)
-	}
-	claims["exp"] = time.Now().Add(duration).Unix()
+	claims["exp"] = time.Now().Add(expiresIn).Unix()
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
@@ -45,7 +40,7 @@ func verifyToken(tokenString string, secret string) (map[string]interface{}, err
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(secret), nil
-	})
+	}, jwt.WithJSONNumber()) // numeric claims such as user IDs stay exact
 
 	if err != nil {
 		return nil, err
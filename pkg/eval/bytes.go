@@ -0,0 +1,253 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"flowa/pkg/ast"
+)
+
+// Bytes is an immutable sequence of raw bytes, for data that is not
+// text: uploads, images, binary websocket frames.
+type Bytes struct {
+	Value []byte
+}
+
+func (b *Bytes) Type() string { return "BYTES" }
+func (b *Bytes) Inspect() string {
+	var out strings.Builder
+	out.WriteString(`b"`)
+	for _, c := range b.Value {
+		switch {
+		case c == '"' || c == '\\':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		case c == '\n':
+			out.WriteString(`\n`)
+		case c == '\t':
+			out.WriteString(`\t`)
+		case c == '\r':
+			out.WriteString(`\r`)
+		case c >= 0x20 && c < 0x7f:
+			out.WriteByte(c)
+		default:
+			fmt.Fprintf(&out, `\x%02x`, c)
+		}
+	}
+	out.WriteString(`"`)
+	return out.String()
+}
+
+// bytesMethod returns the bound method name of b for member access.
+func bytesMethod(b *Bytes, name string) Object {
+	var fn func(args ...Object) Object
+	switch name {
+	// b.decode() converts UTF-8 bytes back to a string
+	case "decode":
+		fn = func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+			encoding := "utf-8"
+			if len(args) == 1 {
+				enc, ok := args[0].(*String)
+				if !ok {
+					return newError("encoding must be STRING, got %s", args[0].Type())
+				}
+				encoding = strings.ToLower(enc.Value)
+			}
+			switch encoding {
+			case "utf-8", "utf8":
+				if !utf8.Valid(b.Value) {
+					return newError("bytes are not valid UTF-8")
+				}
+				return &String{Value: string(b.Value)}
+			case "latin-1", "latin1":
+				runes := make([]rune, len(b.Value))
+				for i, c := range b.Value {
+					runes[i] = rune(c)
+				}
+				return &String{Value: string(runes)}
+			default:
+				return newError("unknown encoding: %s", encoding)
+			}
+		}
+	case "hex":
+		fn = func(args ...Object) Object {
+			return &String{Value: hex.EncodeToString(b.Value)}
+		}
+	case "base64":
+		fn = func(args ...Object) Object {
+			return &String{Value: base64.StdEncoding.EncodeToString(b.Value)}
+		}
+	default:
+		return newError("BYTES has no method %s", name)
+	}
+	return &BuiltinFunction{Fn: fn}
+}
+
+// maxBytesLen bounds bytes(n): a failed allocation is a fatal runtime
+// error that cannot be turned into a Flowa error.
+const maxBytesLen = 1 << 30
+
+// bytesBuiltin implements bytes(value): strings are encoded as UTF-8,
+// arrays hold byte values 0-255, and an integer n gives n zero bytes.
+func bytesBuiltin(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	switch arg := args[0].(type) {
+	case *Bytes:
+		return arg
+	case *String:
+		return &Bytes{Value: []byte(arg.Value)}
+	case *Integer:
+		if arg.Value < 0 {
+			return newError("negative byte count: %d", arg.Value)
+		}
+		if arg.Value > maxBytesLen {
+			return newError("byte count %d exceeds the maximum of %d", arg.Value, maxBytesLen)
+		}
+		return &Bytes{Value: make([]byte, arg.Value)}
+	case *Array, *Range:
+		arr, _ := asArray(arg)
+		out := make([]byte, len(arr.Elements))
+		for i, elem := range arr.Elements {
+			n, ok := elem.(*Integer)
+			if !ok || n.Value < 0 || n.Value > 255 {
+				return newError("byte values must be INTEGER in 0..255, got %s", elem.Inspect())
+			}
+			out[i] = byte(n.Value)
+		}
+		return &Bytes{Value: out}
+	default:
+		return newError("cannot convert %s to BYTES", arg.Type())
+	}
+}
+
+func evalBytesInfixExpression(operator string, left, right *Bytes) Object {
+	switch operator {
+	case "+":
+		out := make([]byte, 0, len(left.Value)+len(right.Value))
+		out = append(append(out, left.Value...), right.Value...)
+		return &Bytes{Value: out}
+	case "==":
+		return nativeBoolToBooleanObject(bytes.Equal(left.Value, right.Value))
+	case "!=":
+		return nativeBoolToBooleanObject(!bytes.Equal(left.Value, right.Value))
+	default:
+		return newError("unknown operator: BYTES %s BYTES", operator)
+	}
+}
+
+func evalBytesIndexExpression(b *Bytes, index Object) Object {
+	idx, ok := index.(*Integer)
+	if !ok {
+		return newError("bytes index must be INTEGER, got %s", index.Type())
+	}
+	if idx.Value < 0 || idx.Value >= int64(len(b.Value)) {
+		return NULL
+	}
+	return &Integer{Value: int64(b.Value[idx.Value])}
+}
+
+// sliceBounds resolves start/end slice operands against length n.
+// Negative values count from the end; out-of-range values are clamped.
+func sliceBounds(start, end Object, n int) (int, int, Object) {
+	resolve := func(obj Object, def int) (int, Object) {
+		if obj == nil {
+			return def, nil
+		}
+		i, ok := obj.(*Integer)
+		if !ok {
+			return 0, newError("slice index must be INTEGER, got %s", obj.Type())
+		}
+		v := i.Value
+		if v < 0 {
+			v += int64(n)
+		}
+		if v < 0 {
+			v = 0
+		}
+		if v > int64(n) {
+			v = int64(n)
+		}
+		return int(v), nil
+	}
+	lo, errObj := resolve(start, 0)
+	if errObj != nil {
+		return 0, 0, errObj
+	}
+	hi, errObj := resolve(end, n)
+	if errObj != nil {
+		return 0, 0, errObj
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi, nil
+}
+
+func evalSliceExpression(node *ast.SliceExpression, env *Environment) Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	var start, end Object
+	if node.Start != nil {
+		if start = Eval(node.Start, env); isError(start) {
+			return start
+		}
+	}
+	if node.End != nil {
+		if end = Eval(node.End, env); isError(end) {
+			return end
+		}
+	}
+
+	switch left := left.(type) {
+	case *Bytes:
+		lo, hi, errObj := sliceBounds(start, end, len(left.Value))
+		if errObj != nil {
+			return errObj
+		}
+		return &Bytes{Value: left.Value[lo:hi:hi]}
+	case *Array:
+		lo, hi, errObj := sliceBounds(start, end, len(left.Elements))
+		if errObj != nil {
+			return errObj
+		}
+		out := make([]Object, hi-lo)
+		copy(out, left.Elements[lo:hi])
+		return &Array{Elements: out}
+	case *Range:
+		lo, hi, errObj := sliceBounds(start, end, int(left.Len()))
+		if errObj != nil {
+			return errObj
+		}
+		sub := &Range{Start: left.Start + int64(lo)*left.Step, Stop: left.Stop, Step: left.Step}
+		return &Array{Elements: sub.elements(int64(hi - lo))}
+	case *Tuple:
+		lo, hi, errObj := sliceBounds(start, end, len(left.Elements))
+		if errObj != nil {
+			return errObj
+		}
+		out := make([]Object, hi-lo)
+		copy(out, left.Elements[lo:hi])
+		return &Tuple{Elements: out}
+	case *String:
+		// Strings slice by character, like iteration
+		runes := []rune(left.Value)
+		lo, hi, errObj := sliceBounds(start, end, len(runes))
+		if errObj != nil {
+			return errObj
+		}
+		return &String{Value: string(runes[lo:hi])}
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+}
@@ -0,0 +1,108 @@
+package eval
+
+import "flowa/pkg/ast"
+
+func evalListComprehension(lc *ast.ListComprehension, env *Environment) Object {
+	elements := []Object{}
+	errObj := evalComprehension(lc.Clauses, NewEnclosedEnvironment(env), func(scope *Environment) Object {
+		val := Eval(lc.Element, scope)
+		if isError(val) {
+			return val
+		}
+		elements = append(elements, val)
+		return nil
+	})
+	if errObj != nil {
+		return errObj
+	}
+	return &Array{Elements: elements}
+}
+
+func evalMapComprehension(mc *ast.MapComprehension, env *Environment) Object {
+	result := &Map{Pairs: make(map[Object]Object)}
+	errObj := evalComprehension(mc.Clauses, NewEnclosedEnvironment(env), func(scope *Environment) Object {
+		key := Eval(mc.Key, scope)
+		if isError(key) {
+			return key
+		}
+		value := Eval(mc.Value, scope)
+		if isError(value) {
+			return value
+		}
+		mapSet(result, key, value)
+		return nil
+	})
+	if errObj != nil {
+		return errObj
+	}
+	return result
+}
+
+func evalSetComprehension(sc *ast.SetComprehension, env *Environment) Object {
+	result := NewSet()
+	errObj := evalComprehension(sc.Clauses, NewEnclosedEnvironment(env), func(scope *Environment) Object {
+		val := Eval(sc.Element, scope)
+		if isError(val) {
+			return val
+		}
+		return result.Add(val)
+	})
+	if errObj != nil {
+		return errObj
+	}
+	return result
+}
+
+// evalComprehension runs emit once for every combination of values
+// produced by the clauses that passes their filters. Loop variables are
+// bound in scope, which is private to the comprehension.
+func evalComprehension(clauses []*ast.ComprehensionClause, scope *Environment, emit func(*Environment) Object) Object {
+	clause := clauses[0]
+	iterable := Eval(clause.Iterable, scope)
+	if isError(iterable) {
+		return iterable
+	}
+	it, ok := iterateIn(iterable, scope)
+	if !ok {
+		return newError("comprehension value must be iterable, got %s", iterable.Type())
+	}
+	defer it.Close()
+
+	for {
+		elem, ok := it.Next()
+		if !ok {
+			return nil
+		}
+		if isError(elem) {
+			return elem
+		}
+		if errObj := destructure(clause.Target, elem, scope); errObj != nil {
+			return errObj
+		}
+
+		keep := true
+		for _, cond := range clause.Conditions {
+			val := Eval(cond, scope)
+			if isError(val) {
+				return val
+			}
+			if !isTruthy(val) {
+				keep = false
+				break
+			}
+		}
+		if !keep {
+			continue
+		}
+
+		var errObj Object
+		if len(clauses) > 1 {
+			errObj = evalComprehension(clauses[1:], scope, emit)
+		} else {
+			errObj = emit(scope)
+		}
+		if errObj != nil {
+			return errObj
+		}
+	}
+}
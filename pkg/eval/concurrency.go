@@ -0,0 +1,420 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"flowa/pkg/ast"
+)
+
+// Channel is a Go channel of Flowa values, shared between spawned tasks.
+type Channel struct {
+	ch     chan Object
+	closed atomic.Bool
+}
+
+func (c *Channel) Type() string { return "CHANNEL" }
+func (c *Channel) Inspect() string {
+	return fmt.Sprintf("channel(%d/%d)", len(c.ch), cap(c.ch))
+}
+
+// send delivers val, turning the runtime panic for a closed channel
+// into an error object. It gives up when ctx is cancelled.
+func (c *Channel) send(ctx context.Context, val Object) (result Object) {
+	defer func() {
+		if recover() != nil {
+			result = newError("send on closed channel")
+		}
+	}()
+	select {
+	case c.ch <- val:
+		return NULL
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// recv blocks for the next value; a closed, drained channel gives None.
+func (c *Channel) recv(ctx context.Context) Object {
+	select {
+	case val, ok := <-c.ch:
+		if !ok {
+			return NULL
+		}
+		return val
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+func channelMethod(c *Channel, name string) Object {
+	var fn func(args ...Object) Object
+	switch name {
+	case "send":
+		return contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return c.send(ctx, args[0])
+		})
+	case "recv":
+		return contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+			return c.recv(ctx)
+		})
+	// ch.try_recv() returns (value, ok) without blocking
+	case "try_recv":
+		fn = func(args ...Object) Object {
+			select {
+			case val, ok := <-c.ch:
+				if !ok {
+					return &Tuple{Elements: []Object{NULL, FALSE}}
+				}
+				return &Tuple{Elements: []Object{val, TRUE}}
+			default:
+				return &Tuple{Elements: []Object{NULL, FALSE}}
+			}
+		}
+	case "close":
+		fn = func(args ...Object) Object {
+			if !c.closed.CompareAndSwap(false, true) {
+				return newError("close of closed channel")
+			}
+			close(c.ch)
+			return NULL
+		}
+	case "closed":
+		return nativeBoolToBooleanObject(c.closed.Load())
+	case "len":
+		return &Integer{Value: int64(len(c.ch))}
+	default:
+		return newError("CHANNEL has no method %s", name)
+	}
+	return &BuiltinFunction{Fn: fn}
+}
+
+// channelIterator yields received values until the channel is closed.
+// It gives up with an error when ctx is cancelled.
+func channelIterator(ctx context.Context, c *Channel) Iterator {
+	return &funcIterator{next: func() (Object, bool) {
+		select {
+		case val, ok := <-c.ch:
+			return val, ok
+		case <-ctx.Done():
+			return contextError(ctx), true
+		}
+	}}
+}
+
+// Mutex guards state shared between tasks. Unlocking a sync.Mutex that
+// isn't locked is a fatal error rather than a panic, so locked tracks the
+// state to refuse that.
+type Mutex struct {
+	mu     sync.Mutex
+	locked atomic.Bool
+}
+
+func (m *Mutex) Type() string    { return "MUTEX" }
+func (m *Mutex) Inspect() string { return "<mutex>" }
+
+func mutexMethod(m *Mutex, name string) Object {
+	var fn func(args ...Object) Object
+	switch name {
+	case "lock":
+		fn = func(args ...Object) Object {
+			m.mu.Lock()
+			m.locked.Store(true)
+			return NULL
+		}
+	case "unlock":
+		fn = func(args ...Object) Object {
+			if !m.locked.CompareAndSwap(true, false) {
+				return newError("unlock of unlocked mutex")
+			}
+			m.mu.Unlock()
+			return NULL
+		}
+	case "try_lock":
+		fn = func(args ...Object) Object {
+			if !m.mu.TryLock() {
+				return FALSE
+			}
+			m.locked.Store(true)
+			return TRUE
+		}
+	// m.do(fn) runs fn with the lock held and returns its result
+	case "do":
+		return callbackBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			m.mu.Lock()
+			m.locked.Store(true)
+			defer func() {
+				if m.locked.CompareAndSwap(true, false) {
+					m.mu.Unlock()
+				}
+			}()
+			return callFunction(ctx, args[0], nil)
+		})
+	default:
+		return newError("MUTEX has no method %s", name)
+	}
+	return &BuiltinFunction{Fn: fn}
+}
+
+// WaitGroup waits for a collection of tasks to finish.
+type WaitGroup struct {
+	wg sync.WaitGroup
+}
+
+func (w *WaitGroup) Type() string    { return "WAIT_GROUP" }
+func (w *WaitGroup) Inspect() string { return "<wait_group>" }
+
+func waitGroupMethod(w *WaitGroup, name string) Object {
+	var fn func(args ...Object) Object
+	switch name {
+	case "add":
+		fn = func(args ...Object) (result Object) {
+			n := int64(1)
+			if len(args) == 1 {
+				i, ok := args[0].(*Integer)
+				if !ok {
+					return newError("argument to `add` must be INTEGER, got %s", args[0].Type())
+				}
+				n = i.Value
+			}
+			defer func() {
+				if recover() != nil {
+					result = newError("negative wait_group counter")
+				}
+			}()
+			w.wg.Add(int(n))
+			return NULL
+		}
+	case "done":
+		fn = func(args ...Object) (result Object) {
+			defer func() {
+				if recover() != nil {
+					result = newError("negative wait_group counter")
+				}
+			}()
+			w.wg.Done()
+			return NULL
+		}
+	case "wait":
+		fn = func(args ...Object) Object {
+			w.wg.Wait()
+			return NULL
+		}
+	default:
+		return newError("WAIT_GROUP has no method %s", name)
+	}
+	return &BuiltinFunction{Fn: fn}
+}
+
+// Atomic is an integer counter that is safe to update from many tasks.
+type Atomic struct {
+	n atomic.Int64
+}
+
+func (a *Atomic) Type() string    { return "ATOMIC" }
+func (a *Atomic) Inspect() string { return fmt.Sprintf("atomic(%d)", a.n.Load()) }
+
+func atomicMethod(a *Atomic, name string) Object {
+	var fn func(args ...Object) Object
+	switch name {
+	case "get":
+		fn = func(args ...Object) Object {
+			return &Integer{Value: a.n.Load()}
+		}
+	case "set":
+		fn = func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			n, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument to `set` must be INTEGER, got %s", args[0].Type())
+			}
+			a.n.Store(n.Value)
+			return NULL
+		}
+	// a.add(n=1) returns the new value
+	case "add":
+		fn = func(args ...Object) Object {
+			delta := int64(1)
+			if len(args) == 1 {
+				n, ok := args[0].(*Integer)
+				if !ok {
+					return newError("argument to `add` must be INTEGER, got %s", args[0].Type())
+				}
+				delta = n.Value
+			}
+			return &Integer{Value: a.n.Add(delta)}
+		}
+	case "compare_and_swap":
+		fn = func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			old, ok1 := args[0].(*Integer)
+			next, ok2 := args[1].(*Integer)
+			if !ok1 || !ok2 {
+				return newError("arguments to `compare_and_swap` must be INTEGER, got %s and %s", args[0].Type(), args[1].Type())
+			}
+			return nativeBoolToBooleanObject(a.n.CompareAndSwap(old.Value, next.Value))
+		}
+	default:
+		return newError("ATOMIC has no method %s", name)
+	}
+	return &BuiltinFunction{Fn: fn}
+}
+
+// concurrencyBuiltins returns the channel, mutex, wait_group and atomic
+// constructors.
+func concurrencyBuiltins() map[string]*BuiltinFunction {
+	return map[string]*BuiltinFunction{
+		// channel(size=0); an unbuffered channel blocks until both sides meet
+		"channel": {
+			Fn: func(args ...Object) Object {
+				if len(args) > 1 {
+					return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+				}
+				size := int64(0)
+				if len(args) == 1 {
+					n, ok := args[0].(*Integer)
+					if !ok || n.Value < 0 {
+						return newError("channel size must be a non-negative INTEGER, got %s", args[0].Inspect())
+					}
+					size = n.Value
+				}
+				return &Channel{ch: make(chan Object, size)}
+			},
+		},
+		"mutex": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 0 {
+					return newError("wrong number of arguments. got=%d, want=0", len(args))
+				}
+				return &Mutex{}
+			},
+		},
+		"wait_group": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 0 {
+					return newError("wrong number of arguments. got=%d, want=0", len(args))
+				}
+				return &WaitGroup{}
+			},
+		},
+		"atomic": {
+			Fn: func(args ...Object) Object {
+				if len(args) > 1 {
+					return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+				}
+				a := &Atomic{}
+				if len(args) == 1 {
+					n, ok := args[0].(*Integer)
+					if !ok {
+						return newError("argument to `atomic` must be INTEGER, got %s", args[0].Type())
+					}
+					a.n.Store(n.Value)
+				}
+				return a
+			},
+		},
+	}
+}
+
+// selectChannel evaluates the channel operand of a select case.
+func selectChannel(expr ast.Expression, env *Environment) (*Channel, Object) {
+	obj := Eval(expr, env)
+	if isError(obj) {
+		return nil, obj
+	}
+	c, ok := obj.(*Channel)
+	if !ok {
+		return nil, newError("select case must use a CHANNEL, got %s", obj.Type())
+	}
+	return c, nil
+}
+
+// evalSelectStatement waits on all cases at once and runs the body of the
+// first one ready. With a `case _:` arm it never blocks.
+func evalSelectStatement(ss *ast.SelectStatement, env *Environment) Object {
+	cases := make([]reflect.SelectCase, len(ss.Cases))
+	for i, c := range ss.Cases {
+		switch c.Kind {
+		case ast.SelectRecv:
+			ch, errObj := selectChannel(c.Channel, env)
+			if errObj != nil {
+				return errObj
+			}
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.ch)}
+		case ast.SelectSend:
+			ch, errObj := selectChannel(c.Channel, env)
+			if errObj != nil {
+				return errObj
+			}
+			val := Eval(c.Value, env)
+			if isError(val) {
+				return val
+			}
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.ch), Send: reflect.ValueOf(&val).Elem()}
+		case ast.SelectTimeout:
+			obj := Eval(c.Timeout, env)
+			if isError(obj) {
+				return obj
+			}
+			d, ok := obj.(*Duration)
+			if !ok {
+				return newError("select timeout must be DURATION, got %s", obj.Type())
+			}
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(time.After(d.Value))}
+		case ast.SelectDefault:
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectDefault}
+		}
+	}
+	// A cancelled task stops waiting, as with any other blocking call
+	ctx := env.context()
+	if ctx != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	}
+
+	var (
+		chosen int
+		recv   reflect.Value
+		recvOK bool
+	)
+	if errObj := func() (errObj Object) {
+		defer func() {
+			if recover() != nil {
+				errObj = newError("send on closed channel")
+			}
+		}()
+		chosen, recv, recvOK = reflect.Select(cases)
+		return nil
+	}(); errObj != nil {
+		return errObj
+	}
+
+	if chosen == len(ss.Cases) {
+		return contextError(ctx)
+	}
+	c := ss.Cases[chosen]
+	if c.Kind == ast.SelectRecv && c.Target != nil {
+		val := Object(NULL)
+		if recvOK {
+			val = recv.Interface().(Object)
+		}
+		env.Set(c.Target.Value, val)
+	}
+	return Eval(c.Body, env)
+}
@@ -0,0 +1,337 @@
+package eval
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+)
+
+// Keys, digests and signatures cross the script boundary as hex strings;
+// ciphertexts as base64, so they can be stored in JSON or a cookie.
+
+// dataArg returns a STRING or BYTES argument as raw bytes.
+func dataArg(name string, args []Object, idx int) ([]byte, Object) {
+	switch arg := args[idx].(type) {
+	case *String:
+		return []byte(arg.Value), nil
+	case *Bytes:
+		return arg.Value, nil
+	default:
+		return nil, newError("argument to `%s` must be STRING or BYTES, got %s", name, args[idx].Type())
+	}
+}
+
+// hexKeyArg decodes a hex-encoded key argument.
+func hexKeyArg(name string, args []Object, idx int) ([]byte, Object) {
+	s, ok := args[idx].(*String)
+	if !ok {
+		return nil, newError("key for `%s` must be a hex STRING, got %s", name, args[idx].Type())
+	}
+	key, err := hex.DecodeString(s.Value)
+	if err != nil {
+		return nil, newError("key for `%s` must be a hex STRING: %s", name, err)
+	}
+	return key, nil
+}
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func hashAlgorithm(name string, args []Object, idx int) (func() hash.Hash, Object) {
+	if len(args) <= idx {
+		return sha256.New, nil
+	}
+	algo, ok := args[idx].(*String)
+	if !ok {
+		return nil, newError("algorithm for `%s` must be STRING, got %s", name, args[idx].Type())
+	}
+	h, ok := hashAlgorithms[algo.Value]
+	if !ok {
+		return nil, newError("unsupported hash algorithm: %s", algo.Value)
+	}
+	return h, nil
+}
+
+func hmacDigest(newHash func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(newHash, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func newGCM(name string, key []byte) (cipher.AEAD, Object) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, newError("%s: key must be 16, 24 or 32 bytes, got %d", name, len(key))
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, newError("%s: %s", name, err)
+	}
+	return gcm, nil
+}
+
+func newCryptoModule() *StructInstance {
+	digest := func(name string, newHash func() hash.Hash) *BuiltinFunction {
+		return &BuiltinFunction{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				data, errObj := dataArg(name, args, 0)
+				if errObj != nil {
+					return errObj
+				}
+				h := newHash()
+				h.Write(data)
+				return &String{Value: hex.EncodeToString(h.Sum(nil))}
+			},
+		}
+	}
+
+	fields := map[string]Object{
+		"sha256": digest("crypto.sha256", sha256.New),
+		"sha512": digest("crypto.sha512", sha512.New),
+	}
+
+	// crypto.hmac(key, data, algorithm="sha256") returns a hex digest.
+	// The key is used as given, not hex-decoded, to match how webhook
+	// providers hand out their signing secrets.
+	fields["hmac"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			key, errObj := dataArg("crypto.hmac", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			data, errObj := dataArg("crypto.hmac", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			newHash, errObj := hashAlgorithm("crypto.hmac", args, 2)
+			if errObj != nil {
+				return errObj
+			}
+			return &String{Value: hex.EncodeToString(hmacDigest(newHash, key, data))}
+		},
+	}
+
+	// crypto.hmac_verify(key, data, signature, algorithm="sha256")
+	fields["hmac_verify"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 3 && len(args) != 4 {
+				return newError("wrong number of arguments. got=%d, want=3 or 4", len(args))
+			}
+			key, errObj := dataArg("crypto.hmac_verify", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			data, errObj := dataArg("crypto.hmac_verify", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			signature, ok := args[2].(*String)
+			if !ok {
+				return newError("signature for `crypto.hmac_verify` must be STRING, got %s", args[2].Type())
+			}
+			newHash, errObj := hashAlgorithm("crypto.hmac_verify", args, 3)
+			if errObj != nil {
+				return errObj
+			}
+			expected, err := hex.DecodeString(signature.Value)
+			if err != nil {
+				return FALSE
+			}
+			return nativeBoolToBooleanObject(hmac.Equal(expected, hmacDigest(newHash, key, data)))
+		},
+	}
+
+	fields["compare"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			a, errObj := dataArg("crypto.compare", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			b, errObj := dataArg("crypto.compare", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			return nativeBoolToBooleanObject(subtle.ConstantTimeCompare(a, b) == 1)
+		},
+	}
+
+	// crypto.generate_key(bits=256) returns a random AES key as hex
+	fields["generate_key"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+			bits := int64(256)
+			if len(args) == 1 {
+				n, ok := args[0].(*Integer)
+				if !ok || (n.Value != 128 && n.Value != 192 && n.Value != 256) {
+					return newError("key size must be 128, 192 or 256, got %s", args[0].Inspect())
+				}
+				bits = n.Value
+			}
+			key := make([]byte, bits/8)
+			if _, err := crand.Read(key); err != nil {
+				return newError("crypto.generate_key: %s", err)
+			}
+			return &String{Value: hex.EncodeToString(key)}
+		},
+	}
+
+	// crypto.encrypt(key, plaintext, aad="") seals with AES-GCM and returns
+	// base64(nonce || ciphertext || tag)
+	fields["encrypt"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			key, errObj := hexKeyArg("crypto.encrypt", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			plaintext, errObj := dataArg("crypto.encrypt", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			var aad []byte
+			if len(args) == 3 {
+				if aad, errObj = dataArg("crypto.encrypt", args, 2); errObj != nil {
+					return errObj
+				}
+			}
+			gcm, errObj := newGCM("crypto.encrypt", key)
+			if errObj != nil {
+				return errObj
+			}
+			nonce := make([]byte, gcm.NonceSize())
+			if _, err := crand.Read(nonce); err != nil {
+				return newError("crypto.encrypt: %s", err)
+			}
+			sealed := gcm.Seal(nonce, nonce, plaintext, aad)
+			return &String{Value: base64.StdEncoding.EncodeToString(sealed)}
+		},
+	}
+
+	fields["decrypt"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			key, errObj := hexKeyArg("crypto.decrypt", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			token, ok := args[1].(*String)
+			if !ok {
+				return newError("ciphertext for `crypto.decrypt` must be STRING, got %s", args[1].Type())
+			}
+			var aad []byte
+			if len(args) == 3 {
+				if aad, errObj = dataArg("crypto.decrypt", args, 2); errObj != nil {
+					return errObj
+				}
+			}
+			gcm, errObj := newGCM("crypto.decrypt", key)
+			if errObj != nil {
+				return errObj
+			}
+			sealed, err := base64.StdEncoding.DecodeString(token.Value)
+			if err != nil || len(sealed) < gcm.NonceSize() {
+				return newError("crypto.decrypt: malformed ciphertext")
+			}
+			nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+			plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+			if err != nil {
+				return newError("crypto.decrypt: message authentication failed")
+			}
+			return &String{Value: string(plaintext)}
+		},
+	}
+
+	// crypto.generate_keypair() returns {"public": hex, "private": hex}
+	// for Ed25519; the private key is the 32-byte seed.
+	fields["generate_keypair"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+			pub, priv, err := ed25519.GenerateKey(crand.Reader)
+			if err != nil {
+				return newError("crypto.generate_keypair: %s", err)
+			}
+			pair := &Map{Pairs: make(map[Object]Object)}
+			mapSet(pair, &String{Value: "public"}, &String{Value: hex.EncodeToString(pub)})
+			mapSet(pair, &String{Value: "private"}, &String{Value: hex.EncodeToString(priv.Seed())})
+			return pair
+		},
+	}
+
+	fields["sign"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			seed, errObj := hexKeyArg("crypto.sign", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			if len(seed) != ed25519.SeedSize {
+				return newError("crypto.sign: private key must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+			}
+			message, errObj := dataArg("crypto.sign", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			signature := ed25519.Sign(ed25519.NewKeyFromSeed(seed), message)
+			return &String{Value: hex.EncodeToString(signature)}
+		},
+	}
+
+	fields["verify"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			pub, errObj := hexKeyArg("crypto.verify", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			if len(pub) != ed25519.PublicKeySize {
+				return newError("crypto.verify: public key must be %d bytes, got %d", ed25519.PublicKeySize, len(pub))
+			}
+			message, errObj := dataArg("crypto.verify", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			sigHex, ok := args[2].(*String)
+			if !ok {
+				return newError("signature for `crypto.verify` must be STRING, got %s", args[2].Type())
+			}
+			signature, err := hex.DecodeString(sigHex.Value)
+			if err != nil {
+				return FALSE
+			}
+			return nativeBoolToBooleanObject(ed25519.Verify(pub, message, signature))
+		},
+	}
+
+	return &StructInstance{Name: "Crypto", Fields: fields}
+}
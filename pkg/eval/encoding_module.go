@@ -0,0 +1,204 @@
+package eval
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// stringFunction wraps a one-argument string transformation that may fail.
+func stringFunction(name string, fn func(string) (string, error)) *BuiltinFunction {
+	return &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `%s` must be STRING, got %s", name, args[0].Type())
+			}
+			out, err := fn(s.Value)
+			if err != nil {
+				return newError("%s: %s", name, err)
+			}
+			return &String{Value: out}
+		},
+	}
+}
+
+// encodeFunction wraps an encoder taking a STRING or BYTES argument.
+func encodeFunction(name string, fn func([]byte) string) *BuiltinFunction {
+	return &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			data, errObj := dataArg(name, args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			return &String{Value: fn(data)}
+		},
+	}
+}
+
+// decodeFunctions returns a decoder as two builtins: one returning a
+// STRING and a `_bytes` variant returning BYTES.
+func decodeFunctions(fields map[string]Object, module, field string, fn func(string) ([]byte, error)) {
+	name := module + "." + field
+	fields[field] = stringFunction(name, func(s string) (string, error) {
+		b, err := fn(s)
+		return string(b), err
+	})
+	fields[field+"_bytes"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `%s_bytes` must be STRING, got %s", name, args[0].Type())
+			}
+			b, err := fn(s.Value)
+			if err != nil {
+				return newError("%s_bytes: %s", name, err)
+			}
+			return &Bytes{Value: b}
+		},
+	}
+}
+
+// decodeBase64 accepts input with or without padding.
+func decodeBase64(enc *base64.Encoding) func(string) ([]byte, error) {
+	return func(s string) ([]byte, error) {
+		return enc.WithPadding(base64.NoPadding).DecodeString(strings.TrimRight(s, "="))
+	}
+}
+
+func newBase64Module() *StructInstance {
+	fields := map[string]Object{
+		"encode": encodeFunction("base64.encode", base64.StdEncoding.EncodeToString),
+		// URL-safe alphabet without padding, as used by JWTs
+		"url_encode": encodeFunction("base64.url_encode", base64.RawURLEncoding.EncodeToString),
+	}
+	decodeFunctions(fields, "base64", "decode", decodeBase64(base64.StdEncoding))
+	decodeFunctions(fields, "base64", "url_decode", decodeBase64(base64.URLEncoding))
+	return &StructInstance{Name: "Base64", Fields: fields}
+}
+
+func newHexModule() *StructInstance {
+	fields := map[string]Object{
+		"encode": encodeFunction("hex.encode", hex.EncodeToString),
+	}
+	decodeFunctions(fields, "hex", "decode", hex.DecodeString)
+	return &StructInstance{Name: "Hex", Fields: fields}
+}
+
+// queryToMap converts parsed query values to a Map. Repeated keys
+// become arrays; single values stay strings.
+func queryToMap(values url.Values) *Map {
+	m := &Map{Pairs: make(map[Object]Object)}
+	for key, vals := range values {
+		if len(vals) == 1 {
+			mapSet(m, &String{Value: key}, &String{Value: vals[0]})
+			continue
+		}
+		elements := make([]Object, len(vals))
+		for i, v := range vals {
+			elements[i] = &String{Value: v}
+		}
+		mapSet(m, &String{Value: key}, &Array{Elements: elements})
+	}
+	return m
+}
+
+func newURLModule() *StructInstance {
+	fields := map[string]Object{
+		// encode/decode escape a query component; path_encode a path segment
+		"encode": stringFunction("url.encode", func(s string) (string, error) {
+			return url.QueryEscape(s), nil
+		}),
+		"decode": stringFunction("url.decode", url.QueryUnescape),
+		"path_encode": stringFunction("url.path_encode", func(s string) (string, error) {
+			return url.PathEscape(s), nil
+		}),
+	}
+
+	// url.parse(s) returns {scheme, host, port, path, query, fragment}
+	fields["parse"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `url.parse` must be STRING, got %s", args[0].Type())
+			}
+			u, err := url.Parse(s.Value)
+			if err != nil {
+				return newError("url.parse: %s", err)
+			}
+			parts := &Map{Pairs: make(map[Object]Object)}
+			mapSet(parts, &String{Value: "scheme"}, &String{Value: u.Scheme})
+			mapSet(parts, &String{Value: "host"}, &String{Value: u.Hostname()})
+			mapSet(parts, &String{Value: "port"}, &String{Value: u.Port()})
+			mapSet(parts, &String{Value: "path"}, &String{Value: u.Path})
+			mapSet(parts, &String{Value: "query"}, queryToMap(u.Query()))
+			mapSet(parts, &String{Value: "fragment"}, &String{Value: u.Fragment})
+			return parts
+		},
+	}
+
+	fields["query_decode"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `url.query_decode` must be STRING, got %s", args[0].Type())
+			}
+			values, err := url.ParseQuery(strings.TrimPrefix(s.Value, "?"))
+			if err != nil {
+				return newError("url.query_decode: %s", err)
+			}
+			return queryToMap(values)
+		},
+	}
+
+	// url.query_encode(map) builds "a=1&b=2" with keys in sorted order;
+	// array values repeat the key
+	fields["query_encode"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			m, ok := args[0].(*Map)
+			if !ok {
+				return newError("argument to `url.query_encode` must be MAP, got %s", args[0].Type())
+			}
+			values := url.Values{}
+			for k, v := range m.Pairs {
+				key := queryText(k)
+				if arr, ok := v.(*Array); ok {
+					for _, elem := range arr.Elements {
+						values.Add(key, queryText(elem))
+					}
+					continue
+				}
+				values.Add(key, queryText(v))
+			}
+			return &String{Value: values.Encode()}
+		},
+	}
+
+	return &StructInstance{Name: "URL", Fields: fields}
+}
+
+func queryText(obj Object) string {
+	if s, ok := obj.(*String); ok {
+		return s.Value
+	}
+	return obj.Inspect()
+}
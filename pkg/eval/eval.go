@@ -1,15 +1,20 @@
 package eval
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"flowa/pkg/ast"
 	"flowa/pkg/lexer"
@@ -70,6 +75,23 @@ func (a *Array) Inspect() string {
 	return "[" + strings.Join(out, ", ") + "]"
 }
 
+// Tuple is an immutable sequence, produced by `a, b` and `(a, b)`.
+type Tuple struct {
+	Elements []Object
+}
+
+func (t *Tuple) Type() string { return "TUPLE" }
+func (t *Tuple) Inspect() string {
+	var out []string
+	for _, e := range t.Elements {
+		out = append(out, e.Inspect())
+	}
+	if len(out) == 1 {
+		return "(" + out[0] + ",)"
+	}
+	return "(" + strings.Join(out, ", ") + ")"
+}
+
 type ReturnValue struct {
 	Value Object
 }
@@ -85,9 +107,13 @@ func (e *ErrorObj) Type() string    { return "ERROR" }
 func (e *ErrorObj) Inspect() string { return "ERROR: " + e.Message }
 
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
+	Patterns   []ast.Pattern // Destructuring parameters, see ast.FunctionStatement
 	Body       *ast.BlockStatement
 	Env        *Environment
+
+	IsGenerator bool
 }
 
 func (f *Function) Type() string    { return "FUNCTION" }
@@ -95,6 +121,9 @@ func (f *Function) Inspect() string { return "function" }
 
 type BuiltinFunction struct {
 	Fn func(args ...Object) Object
+	// CtxFn, when set, replaces Fn for calls made inside a task so that
+	// blocking builtins stop when the task is cancelled.
+	CtxFn func(ctx context.Context, args ...Object) Object
 }
 
 func (b *BuiltinFunction) Type() string    { return "BUILTIN" }
@@ -113,33 +142,24 @@ func (m *Map) Inspect() string {
 	return "{" + strings.Join(out, ", ") + "}"
 }
 
-// Task represents the result of a spawned computation.
-// For now this is a simple wrapper around a value – evaluation is still synchronous.
-type Task struct {
-	Result Object
-	Done   bool
-}
-
-func (t *Task) Type() string    { return "TASK" }
-func (t *Task) Inspect() string { return "task(" + t.Result.Inspect() + ")" }
-func (t *Task) Await() Object {
-	for !t.Done {
-		time.Sleep(1 * time.Millisecond)
-	}
-	return t.Result
-}
-
 // StructInstance is a simple record-like value created via `type` declarations.
 type StructInstance struct {
 	Name   string
 	Fields map[string]Object
+	Order  []string // Field declaration order; nil for built-in records
 }
 
 func (s *StructInstance) Type() string { return "STRUCT_INSTANCE" }
 func (s *StructInstance) Inspect() string {
 	parts := make([]string, 0, len(s.Fields))
-	for k, v := range s.Fields {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, v.Inspect()))
+	if s.Order != nil {
+		for _, k := range s.Order {
+			parts = append(parts, fmt.Sprintf("%s=%s", k, s.Fields[k].Inspect()))
+		}
+	} else {
+		for k, v := range s.Fields {
+			parts = append(parts, fmt.Sprintf("%s=%s", k, v.Inspect()))
+		}
 	}
 	return fmt.Sprintf("%s(%s)", s.Name, strings.Join(parts, ", "))
 }
@@ -165,28 +185,75 @@ type routeDef struct {
 	Middlewares []Object // Route-specific middleware
 }
 
-var registeredRoutes []routeDef
-var globalMiddlewares []Object // Global middleware applied to all routes
+// registry holds what scripts register for the process to serve: HTTP
+// routes and middleware, scheduled jobs and event subscriptions. Every
+// outermost environment uses defaultRegistry unless it is isolated.
+type registry struct {
+	routes      []routeDef
+	middlewares []Object // Global middleware applied to all routes
+	scheduler   *Scheduler
+	bus         *EventBus
+}
+
+var defaultRegistry = &registry{scheduler: DefaultScheduler, bus: DefaultEventBus}
 
+// Environment is a single scope. Functions, modules and services each get
+// their own Environment; loop and if bodies share the enclosing one, so
+// assignments inside them update the surrounding scope.
 type Environment struct {
+	mu    sync.RWMutex // spawned tasks share the scopes they close over
 	store map[string]Object
 	outer *Environment
+
+	// Names declared `global` or `nonlocal` in this scope. Reads and
+	// writes of these names are redirected to the scope they refer to.
+	globals   map[string]bool
+	nonlocals map[string]*Environment
+
+	// Context of the task a function call runs in; nil inherits the
+	// enclosing scope's.
+	ctx context.Context
+
+	// Where print, puts and inspect write, and where routes, jobs and
+	// event subscriptions go; only set on the outermost environment.
+	out io.Writer
+	reg *registry
+
+	// The call running in this scope, recorded while Hooks are installed
+	frame *Frame
+
+	// Set on the scope of a generator's body; yield finds it from there
+	generator *generator
 }
 
 func NewEnvironment() *Environment {
+	return newEnvironment(defaultRegistry)
+}
+
+// NewIsolatedEnvironment is like NewEnvironment, but the routes, scheduled
+// jobs and event subscriptions of the code run in it are its own rather
+// than the process's. Stop its jobs with Scheduler().Stop.
+func NewIsolatedEnvironment() *Environment {
+	return newEnvironment(&registry{
+		scheduler: newScheduler(),
+		bus:       &EventBus{Log: os.Stderr},
+	})
+}
+
+func newEnvironment(reg *registry) *Environment {
 	s := make(map[string]Object)
-	env := &Environment{store: s, outer: nil}
+	env := &Environment{store: s, outer: nil, out: os.Stdout, reg: reg}
 
 	// Add built-in print function
 	env.store["print"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
 			for i, arg := range args {
 				if i > 0 {
-					fmt.Print(" ")
+					fmt.Fprint(env.out, " ")
 				}
-				fmt.Print(arg.Inspect())
+				fmt.Fprint(env.out, arg.Inspect())
 			}
-			fmt.Println()
+			fmt.Fprintln(env.out)
 			return NULL
 		},
 	}
@@ -200,10 +267,18 @@ func NewEnvironment() *Environment {
 			switch arg := args[0].(type) {
 			case *String:
 				return &Integer{Value: int64(len(arg.Value))}
+			case *Bytes:
+				return &Integer{Value: int64(len(arg.Value))}
 			case *Map:
 				return &Integer{Value: int64(len(arg.Pairs))}
 			case *Array:
 				return &Integer{Value: int64(len(arg.Elements))}
+			case *Tuple:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Range:
+				return &Integer{Value: arg.Len()}
+			case *Set:
+				return &Integer{Value: int64(len(arg.Elements))}
 			default:
 				return newError("argument to `len` not supported, got %s", args[0].Type())
 			}
@@ -216,14 +291,23 @@ func NewEnvironment() *Environment {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
-			if args[0].Type() != "ARRAY" {
+			if array, ok := args[0].(*Array); ok {
+				if len(array.Elements) > 0 {
+					return array.Elements[0]
+				}
+				return NULL
+			}
+			// Any other iterable only has its first value consumed
+			it, ok := iterate(args[0])
+			if !ok {
 				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
 			}
-			array := args[0].(*Array)
-			if len(array.Elements) > 0 {
-				return array.Elements[0]
+			defer it.Close()
+			val, ok := it.Next()
+			if !ok {
+				return NULL
 			}
-			return NULL
+			return val
 		},
 	}
 
@@ -233,10 +317,13 @@ func NewEnvironment() *Environment {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
-			if args[0].Type() != "ARRAY" {
+			if r, ok := args[0].(*Range); ok {
+				return evalRangeIndexExpression(r, &Integer{Value: r.Len() - 1})
+			}
+			array, ok := asArray(args[0])
+			if !ok {
 				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
 			}
-			array := args[0].(*Array)
 			if len(array.Elements) > 0 {
 				return array.Elements[len(array.Elements)-1]
 			}
@@ -250,10 +337,10 @@ func NewEnvironment() *Environment {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
-			if args[0].Type() != "ARRAY" {
+			array, ok := asArray(args[0])
+			if !ok {
 				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
 			}
-			array := args[0].(*Array)
 			length := len(array.Elements)
 			if length > 0 {
 				newElements := make([]Object, length-1)
@@ -270,10 +357,10 @@ func NewEnvironment() *Environment {
 			if len(args) != 2 {
 				return newError("wrong number of arguments. got=%d, want=2", len(args))
 			}
-			if args[0].Type() != "ARRAY" {
+			array, ok := asArray(args[0])
+			if !ok {
 				return newError("first argument to `push` must be ARRAY, got %s", args[0].Type())
 			}
-			array := args[0].(*Array)
 			length := len(array.Elements)
 			newElements := make([]Object, length+1)
 			copy(newElements, array.Elements)
@@ -286,95 +373,83 @@ func NewEnvironment() *Environment {
 	env.store["puts"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				fmt.Fprintln(env.out, arg.Inspect())
 			}
 			return NULL
 		},
 	}
 
 	// Add built-in http_get function
-	env.store["http_get"] = &BuiltinFunction{
-		Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			if args[0].Type() != "STRING" {
-				return newError("argument to `http_get` must be STRING, got %s", args[0].Type())
-			}
-			url := args[0].(*String).Value
-			resp, err := http.Get(url)
-			if err != nil {
-				return newError("http get error: %s", err)
-			}
-			defer resp.Body.Close()
-			return &String{Value: resp.Status}
-		},
-	}
+	env.store["http_get"] = contextBuiltin(func(ctx context.Context, args ...Object) Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+		if args[0].Type() != "STRING" {
+			return newError("argument to `http_get` must be STRING, got %s", args[0].Type())
+		}
+		url := args[0].(*String).Value
+		resp, err := httpGet(ctx, url)
+		if err != nil {
+			return newError("http get error: %s", err)
+		}
+		defer resp.Body.Close()
+		return &String{Value: resp.Status}
+	})
 
-	env.store["async_http_get"] = &BuiltinFunction{
-		Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			urlObj, ok := args[0].(*String)
-			if !ok {
-				return newError("argument to `async_http_get` must be STRING, got %s", args[0].Type())
-			}
+	// async_http_get(url) returns a task; cancelling it aborts the request
+	env.store["async_http_get"] = contextBuiltin(func(ctx context.Context, args ...Object) Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+		urlObj, ok := args[0].(*String)
+		if !ok {
+			return newError("argument to `async_http_get` must be STRING, got %s", args[0].Type())
+		}
 
-			task := &Task{Done: false}
+		task := newTask(ctx)
 
-			go func() {
-				resp, err := http.Get(urlObj.Value)
-				if err != nil {
-					task.Result = &ErrorObj{Message: fmt.Sprintf("http error: %s", err)}
-					task.Done = true
-					return
-				}
-				defer resp.Body.Close()
-				body, _ := io.ReadAll(resp.Body)
-				task.Result = &String{Value: string(body)}
-				task.Done = true
-			}()
+		go func() {
+			resp, err := httpGet(task.ctx, urlObj.Value)
+			if err != nil {
+				task.finish(&ErrorObj{Message: fmt.Sprintf("http error: %s", err)})
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			task.finish(&String{Value: string(body)})
+		}()
 
-			return task
-		},
-	}
+		return task
+	})
 
 	// Add additional utility functions
 	env.store["min"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2", len(args))
-			}
-			if args[0].Type() != "INTEGER" || args[1].Type() != "INTEGER" {
-				return newError("arguments to `min` must be INTEGER, got %s and %s", args[0].Type(), args[1].Type())
-			}
-			a := args[0].(*Integer).Value
-			b := args[1].(*Integer).Value
-			if a < b {
-				return &Integer{Value: a}
-			}
-			return &Integer{Value: b}
+			return extremum("min", "<", args)
 		},
 	}
 
 	env.store["max"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2", len(args))
-			}
-			if args[0].Type() != "INTEGER" || args[1].Type() != "INTEGER" {
-				return newError("arguments to `max` must be INTEGER, got %s and %s", args[0].Type(), args[1].Type())
-			}
-			a := args[0].(*Integer).Value
-			b := args[1].(*Integer).Value
-			if a > b {
-				return &Integer{Value: a}
-			}
-			return &Integer{Value: b}
+			return extremum("max", ">", args)
 		},
 	}
 
+	for name, fn := range numberBuiltins() {
+		env.store[name] = fn
+	}
+
+	env.store["bytes"] = &BuiltinFunction{Fn: bytesBuiltin}
+	for name, fn := range concurrencyBuiltins() {
+		env.store[name] = fn
+	}
+	for name, fn := range taskBuiltins() {
+		env.store[name] = fn
+	}
+	for name, fn := range poolBuiltins() {
+		env.store[name] = fn
+	}
+
 	// json module
 	jsonModule := &StructInstance{
 		Name: "JSON",
@@ -402,8 +477,10 @@ func NewEnvironment() *Environment {
 						return newError("argument to `json.decode` must be STRING, got %s", args[0].Type())
 					}
 					var native interface{}
-					err := json.Unmarshal([]byte(strObj.Value), &native)
-					if err != nil {
+					// UseNumber keeps large IDs and prices exact
+					decoder := json.NewDecoder(strings.NewReader(strObj.Value))
+					decoder.UseNumber()
+					if err := decoder.Decode(&native); err != nil {
 						return newError("json decode error: %s", err)
 					}
 					return nativeToFlowa(native)
@@ -413,6 +490,24 @@ func NewEnvironment() *Environment {
 	}
 	env.store["json"] = jsonModule
 
+	// decimal module: exact decimal arithmetic
+	env.store["decimal"] = newDecimalModule()
+
+	// time module: clocks, parsing/formatting, zones and durations
+	env.store["time"] = newTimeModule()
+	env.store["re"] = newRegexModule()
+	env.store["math"] = newMathModule()
+	env.store["random"] = newRandomModule()
+	env.store["secrets"] = newSecretsModule()
+	env.store["uuid"] = newUUIDModule()
+	env.store["crypto"] = newCryptoModule()
+	env.store["base64"] = newBase64Module()
+	env.store["hex"] = newHexModule()
+	env.store["url"] = newURLModule()
+	env.store["schedule"] = newScheduleModule(reg.scheduler)
+	env.store["events"] = newEventsModule(reg.bus)
+	env.store["assert"] = newAssertModule()
+
 	// response helpers
 	responseModule := &StructInstance{
 		Name: "ResponseHelpers",
@@ -492,6 +587,38 @@ func NewEnvironment() *Environment {
 					return &StructInstance{Name: "Response", Fields: fields}
 				},
 			},
+			// response.bytes(data, content_type, status) serves binary
+			// data such as images or downloads
+			"bytes": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) < 1 || len(args) > 3 {
+						return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+					}
+					data, ok := args[0].(*Bytes)
+					if !ok {
+						return newError("response.bytes data must be BYTES, got %s", args[0].Type())
+					}
+					contentType := "application/octet-stream"
+					if len(args) >= 2 {
+						if s, ok := args[1].(*String); ok {
+							contentType = s.Value
+						}
+					}
+					status := int64(200)
+					if len(args) == 3 {
+						if s, ok := args[2].(*Integer); ok {
+							status = s.Value
+						}
+					}
+					fields := make(map[string]Object)
+					fields["status"] = &Integer{Value: status}
+					fields["body"] = data
+					fields["headers"] = &Map{Pairs: map[Object]Object{
+						&String{Value: "Content-Type"}: &String{Value: contentType},
+					}}
+					return &StructInstance{Name: "Response", Fields: fields}
+				},
+			},
 			"redirect": &BuiltinFunction{
 				Fn: func(args ...Object) Object {
 					if len(args) < 1 || len(args) > 2 {
@@ -852,14 +979,15 @@ func NewEnvironment() *Environment {
 			if !ok {
 				return newError("second argument to jwt.sign must be a String")
 			}
-			expiresIn, ok := args[2].(*String)
-			if !ok {
-				return newError("third argument to jwt.sign must be a String")
+			// "24h", "7d" or a duration literal such as 24h
+			expiresIn, errObj := durationArg("jwt.sign", args[2])
+			if errObj != nil {
+				return errObj
 			}
 
 			// Convert Flowa Map to native map
 			nativePayload := flowaToNative(payload).(map[string]interface{})
-			token, err := signToken(nativePayload, secret.Value, expiresIn.Value)
+			token, err := signToken(nativePayload, secret.Value, expiresIn)
 			if err != nil {
 				return newError("failed to sign token: %s", err)
 			}
@@ -932,12 +1060,16 @@ func NewEnvironment() *Environment {
 			if !ok {
 				return newError("first argument to ws.send must be a WebSocketConnection")
 			}
-			msg, ok := args[1].(*String)
-			if !ok {
-				return newError("second argument to ws.send must be a String")
+			// Bytes go out as binary frames, strings as text frames
+			var err error
+			switch msg := args[1].(type) {
+			case *String:
+				err = conn.WriteMessage(websocket.TextMessage, []byte(msg.Value))
+			case *Bytes:
+				err = conn.WriteMessage(websocket.BinaryMessage, msg.Value)
+			default:
+				return newError("second argument to ws.send must be a String or Bytes")
 			}
-
-			err := conn.Conn.WriteMessage(websocket.TextMessage, []byte(msg.Value))
 			if err != nil {
 				return newError("failed to send message: %s", err)
 			}
@@ -954,11 +1086,31 @@ func NewEnvironment() *Environment {
 				return newError("argument to ws.read must be a WebSocketConnection")
 			}
 
-			_, message, err := conn.Conn.ReadMessage()
+			kind, message, err := conn.Conn.ReadMessage()
 			if err != nil {
 				return NULL // Disconnected
 			}
-			return &String{Value: string(message)}
+			return wsMessageObject(kind, message)
+		},
+	}
+	// websocket.messages(conn) yields each incoming message until the
+	// client disconnects
+	wsModule.Fields["messages"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			conn, ok := args[0].(*WebSocketConnection)
+			if !ok {
+				return newError("argument to ws.messages must be a WebSocketConnection")
+			}
+			return &funcIterator{next: func() (Object, bool) {
+				kind, message, err := conn.Conn.ReadMessage()
+				if err != nil {
+					return nil, false // Disconnected
+				}
+				return wsMessageObject(kind, message), true
+			}}
 		},
 	}
 	wsModule.Fields["close"] = &BuiltinFunction{
@@ -998,33 +1150,73 @@ func NewEnvironment() *Environment {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
-			fmt.Printf("[DEBUG] Type: %s, Value: %s\n", args[0].Type(), args[0].Inspect())
+			fmt.Fprintf(env.out, "[DEBUG] Type: %s, Value: %s\n", args[0].Type(), args[0].Inspect())
 			return args[0]
 		},
 	}
 
-	// range(n) -> [0, 1, ..., n-1]
+	// range(stop), range(start, stop[, step]) -> lazy integer sequence
 	env.store["range"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+			}
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				intArg, ok := arg.(*Integer)
+				if !ok {
+					return newError("argument to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = intArg.Value
+			}
+			r := &Range{Step: 1}
+			switch len(bounds) {
+			case 1:
+				r.Stop = bounds[0]
+			case 2:
+				r.Start, r.Stop = bounds[0], bounds[1]
+			case 3:
+				r.Start, r.Stop, r.Step = bounds[0], bounds[1], bounds[2]
+			}
+			if r.Step == 0 {
+				return newError("`range` step must not be zero")
+			}
+			return r
+		},
+	}
+
+	// set() or set(iterable)
+	env.store["set"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+			set := NewSet()
+			if len(args) == 0 {
+				return set
 			}
-			intArg, ok := args[0].(*Integer)
+			it, ok := iterate(args[0])
 			if !ok {
-				return newError("argument to `range` must be INTEGER, got %s", args[0].Type())
+				return newError("argument to `set` must be iterable, got %s", args[0].Type())
 			}
-			n := intArg.Value
-			if n < 0 {
-				return newError("argument to `range` must be non-negative, got %d", n)
+			elements, errObj := collect(it)
+			if errObj != nil {
+				return errObj
 			}
-			elements := make([]Object, 0, n)
-			for i := int64(0); i < n; i++ {
-				elements = append(elements, &Integer{Value: i})
+			for _, elem := range elements {
+				if errObj := set.Add(elem); errObj != nil {
+					return errObj
+				}
 			}
-			return &Array{Elements: elements}
+			return set
 		},
 	}
 
+	// Lazy collection helpers: iter, next, list, map, filter, reduce, ...
+	for name, fn := range iteratorBuiltins() {
+		env.store[name] = fn
+	}
+
 	// HTTP server helpers used by examples/server.flowa
 	// Old response(status, body) function replaced by response module
 
@@ -1079,7 +1271,7 @@ func NewEnvironment() *Environment {
 				}
 			}
 
-			registeredRoutes = append(registeredRoutes, routeDef{
+			reg.routes = append(reg.routes, routeDef{
 				Method:      strings.ToUpper(methodStr.Value),
 				Path:        path,
 				PathPattern: "^" + pattern + "$",
@@ -1101,7 +1293,7 @@ func NewEnvironment() *Environment {
 			if !ok {
 				return newError("argument to `use_middleware` must be FUNCTION, got %s", args[0].Type())
 			}
-			globalMiddlewares = append(globalMiddlewares, middlewareFn)
+			reg.middlewares = append(reg.middlewares, middlewareFn)
 			return NULL
 		},
 	}
@@ -1126,8 +1318,8 @@ func NewEnvironment() *Environment {
 				var matchedRoute *routeDef
 				var pathParams map[string]string
 
-				for i := range registeredRoutes {
-					route := &registeredRoutes[i]
+				for i := range reg.routes {
+					route := &reg.routes[i]
 					if strings.ToUpper(r.Method) != route.Method {
 						continue
 					}
@@ -1186,8 +1378,8 @@ func NewEnvironment() *Environment {
 				}
 
 				// Wrap with global middleware
-				for i := len(globalMiddlewares) - 1; i >= 0; i-- {
-					mw := globalMiddlewares[i]
+				for i := len(reg.middlewares) - 1; i >= 0; i-- {
+					mw := reg.middlewares[i]
 					currentHandler := handler
 					handler = func(req Object) Object {
 						nextFn := &BuiltinFunction{
@@ -1294,7 +1486,7 @@ func NewEnvironment() *Environment {
 			}
 
 			// Register the route
-			registeredRoutes = append(registeredRoutes, routeDef{
+			reg.routes = append(reg.routes, routeDef{
 				Method:      method,
 				Path:        path,
 				Handler:     handler,
@@ -1328,14 +1520,14 @@ func NewEnvironment() *Environment {
 			}
 
 			fmt.Printf("Starting HTTP server on %s\n", port)
-			fmt.Printf("Registered %d route(s)\n", len(registeredRoutes))
+			fmt.Printf("Registered %d route(s)\n", len(reg.routes))
 
 			// Create HTTP server
 			mux := http.NewServeMux()
 
 			// Group routes by path to handle multiple methods per path
 			routesByPath := make(map[string]map[string]routeDef)
-			for _, route := range registeredRoutes {
+			for _, route := range reg.routes {
 				if routesByPath[route.Path] == nil {
 					routesByPath[route.Path] = make(map[string]routeDef)
 				}
@@ -1361,7 +1553,7 @@ func NewEnvironment() *Environment {
 					reqObj := createRequestObject(w, req)
 
 					// Execute handler
-					result := applyFunction(route.Handler, []Object{reqObj})
+					result := callFunction(req.Context(), route.Handler, []Object{reqObj})
 
 					// Handle NULL return (for WebSockets)
 					if result == NULL {
@@ -1418,6 +1610,38 @@ func NewEnvironment() *Environment {
 		},
 	}
 
+	// fs.read_bytes(path) -> Bytes
+	fsModule["read_bytes"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("fs.read_bytes expects 1 argument (path)")
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("fs.read_bytes argument must be STRING")
+			}
+			content, err := os.ReadFile(path.Value)
+			if err != nil {
+				return newError("fs.read_bytes failed: %s", err)
+			}
+			return &Bytes{Value: content}
+		},
+	}
+
+	// fs.lines(path) -> lazy iterator over the lines of a file
+	fsModule["lines"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("fs.lines expects 1 argument (path)")
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("fs.lines argument must be STRING")
+			}
+			return linesIterator(path.Value)
+		},
+	}
+
 	// fs.write(path, content)
 	fsModule["write"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
@@ -1440,6 +1664,28 @@ func NewEnvironment() *Environment {
 		},
 	}
 
+	// fs.write_bytes(path, data)
+	fsModule["write_bytes"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("fs.write_bytes expects 2 arguments (path, data)")
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("fs.write_bytes path must be STRING")
+			}
+			data, ok := args[1].(*Bytes)
+			if !ok {
+				return newError("fs.write_bytes data must be BYTES")
+			}
+			err := os.WriteFile(path.Value, data.Value, 0644)
+			if err != nil {
+				return newError("fs.write_bytes failed: %s", err)
+			}
+			return TRUE
+		},
+	}
+
 	// fs.append(path, content)
 	fsModule["append"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
@@ -1509,113 +1755,111 @@ func NewEnvironment() *Environment {
 	httpModule := make(map[string]Object)
 
 	// http.get(url)
-	httpModule["get"] = &BuiltinFunction{
-		Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("http.get expects 1 argument (url)")
-			}
-			url, ok := args[0].(*String)
-			if !ok {
-				return newError("http.get url must be STRING")
-			}
+	httpModule["get"] = contextBuiltin(func(ctx context.Context, args ...Object) Object {
+		if len(args) != 1 {
+			return newError("http.get expects 1 argument (url)")
+		}
+		url, ok := args[0].(*String)
+		if !ok {
+			return newError("http.get url must be STRING")
+		}
 
-			resp, err := http.Get(url.Value)
-			if err != nil {
-				return newError("http.get failed: %s", err)
-			}
-			defer resp.Body.Close()
+		resp, err := httpGet(ctx, url.Value)
+		if err != nil {
+			return newError("http.get failed: %s", err)
+		}
+		defer resp.Body.Close()
 
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return newError("failed to read response body: %s", err)
-			}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return newError("failed to read response body: %s", err)
+		}
 
-			// Return Response object
-			fields := make(map[string]Object)
-			fields["status"] = &Integer{Value: int64(resp.StatusCode)}
-			fields["body"] = &String{Value: string(bodyBytes)}
+		// Return Response object
+		fields := make(map[string]Object)
+		fields["status"] = &Integer{Value: int64(resp.StatusCode)}
+		fields["body"] = &String{Value: string(bodyBytes)}
+		fields["bytes"] = &Bytes{Value: bodyBytes}
 
-			// Headers
-			headerMap := &Map{Pairs: make(map[Object]Object)}
-			for k, v := range resp.Header {
-				if len(v) > 0 {
-					headerMap.Pairs[&String{Value: k}] = &String{Value: v[0]}
-				}
+		// Headers
+		headerMap := &Map{Pairs: make(map[Object]Object)}
+		for k, v := range resp.Header {
+			if len(v) > 0 {
+				headerMap.Pairs[&String{Value: k}] = &String{Value: v[0]}
 			}
-			fields["headers"] = headerMap
+		}
+		fields["headers"] = headerMap
 
-			return &StructInstance{Name: "Response", Fields: fields}
-		},
-	}
+		return &StructInstance{Name: "Response", Fields: fields}
+	})
 
 	// http.post(url, body, headers)
-	httpModule["post"] = &BuiltinFunction{
-		Fn: func(args ...Object) Object {
-			if len(args) < 2 {
-				return newError("http.post expects at least 2 arguments (url, body)")
-			}
-			url, ok := args[0].(*String)
+	httpModule["post"] = contextBuiltin(func(ctx context.Context, args ...Object) Object {
+		if len(args) < 2 {
+			return newError("http.post expects at least 2 arguments (url, body)")
+		}
+		url, ok := args[0].(*String)
+		if !ok {
+			return newError("http.post url must be STRING")
+		}
+
+		var bodyReader io.Reader
+		if args[1] != NULL {
+			bodyStr, ok := args[1].(*String)
 			if !ok {
-				return newError("http.post url must be STRING")
+				return newError("http.post body must be STRING or NULL")
 			}
+			bodyReader = strings.NewReader(bodyStr.Value)
+		}
 
-			var bodyReader io.Reader
-			if args[1] != NULL {
-				bodyStr, ok := args[1].(*String)
-				if !ok {
-					return newError("http.post body must be STRING or NULL")
-				}
-				bodyReader = strings.NewReader(bodyStr.Value)
-			}
+		req, err := http.NewRequestWithContext(ctx, "POST", url.Value, bodyReader)
+		if err != nil {
+			return newError("failed to create request: %s", err)
+		}
 
-			req, err := http.NewRequest("POST", url.Value, bodyReader)
-			if err != nil {
-				return newError("failed to create request: %s", err)
-			}
-
-			// Optional headers
-			if len(args) > 2 {
-				headers, ok := args[2].(*Map)
-				if ok {
-					for k, v := range headers.Pairs {
-						keyStr, ok1 := k.(*String)
-						valStr, ok2 := v.(*String)
-						if ok1 && ok2 {
-							req.Header.Set(keyStr.Value, valStr.Value)
-						}
+		// Optional headers
+		if len(args) > 2 {
+			headers, ok := args[2].(*Map)
+			if ok {
+				for k, v := range headers.Pairs {
+					keyStr, ok1 := k.(*String)
+					valStr, ok2 := v.(*String)
+					if ok1 && ok2 {
+						req.Header.Set(keyStr.Value, valStr.Value)
 					}
 				}
 			}
+		}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				return newError("http.post failed: %s", err)
-			}
-			defer resp.Body.Close()
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			return newError("http.post failed: %s", err)
+		}
+		defer resp.Body.Close()
 
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return newError("failed to read response body: %s", err)
-			}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return newError("failed to read response body: %s", err)
+		}
 
-			// Return Response object
-			fields := make(map[string]Object)
-			fields["status"] = &Integer{Value: int64(resp.StatusCode)}
-			fields["body"] = &String{Value: string(bodyBytes)}
+		// Return Response object
+		fields := make(map[string]Object)
+		fields["status"] = &Integer{Value: int64(resp.StatusCode)}
+		fields["body"] = &String{Value: string(bodyBytes)}
+		fields["bytes"] = &Bytes{Value: bodyBytes}
 
-			// Headers
-			headerMap := &Map{Pairs: make(map[Object]Object)}
-			for k, v := range resp.Header {
-				if len(v) > 0 {
-					headerMap.Pairs[&String{Value: k}] = &String{Value: v[0]}
-				}
+		// Headers
+		headerMap := &Map{Pairs: make(map[Object]Object)}
+		for k, v := range resp.Header {
+			if len(v) > 0 {
+				headerMap.Pairs[&String{Value: k}] = &String{Value: v[0]}
 			}
-			fields["headers"] = headerMap
+		}
+		fields["headers"] = headerMap
 
-			return &StructInstance{Name: "Response", Fields: fields}
-		},
-	}
+		return &StructInstance{Name: "Response", Fields: fields}
+	})
 
 	env.store["http"] = &StructInstance{Name: "HTTP", Fields: httpModule}
 
@@ -1623,24 +1867,154 @@ func NewEnvironment() *Environment {
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	// Builtins live in the outermost environment and are found through
+	// the outer chain, so enclosed scopes start out empty.
+	return &Environment{store: make(map[string]Object), outer: outer}
+}
+
+// context returns the context of the task this scope runs in, or nil
+// outside any task.
+func (e *Environment) context() context.Context {
+	for ; e != nil; e = e.outer {
+		if e.ctx != nil {
+			return e.ctx
+		}
+	}
+	return nil
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
+	if e.globals[name] {
+		return e.global().Get(name)
+	}
+	if target, ok := e.nonlocals[name]; ok {
+		return target.Get(name)
+	}
+	e.mu.RLock()
 	obj, ok := e.store[name]
+	e.mu.RUnlock()
 	if !ok && e.outer != nil {
 		obj, ok = e.outer.Get(name)
 	}
 	return obj, ok
 }
 
+// Set binds name in this scope, unless it was declared `global` or
+// `nonlocal`, in which case the binding in the referenced scope is updated.
 func (e *Environment) Set(name string, val Object) Object {
+	if e.globals[name] {
+		return e.global().Set(name, val)
+	}
+	if target, ok := e.nonlocals[name]; ok {
+		return target.Set(name, val)
+	}
+	e.mu.Lock()
 	e.store[name] = val
+	e.mu.Unlock()
 	return val
 }
 
+// DeclareGlobal makes name refer to the module-level binding for the rest
+// of this scope.
+func (e *Environment) DeclareGlobal(name string) {
+	if e.globals == nil {
+		e.globals = make(map[string]bool)
+	}
+	e.globals[name] = true
+}
+
+// DeclareNonlocal makes name refer to the nearest enclosing scope (other
+// than the module-level one) that already binds it.
+func (e *Environment) DeclareNonlocal(name string) error {
+	for scope := e.outer; scope != nil && scope.outer != nil; scope = scope.outer {
+		scope.mu.RLock()
+		_, ok := scope.store[name]
+		scope.mu.RUnlock()
+		if ok {
+			if e.nonlocals == nil {
+				e.nonlocals = make(map[string]*Environment)
+			}
+			e.nonlocals[name] = scope
+			return nil
+		}
+	}
+	return fmt.Errorf("no binding for nonlocal '%s' found", name)
+}
+
+// Names returns the names bound directly in this scope, sorted. Tools
+// such as the REPL use it to list and complete bindings.
+func (e *Environment) Names() []string {
+	e.mu.RLock()
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	e.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// SetOutput redirects print, puts and inspect for every scope that
+// shares this environment's builtins. The test runner uses it to
+// capture each test's output.
+func (e *Environment) SetOutput(w io.Writer) {
+	e.global().out = w
+}
+
+// Scheduler returns the scheduler that jobs registered in this
+// environment run on.
+func (e *Environment) Scheduler() *Scheduler {
+	return e.global().reg.scheduler
+}
+
+// newImportEnvironment returns the scope an imported file is evaluated
+// in. It writes where the importer does and registers into the same
+// routes, jobs and events.
+func newImportEnvironment(importer *Environment) *Environment {
+	root := importer.global()
+	env := newEnvironment(root.reg)
+	env.out = root.out
+	if activeHooks.Load() != nil {
+		enterModule(env, importer.currentFrame())
+	}
+	return env
+}
+
+// Outer returns the enclosing scope, or nil for the outermost one.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Assign rebinds name in the nearest scope that defines it and reports
+// whether there was one. Debuggers use it to modify variables.
+func (e *Environment) Assign(name string, val Object) bool {
+	if e.globals[name] {
+		return e.global().Assign(name, val)
+	}
+	if target, ok := e.nonlocals[name]; ok {
+		return target.Assign(name, val)
+	}
+	e.mu.Lock()
+	_, ok := e.store[name]
+	if ok {
+		e.store[name] = val
+	}
+	e.mu.Unlock()
+	if !ok && e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return ok
+}
+
+// global returns the outermost (module-level) environment.
+func (e *Environment) global() *Environment {
+	env := e
+	for env.outer != nil {
+		env = env.outer
+	}
+	return env
+}
+
 var (
 	NULL  = &Null{}
 	TRUE  = &Boolean{Value: true}
@@ -1661,9 +2035,13 @@ func Eval(node ast.Node, env *Environment) Object {
 		return &ReturnValue{Value: val}
 	case *ast.FunctionStatement:
 		fn := &Function{
+			Name:       node.Name.Value,
 			Parameters: node.Parameters,
+			Patterns:   node.ParameterPatterns,
 			Body:       node.Body,
 			Env:        env,
+
+			IsGenerator: node.IsGenerator,
 		}
 		env.Set(node.Name.Value, fn)
 		return fn
@@ -1674,6 +2052,15 @@ func Eval(node ast.Node, env *Environment) Object {
 		}
 		env.Set(node.Name.Value, val)
 		return val
+	case *ast.DestructureStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if errObj := destructure(node.Target, val, env); errObj != nil {
+			return errObj
+		}
+		return val
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.WhileStatement:
@@ -1688,8 +2075,27 @@ func Eval(node ast.Node, env *Environment) Object {
 		return evalFromImportStatement(node, env)
 	case *ast.TypeStatement:
 		return evalTypeStatement(node, env)
+	case *ast.MatchStatement:
+		return evalMatchStatement(node, env)
+	case *ast.SelectStatement:
+		return evalSelectStatement(node, env)
+	case *ast.ScheduleStatement:
+		return evalScheduleStatement(node, env)
+	case *ast.AssertStatement:
+		return evalAssertStatement(node, env)
+	case *ast.GlobalStatement:
+		return evalGlobalStatement(node, env)
+	case *ast.NonlocalStatement:
+		return evalNonlocalStatement(node, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &BigInt{Value: new(big.Int).Set(node.Big)}
+		}
 		return &Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &Float{Value: node.Value}
+	case *ast.DurationLiteral:
+		return &Duration{Value: node.Value}
 	case *ast.StringLiteral:
 		return &String{Value: node.Value}
 	case *ast.Boolean:
@@ -1725,15 +2131,40 @@ func Eval(node ast.Node, env *Environment) Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return callFunction(callContext(env), function, args)
 	case *ast.SpawnExpression:
 		return evalSpawnExpression(node, env)
 	case *ast.AwaitExpression:
 		return evalAwaitExpression(node, env)
+	case *ast.YieldExpression:
+		var val Object = NULL
+		if node.Value != nil {
+			val = Eval(node.Value, env)
+			if isError(val) {
+				return val
+			}
+		}
+		return evalYieldExpression(val, env)
 	case *ast.MapLiteral:
 		return evalMapLiteral(node, env)
+	case *ast.ListComprehension:
+		return evalListComprehension(node, env)
+	case *ast.MapComprehension:
+		return evalMapComprehension(node, env)
+	case *ast.SetComprehension:
+		return evalSetComprehension(node, env)
+	case *ast.SetLiteral:
+		return evalSetLiteral(node, env)
 	case *ast.ArrayLiteral:
 		return evalArrayLiteral(node, env)
+	case *ast.TupleLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &Tuple{Elements: elements}
+	case *ast.SpreadExpression:
+		return newError("spread %s is only allowed in array literals and call arguments", node.String())
 	case *ast.MemberExpression:
 		return evalMemberExpression(node, env)
 	case *ast.IndexExpression:
@@ -1746,6 +2177,8 @@ func Eval(node ast.Node, env *Environment) Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.ServiceStatement:
@@ -1759,8 +2192,14 @@ func Eval(node ast.Node, env *Environment) Object {
 }
 
 func evalProgram(program *ast.Program, env *Environment) Object {
+	if hooks := activeHooks.Load(); hooks != nil && env.outer == nil && env.frame == nil {
+		enterModule(env, nil)
+	}
 	var result Object
 	for _, statement := range program.Statements {
+		if hooks := activeHooks.Load(); hooks != nil {
+			traceStatement(hooks, statement, env)
+		}
 		result = Eval(statement, env)
 		if rv, ok := result.(*ReturnValue); ok {
 			return rv.Value
@@ -1775,6 +2214,9 @@ func evalProgram(program *ast.Program, env *Environment) Object {
 func evalBlockStatement(block *ast.BlockStatement, env *Environment) Object {
 	var result Object
 	for _, statement := range block.Statements {
+		if hooks := activeHooks.Load(); hooks != nil {
+			traceStatement(hooks, statement, env)
+		}
 		result = Eval(statement, env)
 		if result != nil {
 			rt := result.Type()
@@ -1790,7 +2232,7 @@ func evalPrefixExpression(operator string, right Object) Object {
 	switch operator {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
-	case "!":
+	case "!", "not":
 		return evalBangOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
@@ -1798,10 +2240,21 @@ func evalPrefixExpression(operator string, right Object) Object {
 }
 
 func evalMinusPrefixOperatorExpression(right Object) Object {
+	switch right := right.(type) {
+	case *BigInt:
+		return normalizeBigInt(new(big.Int).Neg(right.Value))
+	case *Decimal:
+		return &Decimal{Unscaled: new(big.Int).Neg(right.Unscaled), Scale: right.Scale}
+	case *Float:
+		return &Float{Value: -right.Value}
+	}
 	if right.Type() != "INTEGER" {
 		return newError("unknown operator: -%s", right.Type())
 	}
 	value := right.(*Integer).Value
+	if value == math.MinInt64 {
+		return &BigInt{Value: new(big.Int).Neg(big.NewInt(value))}
+	}
 	return &Integer{Value: -value}
 }
 
@@ -1819,12 +2272,27 @@ func evalBangOperatorExpression(right Object) Object {
 }
 
 func evalInfixExpression(operator string, left, right Object) Object {
+	if operator == "in" || operator == "not in" {
+		return evalMembershipExpression(operator, left, right)
+	}
+	if left.Type() == "SET" && right.Type() == "SET" {
+		return evalSetInfixExpression(operator, left.(*Set), right.(*Set))
+	}
 	if left.Type() == "INTEGER" && right.Type() == "INTEGER" {
 		return evalIntegerInfixExpression(operator, left, right)
 	}
 	if left.Type() == "STRING" && right.Type() == "STRING" {
 		return evalStringInfixExpression(operator, left, right)
 	}
+	if left.Type() == "BYTES" && right.Type() == "BYTES" {
+		return evalBytesInfixExpression(operator, left.(*Bytes), right.(*Bytes))
+	}
+	if isNumber(left) && isNumber(right) {
+		return evalNumericInfixExpression(operator, left, right)
+	}
+	if isTimeValue(left) || isTimeValue(right) {
+		return evalTimeInfixExpression(operator, left, right)
+	}
 	if operator == "==" {
 		return evalEqualInfixExpression(left, right)
 	}
@@ -1841,17 +2309,21 @@ func evalIntegerInfixExpression(operator string, left, right Object) Object {
 	leftVal := left.(*Integer).Value
 	rightVal := right.(*Integer).Value
 	switch operator {
-	case "+":
-		return &Integer{Value: leftVal + rightVal}
-	case "-":
-		return &Integer{Value: leftVal - rightVal}
-	case "*":
-		return &Integer{Value: leftVal * rightVal}
-	case "/":
-		if rightVal == 0 {
+	case "+", "-", "*", "/":
+		if operator == "/" && rightVal == 0 {
 			return newError("division by zero")
 		}
-		return &Integer{Value: leftVal / rightVal}
+		if result, ok := checkedIntegerOp(operator, leftVal, rightVal); ok {
+			return &Integer{Value: result}
+		}
+		// Overflowed int64: redo the operation with arbitrary precision
+		return evalBigIntInfixExpression(operator, big.NewInt(leftVal), big.NewInt(rightVal))
+	case "|":
+		return &Integer{Value: leftVal | rightVal}
+	case "&":
+		return &Integer{Value: leftVal & rightVal}
+	case "^":
+		return &Integer{Value: leftVal ^ rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -2064,7 +2536,7 @@ func evalRouteStatement(node *ast.RouteStatement, env *Environment) Object {
 		// Handler signature: def handler(req)
 		args := []Object{reqObj}
 
-		result := applyFunction(handlerObj, args)
+		result := callFunction(r.Context(), handlerObj, args)
 
 		// Handle result
 		if isError(result) {
@@ -2201,6 +2673,13 @@ func createRequestObjectWithParams(w http.ResponseWriter, r *http.Request, pathP
 		},
 	}
 
+	// req.bytes() - returns the raw body as Bytes
+	fields["bytes"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			return &Bytes{Value: bodyBytes}
+		},
+	}
+
 	// req.json() - returns parsed JSON as map (simplified - just returns raw for now)
 	fields["json"] = &BuiltinFunction{
 		Fn: func(args ...Object) Object {
@@ -2258,9 +2737,12 @@ func writeHTTPResponse(w http.ResponseWriter, resp *StructInstance) {
 
 	// Extract and write body
 	if bodyObj, ok := resp.Fields["body"]; ok {
-		if body, ok := bodyObj.(*String); ok {
+		switch body := bodyObj.(type) {
+		case *String:
 			fmt.Fprint(w, body.Value)
-		} else {
+		case *Bytes:
+			w.Write(body.Value)
+		default:
 			fmt.Fprint(w, bodyObj.Inspect())
 		}
 	}
@@ -2281,6 +2763,18 @@ func evalIdentifier(node *ast.Identifier, env *Environment) Object {
 func evalExpressions(exps []ast.Expression, env *Environment) []Object {
 	var result []Object
 	for _, e := range exps {
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			evaluated := Eval(spread.Value, env)
+			if isError(evaluated) {
+				return []Object{evaluated}
+			}
+			elements, ok := sequenceElements(evaluated)
+			if !ok {
+				return []Object{newError("cannot spread %s", evaluated.Type())}
+			}
+			result = append(result, elements...)
+			continue
+		}
 		evaluated := Eval(e, env)
 		if isError(evaluated) {
 			return []Object{evaluated}
@@ -2290,25 +2784,82 @@ func evalExpressions(exps []ast.Expression, env *Environment) []Object {
 	return result
 }
 
+// sequenceElements returns the elements of an array or tuple.
+func sequenceElements(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Elements, true
+	case *Tuple:
+		return obj.Elements, true
+	case *Range:
+		return obj.elements(obj.Len()), true
+	default:
+		return nil, false
+	}
+}
+
+// asArray returns obj as an array, materializing a range, for the
+// builtins that predate lazy ranges and only accept arrays.
+func asArray(obj Object) (*Array, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj, true
+	case *Range:
+		return obj.toArray(), true
+	default:
+		return nil, false
+	}
+}
+
 func applyFunction(fn Object, args []Object) Object {
+	return callFunction(nil, fn, args)
+}
+
+// callFunction calls fn on behalf of code running under ctx, so that
+// cancellable builtins deep in the call observe the task's cancellation.
+// A nil ctx leaves the callee with the context of its defining scope.
+func callFunction(ctx context.Context, fn Object, args []Object) Object {
 	switch fn := fn.(type) {
 	case *Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		extendedEnv, errObj := extendFunctionEnv(fn, args)
+		if errObj != nil {
+			return errObj
+		}
+		ctx, caller := splitCaller(ctx)
+		extendedEnv.ctx = ctx
+		if hooks := activeHooks.Load(); hooks != nil {
+			return callTraced(hooks, fn, extendedEnv, caller)
+		}
+		if fn.IsGenerator {
+			return newGenerator(fn, extendedEnv)
+		}
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *BuiltinFunction:
+		if fn.CtxFn != nil && ctx != nil {
+			return fn.CtxFn(ctx, args...)
+		}
 		return fn.Fn(args...)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *Function, args []Object) *Environment {
+func extendFunctionEnv(fn *Function, args []Object) (*Environment, Object) {
+	if len(args) < len(fn.Parameters) {
+		return nil, newError("wrong number of arguments. got=%d, want=%d", len(args), len(fn.Parameters))
+	}
 	env := NewEnclosedEnvironment(fn.Env)
 	for paramIdx, param := range fn.Parameters {
+		if fn.Patterns != nil && fn.Patterns[paramIdx] != nil {
+			if errObj := destructure(fn.Patterns[paramIdx], args[paramIdx], env); errObj != nil {
+				return nil, errObj
+			}
+			continue
+		}
 		env.Set(param.Value, args[paramIdx])
 	}
-	return env
+	return env, nil
 }
 
 func unwrapReturnValue(obj Object) Object {
@@ -2339,6 +2890,13 @@ func evalIfExpression(ie *ast.IfExpression, env *Environment) Object {
 	if isError(condition) {
 		return condition
 	}
+	if hooks := activeHooks.Load(); hooks != nil {
+		branch := 1
+		if isTruthy(condition) {
+			branch = 0
+		}
+		traceBranch(hooks, ie, branch, env)
+	}
 	if isTruthy(condition) {
 		return Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
@@ -2351,10 +2909,20 @@ func evalIfExpression(ie *ast.IfExpression, env *Environment) Object {
 func evalWhileStatement(ws *ast.WhileStatement, env *Environment) Object {
 	var result Object = NULL
 	for {
+		if errObj := checkCancelled(env); errObj != nil {
+			return errObj
+		}
 		condition := Eval(ws.Condition, env)
 		if isError(condition) {
 			return condition
 		}
+		if hooks := activeHooks.Load(); hooks != nil {
+			branch := 1
+			if isTruthy(condition) {
+				branch = 0
+			}
+			traceBranch(hooks, ws, branch, env)
+		}
 		if !isTruthy(condition) {
 			break
 		}
@@ -2373,17 +2941,40 @@ func evalForStatement(fs *ast.ForStatement, env *Environment) Object {
 	if isError(iterable) {
 		return iterable
 	}
-	array, ok := iterable.(*Array)
+	it, ok := iterateIn(iterable, env)
 	if !ok {
-		return newError("for-loop value must be ARRAY, got %s", iterable.Type())
+		return newError("for-loop value must be iterable, got %s", iterable.Type())
 	}
+	// Leaving the loop early (return or error) releases the iterator
+	defer it.Close()
 
 	var result Object = NULL
-	for _, elem := range array.Elements {
-		// New inner scope for each iteration
-		iterEnv := NewEnclosedEnvironment(env)
-		iterEnv.Set(fs.Iterator.Value, elem)
-		result = Eval(fs.Body, iterEnv)
+	for {
+		elem, ok := it.Next()
+		if !ok {
+			if hooks := activeHooks.Load(); hooks != nil {
+				traceBranch(hooks, fs, 1, env)
+			}
+			break
+		}
+		if isError(elem) {
+			return elem
+		}
+		if hooks := activeHooks.Load(); hooks != nil {
+			traceBranch(hooks, fs, 0, env)
+		}
+		if errObj := checkCancelled(env); errObj != nil {
+			return errObj
+		}
+		// The loop variable and body share the enclosing scope
+		if fs.Target != nil {
+			if errObj := destructure(fs.Target, elem, env); errObj != nil {
+				return errObj
+			}
+		} else {
+			env.Set(fs.Iterator.Value, elem)
+		}
+		result = Eval(fs.Body, env)
 		if result != nil {
 			if result.Type() == "RETURN_VALUE" || result.Type() == "ERROR" {
 				return result
@@ -2393,19 +2984,59 @@ func evalForStatement(fs *ast.ForStatement, env *Environment) Object {
 	return result
 }
 
+func evalGlobalStatement(gs *ast.GlobalStatement, env *Environment) Object {
+	if env.outer == nil {
+		// Already at module level; nothing to redirect
+		return NULL
+	}
+	for _, name := range gs.Names {
+		env.DeclareGlobal(name.Value)
+	}
+	return NULL
+}
+
+func evalNonlocalStatement(ns *ast.NonlocalStatement, env *Environment) Object {
+	if env.outer == nil {
+		return newError("nonlocal declaration not allowed at module level")
+	}
+	for _, name := range ns.Names {
+		if err := env.DeclareNonlocal(name.Value); err != nil {
+			return newError("%s", err)
+		}
+	}
+	return NULL
+}
+
 func evalPipelineExpression(pe *ast.PipelineExpression, env *Environment) Object {
+	if right, ok := pe.Right.(*ast.PipelineExpression); ok {
+		// Allow chaining inside the right-hand side
+		rightWithLeft := &ast.PipelineExpression{
+			Token: right.Token,
+			Left:  &ast.PipelineExpression{Token: pe.Token, Left: pe.Left, Right: right.Left},
+			Right: right.Right,
+		}
+		return evalPipelineExpression(rightWithLeft, env)
+	}
+
 	leftVal := Eval(pe.Left, env)
 	if isError(leftVal) {
 		return leftVal
 	}
+	if hooks := activeHooks.Load(); hooks != nil {
+		return traceStage(hooks, pe, leftVal, env)
+	}
+	return evalPipelineStage(pe, leftVal, env)
+}
 
+// evalPipelineStage passes leftVal to the right-hand side of pe.
+func evalPipelineStage(pe *ast.PipelineExpression, leftVal Object, env *Environment) Object {
 	switch right := pe.Right.(type) {
 	case *ast.Identifier:
 		fn := evalIdentifier(right, env)
 		if isError(fn) {
 			return fn
 		}
-		return applyFunction(fn, []Object{leftVal})
+		return callFunction(callContext(env), fn, []Object{leftVal})
 	case *ast.CallExpression:
 		// Evaluate function part and arguments separately
 		fn := Eval(right.Function, env)
@@ -2418,42 +3049,12 @@ func evalPipelineExpression(pe *ast.PipelineExpression, env *Environment) Object
 		}
 		// Prepend pipeline value
 		allArgs := append([]Object{leftVal}, args...)
-		return applyFunction(fn, allArgs)
-	case *ast.PipelineExpression:
-		// Allow chaining inside the right-hand side
-		rightWithLeft := &ast.PipelineExpression{
-			Token: pe.Token,
-			Left:  &ast.PipelineExpression{Token: pe.Token, Left: pe.Left, Right: right.Left},
-			Right: right.Right,
-		}
-		return evalPipelineExpression(rightWithLeft, env)
+		return callFunction(callContext(env), fn, allArgs)
 	default:
 		return newError("invalid right-hand side of pipeline: %T", pe.Right)
 	}
 }
 
-func evalSpawnExpression(se *ast.SpawnExpression, env *Environment) Object {
-	// Synchronous "spawn": evaluate the expression immediately and wrap in a Task.
-	val := Eval(se.Call, env)
-	if isError(val) {
-		return val
-	}
-	return &Task{Result: val, Done: true}
-}
-
-func evalAwaitExpression(ae *ast.AwaitExpression, env *Environment) Object {
-	val := Eval(ae.Value, env)
-	if isError(val) {
-		return val
-	}
-	task, ok := val.(*Task)
-	if !ok {
-		return newError("await can only be used on tasks, got %s", val.Type())
-	}
-	// Block until the task has completed and return its result.
-	return task.Await()
-}
-
 func evalModuleStatement(ms *ast.ModuleStatement, env *Environment) Object {
 	moduleEnv := NewEnclosedEnvironment(env)
 	// Evaluate body inside the module environment
@@ -2484,9 +3085,12 @@ func evalImportStatement(node *ast.ImportStatement, env *Environment) Object {
 	if len(p.Errors()) > 0 {
 		return newError("parse errors in %s: %s", path, strings.Join(p.Errors(), ", "))
 	}
+	if hooks := activeHooks.Load(); hooks != nil && hooks.Load != nil {
+		hooks.Load(path, program)
+	}
 
 	// Evaluate in new env
-	newEnv := NewEnvironment()
+	newEnv := newImportEnvironment(env)
 	evalProgram(program, newEnv)
 
 	// For `import "path"`, we could bind the module to a variable derived from path
@@ -2516,9 +3120,12 @@ func evalFromImportStatement(node *ast.FromImportStatement, env *Environment) Ob
 	if len(p.Errors()) > 0 {
 		return newError("parse errors in %s: %s", path, strings.Join(p.Errors(), ", "))
 	}
+	if hooks := activeHooks.Load(); hooks != nil && hooks.Load != nil {
+		hooks.Load(path, program)
+	}
 
 	// Evaluate in new env
-	newEnv := NewEnvironment()
+	newEnv := newImportEnvironment(env)
 	evalProgram(program, newEnv)
 
 	// Import all symbols
@@ -2550,12 +3157,15 @@ func evalTypeStatement(ts *ast.TypeStatement, env *Environment) Object {
 					ts.Name.Value, len(args), len(ts.Fields))
 			}
 			fields := make(map[string]Object, len(ts.Fields))
+			order := make([]string, len(ts.Fields))
 			for i, field := range ts.Fields {
 				fields[field.Value] = args[i]
+				order[i] = field.Value
 			}
 			return &StructInstance{
 				Name:   ts.Name.Value,
 				Fields: fields,
+				Order:  order,
 			}
 		},
 	}
@@ -2581,6 +3191,30 @@ func evalMemberExpression(me *ast.MemberExpression, env *Environment) Object {
 			return val
 		}
 		return NULL
+	case *Time:
+		return timeField(v, propName)
+	case *Duration:
+		return durationField(v, propName)
+	case *Regex:
+		return regexMethod(v, propName)
+	case *Bytes:
+		return bytesMethod(v, propName)
+	case *Task:
+		return taskField(v, propName)
+	case *Channel:
+		return channelMethod(v, propName)
+	case *Pool:
+		return poolMethod(v, propName)
+	case *Job:
+		return jobField(v, propName)
+	case *Subscription:
+		return subscriptionField(v, propName)
+	case *Mutex:
+		return mutexMethod(v, propName)
+	case *WaitGroup:
+		return waitGroupMethod(v, propName)
+	case *Atomic:
+		return atomicMethod(v, propName)
 	case *Map:
 		// Allow map["key"] style via member for string-like keys
 		key := &String{Value: propName}
@@ -2607,6 +3241,12 @@ func evalIndexExpression(left, index Object) Object {
 		return evalMapIndexExpression(left, index)
 	case left.Type() == "ARRAY":
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == "TUPLE":
+		return evalArrayIndexExpression(&Array{Elements: left.(*Tuple).Elements}, index)
+	case left.Type() == "RANGE":
+		return evalRangeIndexExpression(left.(*Range), index)
+	case left.Type() == "BYTES":
+		return evalBytesIndexExpression(left.(*Bytes), index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -2641,13 +3281,130 @@ func evalArrayIndexExpression(arrayObj, index Object) Object {
 	return arrayObject.Elements[idx.Value]
 }
 
+func evalRangeIndexExpression(r *Range, index Object) Object {
+	idx, ok := index.(*Integer)
+	if !ok {
+		return newError("range index must be INTEGER, got %s", index.Type())
+	}
+	if idx.Value < 0 || idx.Value >= r.Len() {
+		return NULL
+	}
+	return &Integer{Value: r.Start + idx.Value*r.Step}
+}
+
+// mapGet looks up key in m using the same key comparison as indexing.
+func mapGet(m *Map, key Object) (Object, bool) {
+	for k, v := range m.Pairs {
+		if compareKeys(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// mapSet stores value under key, replacing an existing equal key.
+func mapSet(m *Map, key, value Object) {
+	for k := range m.Pairs {
+		if compareKeys(k, key) {
+			m.Pairs[k] = value
+			return
+		}
+	}
+	m.Pairs[key] = value
+}
+
+// objectsEqual reports whether two values are structurally equal.
+func objectsEqual(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		if isNumber(b) && b.Type() != "INTEGER" {
+			return objectsEqual(b, a)
+		}
+		bv, ok := b.(*Integer)
+		return ok && a.Value == bv.Value
+	case *String:
+		bv, ok := b.(*String)
+		return ok && a.Value == bv.Value
+	case *Boolean:
+		bv, ok := b.(*Boolean)
+		return ok && a.Value == bv.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		bv, ok := b.(*Array)
+		return ok && elementsEqual(a.Elements, bv.Elements)
+	case *Tuple:
+		bv, ok := b.(*Tuple)
+		return ok && elementsEqual(a.Elements, bv.Elements)
+	case *Map:
+		bv, ok := b.(*Map)
+		if !ok || len(a.Pairs) != len(bv.Pairs) {
+			return false
+		}
+		for k, v := range a.Pairs {
+			other, found := mapGet(bv, k)
+			if !found || !objectsEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case *Set:
+		bv, ok := b.(*Set)
+		return ok && len(a.Elements) == len(bv.Elements) && a.isSubset(bv)
+	case *Time:
+		bv, ok := b.(*Time)
+		return ok && a.Value.Equal(bv.Value)
+	case *Duration:
+		bv, ok := b.(*Duration)
+		return ok && a.Value == bv.Value
+	case *Bytes:
+		bv, ok := b.(*Bytes)
+		return ok && bytes.Equal(a.Value, bv.Value)
+	case *BigInt, *Decimal, *Float:
+		if !isNumber(b) {
+			return false
+		}
+		return evalNumericInfixExpression("==", a, b) == TRUE
+	case *StructInstance:
+		bv, ok := b.(*StructInstance)
+		if !ok || a.Name != bv.Name || len(a.Fields) != len(bv.Fields) {
+			return false
+		}
+		for k, v := range a.Fields {
+			other, found := bv.Fields[k]
+			if !found || !objectsEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func elementsEqual(a, b []Object) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !objectsEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareKeys reports whether two map keys are the same key. It uses the
+// same rule as set membership: equal type and value, with numbers
+// compared by value, so {1.0: "a"}[1] finds the entry but "1" does not.
 func compareKeys(key1, key2 Object) bool {
-	// Handle String keys specially
-	if key1.Type() == "STRING" && key2.Type() == "STRING" {
-		return key1.(*String).Value == key2.(*String).Value
+	h1, ok1 := hashKey(key1)
+	h2, ok2 := hashKey(key2)
+	if ok1 && ok2 {
+		return h1 == h2
 	}
-	// For other types, use Inspect() comparison (simple but works)
-	return key1.Inspect() == key2.Inspect()
+	return objectsEqual(key1, key2)
 }
 
 func isTruthy(obj Object) bool {
@@ -0,0 +1,1912 @@
+package eval
+
+import (
+	"flowa/pkg/ast"
+	"flowa/pkg/lexer"
+	"flowa/pkg/parser"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func testEval(t *testing.T, input string) Object {
+	t.Helper()
+	return testEvalIn(t, input, NewEnvironment())
+}
+
+func testEvalIn(t *testing.T, input string, env *Environment) Object {
+	t.Helper()
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		t.Fatalf("parser errors: %v", errs)
+	}
+	return Eval(program, env)
+}
+
+func testIntegerObject(t *testing.T, obj Object, expected int64) {
+	t.Helper()
+	result, ok := obj.(*Integer)
+	if !ok {
+		t.Fatalf("object is not Integer. got=%T (%+v)", obj, obj)
+	}
+	if result.Value != expected {
+		t.Fatalf("object has wrong value. got=%d, want=%d", result.Value, expected)
+	}
+}
+
+func TestScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+total = 0
+for x in [1, 2, 3]:
+    total = total + x
+total
+`, 6},
+		{`
+i = 0
+while i < 5:
+    if i > 2:
+        last = i
+    i = i + 1
+last
+`, 4},
+		{`
+total = 1
+def f():
+    total = 100
+    return total
+f()
+total
+`, 1},
+		{`
+counter = 0
+def bump():
+    global counter
+    counter = counter + 1
+bump()
+bump()
+counter
+`, 2},
+		{`
+def make():
+    n = 10
+    def inc():
+        nonlocal n
+        n = n + 1
+        return n
+    inc()
+    return inc()
+make()
+`, 12},
+		{`
+def outer():
+    def inner():
+        global created
+        created = 7
+    inner()
+outer()
+created
+`, 7},
+	}
+
+	for i, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("tests[%d] - unexpected error: %s", i, evaluated.Inspect())
+		}
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestNonlocalWithoutBinding(t *testing.T) {
+	input := `
+def f():
+    nonlocal missing
+    missing = 1
+f()
+`
+	evaluated := testEval(t, input)
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "no binding for nonlocal 'missing' found" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestMatchStatement(t *testing.T) {
+	prelude := `
+type Point:
+    x
+    y
+
+def classify(v):
+    match v:
+        case 0:
+            return 1
+        case "GET":
+            return 2
+        case [first, *rest]:
+            return 100 + first + len(rest)
+        case {"kind": "circle", "r": r}:
+            return 200 + r
+        case Point(0, y):
+            return 300 + y
+        case Point(x=x) if x > 10:
+            return 400 + x
+        case n if n == 5000:
+            return 5
+        case _:
+            return 6
+`
+	tests := []struct {
+		call     string
+		expected int64
+	}{
+		{"classify(0)", 1},
+		{`classify("GET")`, 2},
+		{"classify([7, 8, 9])", 109},
+		{`classify({"kind": "circle", "r": 4})`, 204},
+		{`classify({"kind": "square", "r": 4})`, 6},
+		{"classify(Point(0, 3))", 303},
+		{"classify(Point(20, 3))", 420},
+		{"classify(Point(2, 3))", 6},
+		{"classify(5000)", 5},
+		{`classify("other")`, 6},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, prelude+tt.call)
+		if isError(evaluated) {
+			t.Fatalf("%s - unexpected error: %s", tt.call, evaluated.Inspect())
+		}
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestMatchWithoutMatchingCase(t *testing.T) {
+	input := `
+result = 1
+match 42:
+    case 0:
+        result = 2
+result
+`
+	testIntegerObject(t, testEval(t, input), 1)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"a, b = 1, 2\na * 10 + b", 12},
+		{"a = 1\nb = 2\na, b = b, a\na * 10 + b", 21},
+		{"def pair():\n    return 3, 4\nx, y = pair()\nx * y", 12},
+		{"[x, y, *rest] = [1, 2, 3, 4]\nlen(rest) * 100 + x * 10 + y", 212},
+		{"[*init, last] = [1, 2, 3]\nlen(init) * 10 + last", 23},
+		{`{"id": id, "n": n} = {"id": 7, "n": 2, "extra": 0}` + "\nid * n", 14},
+		{"total = 0\nfor k, v in [(1, 2), (3, 4)]:\n    total = total + k * v\ntotal", 14},
+		{"def f([a, b], c):\n    return a + b + c\nf([1, 2], 3)", 6},
+		{"t = (5, 6)\nlen(t) + t[1]", 8},
+	}
+
+	for i, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("tests[%d] - unexpected error: %s", i, evaluated.Inspect())
+		}
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestDestructuringMismatch(t *testing.T) {
+	evaluated := testEval(t, "a, b = [1, 2, 3]")
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "cannot destructure [1, 2, 3] into (a, b)" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	prelude := `
+def count(n):
+    i = 0
+    while i < n:
+        yield i
+        i = i + 1
+
+def naturals():
+    i = 0
+    while True:
+        yield i
+        i = i + 1
+
+def double(x):
+    return x * 2
+
+def small(x):
+    return x < 3
+`
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"total = 0\nfor x in count(5):\n    total = total + x\ntotal", 10},
+		{"len(list(count(4)))", 4},
+		{"g = count(3)\nnext(g)\nnext(g)", 1},
+		{"g = count(1)\nnext(g)\nnext(g, 42)", 42},
+		{"reduce(naturals() |> map(double) |> take(4), def_sum, 0)", 12},
+		{"len(naturals() |> filter(small) |> take(3) |> list)", 3},
+		{"def find():\n    for x in naturals():\n        if x == 7:\n            return x\nfind()", 7},
+		{"first(naturals() |> map(double))", 0},
+	}
+
+	for _, tt := range tests {
+		input := prelude + "def def_sum(a, b):\n    return a + b\n" + tt.input
+		evaluated := testEval(t, input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestGeneratorError(t *testing.T) {
+	input := `
+def broken():
+    yield 1
+    yield missing
+list(broken())
+`
+	evaluated := testEval(t, input)
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "identifier not found: missing" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestGeneratorLifetime(t *testing.T) {
+	before := runtime.NumGoroutine()
+	input := `
+def naturals():
+    i = 0
+    while True:
+        yield i
+        i = i + 1
+
+for n in range(100):
+    g = naturals()
+    next(g)
+`
+	if result := testEval(t, input); isError(result) {
+		t.Fatalf("unexpected error: %s", result.Inspect())
+	}
+	// Abandoned generators are closed once they are collected
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before+10 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before+10 {
+		t.Errorf("generators leaked goroutines: %d before, %d after", before, n)
+	}
+}
+
+func TestGeneratorSharedBetweenTasks(t *testing.T) {
+	input := `
+def count(n):
+    i = 0
+    while i < n:
+        yield i
+        i = i + 1
+
+g = count(200)
+
+def drain():
+    total = 0
+    for x in g:
+        total = total + 1
+    return total
+
+a = spawn drain()
+b = spawn drain()
+(await a) + (await b)
+`
+	evaluated := testEval(t, input)
+	if isError(evaluated) {
+		t.Fatalf("unexpected error: %s", evaluated.Inspect())
+	}
+	testIntegerObject(t, evaluated, 200)
+
+	// The generator is not visible to Flowa code
+	evaluated = testEval(t, "def peek():\n    yield __generator__\nnext(peek())")
+	if errObj, ok := evaluated.(*ErrorObj); !ok || errObj.Message != "identifier not found: __generator__" {
+		t.Errorf("expected __generator__ to be undefined, got %s", evaluated.Inspect())
+	}
+}
+
+func TestGeneratorReentry(t *testing.T) {
+	tests := []string{
+		"def selfish():\n    yield next(g)\ng = selfish()\nnext(g)",
+		"def selfish():\n    for x in g:\n        yield x\ng = selfish()\nnext(g)",
+		"def helper():\n    return next(g)\ndef selfish():\n    yield helper()\ng = selfish()\nnext(g)",
+	}
+
+	for _, input := range tests {
+		done := make(chan Object, 1)
+		go func() { done <- testEval(t, input) }()
+		select {
+		case evaluated := <-done:
+			errObj, ok := evaluated.(*ErrorObj)
+			if !ok || errObj.Message != "generator already executing" {
+				t.Errorf("%q - expected re-entry error, got %s", input, evaluated.Inspect())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q - generator deadlocked on itself", input)
+		}
+	}
+}
+
+func TestLazyIteration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"len(range(10))", 10},
+		{"len(range(2, 11, 3))", 3},
+		{"range(10, 0, -2)[1]", 8},
+		{"total = 0\nfor i in range(1, 4):\n    total = total + i\ntotal", 6},
+		{"total = 0\nfor k, v in {\"a\": 1, \"b\": 2}:\n    total = total + v\ntotal", 3},
+		{"total = 0\nfor i, x in enumerate([5, 6]):\n    total = total + i * x\ntotal", 6},
+		{"total = 0\nfor a, b in zip([1, 2], range(10, 20)):\n    total = total + a * b\ntotal", 32},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestRangeAsArray(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"range(3)", "[0, 1, 2]"},
+		{"range(0)", "[]"},
+		{"def double(x):\n    return x * 2\nrange(3) |> map(double)", "[0, 2, 4]"},
+		{"def double(x):\n    return x * 2\nlen(range(3) |> map(double))", "3"},
+		{"def odd(x):\n    return x & 1 == 1\nrange(6) |> filter(odd)", "[1, 3, 5]"},
+		{"take(range(1000000000000), 3)", "[0, 1, 2]"},
+		{"[last(range(10, 0, -3)), rest(range(3)), push(range(2), 9)]", "[1, [1, 2], [0, 1, 9]]"},
+		{"[range(10)[2:5], range(10, 0, -2)[1:3]]", "[[2, 3, 4], [8, 6]]"},
+		{"a, b, c = range(3)\n[*range(2), a, c]", "[0, 1, 0, 2]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFirstClosesIterator(t *testing.T) {
+	input := "def gen():\n    yield 1\n    yield 2\ng = gen()\n[first(g), next(g, \"closed\")]"
+	evaluated := testEval(t, input)
+	if evaluated.Inspect() != "[1, closed]" {
+		t.Errorf("expected first to close the generator, got %s", evaluated.Inspect())
+	}
+}
+
+func TestFsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	content := strings.Repeat("line\n", 1000)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := "n = 0\nfor line in fs.lines(\"" + path + "\"):\n    n = n + len(line)\nn"
+	testIntegerObject(t, testEval(t, input), 4000)
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"xs = [x * x for x in [1, 2, 3] if x > 1]\nxs[0] + xs[1]", 13},
+		{"len([(a, b) for a in range(3) for b in range(3) if a != b])", 6},
+		{`m = {k: v * 10 for k, v in {"a": 1, "b": 2}}` + "\nm[\"b\"]", 20},
+		{`len({k: 1 for k in ["a", "b", "a"]})`, 2},
+		{"len({x for x in [1, 2, 2, 3, 3, 3]})", 3},
+		{"x = 99\nys = [x for x in [1, 2]]\nx", 99},
+		{"base = 10\n[base + x for x in [1]][0]", 11},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestSets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{1, 2, 2, 3}", "{1, 2, 3}"},
+		{"set([3, 1, 3])", "{3, 1}"},
+		{"set()", "set()"},
+		{"{1, 2} | {2, 3}", "{1, 2, 3}"},
+		{"{1, 2} & {2, 3}", "{2}"},
+		{"{1, 2} - {2, 3}", "{1}"},
+		{"{1, 2} ^ {2, 3}", "{1, 3}"},
+		{"{1, 2} <= {1, 2, 3}", "true"},
+		{"{1, 2} < {1, 2}", "false"},
+		{"{1, 2} == {2, 1}", "true"},
+		{"{(1, 2), (1, 2)}", "{(1, 2)}"},
+		{"2 in {1, 2}", "true"},
+		{"[1, 2] in [[1, 2]]", "true"},
+		{`"id" not in {"id": 1}`, "false"},
+		{`1 in {"1": 2}`, "false"},
+		{`"1" in {1}`, "false"},
+		{"1 in {1.0}", "true"},
+		{`{1.0: "a"}[1]`, "a"},
+		{`{(1, 2): "pair"}[(1.0, 2)]`, "pair"},
+		{`"ell" in "hello"`, "true"},
+		{"5 in range(1, 10, 2)", "true"},
+		{"not 1 == 2", "true"},
+		{"json.encode({1, 2})", "[1,2]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestUnhashableSetElement(t *testing.T) {
+	evaluated := testEval(t, "{[1, 2]}")
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "unhashable type: ARRAY" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestIntegerOverflowPromotes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		typ      string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808", "BIGINT"},
+		{"0 - 9223372036854775807 - 2", "-9223372036854775809", "BIGINT"},
+		{"4294967296 * 4294967296", "18446744073709551616", "BIGINT"},
+		{"(9223372036854775807 + 1) - 1", "9223372036854775807", "INTEGER"},
+		{"9223372036854775807 + 1 > 9223372036854775807", "true", "BOOLEAN"},
+		{"9223372036854775808", "9223372036854775808", "BIGINT"},
+		{"123456789012345678901234567890 - 1", "123456789012345678901234567889", "BIGINT"},
+		{"-9223372036854775808", "-9223372036854775808", "INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated.Type() != tt.typ || evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected %s %s, got %s %s", tt.input, tt.typ, tt.expected, evaluated.Type(), evaluated.Inspect())
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`decimal.new("0.1") + decimal.new("0.2")`, "0.3"},
+		{`decimal.new("19.99") * 3`, "59.97"},
+		{`decimal.new("1") / decimal.new("8")`, "0.125"},
+		{`decimal.new("10.00") - 1`, "9.00"},
+		{`decimal.new("1.50") == decimal.new("1.5")`, "true"},
+		{`decimal.new("1.5") > 1`, "true"},
+		{`decimal.round(decimal.new("2.665"), 2)`, "2.66"},
+		{`decimal.round(decimal.new("2.665"), 2, "half_up")`, "2.67"},
+		{`decimal.round(decimal.new("-1.21"), 1, "ceiling")`, "-1.2"},
+		{`decimal.round(decimal.new("-1.21"), 1, "floor")`, "-1.3"},
+		{`decimal.round(decimal.new("7"), 2)`, "7.00"},
+		{`decimal.div(2, 3, 4)`, "0.6667"},
+		{`decimal.to_int(decimal.new("-7.9"))`, "-7"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestJSONNumberPrecision(t *testing.T) {
+	input := `json.encode(json.decode("[123456789012345678901234567890, 19.99, 7, -0.5]"))`
+	evaluated := testEval(t, input)
+	expected := "[123456789012345678901234567890,19.99,7,-0.5]"
+	if evaluated.Inspect() != expected {
+		t.Fatalf("expected=%s, got=%s", expected, evaluated.Inspect())
+	}
+
+	price := testEval(t, `json.decode("{\"price\": 0.1}")["price"] + decimal.new("0.2")`)
+	if price.Inspect() != "0.3" {
+		t.Fatalf("expected decimal 0.3, got=%s", price.Inspect())
+	}
+}
+
+func TestJWTIntegerClaims(t *testing.T) {
+	input := `
+token = jwt.sign({"id": 123, "big": 9007199254740993, "ratio": 0.5}, "secret", 1h)
+claims = jwt.verify(token, "secret")
+[claims["id"], claims["id"] + 1, claims["big"], claims["ratio"], claims["exp"] / 1000000000]
+`
+	evaluated := testEval(t, input)
+	expected := "[123, 124, 9007199254740993, 0.5, 1]"
+	if evaluated.Inspect() != expected {
+		t.Errorf("expected=%s, got=%s", expected, evaluated.Inspect())
+	}
+}
+
+func TestTimeModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`time.parse("2024-05-01T10:00:00Z") + 90m`, "2024-05-01T11:30:00Z"},
+		{`time.parse("2024-05-02T00:00:00Z") - time.parse("2024-05-01T00:00:00Z")`, "24h0m0s"},
+		{`time.format(time.parse("2024-05-01", "date"), "%d/%m/%Y")`, "01/05/2024"},
+		{`time.format(time.date(2024, 7, 4, 9, 5), "Jan 2, 2006 3:04PM")`, "Jul 4, 2024 9:05AM"},
+		{`time.in_zone(time.parse("2024-01-15T12:00:00Z"), "Asia/Tokyo")`, "2024-01-15T21:00:00+09:00"},
+		{`time.parse("2024-03-10 01:30:00", "datetime", "America/New_York")`, "2024-03-10T01:30:00-05:00"},
+		{`time.unix(time.from_unix(1700000000))`, "1700000000"},
+		{`time.date(2024, 2, 29).weekday`, "Thursday"},
+		{`2d + 30m`, "48h30m0s"},
+		{`90s * 2 == 3m`, "true"},
+		{`time.duration("1d").hours`, "24"},
+		{`json.encode({"at": time.date(2024, 1, 2), "ttl": 5m})`, `{"at":"2024-01-02T00:00:00Z","ttl":"5m0s"}`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestUnknownTimeZone(t *testing.T) {
+	evaluated := testEval(t, `time.now("Nowhere/City")`)
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "unknown time zone: Nowhere/City" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestTimeDateOutOfRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`time.date(2024, 2, 30)`, "time.date day out of range: 30"},
+		{`time.date(2023, 2, 29)`, "time.date day out of range: 29"},
+		{`time.date(2024, 4, 0)`, "time.date day out of range: 0"},
+		{`time.date(2024, 13, 1)`, "time.date month out of range: 13"},
+		{`time.date(2024, 0, 1)`, "time.date month out of range: 0"},
+		{`time.date(2024, 1, 1, 24)`, "time.date hour out of range: 24"},
+		{`time.date(2024, 1, 1, 0, 60)`, "time.date minute out of range: 60"},
+		{`time.date(2024, 1, 1, 0, 0, -1)`, "time.date second out of range: -1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		errObj, ok := evaluated.(*ErrorObj)
+		if !ok {
+			t.Errorf("%q - expected error, got=%s", tt.input, evaluated.Inspect())
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q - wrong error message. got=%q", tt.input, errObj.Message)
+		}
+	}
+}
+
+func TestReModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`re.match("\d+", "42 apples").text`, "42"},
+		{`re.match("\d+", "apples 42")`, "null"},
+		{`re.search("\d+", "apples 42").start`, "7"},
+		{`re.full_match("[a-z]+", "abc1")`, "null"},
+		{`re.find_all("\d+", "1 a 22 b 333")`, `[1, 22, 333]`},
+		{`re.find_all("(\w)=(\d)", "a=1 b=2")`, `[(a, 1), (b, 2)]`},
+		{`re.search("(?P<user>\w+)@(?P<host>[\w.]+)", "mail bob@example.com").named["host"]`, "example.com"},
+		{`re.search("(\w+)@(\w+)", "x@y").group(2)`, "y"},
+		{`re.replace("(\w+) (\w+)", "hello world", "\2 \1")`, "world hello"},
+		{`re.replace("a", "aaa", "$", 2)`, "$$a"},
+		{`def wrap(m):
+    return "<" + m.text + ">"
+re.replace("\d+", "a1b22", wrap)`, "a<1>b<22>"},
+		{`re.split(",\s*", "a, b,c")`, `[a, b, c]`},
+		{`re.split(",", "a,b,c", 1)`, `[a, b,c]`},
+		{`email = re.compile("^[^@]+@[^@]+$")
+email.match("me@example.com") != None`, "true"},
+		{`re.compile("abc", "i").search("xABC").text`, "ABC"},
+		{`re.escape("a.b")`, `a\.b`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestReInvalidPattern(t *testing.T) {
+	evaluated := testEval(t, `re.compile("(")`)
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if !strings.HasPrefix(errObj.Message, "invalid regular expression:") {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1.5 + 2`, "3.5"},
+		{`3 * 0.5`, "1.5"},
+		{`1.0`, "1.0"},
+		{`-2.5 * 2`, "-5.0"},
+		{`7 / 2.0`, "3.5"},
+		{`1.5 < 2`, "true"},
+		{`2.0 == 2`, "true"},
+		{`decimal.new("0.1") + 0.2`, "0.3"},
+		{`{1, 1.0, 2.5}`, "{1, 2.5}"},
+		{`json.encode([1.5, 2])`, "[1.5,2]"},
+		{`int(3.9)`, "3"},
+		{`int("-12")`, "-12"},
+		{`float("2.5") * 2`, "5.0"},
+		{`min(3, 1.5, 2)`, "1.5"},
+		{`max([4, 9, 2])`, "9"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMathModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`math.abs(-4)`, "4"},
+		{`math.abs(decimal.new("-1.25"))`, "1.25"},
+		{`math.sqrt(16)`, "4.0"},
+		{`math.pow(2, 10)`, "1024"},
+		{`math.pow(2, 100)`, "1267650600228229401496703205376"},
+		{`math.pow(2, 500000) > 0`, "true"},
+		{`math.pow(-1, 100000000001)`, "-1"},
+		{`math.pow(4, 0.5)`, "2.0"},
+		{`math.floor(-2.5)`, "-3"},
+		{`math.ceil(2.1)`, "3"},
+		{`math.round(2.5)`, "2"},
+		{`math.round(3.14159, 2)`, "3.14"},
+		{`math.round(decimal.new("2.675"), 2)`, "2.68"},
+		{`math.clamp(15, 0, 10)`, "10"},
+		{`math.is_nan(math.nan)`, "true"},
+		{`math.floor(math.pi * 100)`, "314"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestRandomSeedIsDeterministic(t *testing.T) {
+	input := `
+random.seed(42)
+[random.randint(1, 100), random.choice(["x", "y", "z"]), random.shuffle([1, 2, 3, 4, 5]), random.sample(range(10), 3), random.random()]
+`
+	first := testEval(t, input)
+	second := testEval(t, input)
+	if isError(first) {
+		t.Fatalf("unexpected error: %s", first.Inspect())
+	}
+	if first.Inspect() != second.Inspect() {
+		t.Fatalf("expected identical sequences after reseeding, got=%s and %s", first.Inspect(), second.Inspect())
+	}
+
+	shuffled := testEval(t, "random.seed(7)\nset(random.shuffle([1, 2, 3, 4, 5, 6])) == {1, 2, 3, 4, 5, 6}")
+	if shuffled != TRUE {
+		t.Fatalf("shuffle must keep all elements, got=%s", shuffled.Inspect())
+	}
+}
+
+func TestSecretsAndUUID(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(secrets.token_hex(16))`, "32"},
+		{`secrets.token_hex() == secrets.token_hex()`, "false"},
+		{`secrets.compare("abc", "abc")`, "true"},
+		{`secrets.randint(5, 5)`, "5"},
+		{`uuid.is_valid(uuid.v4())`, "true"},
+		{`re.match("[0-9a-f]{8}-[0-9a-f]{4}-4", uuid.v4()) != None`, "true"},
+		{`re.match("[0-9a-f]{8}-[0-9a-f]{4}-7", uuid.v7()) != None`, "true"},
+		{`uuid.v4() != uuid.v4()`, "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestCryptoModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`crypto.sha256("abc")`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{`len(crypto.sha512("abc"))`, "128"},
+		{`crypto.hmac("key", "The quick brown fox jumps over the lazy dog")`, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		{`crypto.hmac_verify("key", "payload", crypto.hmac("key", "payload"))`, "true"},
+		{`crypto.hmac_verify("key", "payload", crypto.hmac("other", "payload"))`, "false"},
+		{`key = crypto.generate_key()
+crypto.decrypt(key, crypto.encrypt(key, "attack at dawn"))`, "attack at dawn"},
+		{`key = crypto.generate_key(128)
+crypto.decrypt(key, crypto.encrypt(key, "s3cret", "user:1"), "user:1")`, "s3cret"},
+		{`pair = crypto.generate_keypair()
+sig = crypto.sign(pair["private"], "hello")
+[crypto.verify(pair["public"], "hello", sig), crypto.verify(pair["public"], "hellO", sig)]`, "[true, false]"},
+		{`base64.encode("hi?")`, "aGk/"},
+		{`base64.url_encode("hi?")`, "aGk_"},
+		{`base64.decode("aGk")`, "hi"},
+		{`hex.decode(hex.encode("flowa"))`, "flowa"},
+		{`url.encode("a b&c")`, "a+b%26c"},
+		{`url.query_encode({"q": "go lang", "tag": ["a", "b"]})`, "q=go+lang&tag=a&tag=b"},
+		{`url.parse("https://example.com:8080/p?x=1#top")["port"]`, "8080"},
+		{`url.query_decode("?x=1&y=2")["y"]`, "2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	input := `key = crypto.generate_key()
+crypto.decrypt(key, crypto.encrypt(key, "data", "a"), "b")`
+	evaluated := testEval(t, input)
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "crypto.decrypt: message authentication failed" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`bytes("hi")`, `b"hi"`},
+		{`bytes([0, 255, 10])`, `b"\x00\xff\n"`},
+		{`len(bytes("héllo"))`, "6"},
+		{`bytes("ab") + bytes([99])`, `b"abc"`},
+		{`bytes("abc")[1]`, "98"},
+		{`bytes("hello")[1:3]`, `b"el"`},
+		{`bytes("hello")[-3:]`, `b"llo"`},
+		{`bytes("héllo").decode()`, "héllo"},
+		{`bytes([255]).decode("latin-1")`, "ÿ"},
+		{`bytes([1, 2, 255]).hex()`, "0102ff"},
+		{`hex.decode_bytes("0102ff") == bytes([1, 2, 255])`, "true"},
+		{`base64.encode(bytes([0, 1, 2]))`, "AAEC"},
+		{`crypto.sha256(bytes("abc")) == crypto.sha256("abc")`, "true"},
+		{`[b for b in bytes("AB")]`, "[65, 66]"},
+		{`66 in bytes("AB")`, "true"},
+		{`bytes("lo") in bytes("hello")`, "true"},
+		{`json.encode({"data": bytes("hi")})`, `{"data":"aGk="}`},
+		{`len({bytes("a"), bytes("a")})`, "1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestInvalidUTF8Decode(t *testing.T) {
+	evaluated := testEval(t, `bytes([255, 254]).decode()`)
+	errObj, ok := evaluated.(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error, got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "bytes are not valid UTF-8" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestOversizedAllocations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"bytes(10000000000000)", "byte count 10000000000000 exceeds the maximum of 1073741824"},
+		{"secrets.token_hex(100000000000)", "`secrets.token_hex` token size 100000000000 exceeds the maximum of 4096 bytes"},
+		{"secrets.token_urlsafe(4097)", "`secrets.token_urlsafe` token size 4097 exceeds the maximum of 4096 bytes"},
+		{"math.pow(10, 100000000)", "math.pow result would exceed 1048576 bits"},
+		{"math.pow(3, 100000000000000000000)", "math.pow result would exceed 1048576 bits"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		errObj, ok := evaluated.(*ErrorObj)
+		if !ok {
+			t.Fatalf("%q - expected error, got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q - wrong error message. got=%q", tt.input, errObj.Message)
+		}
+	}
+}
+
+func TestSlicing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[1, 2, 3, 4][1:3]`, "[2, 3]"},
+		{`[1, 2, 3, 4][:-1]`, "[1, 2, 3]"},
+		{`[1, 2, 3][5:]`, "[]"},
+		{`(1, 2, 3)[1:]`, "(2, 3)"},
+		{`"héllo"[1:3]`, "él"},
+		{`"abc"[:]`, "abc"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFsBytes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.bin")
+	dst := filepath.Join(dir, "out.bin")
+	data := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := "b = fs.read_bytes(\"" + src + "\")\nfs.write_bytes(\"" + dst + "\", b[:4] + b[4:])\nlen(b)"
+	testIntegerObject(t, testEval(t, input), int64(len(data)))
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Fatalf("bytes changed on round trip: %v", got)
+	}
+}
+
+func TestBytesResponseBody(t *testing.T) {
+	resp := testEval(t, `response.bytes(bytes([0, 159, 146, 150]), "image/png")`)
+	inst, ok := resp.(*StructInstance)
+	if !ok {
+		t.Fatalf("expected Response, got=%T (%+v)", resp, resp)
+	}
+
+	rec := httptest.NewRecorder()
+	writeHTTPResponse(rec, inst)
+	if got := rec.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("wrong content type. got=%q", got)
+	}
+	if got := rec.Body.Bytes(); string(got) != "\x00\x9f\x92\x96" {
+		t.Errorf("wrong body. got=%v", got)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ch = channel(2)\nch.send(1)\nch.send(2)\n[ch.recv(), ch.recv()]", "[1, 2]"},
+		{"ch = channel(1)\nch.close()\nch.recv()", "null"},
+		{"ch = channel()\nch.try_recv()", "(null, false)"},
+		{`ch = channel()
+def produce(n):
+    for i in range(n):
+        ch.send(i)
+    ch.close()
+spawn produce(4)
+total = 0
+for v in ch:
+    total = total + v
+total`, "6"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestChannelErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ch = channel(1)\nch.close()\nch.send(1)", "send on closed channel"},
+		{"ch = channel(1)\nch.close()\nch.close()", "close of closed channel"},
+		{"channel(-1)", "channel size must be a non-negative INTEGER, got -1"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(t, tt.input).(*ErrorObj)
+		if !ok {
+			t.Fatalf("%q - expected error", tt.input)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q - wrong message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestSelectStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`ch = channel(1)
+ch.send("hi")
+out = ""
+select:
+    case msg = ch.recv():
+        out = msg
+    case timeout 1s:
+        out = "slow"
+out`, "hi"},
+		{`ch = channel()
+out = ""
+select:
+    case msg = ch.recv():
+        out = msg
+    case timeout 10ms:
+        out = "timed out"
+out`, "timed out"},
+		{`ch = channel()
+out = ""
+select:
+    case ch.send(1):
+        out = "sent"
+    case _:
+        out = "default"
+out`, "default"},
+		{`ch = channel(1)
+out = ""
+select:
+    case ch.send(1):
+        out = "sent"
+    case _:
+        out = "default"
+out`, "sent"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSyncPrimitives(t *testing.T) {
+	input := `
+wg = wait_group()
+hits = atomic()
+lock = mutex()
+seen = 0
+def bump():
+    global seen
+    seen = seen + 1
+def work():
+    hits.add()
+    lock.do(bump)
+    wg.done()
+for i in range(100):
+    wg.add()
+    spawn work()
+wg.wait()
+[hits.get(), seen, hits.compare_and_swap(100, 0), hits.get()]
+`
+	evaluated := testEval(t, input)
+	if isError(evaluated) {
+		t.Fatalf("unexpected error: %s", evaluated.Inspect())
+	}
+	if evaluated.Inspect() != "[100, 100, true, 0]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestMutexUnlock(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"m = mutex()\nm.unlock()", "ERROR: unlock of unlocked mutex"},
+		{"m = mutex()\nm.lock()\nm.unlock()\nm.unlock()", "ERROR: unlock of unlocked mutex"},
+		{"m = mutex()\nm.try_lock()\nm.unlock()\nm.try_lock()", "true"},
+		{"m = mutex()\ndef release():\n    return m.unlock()\nm.do(release)\nm.try_lock()", "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSpawnRunsConcurrently(t *testing.T) {
+	input := `
+ch = channel()
+def ping():
+    ch.send("ping")
+    return ch.recv()
+task = spawn ping()
+msg = ch.recv()
+ch.send("pong")
+[msg, await task]
+`
+	evaluated := testEval(t, input)
+	if isError(evaluated) {
+		t.Fatalf("unexpected error: %s", evaluated.Inspect())
+	}
+	if evaluated.Inspect() != `[ping, pong]` {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestTaskCombinators(t *testing.T) {
+	prelude := `
+def slow(d, v):
+    time.sleep(d)
+    return v
+def fail():
+    return 1 / 0
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"gather([spawn slow(20ms, 1), spawn slow(1ms, 2)])", "[1, 2]"},
+		{"race([spawn slow(1s, 1), spawn slow(1ms, 2)])", "2"},
+		{"await spawn slow(1ms, 3) timeout 1s", "3"},
+		{"r = all_settled([spawn slow(1ms, 1), spawn fail()])\n[r[0][\"value\"], r[1][\"status\"], r[1][\"reason\"]]",
+			"[1, rejected, division by zero]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, prelude+tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTaskCancellation(t *testing.T) {
+	prelude := `
+def slow(d):
+    time.sleep(d)
+    return d
+def spin():
+    while True:
+        x = 1
+def drain(ch):
+    for v in ch:
+        x = v
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"await spawn slow(10s) timeout 10ms", "await timed out after 10ms"},
+		{"ch = channel()\nt = spawn drain(ch)\ntime.sleep(5ms)\nt.cancel()\nawait t", "task cancelled"},
+		{"ch = channel()\nt = spawn [v for v in ch]\ntime.sleep(5ms)\nt.cancel()\nawait t", "task cancelled"},
+		{"await_timeout(spawn slow(10s), 10ms)", "await timed out after 10ms"},
+		{"t = spawn spin()\nt.cancel()\nawait t", "task cancelled"},
+		{"gather([spawn slow(10s), spawn 1 / 0])", "division by zero"},
+		{"def outer():\n    return await spawn slow(10s)\nt = spawn outer()\ntime.sleep(5ms)\nt.cancel()\nawait t", "task cancelled"},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		errObj, ok := testEval(t, prelude+tt.input).(*ErrorObj)
+		if !ok {
+			t.Fatalf("%q - expected error", tt.input)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q - wrong message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%q - took %s; cancellation did not interrupt the task", tt.input, elapsed)
+		}
+	}
+}
+
+func TestCancelledChannelLoopsExit(t *testing.T) {
+	before := runtime.NumGoroutine()
+	input := `
+def drain(ch):
+    for v in ch:
+        x = v
+
+ch = channel()
+tasks = [spawn drain(ch) for i in range(50)]
+time.sleep(5ms)
+for t in tasks:
+    t.cancel()
+`
+	if result := testEval(t, input); isError(result) {
+		t.Fatalf("unexpected error: %s", result.Inspect())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before+5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before+5 {
+		t.Errorf("cancelled consumers are still blocked: %d goroutines before, %d after", before, n)
+	}
+}
+
+func TestCancelStopsHTTPRequest(t *testing.T) {
+	release := make(chan struct{})
+	aborted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	input := "t = spawn http.get(\"" + server.URL + "\")\nawait t timeout 20ms"
+	errObj, ok := testEval(t, input).(*ErrorObj)
+	if !ok || errObj.Message != "await timed out after 20ms" {
+		t.Fatalf("expected timeout error, got %v", errObj)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not aborted when the task was cancelled")
+	}
+}
+
+const peakTracker = `
+inflight = atomic()
+peak = atomic()
+def work(n):
+    now = inflight.add()
+    cur = peak.get()
+    while now > cur:
+        if peak.compare_and_swap(cur, now):
+            cur = now
+        else:
+            cur = peak.get()
+    time.sleep(2ms)
+    inflight.add(-1)
+    return n * 10
+`
+
+func TestParallelMap(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"r = range(8) |> parallel_map(work, 3)\n[r, peak.get() <= 3]", "[[0, 10, 20, 30, 40, 50, 60, 70], true]"},
+		{"p = pool(2)\nr = p.map([1, 2, 3, 4], work)\n[r, peak.get() <= 2]", "[[10, 20, 30, 40], true]"},
+		{"p = pool(2)\nt = p.submit(work, 5)\np.wait()\n[await t, t.done]", "[50, true]"},
+		{"[] |> parallel_map(work, 2)", "[]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, peakTracker+tt.input)
+		if isError(evaluated) {
+			t.Fatalf("%q - unexpected error: %s", tt.input, evaluated.Inspect())
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestParallelMapFirstError(t *testing.T) {
+	input := `
+def check(n):
+    if n == 2:
+        return 1 / 0
+    time.sleep(10s)
+    return n
+[1, 2, 3] |> parallel_map(check, 3)
+`
+	start := time.Now()
+	errObj, ok := testEval(t, input).(*ErrorObj)
+	if !ok {
+		t.Fatalf("expected error")
+	}
+	if errObj.Message != "division by zero" {
+		t.Errorf("wrong message. got=%q", errObj.Message)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s; the error did not cancel the other calls", elapsed)
+	}
+}
+
+func TestMaxWorkersLimit(t *testing.T) {
+	t.Setenv("FLOWA_MAX_WORKERS", "1")
+	evaluated := testEval(t, peakTracker+"r = range(4) |> parallel_map(work, 8)\n[pool(8).size, peak.get()]")
+	if evaluated.Inspect() != "[1, 1]" {
+		t.Errorf("expected workers capped at 1, got=%s", evaluated.Inspect())
+	}
+}
+
+func TestParallelMapBoundsGoroutines(t *testing.T) {
+	env := NewEnvironment()
+	peak := 0
+	var mu sync.Mutex
+	env.Set("sample", &BuiltinFunction{Fn: func(args ...Object) Object {
+		mu.Lock()
+		defer mu.Unlock()
+		if n := runtime.NumGoroutine(); n > peak {
+			peak = n
+		}
+		return args[0]
+	}})
+	before := runtime.NumGoroutine()
+	program := parser.New(lexer.New("len(range(5000) |> parallel_map(sample, 4))")).ParseProgram()
+	if result := Eval(program, env); result.Inspect() != "5000" {
+		t.Fatalf("unexpected result %s", result.Inspect())
+	}
+	if peak > before+20 {
+		t.Errorf("parallel_map started too many goroutines: %d before, peak %d", before, peak)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, time.March, 14, 10, 30, 45, 0, time.UTC) // a Saturday
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.March, 14, 10, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, time.March, 15, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.March, 14, 10, 45, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2026, time.March, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"0 12 31 * *", time.Date(2026, time.March, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("%q - unexpected error: %s", tt.spec, err)
+		}
+		if got := s.next(from); !got.Equal(tt.expected) {
+			t.Errorf("%q - expected=%s, got=%s", tt.spec, tt.expected, got)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * * * funday", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q - expected an error", spec)
+		}
+	}
+}
+
+func TestScheduledJobs(t *testing.T) {
+	var log strings.Builder
+	env := NewIsolatedEnvironment()
+	scheduler := env.Scheduler()
+	scheduler.Log = &syncWriter{w: &log}
+	t.Cleanup(func() { scheduler.Stop(time.Second) })
+
+	input := `
+ticks = atomic()
+every 10ms:
+    ticks.add()
+def fail():
+    return 1 / 0
+def slow():
+    time.sleep(1s)
+broken = schedule.every("10ms", fail)
+busy = schedule.every(10ms, slow)
+time.sleep(100ms)
+[ticks.get() >= 3, broken.failures >= 3, broken.last_error, busy.skipped >= 3, busy.running, len(schedule.jobs())]
+`
+	evaluated := testEvalIn(t, input, env)
+	if isError(evaluated) {
+		t.Fatalf("unexpected error: %s", evaluated.Inspect())
+	}
+	if evaluated.Inspect() != "[true, true, division by zero, true, true, 3]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+
+	start := time.Now()
+	scheduler.Stop(5 * time.Second)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Stop took %s; running jobs were not cancelled", elapsed)
+	}
+	if scheduler.Jobs() != 0 {
+		t.Errorf("jobs still registered after Stop: %d", scheduler.Jobs())
+	}
+	if !strings.Contains(log.String(), "schedule: every 10ms failed: division by zero") {
+		t.Errorf("failure not logged. got=%q", log.String())
+	}
+}
+
+func TestSchedulerWaitsForLastJob(t *testing.T) {
+	var log strings.Builder
+	env := NewIsolatedEnvironment()
+	scheduler := env.Scheduler()
+	scheduler.Log = &syncWriter{w: &log}
+	t.Cleanup(func() { scheduler.Stop(time.Second) })
+
+	// n carries over between runs, so the total is 1 + 2 + 3
+	input := `
+total = atomic()
+n = 0
+every 10ms:
+    n = n + 1
+    total.add(n)
+    if n == 3:
+        for job in schedule.jobs():
+            job.stop()
+total
+`
+	total := testEvalIn(t, input, env)
+	if isError(total) {
+		t.Fatalf("unexpected error: %s", total.Inspect())
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		scheduler.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after the last job stopped")
+	}
+	if total.Inspect() != "atomic(6)" {
+		t.Errorf("wrong total. got=%s, log=%q", total.Inspect(), log.String())
+	}
+}
+
+func TestSchedulerRefusesJobsAfterStop(t *testing.T) {
+	env := NewIsolatedEnvironment()
+	scheduler := env.Scheduler()
+	if result := testEvalIn(t, "every 1h:\n    print(1)", env); isError(result) {
+		t.Fatalf("unexpected error: %s", result.Inspect())
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		scheduler.Wait()
+		close(waited)
+	}()
+	scheduler.Stop(time.Second)
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after Stop")
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"every 10ms:\n    print(1)", "cannot schedule every 10ms: the scheduler is stopped"},
+		{"def tick():\n    return 1\nschedule.cron(\"* * * * *\", tick)", "cannot schedule cron * * * * *: the scheduler is stopped"},
+	}
+	for _, tt := range tests {
+		errObj, ok := testEvalIn(t, tt.input, env).(*ErrorObj)
+		if !ok {
+			t.Fatalf("%q - expected error", tt.input)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q - wrong message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+	if n := scheduler.Jobs(); n != 0 {
+		t.Errorf("jobs registered after Stop: %d", n)
+	}
+}
+
+func TestInvalidSchedule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"cron \"61 * * * *\":\n    print(1)", `invalid cron schedule "61 * * * *": minute: value 61 out of range 0-59`},
+		{"every \"0s\":\n    print(1)", "every: interval must be positive, got 0s"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(t, tt.input).(*ErrorObj)
+		if !ok {
+			t.Fatalf("%q - expected error", tt.input)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q - wrong message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	t.Cleanup(func() { DefaultEventBus.subs = nil })
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`
+seen = ""
+def record(payload, topic):
+    global seen
+    seen = seen + topic + "=" + payload + ";"
+events.on("user.*", record)
+events.on("user.created", record)
+events.on("**", record)
+n = events.emit("user.created", "ann")
+events.emit("order.paid.late", "x")
+[n, seen]`, "[3, user.created=ann;user.created=ann;user.created=ann;order.paid.late=x;]"},
+		{`
+count = 0
+def bump(payload, topic):
+    global count
+    count = count + payload
+events.once("tick", bump)
+sub = events.on("tick", bump)
+events.emit("tick", 1)
+events.emit("tick", 10)
+[sub.off(), sub.off(), events.emit("tick", 100), count, events.subscribers("tick")]`, "[true, false, 0, 12, 0]"},
+		{`
+def double(payload, topic):
+    return payload * 2
+events.on("job.*.done", double)
+gather(events.emit_async("job.42.done", 21))`, "[42]"},
+		{`
+def fail(payload, topic):
+    return 1 / 0
+events.on("boom", fail)
+events.emit("boom")`, "division by zero"},
+		{`events.emit("user.*", 1)`, "cannot emit to a wildcard topic: user.*"},
+		{`events.on("user", 1)`, "handler for `events.on` must be a function, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		DefaultEventBus.subs = nil
+		evaluated := testEval(t, tt.input)
+		if errObj, ok := evaluated.(*ErrorObj); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("%q - wrong error. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+			}
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestAssert(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"assert 1 < 2\nassert [1, 2]\n1", "1"},
+		{"total = 2\nassert total + 1 == 4", "assertion failed: (total + 1) == 4 (left: 3, right: 4)"},
+		{"assert \"x\" in \"abc\"", "assertion failed: \"x\" in \"abc\" (left: x, right: abc)"},
+		{"assert False", "assertion failed: False"},
+		{"ready = False\nassert ready, \"not \" + \"ready\"", "assertion failed: not ready"},
+		{"assert 1 / 0 == 1", "division by zero"},
+		{"assert.equal({\"a\": [1]}, {\"a\": [1]})", "null"},
+		{"assert.equal(1 + 1, 3)", "assertion failed: expected 3, got 2"},
+		{"assert.equal(1, 2, \"custom\")", "assertion failed: custom"},
+		{"assert.contains([1, 2], 2)", "null"},
+		{"assert.contains(\"team\", \"i\")", "assertion failed: team does not contain i"},
+		{"assert.contains(5, 1)", "`in` not supported for INTEGER"},
+		{"def boom():\n    return 1 / 0\nassert.raises(boom, \"zero\")", "division by zero"},
+		{"def boom():\n    return 1 / 0\nassert.raises(boom, \"overflow\")", `assertion failed: expected an error containing "overflow", got "division by zero"`},
+		{"def fine():\n    return 1\nassert.raises(fine)", "assertion failed: expected an error, got 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if errObj, ok := evaluated.(*ErrorObj); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("%q - wrong error. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+			}
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	input := `
+def inner(x):
+    return x * 2
+
+def outer(xs):
+    ys = map(xs, inner)
+    return ys
+
+outer([1, 2])
+`
+	var calls, stacks []string
+	lines := map[int]bool{}
+	SetHooks(&Hooks{
+		Statement: func(frame *Frame, stmt ast.Statement) {
+			if frame.Statement() != stmt {
+				t.Errorf("frame is not at the statement being run")
+			}
+			if s, ok := stmt.(*ast.ReturnStatement); ok {
+				lines[s.Token.Line] = true
+			}
+		},
+		Enter: func(frame *Frame) {
+			calls = append(calls, frame.Name)
+			var names []string
+			for f := frame; f != nil; f = f.Caller {
+				names = append(names, fmt.Sprintf("%s@%d", f.Name, f.Depth))
+			}
+			stacks = append(stacks, strings.Join(names, " < "))
+		},
+		Leave: func(frame *Frame, result Object) {
+			calls = append(calls, frame.Name+"="+result.Inspect())
+		},
+	})
+	defer SetHooks(nil)
+
+	if result := testEval(t, input); result.Inspect() != "[2, 4]" {
+		t.Fatalf("unexpected result %s", result.Inspect())
+	}
+	if got := strings.Join(calls, " "); got != "outer inner inner=2 inner inner=4 outer=[2, 4]" {
+		t.Errorf("unexpected calls %s", got)
+	}
+	// Callbacks from builtins know their Flowa caller
+	if stacks[1] != "inner@2 < outer@1 < <module>@0" {
+		t.Errorf("unexpected stack %s", stacks[1])
+	}
+	if !lines[3] || !lines[7] {
+		t.Errorf("expected return statements to be traced, got %v", lines)
+	}
+}
+
+func TestHookCallers(t *testing.T) {
+	input := `
+def cb0():
+    return "x"
+
+def cb1(a):
+    return "x"
+
+def cb2(a, b):
+    return "x"
+
+def outer():
+    m = mutex()
+    total = reduce([1], cb2, 0)
+    s = re.replace("a", "a", cb1)
+    d = m.do(cb0)
+    return 1 |> cb1
+
+outer()
+`
+	var stacks []string
+	SetHooks(&Hooks{
+		Enter: func(frame *Frame) {
+			if frame.Name == "outer" {
+				return
+			}
+			var names []string
+			for f := frame; f != nil; f = f.Caller {
+				names = append(names, f.Name)
+			}
+			stacks = append(stacks, strings.Join(names, " < "))
+		},
+	})
+	defer SetHooks(nil)
+
+	if result := testEval(t, input); isError(result) {
+		t.Fatalf("unexpected error %s", result.Inspect())
+	}
+	// Functions called back by builtins know their caller
+	expected := "cb2 < outer < <module>, cb1 < outer < <module>, cb0 < outer < <module>, cb1 < outer < <module>"
+	if got := strings.Join(stacks, ", "); got != expected {
+		t.Errorf("unexpected stacks %s", got)
+	}
+}
+
+func TestPipelineStageHooks(t *testing.T) {
+	input := `
+def twice(x):
+    return x * 2
+
+def big(x):
+    return x > 2
+
+[1, 2, 3] |> map(twice) |> (filter(big) |> len)
+`
+	var stages []string
+	SetHooks(&Hooks{
+		EnterStage: func(frame *Frame, stage *ast.PipelineExpression) {
+			stages = append(stages, fmt.Sprintf("%s@%d:%d", stage.Right.String(), stage.Token.Line, stage.Token.Column))
+		},
+		LeaveStage: func(frame *Frame, stage *ast.PipelineExpression, result Object) {
+			stages = append(stages, frame.Name+"="+result.Inspect())
+		},
+	})
+	defer SetHooks(nil)
+
+	if result := testEval(t, input); result.Inspect() != "2" {
+		t.Fatalf("unexpected result %s", result.Inspect())
+	}
+	expected := "map(twice)@8:11 <module>=[2, 4, 6] filter(big)@8:25 <module>=[4, 6] len@8:41 <module>=2"
+	if got := strings.Join(stages, " "); got != expected {
+		t.Errorf("unexpected stages %s", got)
+	}
+}
+
+func TestBranchHooks(t *testing.T) {
+	input := `
+def sign(x):
+    if x < 0:
+        return -1
+    elif x == 0:
+        return 0
+    return 1
+
+total = 0
+for x in [-2, 3]:
+    total = total + sign(x)
+while total < 1:
+    total = total + 1
+match total:
+    case 0:
+        total = 10
+    case _:
+        total = 20
+total
+`
+	var branches []string
+	SetHooks(&Hooks{
+		Branch: func(frame *Frame, node ast.Node, branch int) {
+			branches = append(branches, fmt.Sprintf("%s@%d=%d", node.TokenLiteral(), nodeLine(node), branch))
+		},
+	})
+	defer SetHooks(nil)
+
+	if result := testEval(t, input); result.Inspect() != "20" {
+		t.Fatalf("unexpected result %s", result.Inspect())
+	}
+	expected := "for@10=0 if@3=0 for@10=0 if@3=1 elif@5=1 for@10=1 while@12=0 while@12=1 match@14=1"
+	if got := strings.Join(branches, " "); got != expected {
+		t.Errorf("unexpected branches %s", got)
+	}
+}
+
+func nodeLine(node ast.Node) int {
+	switch n := node.(type) {
+	case *ast.IfExpression:
+		return n.Token.Line
+	case *ast.WhileStatement:
+		return n.Token.Line
+	case *ast.ForStatement:
+		return n.Token.Line
+	case *ast.MatchStatement:
+		return n.Token.Line
+	}
+	return 0
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("x", &Integer{Value: 1})
+	local := NewEnclosedEnvironment(global)
+	local.Set("y", &Integer{Value: 2})
+
+	if !local.Assign("x", &Integer{Value: 10}) || !local.Assign("y", &Integer{Value: 20}) {
+		t.Fatal("expected both assignments to find a binding")
+	}
+	if local.Assign("z", NULL) {
+		t.Error("assigning an unbound name should fail")
+	}
+	if x, _ := global.Get("x"); x.Inspect() != "10" {
+		t.Errorf("x was not rebound in the global scope, got %s", x.Inspect())
+	}
+	if names := local.Names(); len(names) != 1 || names[0] != "y" {
+		t.Errorf("assign should not create locals, got %v", names)
+	}
+}
+
+func TestEventsBridgeToWebSocket(t *testing.T) {
+	t.Cleanup(func() { DefaultEventBus.subs = nil })
+
+	bridged := make(chan *Subscription, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeToWebSocket(w, r)
+		if err != nil {
+			t.Errorf("upgrade failed: %s", err)
+			return
+		}
+		bridged <- DefaultEventBus.bridge("chat.*", conn)
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	sub := <-bridged
+
+	evaluated := testEval(t, `events.emit("chat.general", {"from": "ann", "text": "hi"})`)
+	if evaluated.Inspect() != "1" {
+		t.Fatalf("expected one subscriber, got=%s", evaluated.Inspect())
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, frame, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	if string(frame) != `{"payload":{"from":"ann","text":"hi"},"topic":"chat.general"}` {
+		t.Errorf("wrong frame. got=%s", frame)
+	}
+
+	// Once the client has gone away a failed send ends the subscription
+	client.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for DefaultEventBus.subscribers("chat.general") > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("bridge to closed client still subscribed: %s", sub.Inspect())
+		}
+		testEval(t, `events.emit("chat.general", "anyone?")`)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// syncWriter serializes writes from concurrently running jobs.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
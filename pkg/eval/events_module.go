@@ -0,0 +1,296 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Topics are dot-separated names such as "user.registered". In
+// subscription patterns `*` matches exactly one segment and `**` any
+// number of segments, so "user.*" sees "user.registered" and "**" sees
+// everything.
+
+// Subscription is a handler registered on an EventBus.
+type Subscription struct {
+	Pattern string
+	handler Object
+	once    bool
+	bus     *EventBus
+}
+
+func (s *Subscription) Type() string    { return "SUBSCRIPTION" }
+func (s *Subscription) Inspect() string { return fmt.Sprintf("subscription(%s)", s.Pattern) }
+
+// EventBus delivers emitted events to the subscriptions whose pattern
+// matches the topic, in the order they subscribed.
+type EventBus struct {
+	mu   sync.Mutex
+	subs []*Subscription
+
+	// Failed asynchronous handlers are reported here
+	Log io.Writer
+}
+
+// DefaultEventBus backs the `events` module.
+var DefaultEventBus = &EventBus{Log: os.Stderr}
+
+func topicMatches(pattern, topic []string) bool {
+	if len(pattern) == 0 {
+		return len(topic) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(topic); i++ {
+			if topicMatches(pattern[1:], topic[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(topic) == 0 || (pattern[0] != "*" && pattern[0] != topic[0]) {
+		return false
+	}
+	return topicMatches(pattern[1:], topic[1:])
+}
+
+func (b *EventBus) subscribe(pattern string, handler Object, once bool) *Subscription {
+	return b.add(&Subscription{Pattern: pattern, handler: handler, once: once, bus: b})
+}
+
+func (b *EventBus) add(sub *Subscription) *Subscription {
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return sub
+}
+
+// unsubscribe removes sub and reports whether it was still registered.
+func (b *EventBus) unsubscribe(sub *Subscription) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, other := range b.subs {
+		if other == sub {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// matching returns the subscriptions for topic. `once` subscriptions are
+// removed here, so each fires a single time even with concurrent emits.
+func (b *EventBus) matching(topic string) []*Subscription {
+	segments := strings.Split(topic, ".")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []*Subscription
+	kept := b.subs[:0]
+	for _, sub := range b.subs {
+		matched := topicMatches(strings.Split(sub.Pattern, "."), segments)
+		if matched {
+			out = append(out, sub)
+		}
+		if !matched || !sub.once {
+			kept = append(kept, sub)
+		}
+	}
+	// Clear the tail so removed subscriptions can be collected
+	for i := len(kept); i < len(b.subs); i++ {
+		b.subs[i] = nil
+	}
+	b.subs = kept
+	return out
+}
+
+// subscribers counts the subscriptions that would receive topic.
+func (b *EventBus) subscribers(topic string) int {
+	segments := strings.Split(topic, ".")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, sub := range b.subs {
+		if topicMatches(strings.Split(sub.Pattern, "."), segments) {
+			n++
+		}
+	}
+	return n
+}
+
+// emit calls each matching handler with (payload, topic) in turn. The
+// first error stops delivery and is returned.
+func (b *EventBus) emit(ctx context.Context, topic string, payload Object) Object {
+	subs := b.matching(topic)
+	for _, sub := range subs {
+		result := callFunction(ctx, sub.handler, []Object{payload, &String{Value: topic}})
+		if isError(result) {
+			return result
+		}
+	}
+	return &Integer{Value: int64(len(subs))}
+}
+
+// emitAsync runs each matching handler as its own task. Failures are
+// logged, since nobody may be awaiting the tasks.
+func (b *EventBus) emitAsync(ctx context.Context, topic string, payload Object) Object {
+	subs := b.matching(topic)
+	tasks := make([]Object, len(subs))
+	for i, sub := range subs {
+		task := newTask(ctx)
+		task.start(func() Object {
+			result := callFunction(task.ctx, sub.handler, []Object{payload, &String{Value: topic}})
+			if errObj, ok := result.(*ErrorObj); ok {
+				fmt.Fprintf(b.Log, "events: handler for %s failed: %s\n", topic, errObj.Message)
+			}
+			return result
+		})
+		tasks[i] = task
+	}
+	return &Array{Elements: tasks}
+}
+
+// bridge forwards matching events to a websocket client as JSON text
+// frames: {"topic": "...", "payload": ...}. The subscription ends when
+// the client goes away.
+func (b *EventBus) bridge(pattern string, conn *WebSocketConnection) *Subscription {
+	sub := &Subscription{Pattern: pattern, bus: b}
+	sub.handler = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			frame, err := json.Marshal(map[string]interface{}{
+				"topic":   args[1].(*String).Value,
+				"payload": flowaToNative(args[0]),
+			})
+			if err != nil {
+				return newError("events.bridge: %s", err)
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				b.unsubscribe(sub)
+			}
+			return NULL
+		},
+	}
+	return b.add(sub)
+}
+
+func subscriptionField(s *Subscription, name string) Object {
+	switch name {
+	case "pattern":
+		return &String{Value: s.Pattern}
+	case "off":
+		return &BuiltinFunction{Fn: func(args ...Object) Object {
+			return nativeBoolToBooleanObject(s.bus.unsubscribe(s))
+		}}
+	default:
+		return newError("SUBSCRIPTION has no field %s", name)
+	}
+}
+
+func topicArg(name string, args []Object, idx int) (string, Object) {
+	topic, ok := args[idx].(*String)
+	if !ok {
+		return "", newError("topic for `%s` must be STRING, got %s", name, args[idx].Type())
+	}
+	if topic.Value == "" {
+		return "", newError("topic for `%s` must not be empty", name)
+	}
+	return topic.Value, nil
+}
+
+func newEventsModule(bus *EventBus) *StructInstance {
+	subscribe := func(name string, once bool) *BuiltinFunction {
+		return &BuiltinFunction{
+			Fn: func(args ...Object) Object {
+				if len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=2", len(args))
+				}
+				pattern, errObj := topicArg(name, args, 0)
+				if errObj != nil {
+					return errObj
+				}
+				switch args[1].(type) {
+				case *Function, *BuiltinFunction:
+				default:
+					return newError("handler for `%s` must be a function, got %s", name, args[1].Type())
+				}
+				return bus.subscribe(pattern, args[1], once)
+			},
+		}
+	}
+	emit := func(name string, deliver func(*EventBus, context.Context, string, Object) Object) *BuiltinFunction {
+		return contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			topic, errObj := topicArg(name, args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			if strings.Contains(topic, "*") {
+				return newError("cannot emit to a wildcard topic: %s", topic)
+			}
+			var payload Object = NULL
+			if len(args) == 2 {
+				payload = args[1]
+			}
+			return deliver(bus, ctx, topic, payload)
+		})
+	}
+
+	return &StructInstance{
+		Name: "Events",
+		Fields: map[string]Object{
+			// events.on("user.*", fn) calls fn(payload, topic) for each event
+			"on":   subscribe("events.on", false),
+			"once": subscribe("events.once", true),
+			"off": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 1 {
+						return newError("wrong number of arguments. got=%d, want=1", len(args))
+					}
+					sub, ok := args[0].(*Subscription)
+					if !ok {
+						return newError("argument to `events.off` must be SUBSCRIPTION, got %s", args[0].Type())
+					}
+					return nativeBoolToBooleanObject(bus.unsubscribe(sub))
+				},
+			},
+			// events.emit waits for every handler and returns how many ran
+			"emit": emit("events.emit", (*EventBus).emit),
+			// events.emit_async returns one task per handler
+			"emit_async": emit("events.emit_async", (*EventBus).emitAsync),
+			"bridge": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 2 {
+						return newError("wrong number of arguments. got=%d, want=2", len(args))
+					}
+					pattern, errObj := topicArg("events.bridge", args, 0)
+					if errObj != nil {
+						return errObj
+					}
+					conn, ok := args[1].(*WebSocketConnection)
+					if !ok {
+						return newError("second argument to `events.bridge` must be a WebSocketConnection, got %s", args[1].Type())
+					}
+					return bus.bridge(pattern, conn)
+				},
+			},
+			"subscribers": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 1 {
+						return newError("wrong number of arguments. got=%d, want=1", len(args))
+					}
+					topic, errObj := topicArg("events.subscribers", args, 0)
+					if errObj != nil {
+						return errObj
+					}
+					return &Integer{Value: int64(bus.subscribers(topic))}
+				},
+			},
+		},
+	}
+}
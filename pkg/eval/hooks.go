@@ -0,0 +1,181 @@
+package eval
+
+import (
+	"context"
+	"sync/atomic"
+
+	"flowa/pkg/ast"
+)
+
+// Hooks let tools such as the debugger observe evaluation. Any field may
+// be nil. While hooks are installed every call of a Flowa function, and
+// the top level of each program, runs in a Frame.
+type Hooks struct {
+	// Statement runs before each statement of a program or block, on the
+	// goroutine evaluating it. Blocking in it pauses that goroutine.
+	Statement func(frame *Frame, stmt ast.Statement)
+
+	// Enter and Leave run around each call of a Flowa function.
+	Enter func(frame *Frame)
+	Leave func(frame *Frame, result Object)
+
+	// EnterStage and LeaveStage run around each stage of a pipeline:
+	// passing the value of stage.Left to stage.Right.
+	EnterStage func(frame *Frame, stage *ast.PipelineExpression)
+	LeaveStage func(frame *Frame, stage *ast.PipelineExpression, result Object)
+
+	// Branch runs each time a conditional decides where to go: an
+	// *ast.IfExpression taking its consequence (0) or not (1), a while
+	// or for loop running its body (0) or finishing (1), and a match
+	// running case i or, with len(Cases), none of them.
+	Branch func(frame *Frame, node ast.Node, branch int)
+
+	// Load runs when an import has parsed a file, before it runs.
+	Load func(path string, program *ast.Program)
+}
+
+var activeHooks atomic.Pointer[Hooks]
+
+// SetHooks installs h for all evaluation in the process; nil removes
+// them. Install hooks before evaluation starts.
+func SetHooks(h *Hooks) {
+	activeHooks.Store(h)
+}
+
+// Frame is an active call of a Flowa function, or the top level of a
+// program when Function is nil.
+type Frame struct {
+	Function *Function
+	Name     string       // "<module>" for the top level
+	Env      *Environment // the scope the call runs in
+	Caller   *Frame       // nil at the top level and in tasks, jobs and handlers started on their own
+	Depth    int          // number of callers
+
+	stmt atomic.Value // statementRef
+}
+
+// statementRef gives atomic.Value one concrete type to hold.
+type statementRef struct{ ast.Statement }
+
+// Statement returns the statement the frame is running, or nil before
+// the first one.
+func (f *Frame) Statement() ast.Statement {
+	ref, _ := f.stmt.Load().(statementRef)
+	return ref.Statement
+}
+
+// currentFrame returns the call this scope belongs to. Blocks and
+// comprehensions don't get a Frame of their own, so it is the nearest
+// one up the chain.
+func (e *Environment) currentFrame() *Frame {
+	for ; e != nil; e = e.outer {
+		if e.frame != nil {
+			return e.frame
+		}
+	}
+	return nil
+}
+
+// callerContext is the context of a call made while hooks are installed.
+// Besides the calling task's context, nil outside a task, it carries the
+// calling frame, so that functions called back by builtins such as map()
+// know their Flowa caller. callFunction unwraps it again.
+type callerContext struct {
+	context.Context
+	task   context.Context
+	caller *Frame
+}
+
+// callContext returns the context to hand to a call made from env.
+func callContext(env *Environment) context.Context {
+	ctx := env.context()
+	if activeHooks.Load() == nil {
+		return ctx
+	}
+	frame := env.currentFrame()
+	if frame == nil {
+		return ctx
+	}
+	parent := ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	return &callerContext{Context: parent, task: ctx, caller: frame}
+}
+
+// splitCaller separates the calling frame from the task context.
+func splitCaller(ctx context.Context) (context.Context, *Frame) {
+	if cc, ok := ctx.(*callerContext); ok {
+		return cc.task, cc.caller
+	}
+	return ctx, nil
+}
+
+// newFrame makes the frame of a call running in env.
+func newFrame(fn *Function, name string, env *Environment, caller *Frame) *Frame {
+	frame := &Frame{Function: fn, Name: name, Env: env, Caller: caller}
+	if caller != nil {
+		frame.Depth = caller.Depth + 1
+	}
+	env.frame = frame
+	return frame
+}
+
+// enterModule gives a program's top level a frame.
+func enterModule(env *Environment, caller *Frame) {
+	newFrame(nil, "<module>", env, caller)
+}
+
+func traceStatement(hooks *Hooks, stmt ast.Statement, env *Environment) {
+	frame := env.currentFrame()
+	if frame == nil {
+		return
+	}
+	frame.stmt.Store(statementRef{stmt})
+	if hooks.Statement != nil {
+		hooks.Statement(frame, stmt)
+	}
+}
+
+func traceBranch(hooks *Hooks, node ast.Node, branch int, env *Environment) {
+	if hooks.Branch == nil {
+		return
+	}
+	if frame := env.currentFrame(); frame != nil {
+		hooks.Branch(frame, node, branch)
+	}
+}
+
+// callTraced is the body of callFunction while hooks are installed.
+func callTraced(hooks *Hooks, fn *Function, env *Environment, caller *Frame) Object {
+	frame := newFrame(fn, fn.Name, env, caller)
+	if fn.IsGenerator {
+		// The body runs later, on the generator's goroutine
+		return newGenerator(fn, env)
+	}
+
+	if hooks.Enter != nil {
+		hooks.Enter(frame)
+	}
+	result := unwrapReturnValue(Eval(fn.Body, env))
+	if hooks.Leave != nil {
+		hooks.Leave(frame, result)
+	}
+	return result
+}
+
+// traceStage is evalPipelineStage while hooks are installed.
+func traceStage(hooks *Hooks, stage *ast.PipelineExpression, leftVal Object, env *Environment) Object {
+	frame := env.currentFrame()
+	if frame == nil {
+		return evalPipelineStage(stage, leftVal, env)
+	}
+	if hooks.EnterStage != nil {
+		hooks.EnterStage(frame, stage)
+	}
+	result := evalPipelineStage(stage, leftVal, env)
+	if hooks.LeaveStage != nil {
+		hooks.LeaveStage(frame, stage, result)
+	}
+	return result
+}
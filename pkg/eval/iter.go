@@ -0,0 +1,651 @@
+package eval
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Iterator is implemented by objects that produce values one at a time.
+// Next returns false once the sequence is exhausted; a failure is
+// reported as an *ErrorObj value, after which the iterator is done.
+// Close releases whatever the iterator holds (goroutines, files,
+// connections) and is safe to call more than once.
+type Iterator interface {
+	Object
+	Next() (Object, bool)
+	Close()
+}
+
+// iterateIn is iterate for loops running in env: receiving from a
+// channel stops when the task env belongs to is cancelled.
+func iterateIn(obj Object, env *Environment) (Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Channel:
+		if ctx := env.context(); ctx != nil {
+			return channelIterator(ctx, obj), true
+		}
+	case *Generator:
+		if obj.executingIn(env.context()) {
+			return errorIterator(newError("generator already executing")), true
+		}
+	}
+	return iterate(obj)
+}
+
+// errorIterator fails with errObj on its first Next.
+func errorIterator(errObj *ErrorObj) Iterator {
+	failed := false
+	return &funcIterator{next: func() (Object, bool) {
+		if failed {
+			return nil, false
+		}
+		failed = true
+		return errObj, true
+	}}
+}
+
+// iterate returns an iterator over obj. Iterators are returned as-is;
+// arrays, tuples, sets, strings, maps and ranges get a fresh iterator.
+func iterate(obj Object) (Iterator, bool) {
+	switch obj := obj.(type) {
+	case Iterator:
+		return obj, true
+	case *Array:
+		return &sliceIterator{elements: obj.Elements}, true
+	case *Tuple:
+		return &sliceIterator{elements: obj.Elements}, true
+	case *Set:
+		return &sliceIterator{elements: obj.Elements}, true
+	case *String:
+		runes := []rune(obj.Value)
+		elements := make([]Object, len(runes))
+		for i, r := range runes {
+			elements[i] = &String{Value: string(r)}
+		}
+		return &sliceIterator{elements: elements}, true
+	case *Bytes:
+		elements := make([]Object, len(obj.Value))
+		for i, c := range obj.Value {
+			elements[i] = &Integer{Value: int64(c)}
+		}
+		return &sliceIterator{elements: elements}, true
+	case *Map:
+		return &sliceIterator{elements: mapItems(obj)}, true
+	case *Channel:
+		return channelIterator(context.Background(), obj), true
+	case *Range:
+		next := obj.Start
+		return &funcIterator{next: func() (Object, bool) {
+			if !obj.contains(next) {
+				return nil, false
+			}
+			val := &Integer{Value: next}
+			next += obj.Step
+			return val, true
+		}}, true
+	default:
+		return nil, false
+	}
+}
+
+// mapItems returns the (key, value) pairs of m as tuples, ordered by
+// key so that iteration is deterministic.
+func mapItems(m *Map) []Object {
+	keys := make([]Object, 0, len(m.Pairs))
+	for k := range m.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Inspect() < keys[j].Inspect() })
+	items := make([]Object, len(keys))
+	for i, k := range keys {
+		items[i] = &Tuple{Elements: []Object{k, m.Pairs[k]}}
+	}
+	return items
+}
+
+// collect drains it into a slice, stopping at the first error.
+func collect(it Iterator) ([]Object, Object) {
+	defer it.Close()
+	var elements []Object
+	for {
+		val, ok := it.Next()
+		if !ok {
+			return elements, nil
+		}
+		if isError(val) {
+			return nil, val
+		}
+		elements = append(elements, val)
+	}
+}
+
+// sequenceArg materializes an iterable builtin argument into a slice.
+func sequenceArg(name string, obj Object) ([]Object, Object) {
+	it, ok := iterate(obj)
+	if !ok {
+		return nil, newError("argument to `%s` must be iterable, got %s", name, obj.Type())
+	}
+	return collect(it)
+}
+
+type sliceIterator struct {
+	elements []Object
+	pos      int
+}
+
+func (s *sliceIterator) Type() string    { return "ITERATOR" }
+func (s *sliceIterator) Inspect() string { return "<iterator>" }
+func (s *sliceIterator) Close()          { s.pos = len(s.elements) }
+func (s *sliceIterator) Next() (Object, bool) {
+	if s.pos >= len(s.elements) {
+		return nil, false
+	}
+	s.pos++
+	return s.elements[s.pos-1], true
+}
+
+// funcIterator adapts a pair of closures to the Iterator interface. It
+// backs the lazy builtins (map, filter, fs.lines, ...).
+type funcIterator struct {
+	next  func() (Object, bool)
+	close func()
+	done  bool
+}
+
+func (f *funcIterator) Type() string    { return "ITERATOR" }
+func (f *funcIterator) Inspect() string { return "<iterator>" }
+func (f *funcIterator) Next() (Object, bool) {
+	if f.done {
+		return nil, false
+	}
+	val, ok := f.next()
+	if !ok || isError(val) {
+		f.Close()
+	}
+	return val, ok
+}
+func (f *funcIterator) Close() {
+	if f.done {
+		return
+	}
+	f.done = true
+	if f.close != nil {
+		f.close()
+	}
+}
+
+// Range is the lazy integer sequence returned by range(). Loops and
+// pipelines step through it without allocating; anything that needs the
+// values as a whole (printing, eager map/filter, slicing, equality) sees
+// the same array range() used to return.
+type Range struct {
+	Start, Stop, Step int64
+}
+
+func (r *Range) Type() string    { return "RANGE" }
+func (r *Range) Inspect() string { return r.toArray().Inspect() }
+
+// elements materializes the first n values of the range.
+func (r *Range) elements(n int64) []Object {
+	if l := r.Len(); n > l {
+		n = l
+	}
+	if n < 0 {
+		n = 0
+	}
+	out := make([]Object, n)
+	for i := range out {
+		out[i] = &Integer{Value: r.Start + int64(i)*r.Step}
+	}
+	return out
+}
+
+func (r *Range) toArray() *Array { return &Array{Elements: r.elements(r.Len())} }
+
+func (r *Range) contains(n int64) bool {
+	if r.Step > 0 {
+		return n >= r.Start && n < r.Stop
+	}
+	return n <= r.Start && n > r.Stop
+}
+
+func (r *Range) Len() int64 {
+	var n int64
+	if r.Step > 0 {
+		n = (r.Stop - r.Start + r.Step - 1) / r.Step
+	} else {
+		n = (r.Start - r.Stop - r.Step - 1) / -r.Step
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Generator is the object returned by calling a def that contains
+// `yield`. The body runs on its own goroutine, but only ever while the
+// consumer is blocked in Next, so the two never execute concurrently.
+// The goroutine only sees the generator state, not this handle, so a
+// generator that is dropped half way is closed when it is collected.
+type Generator struct {
+	*generator
+}
+
+type generator struct {
+	body *Function
+	env  *Environment
+
+	values chan Object   // yielded values; closed when the body returns
+	resume chan struct{} // lets a suspended body continue; buffered, as the body may have stopped
+	done   chan struct{} // closed by Close to unwind a suspended body
+
+	mu       sync.Mutex  // serializes Next and Close across tasks
+	running  atomic.Bool // set while the body runs on behalf of a Next
+	started  bool
+	finished bool
+	err      Object // set by the body before values is closed
+}
+
+// generatorKey marks the context a generator body runs under, so code
+// in the body can tell it is about to wait on its own generator.
+type generatorKey struct{ g *generator }
+
+// errGeneratorClosed unwinds a suspended generator body after Close.
+var errGeneratorClosed = &ErrorObj{Message: "generator closed"}
+
+func newGenerator(fn *Function, env *Environment) *Generator {
+	g := &generator{
+		body:   fn,
+		env:    env,
+		values: make(chan Object),
+		resume: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	env.generator = g
+	parent := env.context()
+	if parent == nil {
+		parent = context.Background()
+	}
+	env.ctx = context.WithValue(parent, generatorKey{g}, true)
+	handle := &Generator{g}
+	runtime.SetFinalizer(handle, func(h *Generator) { h.Close() })
+	return handle
+}
+
+func (g *Generator) Type() string    { return "GENERATOR" }
+func (g *Generator) Inspect() string { return "<generator>" }
+
+func (g *generator) Next() (Object, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.finished {
+		return nil, false
+	}
+	g.running.Store(true)
+	defer g.running.Store(false)
+	if !g.started {
+		g.started = true
+		go g.run()
+	} else {
+		g.resume <- struct{}{}
+	}
+	val, ok := <-g.values
+	if !ok {
+		g.finished = true
+		if g.err != nil {
+			return g.err, true
+		}
+		return nil, false
+	}
+	return val, true
+}
+
+// executingIn reports whether ctx is that of g's body while the body is
+// running, when asking g for its next value would wait on itself.
+func (g *generator) executingIn(ctx context.Context) bool {
+	return ctx != nil && g.running.Load() && ctx.Value(generatorKey{g}) != nil
+}
+
+func (g *generator) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.started && !g.finished {
+		close(g.done)
+		// Wait for the body to finish unwinding
+		for range g.values {
+		}
+	}
+	g.finished = true
+}
+
+func (g *generator) run() {
+	defer close(g.values)
+	defer func() {
+		if r := recover(); r != nil {
+			g.err = newError("generator failed: %v", r)
+		}
+	}()
+	result := Eval(g.body.Body, g.env)
+	if errObj, ok := result.(*ErrorObj); ok && errObj != errGeneratorClosed {
+		g.err = errObj
+	}
+}
+
+// yield hands val to the consumer and suspends the body until the next
+// call to Next. It runs on the generator goroutine and gives up when the
+// generator is closed or its task is cancelled.
+func (g *generator) yield(val Object) Object {
+	ctx := g.env.context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case g.values <- val:
+	case <-g.done:
+		return errGeneratorClosed
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+	select {
+	case <-g.resume:
+		return NULL
+	case <-g.done:
+		return errGeneratorClosed
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+func evalYieldExpression(val Object, env *Environment) Object {
+	for e := env; e != nil; e = e.outer {
+		if e.generator != nil {
+			return e.generator.yield(val)
+		}
+	}
+	return newError("'yield' outside generator")
+}
+
+// nextBuiltin implements next(it, default). ctx is that of the caller,
+// so a generator body asking for its own next value fails instead of
+// waiting on itself.
+func nextBuiltin(ctx context.Context, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	it, ok := args[0].(Iterator)
+	if !ok {
+		return newError("argument to `next` must be an iterator, got %s", args[0].Type())
+	}
+	if g, ok := it.(*Generator); ok && g.executingIn(ctx) {
+		return newError("generator already executing")
+	}
+	val, ok := it.Next()
+	if !ok {
+		if len(args) == 2 {
+			return args[1]
+		}
+		return NULL
+	}
+	return val
+}
+
+// iteratorBuiltins are the collection helpers that understand the
+// iterator protocol. map, filter and take stay eager on arrays and
+// tuples and return lazy iterators for everything else.
+func iteratorBuiltins() map[string]*BuiltinFunction {
+	return map[string]*BuiltinFunction{
+		"iter": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				it, ok := iterate(args[0])
+				if !ok {
+					return newError("argument to `iter` must be iterable, got %s", args[0].Type())
+				}
+				return it
+			},
+		},
+		"next": {
+			Fn:    func(args ...Object) Object { return nextBuiltin(nil, args...) },
+			CtxFn: nextBuiltin,
+		},
+		"list": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				it, ok := iterate(args[0])
+				if !ok {
+					return newError("argument to `list` must be iterable, got %s", args[0].Type())
+				}
+				elements, errObj := collect(it)
+				if errObj != nil {
+					return errObj
+				}
+				if elements == nil {
+					elements = []Object{}
+				}
+				return &Array{Elements: elements}
+			},
+		},
+		"map": callbackBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			fn := args[1]
+			if elements, ok := sequenceElements(args[0]); ok {
+				out := make([]Object, len(elements))
+				for i, elem := range elements {
+					val := callFunction(ctx, fn, []Object{elem})
+					if isError(val) {
+						return val
+					}
+					out[i] = val
+				}
+				return &Array{Elements: out}
+			}
+			src, ok := iterate(args[0])
+			if !ok {
+				return newError("argument to `map` must be iterable, got %s", args[0].Type())
+			}
+			return &funcIterator{
+				next: func() (Object, bool) {
+					val, ok := src.Next()
+					if !ok || isError(val) {
+						return val, ok
+					}
+					return callFunction(ctx, fn, []Object{val}), true
+				},
+				close: src.Close,
+			}
+		}),
+		"filter": callbackBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			fn := args[1]
+			if elements, ok := sequenceElements(args[0]); ok {
+				out := []Object{}
+				for _, elem := range elements {
+					keep := callFunction(ctx, fn, []Object{elem})
+					if isError(keep) {
+						return keep
+					}
+					if isTruthy(keep) {
+						out = append(out, elem)
+					}
+				}
+				return &Array{Elements: out}
+			}
+			src, ok := iterate(args[0])
+			if !ok {
+				return newError("argument to `filter` must be iterable, got %s", args[0].Type())
+			}
+			return &funcIterator{
+				next: func() (Object, bool) {
+					for {
+						val, ok := src.Next()
+						if !ok || isError(val) {
+							return val, ok
+						}
+						keep := callFunction(ctx, fn, []Object{val})
+						if isError(keep) {
+							return keep, true
+						}
+						if isTruthy(keep) {
+							return val, true
+						}
+					}
+				},
+				close: src.Close,
+			}
+		}),
+		"reduce": callbackBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			it, ok := iterate(args[0])
+			if !ok {
+				return newError("argument to `reduce` must be iterable, got %s", args[0].Type())
+			}
+			defer it.Close()
+			acc := args[2]
+			for {
+				val, ok := it.Next()
+				if !ok {
+					return acc
+				}
+				if isError(val) {
+					return val
+				}
+				acc = callFunction(ctx, args[1], []Object{acc, val})
+				if isError(acc) {
+					return acc
+				}
+			}
+		}),
+		"take": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=2", len(args))
+				}
+				n, ok := args[1].(*Integer)
+				if !ok {
+					return newError("second argument to `take` must be INTEGER, got %s", args[1].Type())
+				}
+				if r, ok := args[0].(*Range); ok {
+					return &Array{Elements: r.elements(n.Value)}
+				}
+				if elements, ok := sequenceElements(args[0]); ok {
+					end := int(n.Value)
+					if end > len(elements) {
+						end = len(elements)
+					}
+					if end < 0 {
+						end = 0
+					}
+					return &Array{Elements: append([]Object{}, elements[:end]...)}
+				}
+				src, ok := iterate(args[0])
+				if !ok {
+					return newError("argument to `take` must be iterable, got %s", args[0].Type())
+				}
+				remaining := n.Value
+				return &funcIterator{
+					next: func() (Object, bool) {
+						if remaining <= 0 {
+							return nil, false
+						}
+						remaining--
+						return src.Next()
+					},
+					close: src.Close,
+				}
+			},
+		},
+		"enumerate": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				src, ok := iterate(args[0])
+				if !ok {
+					return newError("argument to `enumerate` must be iterable, got %s", args[0].Type())
+				}
+				var i int64
+				return &funcIterator{
+					next: func() (Object, bool) {
+						val, ok := src.Next()
+						if !ok || isError(val) {
+							return val, ok
+						}
+						i++
+						return &Tuple{Elements: []Object{&Integer{Value: i - 1}, val}}, true
+					},
+					close: src.Close,
+				}
+			},
+		},
+		"zip": {
+			Fn: func(args ...Object) Object {
+				if len(args) < 2 {
+					return newError("wrong number of arguments. got=%d, want at least 2", len(args))
+				}
+				sources := make([]Iterator, len(args))
+				for i, arg := range args {
+					src, ok := iterate(arg)
+					if !ok {
+						return newError("argument to `zip` must be iterable, got %s", arg.Type())
+					}
+					sources[i] = src
+				}
+				return &funcIterator{
+					next: func() (Object, bool) {
+						elements := make([]Object, len(sources))
+						for i, src := range sources {
+							val, ok := src.Next()
+							if !ok || isError(val) {
+								return val, ok
+							}
+							elements[i] = val
+						}
+						return &Tuple{Elements: elements}, true
+					},
+					close: func() {
+						for _, src := range sources {
+							src.Close()
+						}
+					},
+				}
+			},
+		},
+	}
+}
+
+// linesIterator reads a file one line at a time for fs.lines.
+func linesIterator(path string) Object {
+	f, err := os.Open(path)
+	if err != nil {
+		return newError("fs.lines failed: %s", err)
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &funcIterator{
+		next: func() (Object, bool) {
+			if scanner.Scan() {
+				return &String{Value: scanner.Text()}, true
+			}
+			if err := scanner.Err(); err != nil {
+				return newError("fs.lines failed: %s", err), true
+			}
+			return nil, false
+		},
+		close: func() { f.Close() },
+	}
+}
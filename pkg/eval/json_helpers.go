@@ -1,7 +1,13 @@
 package eval
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
 )
 
 // Helper to convert Flowa objects to native Go types for JSON marshaling
@@ -9,6 +15,23 @@ func flowaToNative(obj Object) interface{} {
 	switch obj := obj.(type) {
 	case *Integer:
 		return obj.Value
+	case *BigInt:
+		return json.Number(obj.Value.String())
+	case *Decimal:
+		return json.Number(obj.Inspect())
+	case *Float:
+		// JSON has no NaN or infinities
+		if math.IsNaN(obj.Value) || math.IsInf(obj.Value, 0) {
+			return nil
+		}
+		return obj.Value
+	case *Bytes:
+		// Same as encoding/json does for []byte
+		return base64.StdEncoding.EncodeToString(obj.Value)
+	case *Time:
+		return obj.Value.Format(time.RFC3339Nano)
+	case *Duration:
+		return obj.Value.String()
 	case *String:
 		return obj.Value
 	case *Boolean:
@@ -21,6 +44,12 @@ func flowaToNative(obj Object) interface{} {
 			result = append(result, flowaToNative(elem))
 		}
 		return result
+	case *Tuple:
+		result := make([]interface{}, 0, len(obj.Elements))
+		for _, elem := range obj.Elements {
+			result = append(result, flowaToNative(elem))
+		}
+		return result
 	case *Map:
 		result := make(map[string]interface{})
 		for k, v := range obj.Pairs {
@@ -37,6 +66,18 @@ func flowaToNative(obj Object) interface{} {
 			result[k] = flowaToNative(v)
 		}
 		return result
+	case *Set:
+		result := make([]interface{}, len(obj.Elements))
+		for i, elem := range obj.Elements {
+			result[i] = flowaToNative(elem)
+		}
+		return result
+	case *Range:
+		result := make([]interface{}, 0, obj.Len())
+		for n := obj.Start; obj.contains(n); n += obj.Step {
+			result = append(result, n)
+		}
+		return result
 	default:
 		return obj.Inspect()
 	}
@@ -52,8 +93,14 @@ func nativeToFlowa(val interface{}) Object {
 			return TRUE
 		}
 		return FALSE
+	case json.Number:
+		return numberToFlowa(v)
 	case float64:
-		return &Integer{Value: int64(v)}
+		// Decoders without UseNumber give every number as float64
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return &Integer{Value: int64(v)}
+		}
+		return &Float{Value: v}
 	case int:
 		return &Integer{Value: int64(v)}
 	case int64:
@@ -76,3 +123,22 @@ func nativeToFlowa(val interface{}) Object {
 		return &String{Value: fmt.Sprintf("%v", v)}
 	}
 }
+
+// numberToFlowa converts a JSON number without losing precision:
+// integers become Integer (or BigInt when too large) and anything with
+// a fraction or exponent becomes a Decimal.
+func numberToFlowa(n json.Number) Object {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := n.Int64(); err == nil {
+			return &Integer{Value: i}
+		}
+		if b, ok := new(big.Int).SetString(s, 10); ok {
+			return &BigInt{Value: b}
+		}
+	}
+	if d, ok := parseDecimal(s); ok {
+		return d
+	}
+	return &String{Value: s}
+}
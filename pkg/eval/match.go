@@ -0,0 +1,210 @@
+package eval
+
+import (
+	"flowa/pkg/ast"
+)
+
+func evalMatchStatement(ms *ast.MatchStatement, env *Environment) Object {
+	subject := Eval(ms.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for i, c := range ms.Cases {
+		bindings := make(map[string]Object)
+		matched, errObj := matchPattern(c.Pattern, subject, env, bindings)
+		if errObj != nil {
+			return errObj
+		}
+		if !matched {
+			continue
+		}
+
+		// Captures are bound before the guard runs so it can refer to them
+		for name, val := range bindings {
+			env.Set(name, val)
+		}
+
+		if c.Guard != nil {
+			guard := Eval(c.Guard, env)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		if hooks := activeHooks.Load(); hooks != nil {
+			traceBranch(hooks, ms, i, env)
+		}
+		return Eval(c.Body, env)
+	}
+
+	if hooks := activeHooks.Load(); hooks != nil {
+		traceBranch(hooks, ms, len(ms.Cases), env)
+	}
+	return NULL
+}
+
+// matchPattern reports whether value matches pattern, recording captured
+// names in bindings. A non-nil error object is returned when evaluating
+// part of the pattern fails.
+func matchPattern(pattern ast.Pattern, value Object, env *Environment, bindings map[string]Object) (bool, Object) {
+	switch pat := pattern.(type) {
+	case *ast.WildcardPattern:
+		return true, nil
+
+	case *ast.CapturePattern:
+		bindings[pat.Name.Value] = value
+		return true, nil
+
+	case *ast.ValuePattern:
+		expected := Eval(pat.Value, env)
+		if isError(expected) {
+			return false, expected
+		}
+		return objectsEqual(value, expected), nil
+
+	case *ast.ArrayPattern:
+		elements, ok := sequenceElements(value)
+		if !ok {
+			return false, nil
+		}
+		return matchSequence(pat.Elements, elements, env, bindings)
+
+	case *ast.TuplePattern:
+		elements, ok := sequenceElements(value)
+		if !ok {
+			return false, nil
+		}
+		return matchSequence(pat.Elements, elements, env, bindings)
+
+	case *ast.MapPattern:
+		m, ok := value.(*Map)
+		if !ok {
+			return false, nil
+		}
+		for i, keyExp := range pat.Keys {
+			key := Eval(keyExp, env)
+			if isError(key) {
+				return false, key
+			}
+			val, found := mapGet(m, key)
+			if !found {
+				return false, nil
+			}
+			matched, errObj := matchPattern(pat.Values[i], val, env, bindings)
+			if errObj != nil || !matched {
+				return false, errObj
+			}
+		}
+		return true, nil
+
+	case *ast.TypePattern:
+		instance, ok := value.(*StructInstance)
+		if !ok || instance.Name != pat.Name.Value {
+			return false, nil
+		}
+		if len(pat.Args) > len(instance.Order) {
+			if instance.Order == nil && len(pat.Args) > 0 {
+				return false, newError("%s does not support positional patterns", instance.Name)
+			}
+			return false, nil
+		}
+		for i, sub := range pat.Args {
+			matched, errObj := matchPattern(sub, instance.Fields[instance.Order[i]], env, bindings)
+			if errObj != nil || !matched {
+				return false, errObj
+			}
+		}
+		for _, kw := range pat.Keywords {
+			field, found := instance.Fields[kw.Name.Value]
+			if !found {
+				return false, nil
+			}
+			matched, errObj := matchPattern(kw.Pattern, field, env, bindings)
+			if errObj != nil || !matched {
+				return false, errObj
+			}
+		}
+		return true, nil
+
+	case *ast.StarPattern:
+		return false, newError("starred pattern %s is only allowed inside a sequence", pat.String())
+
+	default:
+		return false, newError("unsupported pattern: %T", pattern)
+	}
+}
+
+// matchSequence matches elements against patterns, allowing at most one
+// starred pattern that captures the remaining elements as an array.
+func matchSequence(patterns []ast.Pattern, elements []Object, env *Environment, bindings map[string]Object) (bool, Object) {
+	starIdx := -1
+	for i, p := range patterns {
+		if _, ok := p.(*ast.StarPattern); ok {
+			if starIdx != -1 {
+				return false, newError("multiple starred patterns in sequence")
+			}
+			starIdx = i
+		}
+	}
+
+	if starIdx == -1 {
+		if len(elements) != len(patterns) {
+			return false, nil
+		}
+		for i, p := range patterns {
+			matched, errObj := matchPattern(p, elements[i], env, bindings)
+			if errObj != nil || !matched {
+				return false, errObj
+			}
+		}
+		return true, nil
+	}
+
+	before := starIdx
+	after := len(patterns) - starIdx - 1
+	if len(elements) < before+after {
+		return false, nil
+	}
+
+	for i := 0; i < before; i++ {
+		matched, errObj := matchPattern(patterns[i], elements[i], env, bindings)
+		if errObj != nil || !matched {
+			return false, errObj
+		}
+	}
+	for i := 0; i < after; i++ {
+		matched, errObj := matchPattern(patterns[starIdx+1+i], elements[len(elements)-after+i], env, bindings)
+		if errObj != nil || !matched {
+			return false, errObj
+		}
+	}
+
+	if star := patterns[starIdx].(*ast.StarPattern); star.Name != nil {
+		rest := make([]Object, len(elements)-before-after)
+		copy(rest, elements[before:len(elements)-after])
+		bindings[star.Name.Value] = &Array{Elements: rest}
+	}
+
+	return true, nil
+}
+
+// destructure binds the names in target to the parts of value, returning
+// an error object when value does not have the required shape.
+func destructure(target ast.Pattern, value Object, env *Environment) Object {
+	bindings := make(map[string]Object)
+	matched, errObj := matchPattern(target, value, env, bindings)
+	if errObj != nil {
+		return errObj
+	}
+	if !matched {
+		return newError("cannot destructure %s into %s", value.Inspect(), target.String())
+	}
+	for name, val := range bindings {
+		env.Set(name, val)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package eval
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// numberArg checks that args[idx] is a number.
+func numberArg(name string, args []Object, idx int) (Object, Object) {
+	if !isNumber(args[idx]) {
+		return nil, newError("argument to `%s` must be a number, got %s", name, args[idx].Type())
+	}
+	return args[idx], nil
+}
+
+// floatArg returns args[idx] as a float64.
+func floatArg(name string, args []Object, idx int) (float64, Object) {
+	f, ok := toFloat(args[idx])
+	if !ok {
+		return 0, newError("argument to `%s` must be a number, got %s", name, args[idx].Type())
+	}
+	return f, nil
+}
+
+// floatToInteger converts a finite, already-rounded float to an Integer,
+// or a BigInt when it does not fit.
+func floatToInteger(f float64) Object {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return newError("cannot convert %s to INTEGER", (&Float{Value: f}).Inspect())
+	}
+	if f >= math.MinInt64 && f < math.MaxInt64 {
+		return &Integer{Value: int64(f)}
+	}
+	b, _ := big.NewFloat(f).Int(nil)
+	return normalizeBigInt(b)
+}
+
+// roundNumber rounds n to an integer using a decimal rounding mode.
+// Integers are returned unchanged.
+func roundNumber(n Object, mode string) Object {
+	switch n := n.(type) {
+	case *Integer, *BigInt:
+		return n
+	case *Decimal:
+		d := n.Round(0, mode)
+		return normalizeBigInt(d.rescale(0))
+	case *Float:
+		switch mode {
+		case "floor":
+			return floatToInteger(math.Floor(n.Value))
+		case "ceiling":
+			return floatToInteger(math.Ceil(n.Value))
+		case "down":
+			return floatToInteger(math.Trunc(n.Value))
+		default:
+			return floatToInteger(math.RoundToEven(n.Value))
+		}
+	}
+	return newError("cannot round %s", n.Type())
+}
+
+// extremum implements min and max: either several arguments or a single
+// iterable. Values are compared with the `<` / `>` operators, so any
+// mix of numbers works, as do times and durations.
+func extremum(name, operator string, args []Object) Object {
+	values := args
+	if len(args) == 1 {
+		var errObj Object
+		values, errObj = sequenceArg(name, args[0])
+		if errObj != nil {
+			return errObj
+		}
+	}
+	if len(values) == 0 {
+		return newError("`%s` of an empty sequence", name)
+	}
+	best := values[0]
+	for _, val := range values[1:] {
+		better := evalInfixExpression(operator, val, best)
+		if isError(better) {
+			return better
+		}
+		if better == TRUE {
+			best = val
+		}
+	}
+	return best
+}
+
+// maxPowBits bounds exact integer powers, about 300,000 digits; larger
+// ones take minutes and memory without limit.
+const maxPowBits = 1 << 20
+
+func newMathModule() *StructInstance {
+	unary := func(name string, fn func(float64) float64) *BuiltinFunction {
+		return &BuiltinFunction{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				f, errObj := floatArg("math."+name, args, 0)
+				if errObj != nil {
+					return errObj
+				}
+				return &Float{Value: fn(f)}
+			},
+		}
+	}
+	rounding := func(name, mode string) *BuiltinFunction {
+		return &BuiltinFunction{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				n, errObj := numberArg("math."+name, args, 0)
+				if errObj != nil {
+					return errObj
+				}
+				return roundNumber(n, mode)
+			},
+		}
+	}
+
+	fields := map[string]Object{
+		"pi":    &Float{Value: math.Pi},
+		"e":     &Float{Value: math.E},
+		"inf":   &Float{Value: math.Inf(1)},
+		"nan":   &Float{Value: math.NaN()},
+		"sqrt":  unary("sqrt", math.Sqrt),
+		"exp":   unary("exp", math.Exp),
+		"log":   unary("log", math.Log),
+		"log2":  unary("log2", math.Log2),
+		"log10": unary("log10", math.Log10),
+		"sin":   unary("sin", math.Sin),
+		"cos":   unary("cos", math.Cos),
+		"tan":   unary("tan", math.Tan),
+		"asin":  unary("asin", math.Asin),
+		"acos":  unary("acos", math.Acos),
+		"atan":  unary("atan", math.Atan),
+		"floor": rounding("floor", "floor"),
+		"ceil":  rounding("ceil", "ceiling"),
+		"trunc": rounding("trunc", "down"),
+	}
+
+	fields["abs"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			n, errObj := numberArg("math.abs", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			if evalInfixExpression("<", n, &Integer{Value: 0}) == TRUE {
+				return evalMinusPrefixOperatorExpression(n)
+			}
+			return n
+		},
+	}
+
+	// math.round(x) rounds half to even and returns an integer;
+	// math.round(x, places) keeps the type of x.
+	fields["round"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			n, errObj := numberArg("math.round", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			if len(args) == 1 {
+				return roundNumber(n, "half_even")
+			}
+			places, ok := args[1].(*Integer)
+			if !ok {
+				return newError("places for `math.round` must be INTEGER, got %s", args[1].Type())
+			}
+			switch n := n.(type) {
+			case *Decimal:
+				return n.Round(int32(places.Value), "half_even")
+			case *Float:
+				d, ok := toDecimal(n)
+				if !ok {
+					return n
+				}
+				f, _ := strconv.ParseFloat(d.Round(int32(places.Value), "half_even").Inspect(), 64)
+				return &Float{Value: f}
+			default:
+				return n
+			}
+		},
+	}
+
+	// math.pow keeps integer results exact for non-negative exponents.
+	fields["pow"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			base, errObj := numberArg("math.pow", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			exp, errObj := numberArg("math.pow", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			b, bInt := toBigInt(base)
+			e, eInt := toBigInt(exp)
+			if bInt && eInt && e.Sign() >= 0 {
+				// The result has about BitLen(b) * e bits; 0, 1 and -1
+				// stay small whatever the exponent
+				if b.CmpAbs(big.NewInt(1)) > 0 &&
+					(!e.IsInt64() || e.Int64() > maxPowBits/int64(b.BitLen())) {
+					return newError("math.pow result would exceed %d bits", maxPowBits)
+				}
+				return normalizeBigInt(new(big.Int).Exp(b, e, nil))
+			}
+			bf, _ := toFloat(base)
+			ef, _ := toFloat(exp)
+			return &Float{Value: math.Pow(bf, ef)}
+		},
+	}
+
+	fields["atan2"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			y, errObj := floatArg("math.atan2", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			x, errObj := floatArg("math.atan2", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			return &Float{Value: math.Atan2(y, x)}
+		},
+	}
+
+	fields["hypot"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			x, errObj := floatArg("math.hypot", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			y, errObj := floatArg("math.hypot", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			return &Float{Value: math.Hypot(x, y)}
+		},
+	}
+
+	// math.clamp(x, lo, hi)
+	fields["clamp"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			if evalInfixExpression("<", args[0], args[1]) == TRUE {
+				return args[1]
+			}
+			if evalInfixExpression(">", args[0], args[2]) == TRUE {
+				return args[2]
+			}
+			return args[0]
+		},
+	}
+
+	fields["is_nan"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			f, ok := args[0].(*Float)
+			return nativeBoolToBooleanObject(ok && math.IsNaN(f.Value))
+		},
+	}
+
+	fields["is_inf"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			f, ok := args[0].(*Float)
+			return nativeBoolToBooleanObject(ok && math.IsInf(f.Value, 0))
+		},
+	}
+
+	return &StructInstance{Name: "Math", Fields: fields}
+}
+
+// numberBuiltins returns the `int` and `float` conversion builtins.
+func numberBuiltins() map[string]*BuiltinFunction {
+	return map[string]*BuiltinFunction{
+		// int(x) truncates numbers toward zero and parses strings
+		"int": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				switch arg := args[0].(type) {
+				case *String:
+					s := strings.TrimSpace(arg.Value)
+					if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+						return &Integer{Value: i}
+					}
+					if b, ok := new(big.Int).SetString(s, 10); ok {
+						return normalizeBigInt(b)
+					}
+					return newError("invalid integer: %q", arg.Value)
+				case *Boolean:
+					if arg.Value {
+						return &Integer{Value: 1}
+					}
+					return &Integer{Value: 0}
+				default:
+					if !isNumber(arg) {
+						return newError("argument to `int` must be a number or STRING, got %s", arg.Type())
+					}
+					return roundNumber(arg, "down")
+				}
+			},
+		},
+		"float": {
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				if s, ok := args[0].(*String); ok {
+					f, err := strconv.ParseFloat(strings.TrimSpace(s.Value), 64)
+					if err != nil {
+						return newError("invalid float: %q", s.Value)
+					}
+					return &Float{Value: f}
+				}
+				f, errObj := floatArg("float", args, 0)
+				if errObj != nil {
+					return errObj
+				}
+				return &Float{Value: f}
+			},
+		},
+	}
+}
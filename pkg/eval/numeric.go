@@ -0,0 +1,545 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// BigInt holds integers that do not fit in an int64. Integer arithmetic
+// promotes to BigInt on overflow, and BigInt results that fit again are
+// demoted back to Integer, so scripts only see one kind of integer.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() string    { return "BIGINT" }
+func (b *BigInt) Inspect() string { return b.Value.String() }
+
+// Decimal is an exact decimal number: Unscaled * 10^-Scale.
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int32
+}
+
+func (d *Decimal) Type() string { return "DECIMAL" }
+func (d *Decimal) Inspect() string {
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	sign := ""
+	if d.Unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if d.Scale <= 0 {
+		return sign + digits
+	}
+	if pad := int(d.Scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	point := len(digits) - int(d.Scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Float is an IEEE 754 double. Integers mixed with floats are widened
+// to Float; floats mixed with decimals are converted to Decimal using
+// their shortest representation, so 0.1 becomes exactly 0.1.
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() string { return "FLOAT" }
+func (f *Float) Inspect() string {
+	switch {
+	case math.IsNaN(f.Value):
+		return "nan"
+	case math.IsInf(f.Value, 1):
+		return "inf"
+	case math.IsInf(f.Value, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// divisionScale is the number of fractional digits kept by the `/`
+// operator on decimals. Use decimal.div to choose the scale explicitly.
+const divisionScale = 28
+
+// Rounding modes accepted by decimal.round and decimal.div.
+var roundingModes = map[string]bool{
+	"half_even": true,
+	"half_up":   true,
+	"half_down": true,
+	"up":        true,
+	"down":      true,
+	"ceiling":   true,
+	"floor":     true,
+}
+
+var bigTen = big.NewInt(10)
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+// normalizeBigInt demotes v to an Integer when it fits in an int64.
+func normalizeBigInt(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInt{Value: v}
+}
+
+// parseDecimal parses strings such as "12.50", "-3", "1e-3".
+func parseDecimal(s string) (*Decimal, bool) {
+	mantissa, exp := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa = s[:i]
+		var err error
+		if _, err = fmt.Sscan(s[i+1:], &exp); err != nil || exp > math.MaxInt16 || exp < math.MinInt16 {
+			return nil, false
+		}
+	}
+	var scale int64
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		scale = int64(len(mantissa) - i - 1)
+		mantissa = mantissa[:i] + mantissa[i+1:]
+	}
+	if mantissa == "" || mantissa == "-" || mantissa == "+" {
+		return nil, false
+	}
+	unscaled, ok := new(big.Int).SetString(mantissa, 10)
+	if !ok {
+		return nil, false
+	}
+	scale -= exp
+	if scale < 0 {
+		unscaled.Mul(unscaled, pow10(int32(-scale)))
+		scale = 0
+	}
+	return &Decimal{Unscaled: unscaled, Scale: int32(scale)}, true
+}
+
+// toDecimal converts integers, finite floats and decimals to a Decimal.
+func toDecimal(obj Object) (*Decimal, bool) {
+	switch obj := obj.(type) {
+	case *Decimal:
+		return obj, true
+	case *Float:
+		if math.IsNaN(obj.Value) || math.IsInf(obj.Value, 0) {
+			return nil, false
+		}
+		return parseDecimal(strconv.FormatFloat(obj.Value, 'f', -1, 64))
+	case *Integer:
+		return &Decimal{Unscaled: big.NewInt(obj.Value)}, true
+	case *BigInt:
+		return &Decimal{Unscaled: obj.Value}, true
+	default:
+		return nil, false
+	}
+}
+
+// toFloat converts any number to a float64, rounding if necessary.
+func toFloat(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Float:
+		return obj.Value, true
+	case *Integer:
+		return float64(obj.Value), true
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f, true
+	case *Decimal:
+		f, err := strconv.ParseFloat(obj.Inspect(), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func toBigInt(obj Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInt:
+		return obj.Value, true
+	default:
+		return nil, false
+	}
+}
+
+// rescale returns d's unscaled value expressed at the given (larger) scale.
+func (d *Decimal) rescale(scale int32) *big.Int {
+	if scale == d.Scale {
+		return d.Unscaled
+	}
+	return new(big.Int).Mul(d.Unscaled, pow10(scale-d.Scale))
+}
+
+func (d *Decimal) Cmp(other *Decimal) int {
+	scale := d.Scale
+	if other.Scale > scale {
+		scale = other.Scale
+	}
+	return d.rescale(scale).Cmp(other.rescale(scale))
+}
+
+// stripZeros removes trailing fractional zeros, keeping at least minScale digits.
+func (d *Decimal) stripZeros(minScale int32) *Decimal {
+	unscaled, scale := new(big.Int).Set(d.Unscaled), d.Scale
+	q, r := new(big.Int), new(big.Int)
+	for scale > minScale {
+		q.QuoRem(unscaled, bigTen, r)
+		if r.Sign() != 0 {
+			break
+		}
+		unscaled.Set(q)
+		scale--
+	}
+	return &Decimal{Unscaled: unscaled, Scale: scale}
+}
+
+// roundQuotient divides num by den and rounds the result to an integer
+// using mode.
+func roundQuotient(num, den *big.Int, mode string) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	sign := num.Sign() * den.Sign()
+	// Compare the discarded remainder against half of the divisor
+	half := new(big.Int).Abs(r)
+	half.Lsh(half, 1)
+	cmpHalf := half.Cmp(new(big.Int).Abs(den))
+
+	var away bool
+	switch mode {
+	case "up":
+		away = true
+	case "down":
+		away = false
+	case "ceiling":
+		away = sign > 0
+	case "floor":
+		away = sign < 0
+	case "half_up":
+		away = cmpHalf >= 0
+	case "half_down":
+		away = cmpHalf > 0
+	default: // half_even
+		away = cmpHalf > 0 || (cmpHalf == 0 && q.Bit(0) == 1)
+	}
+	if away {
+		q.Add(q, big.NewInt(int64(sign)))
+	}
+	return q
+}
+
+// Round returns d rounded to exactly places fractional digits.
+func (d *Decimal) Round(places int32, mode string) *Decimal {
+	if places >= d.Scale {
+		return &Decimal{Unscaled: d.rescale(places), Scale: places}
+	}
+	return &Decimal{Unscaled: roundQuotient(d.Unscaled, pow10(d.Scale-places), mode), Scale: places}
+}
+
+// Div returns d / other rounded to places fractional digits.
+func (d *Decimal) Div(other *Decimal, places int32, mode string) (*Decimal, bool) {
+	if other.Unscaled.Sign() == 0 {
+		return nil, false
+	}
+	num, den := new(big.Int).Set(d.Unscaled), new(big.Int).Set(other.Unscaled)
+	if e := places - d.Scale + other.Scale; e >= 0 {
+		num.Mul(num, pow10(e))
+	} else {
+		den.Mul(den, pow10(-e))
+	}
+	return &Decimal{Unscaled: roundQuotient(num, den, mode), Scale: places}, true
+}
+
+// checkedIntegerOp performs + - * / on int64s, reporting false on overflow.
+func checkedIntegerOp(operator string, a, b int64) (int64, bool) {
+	switch operator {
+	case "+":
+		r := a + b
+		return r, (r > a) == (b > 0)
+	case "-":
+		r := a - b
+		return r, (r < a) == (b > 0)
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		r := a * b
+		return r, r/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+	case "/":
+		return a / b, !(a == math.MinInt64 && b == -1)
+	}
+	return 0, false
+}
+
+func isNumber(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInt, *Decimal, *Float:
+		return true
+	}
+	return false
+}
+
+// evalNumericInfixExpression handles arithmetic and comparisons once a
+// BigInt, Decimal or Float is involved. Mixed operands are widened:
+// integers to BigInt or Float, and anything combined with a Decimal to
+// Decimal.
+func evalNumericInfixExpression(operator string, left, right Object) Object {
+	_, leftDec := left.(*Decimal)
+	_, rightDec := right.(*Decimal)
+	if leftDec || rightDec {
+		l, lok := toDecimal(left)
+		r, rok := toDecimal(right)
+		if !lok || !rok {
+			return newError("cannot convert %s to DECIMAL", nonDecimalOperand(left, right).Inspect())
+		}
+		return evalDecimalInfixExpression(operator, l, r)
+	}
+	_, leftFloat := left.(*Float)
+	_, rightFloat := right.(*Float)
+	if leftFloat || rightFloat {
+		l, _ := toFloat(left)
+		r, _ := toFloat(right)
+		return evalFloatInfixExpression(operator, l, r)
+	}
+	l, _ := toBigInt(left)
+	r, _ := toBigInt(right)
+	return evalBigIntInfixExpression(operator, l, r)
+}
+
+func evalBigIntInfixExpression(operator string, l, r *big.Int) Object {
+	switch operator {
+	case "+":
+		return normalizeBigInt(new(big.Int).Add(l, r))
+	case "-":
+		return normalizeBigInt(new(big.Int).Sub(l, r))
+	case "*":
+		return normalizeBigInt(new(big.Int).Mul(l, r))
+	case "/":
+		if r.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return normalizeBigInt(new(big.Int).Quo(l, r))
+	case "|":
+		return normalizeBigInt(new(big.Int).Or(l, r))
+	case "&":
+		return normalizeBigInt(new(big.Int).And(l, r))
+	case "^":
+		return normalizeBigInt(new(big.Int).Xor(l, r))
+	}
+	if result, ok := compareResult(operator, l.Cmp(r)); ok {
+		return result
+	}
+	return newError("unknown operator: BIGINT %s BIGINT", operator)
+}
+
+func nonDecimalOperand(left, right Object) Object {
+	if _, ok := left.(*Decimal); ok {
+		return right
+	}
+	return left
+}
+
+func evalFloatInfixExpression(operator string, l, r float64) Object {
+	switch operator {
+	case "+":
+		return &Float{Value: l + r}
+	case "-":
+		return &Float{Value: l - r}
+	case "*":
+		return &Float{Value: l * r}
+	case "/":
+		if r == 0 {
+			return newError("division by zero")
+		}
+		return &Float{Value: l / r}
+	case "==":
+		return nativeBoolToBooleanObject(l == r)
+	case "!=":
+		return nativeBoolToBooleanObject(l != r)
+	case "<":
+		return nativeBoolToBooleanObject(l < r)
+	case ">":
+		return nativeBoolToBooleanObject(l > r)
+	case "<=":
+		return nativeBoolToBooleanObject(l <= r)
+	case ">=":
+		return nativeBoolToBooleanObject(l >= r)
+	}
+	return newError("unknown operator: FLOAT %s FLOAT", operator)
+}
+
+func evalDecimalInfixExpression(operator string, l, r *Decimal) Object {
+	scale := l.Scale
+	if r.Scale > scale {
+		scale = r.Scale
+	}
+	switch operator {
+	case "+":
+		return &Decimal{Unscaled: new(big.Int).Add(l.rescale(scale), r.rescale(scale)), Scale: scale}
+	case "-":
+		return &Decimal{Unscaled: new(big.Int).Sub(l.rescale(scale), r.rescale(scale)), Scale: scale}
+	case "*":
+		return &Decimal{Unscaled: new(big.Int).Mul(l.Unscaled, r.Unscaled), Scale: l.Scale + r.Scale}
+	case "/":
+		result, ok := l.Div(r, scale+divisionScale, "half_even")
+		if !ok {
+			return newError("division by zero")
+		}
+		return result.stripZeros(scale)
+	}
+	if result, ok := compareResult(operator, l.Cmp(r)); ok {
+		return result
+	}
+	return newError("unknown operator: DECIMAL %s DECIMAL", operator)
+}
+
+func compareResult(operator string, cmp int) (Object, bool) {
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(cmp < 0), true
+	case ">":
+		return nativeBoolToBooleanObject(cmp > 0), true
+	case "<=":
+		return nativeBoolToBooleanObject(cmp <= 0), true
+	case ">=":
+		return nativeBoolToBooleanObject(cmp >= 0), true
+	case "==":
+		return nativeBoolToBooleanObject(cmp == 0), true
+	case "!=":
+		return nativeBoolToBooleanObject(cmp != 0), true
+	}
+	return nil, false
+}
+
+func newDecimalModule() *StructInstance {
+	decimalArg := func(name string, obj Object) (*Decimal, Object) {
+		if s, ok := obj.(*String); ok {
+			d, ok := parseDecimal(strings.TrimSpace(s.Value))
+			if !ok {
+				return nil, newError("invalid decimal: %q", s.Value)
+			}
+			return d, nil
+		}
+		d, ok := toDecimal(obj)
+		if !ok {
+			return nil, newError("argument to `decimal.%s` must be DECIMAL, INTEGER or STRING, got %s", name, obj.Type())
+		}
+		return d, nil
+	}
+	modeArg := func(args []Object, idx int) (string, Object) {
+		if len(args) <= idx {
+			return "half_even", nil
+		}
+		mode, ok := args[idx].(*String)
+		if !ok || !roundingModes[mode.Value] {
+			return "", newError("unknown rounding mode: %s", args[idx].Inspect())
+		}
+		return mode.Value, nil
+	}
+
+	return &StructInstance{
+		Name: "Decimal",
+		Fields: map[string]Object{
+			// decimal.new("12.50") or decimal.new(12)
+			"new": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 1 {
+						return newError("wrong number of arguments. got=%d, want=1", len(args))
+					}
+					d, errObj := decimalArg("new", args[0])
+					if errObj != nil {
+						return errObj
+					}
+					return d
+				},
+			},
+			// decimal.round(d, places, mode="half_even")
+			"round": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 2 && len(args) != 3 {
+						return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+					}
+					d, errObj := decimalArg("round", args[0])
+					if errObj != nil {
+						return errObj
+					}
+					places, ok := args[1].(*Integer)
+					if !ok || places.Value < 0 || places.Value > math.MaxInt16 {
+						return newError("decimal places must be a non-negative INTEGER, got %s", args[1].Inspect())
+					}
+					mode, errObj := modeArg(args, 2)
+					if errObj != nil {
+						return errObj
+					}
+					return d.Round(int32(places.Value), mode)
+				},
+			},
+			// decimal.div(a, b, places, mode="half_even")
+			"div": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 3 && len(args) != 4 {
+						return newError("wrong number of arguments. got=%d, want=3 or 4", len(args))
+					}
+					a, errObj := decimalArg("div", args[0])
+					if errObj != nil {
+						return errObj
+					}
+					b, errObj := decimalArg("div", args[1])
+					if errObj != nil {
+						return errObj
+					}
+					places, ok := args[2].(*Integer)
+					if !ok || places.Value < 0 || places.Value > math.MaxInt16 {
+						return newError("decimal places must be a non-negative INTEGER, got %s", args[2].Inspect())
+					}
+					mode, errObj := modeArg(args, 3)
+					if errObj != nil {
+						return errObj
+					}
+					result, ok := a.Div(b, int32(places.Value), mode)
+					if !ok {
+						return newError("division by zero")
+					}
+					return result
+				},
+			},
+			// decimal.to_int(d) truncates toward zero
+			"to_int": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 1 {
+						return newError("wrong number of arguments. got=%d, want=1", len(args))
+					}
+					d, errObj := decimalArg("to_int", args[0])
+					if errObj != nil {
+						return errObj
+					}
+					return normalizeBigInt(d.Round(0, "down").Unscaled)
+				},
+			},
+			// decimal.to_string(d)
+			"to_string": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 1 {
+						return newError("wrong number of arguments. got=%d, want=1", len(args))
+					}
+					d, errObj := decimalArg("to_string", args[0])
+					if errObj != nil {
+						return errObj
+					}
+					return &String{Value: d.Inspect()}
+				},
+			},
+		},
+	}
+}
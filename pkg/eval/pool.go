@@ -0,0 +1,204 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// maxWorkers caps a requested level of parallelism by FLOWA_MAX_WORKERS,
+// which lets a host running untrusted scripts bound the concurrency of
+// each pool. It is not a process-wide limit.
+func maxWorkers(requested int) int {
+	if limit, err := strconv.Atoi(os.Getenv("FLOWA_MAX_WORKERS")); err == nil && limit > 0 && requested > limit {
+		return limit
+	}
+	return requested
+}
+
+// Pool runs submitted calls as tasks, at most Size at a time. Tasks
+// beyond that wait for a free slot.
+type Pool struct {
+	Size    int
+	slots   chan struct{}
+	pending sync.WaitGroup
+}
+
+func newPool(size int) *Pool {
+	size = maxWorkers(size)
+	return &Pool{Size: size, slots: make(chan struct{}, size)}
+}
+
+func (p *Pool) Type() string    { return "POOL" }
+func (p *Pool) Inspect() string { return fmt.Sprintf("pool(%d)", p.Size) }
+
+// submit starts fn(args) as a task once a slot is free. Cancelling the
+// task while it waits gives up its place in the queue.
+func (p *Pool) submit(ctx context.Context, fn Object, args []Object) *Task {
+	task := newTask(ctx)
+	p.pending.Add(1)
+	task.start(func() Object {
+		defer p.pending.Done()
+		select {
+		case p.slots <- struct{}{}:
+		case <-task.ctx.Done():
+			return contextError(task.ctx)
+		}
+		defer func() { <-p.slots }()
+		return callFunction(task.ctx, fn, args)
+	})
+	return task
+}
+
+// mapValues applies fn to every value through the pool and returns the
+// results in input order. Values are fed to at most Size workers, so a
+// long list does not start a goroutine per item. The first error cancels
+// the calls still running and is returned once they have stopped.
+func (p *Pool) mapValues(ctx context.Context, fn Object, values []Object) Object {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]Object, len(values))
+	var failed Object
+	var failOnce sync.Once
+	fail := func(errObj Object) {
+		failOnce.Do(func() {
+			failed = errObj
+			cancel()
+		})
+	}
+
+	jobs := make(chan int)
+	var workers sync.WaitGroup
+	for w := 0; w < p.Size && w < len(values); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				if result := p.call(ctx, fn, values[i]); isError(result) {
+					fail(result)
+				} else {
+					results[i] = result
+				}
+			}
+		}()
+	}
+feed:
+	for i := range values {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	workers.Wait()
+
+	if failed != nil {
+		return failed
+	}
+	if ctx.Err() != nil {
+		return contextError(ctx)
+	}
+	return &Array{Elements: results}
+}
+
+// call runs fn(val) in one of the pool's slots.
+func (p *Pool) call(ctx context.Context, fn Object, val Object) (result Object) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+	defer func() { <-p.slots }()
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("parallel call panicked: %v", r)
+		}
+	}()
+	return callFunction(ctx, fn, []Object{val})
+}
+
+func poolMethod(p *Pool, name string) Object {
+	switch name {
+	case "size":
+		return &Integer{Value: int64(p.Size)}
+	// p.submit(fn, args...) returns a task
+	case "submit":
+		return contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want=at least 1", len(args))
+			}
+			return p.submit(ctx, args[0], args[1:])
+		})
+	// p.map(items, fn) works like map, with the pool's parallelism
+	case "map":
+		return contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			values, errObj := sequenceArg("map", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return p.mapValues(ctx, args[1], values)
+		})
+	// p.wait() blocks until every submitted task has finished
+	case "wait":
+		return &BuiltinFunction{Fn: func(args ...Object) Object {
+			p.pending.Wait()
+			return NULL
+		}}
+	default:
+		return newError("POOL has no method %s", name)
+	}
+}
+
+// workersArg reads an optional positive worker count.
+func workersArg(name string, args []Object, idx int) (int, Object) {
+	if len(args) <= idx {
+		return runtime.NumCPU(), nil
+	}
+	n, ok := args[idx].(*Integer)
+	if !ok || n.Value <= 0 {
+		return 0, newError("worker count for `%s` must be a positive INTEGER, got %s", name, args[idx].Inspect())
+	}
+	return int(n.Value), nil
+}
+
+func poolBuiltins() map[string]*BuiltinFunction {
+	return map[string]*BuiltinFunction{
+		// pool(size) defaults to the number of CPUs
+		"pool": {
+			Fn: func(args ...Object) Object {
+				if len(args) > 1 {
+					return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+				}
+				size, errObj := workersArg("pool", args, 0)
+				if errObj != nil {
+					return errObj
+				}
+				return newPool(size)
+			},
+		},
+		// parallel_map(items, fn, workers) is a pipeline stage:
+		// `items |> parallel_map(send_email, 8)`
+		"parallel_map": contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			values, errObj := sequenceArg("parallel_map", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			workers, errObj := workersArg("parallel_map", args, 2)
+			if errObj != nil {
+				return errObj
+			}
+			return newPool(workers).mapValues(ctx, args[1], values)
+		}),
+	}
+}
@@ -0,0 +1,334 @@
+package eval
+
+import (
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand/v2"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// The `random` module draws from one shared generator. random.seed makes
+// it deterministic, which is what tests want; it is NOT suitable for
+// secrets — use the `secrets` module for tokens and passwords.
+var randomSource = struct {
+	sync.Mutex
+	rng *rand.Rand
+}{rng: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))}
+
+// randIntn is the source-agnostic helper behind choice, shuffle and
+// sample, so the same code serves `random` and `secrets`.
+type randIntn func(n int64) int64
+
+func seededIntn(n int64) int64 {
+	randomSource.Lock()
+	defer randomSource.Unlock()
+	return randomSource.rng.Int64N(n)
+}
+
+func secureIntn(n int64) int64 {
+	v, err := crand.Int(crand.Reader, big.NewInt(n))
+	if err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return v.Int64()
+}
+
+// randomRange returns an integer in [lo, hi].
+func randomRange(name string, intn randIntn, args []Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	lo, ok1 := args[0].(*Integer)
+	hi, ok2 := args[1].(*Integer)
+	if !ok1 || !ok2 {
+		return newError("arguments to `%s` must be INTEGER, got %s and %s", name, args[0].Type(), args[1].Type())
+	}
+	if lo.Value > hi.Value {
+		return newError("empty range for `%s`: %d > %d", name, lo.Value, hi.Value)
+	}
+	span := hi.Value - lo.Value + 1
+	if span <= 0 {
+		return newError("range too large for `%s`", name)
+	}
+	return &Integer{Value: lo.Value + intn(span)}
+}
+
+func randomChoice(name string, intn randIntn, args []Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	values, errObj := sequenceArg(name, args[0])
+	if errObj != nil {
+		return errObj
+	}
+	if len(values) == 0 {
+		return newError("`%s` from an empty sequence", name)
+	}
+	return values[intn(int64(len(values)))]
+}
+
+// shuffled returns a shuffled copy of values (Fisher-Yates).
+func shuffled(values []Object, intn randIntn) []Object {
+	out := make([]Object, len(values))
+	copy(out, values)
+	for i := len(out) - 1; i > 0; i-- {
+		j := intn(int64(i + 1))
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func newRandomModule() *StructInstance {
+	fields := map[string]Object{}
+
+	fields["seed"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			seed, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument to `random.seed` must be INTEGER, got %s", args[0].Type())
+			}
+			randomSource.Lock()
+			randomSource.rng = rand.New(rand.NewPCG(uint64(seed.Value), 0))
+			randomSource.Unlock()
+			return NULL
+		},
+	}
+
+	// random.random() returns a float in [0, 1)
+	fields["random"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+			randomSource.Lock()
+			defer randomSource.Unlock()
+			return &Float{Value: randomSource.rng.Float64()}
+		},
+	}
+
+	fields["uniform"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			lo, errObj := floatArg("random.uniform", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			hi, errObj := floatArg("random.uniform", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			randomSource.Lock()
+			defer randomSource.Unlock()
+			return &Float{Value: lo + (hi-lo)*randomSource.rng.Float64()}
+		},
+	}
+
+	// random.randint(lo, hi) includes both ends
+	fields["randint"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			return randomRange("random.randint", seededIntn, args)
+		},
+	}
+
+	fields["choice"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			return randomChoice("random.choice", seededIntn, args)
+		},
+	}
+
+	// random.shuffle returns a shuffled copy; the argument is unchanged
+	fields["shuffle"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			values, errObj := sequenceArg("random.shuffle", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return &Array{Elements: shuffled(values, seededIntn)}
+		},
+	}
+
+	// random.sample(seq, k) picks k distinct positions
+	fields["sample"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			values, errObj := sequenceArg("random.sample", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			k, ok := args[1].(*Integer)
+			if !ok {
+				return newError("sample size must be INTEGER, got %s", args[1].Type())
+			}
+			if k.Value < 0 || k.Value > int64(len(values)) {
+				return newError("sample larger than population: %d > %d", k.Value, len(values))
+			}
+			return &Array{Elements: shuffled(values, seededIntn)[:k.Value]}
+		},
+	}
+
+	return &StructInstance{Name: "Random", Fields: fields}
+}
+
+// maxTokenBytes bounds secrets tokens; anything larger is a mistake, and
+// an unchecked size could exhaust memory.
+const maxTokenBytes = 4096
+
+func newSecretsModule() *StructInstance {
+	// nbytes defaults to 32, like Python's secrets module
+	tokenBytes := func(name string, args []Object) ([]byte, Object) {
+		if len(args) > 1 {
+			return nil, newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+		}
+		n := int64(32)
+		if len(args) == 1 {
+			size, ok := args[0].(*Integer)
+			if !ok || size.Value <= 0 {
+				return nil, newError("argument to `%s` must be a positive INTEGER, got %s", name, args[0].Inspect())
+			}
+			if size.Value > maxTokenBytes {
+				return nil, newError("`%s` token size %d exceeds the maximum of %d bytes", name, size.Value, maxTokenBytes)
+			}
+			n = size.Value
+		}
+		buf := make([]byte, n)
+		if _, err := crand.Read(buf); err != nil {
+			return nil, newError("%s: %s", name, err)
+		}
+		return buf, nil
+	}
+
+	fields := map[string]Object{}
+
+	fields["token_hex"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			buf, errObj := tokenBytes("secrets.token_hex", args)
+			if errObj != nil {
+				return errObj
+			}
+			return &String{Value: hex.EncodeToString(buf)}
+		},
+	}
+
+	fields["token_urlsafe"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			buf, errObj := tokenBytes("secrets.token_urlsafe", args)
+			if errObj != nil {
+				return errObj
+			}
+			return &String{Value: base64.RawURLEncoding.EncodeToString(buf)}
+		},
+	}
+
+	fields["randint"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			return randomRange("secrets.randint", secureIntn, args)
+		},
+	}
+
+	fields["choice"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			return randomChoice("secrets.choice", secureIntn, args)
+		},
+	}
+
+	// secrets.compare runs in constant time, for checking tokens
+	fields["compare"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			a, ok1 := args[0].(*String)
+			b, ok2 := args[1].(*String)
+			if !ok1 || !ok2 {
+				return newError("arguments to `secrets.compare` must be STRING, got %s and %s", args[0].Type(), args[1].Type())
+			}
+			return nativeBoolToBooleanObject(subtle.ConstantTimeCompare([]byte(a.Value), []byte(b.Value)) == 1)
+		},
+	}
+
+	return &StructInstance{Name: "Secrets", Fields: fields}
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func formatUUID(b [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newUUIDv4 returns a random (version 4) UUID.
+func newUUIDv4() (string, error) {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b), nil
+}
+
+// newUUIDv7 returns a time-ordered (version 7) UUID: a 48-bit Unix
+// millisecond timestamp followed by random bits, so IDs sort by
+// creation time.
+func newUUIDv7() (string, error) {
+	var b [16]byte
+	if _, err := crand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(b[0:6], ts[2:8])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b), nil
+}
+
+func newUUIDModule() *StructInstance {
+	generator := func(name string, gen func() (string, error)) *BuiltinFunction {
+		return &BuiltinFunction{
+			Fn: func(args ...Object) Object {
+				if len(args) != 0 {
+					return newError("wrong number of arguments. got=%d, want=0", len(args))
+				}
+				id, err := gen()
+				if err != nil {
+					return newError("%s: %s", name, err)
+				}
+				return &String{Value: id}
+			},
+		}
+	}
+
+	return &StructInstance{
+		Name: "UUID",
+		Fields: map[string]Object{
+			"v4": generator("uuid.v4", newUUIDv4),
+			"v7": generator("uuid.v7", newUUIDv7),
+			"is_valid": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					if len(args) != 1 {
+						return newError("wrong number of arguments. got=%d, want=1", len(args))
+					}
+					s, ok := args[0].(*String)
+					return nativeBoolToBooleanObject(ok && uuidPattern.MatchString(s.Value))
+				},
+			},
+		},
+	}
+}
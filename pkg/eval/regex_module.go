@@ -0,0 +1,393 @@
+package eval
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Regex is a compiled pattern returned by re.compile. Its methods mirror
+// the module functions without the pattern argument.
+type Regex struct {
+	Source string
+	re     *regexp.Regexp
+}
+
+func (r *Regex) Type() string    { return "REGEX" }
+func (r *Regex) Inspect() string { return "re.compile(" + strconv.Quote(r.Source) + ")" }
+
+// regexCacheSize bounds the number of compiled patterns kept around for
+// the string-pattern forms of the module functions.
+const regexCacheSize = 256
+
+var regexCache = struct {
+	sync.Mutex
+	entries map[string]*regexp.Regexp
+}{entries: make(map[string]*regexp.Regexp)}
+
+// compileRegex compiles source, reusing a cached result when possible.
+func compileRegex(source string) (*regexp.Regexp, Object) {
+	regexCache.Lock()
+	defer regexCache.Unlock()
+	if re, ok := regexCache.entries[source]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(source)
+	if err != nil {
+		return nil, newError("invalid regular expression: %s", err)
+	}
+	if len(regexCache.entries) >= regexCacheSize {
+		regexCache.entries = make(map[string]*regexp.Regexp)
+	}
+	regexCache.entries[source] = re
+	return re, nil
+}
+
+// regexArg accepts either a compiled Regex or a pattern string.
+func regexArg(name string, obj Object) (*regexp.Regexp, Object) {
+	switch obj := obj.(type) {
+	case *Regex:
+		return obj.re, nil
+	case *String:
+		return compileRegex(obj.Value)
+	default:
+		return nil, newError("pattern for `re.%s` must be STRING or REGEX, got %s", name, obj.Type())
+	}
+}
+
+// newMatch builds the Match record for the submatch indices loc of s.
+func newMatch(re *regexp.Regexp, s string, loc []int) *StructInstance {
+	groups := make([]Object, 0, re.NumSubexp())
+	named := &Map{Pairs: make(map[Object]Object)}
+	names := re.SubexpNames()
+	for i := 1; i <= re.NumSubexp(); i++ {
+		var group Object = NULL
+		if loc[2*i] >= 0 {
+			group = &String{Value: s[loc[2*i]:loc[2*i+1]]}
+		}
+		groups = append(groups, group)
+		if names[i] != "" {
+			named.Pairs[&String{Value: names[i]}] = group
+		}
+	}
+
+	text := &String{Value: s[loc[0]:loc[1]]}
+	fields := map[string]Object{
+		"text":   text,
+		"start":  &Integer{Value: int64(loc[0])},
+		"end":    &Integer{Value: int64(loc[1])},
+		"groups": &Array{Elements: groups},
+		"named":  named,
+	}
+	// m.group(0), m.group(2) or m.group("name")
+	fields["group"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch key := args[0].(type) {
+			case *Integer:
+				if key.Value == 0 {
+					return text
+				}
+				if key.Value < 0 || int(key.Value) > len(groups) {
+					return newError("no such group: %d", key.Value)
+				}
+				return groups[key.Value-1]
+			case *String:
+				if val, ok := mapGet(named, key); ok {
+					return val
+				}
+				return newError("no such group: %s", key.Value)
+			default:
+				return newError("group must be INTEGER or STRING, got %s", args[0].Type())
+			}
+		},
+	}
+	return &StructInstance{Name: "Match", Fields: fields}
+}
+
+// pythonTemplate rewrites Python-style group references (\1, \g<name>)
+// into Go's ${1} form, escaping literal dollar signs.
+func pythonTemplate(repl string) string {
+	var out strings.Builder
+	for i := 0; i < len(repl); i++ {
+		ch := repl[i]
+		switch {
+		case ch == '$':
+			out.WriteString("$$")
+		case ch == '\\' && i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9':
+			j := i + 1
+			for j < len(repl) && repl[j] >= '0' && repl[j] <= '9' {
+				j++
+			}
+			out.WriteString("${" + repl[i+1:j] + "}")
+			i = j - 1
+		case ch == '\\' && strings.HasPrefix(repl[i+1:], "g<"):
+			end := strings.IndexByte(repl[i:], '>')
+			if end < 0 {
+				out.WriteByte(ch)
+				continue
+			}
+			out.WriteString("${" + repl[i+3:i+end] + "}")
+			i += end
+		case ch == '\\' && i+1 < len(repl) && repl[i+1] == '\\':
+			out.WriteByte('\\')
+			i++
+		default:
+			out.WriteByte(ch)
+		}
+	}
+	return out.String()
+}
+
+func regexReplace(ctx context.Context, re *regexp.Regexp, s string, repl Object, count int) Object {
+	var template string
+	switch r := repl.(type) {
+	case *String:
+		template = pythonTemplate(r.Value)
+	case *Function, *BuiltinFunction:
+	default:
+		return newError("replacement must be STRING or FUNCTION, got %s", repl.Type())
+	}
+
+	var out []byte
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, count) {
+		out = append(out, s[last:loc[0]]...)
+		if _, ok := repl.(*String); ok {
+			out = re.ExpandString(out, template, s, loc)
+		} else {
+			val := callFunction(ctx, repl, []Object{newMatch(re, s, loc)})
+			if isError(val) {
+				return val
+			}
+			str, ok := val.(*String)
+			if !ok {
+				return newError("replacement function must return STRING, got %s", val.Type())
+			}
+			out = append(out, str.Value...)
+		}
+		last = loc[1]
+	}
+	out = append(out, s[last:]...)
+	return &String{Value: string(out)}
+}
+
+// regexFunctions returns the operations shared by the module (where the
+// pattern is the first argument) and compiled Regex objects.
+func regexFunctions() map[string]func(ctx context.Context, re *regexp.Regexp, args []Object) Object {
+	stringArg := func(name string, args []Object, idx int) (string, Object) {
+		s, ok := args[idx].(*String)
+		if !ok {
+			return "", newError("argument to `re.%s` must be STRING, got %s", name, args[idx].Type())
+		}
+		return s.Value, nil
+	}
+	countArg := func(name string, args []Object, idx int) (int, Object) {
+		if len(args) <= idx {
+			return -1, nil
+		}
+		n, ok := args[idx].(*Integer)
+		if !ok {
+			return 0, newError("count for `re.%s` must be INTEGER, got %s", name, args[idx].Type())
+		}
+		if n.Value <= 0 {
+			return -1, nil
+		}
+		return int(n.Value), nil
+	}
+
+	return map[string]func(ctx context.Context, re *regexp.Regexp, args []Object) Object{
+		// match only succeeds at the start of the string
+		"match": func(ctx context.Context, re *regexp.Regexp, args []Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, errObj := stringArg("match", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			loc := re.FindStringSubmatchIndex(s)
+			if loc == nil || loc[0] != 0 {
+				return NULL
+			}
+			return newMatch(re, s, loc)
+		},
+		"full_match": func(ctx context.Context, re *regexp.Regexp, args []Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, errObj := stringArg("full_match", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			anchored, errObj := compileRegex(`\A(?:` + re.String() + `)\z`)
+			if errObj != nil {
+				return errObj
+			}
+			loc := anchored.FindStringSubmatchIndex(s)
+			if loc == nil {
+				return NULL
+			}
+			return newMatch(anchored, s, loc)
+		},
+		"search": func(ctx context.Context, re *regexp.Regexp, args []Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, errObj := stringArg("search", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			loc := re.FindStringSubmatchIndex(s)
+			if loc == nil {
+				return NULL
+			}
+			return newMatch(re, s, loc)
+		},
+		// find_all returns the matched strings, the single group's value,
+		// or a tuple per match when the pattern has several groups
+		"find_all": func(ctx context.Context, re *regexp.Regexp, args []Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, errObj := stringArg("find_all", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			results := []Object{}
+			for _, m := range re.FindAllStringSubmatch(s, -1) {
+				switch len(m) {
+				case 1:
+					results = append(results, &String{Value: m[0]})
+				case 2:
+					results = append(results, &String{Value: m[1]})
+				default:
+					groups := make([]Object, len(m)-1)
+					for i, g := range m[1:] {
+						groups[i] = &String{Value: g}
+					}
+					results = append(results, &Tuple{Elements: groups})
+				}
+			}
+			return &Array{Elements: results}
+		},
+		// replace(s, repl, count=0); repl may use \1 or \g<name>, or be a
+		// function receiving the Match and returning the replacement
+		"replace": func(ctx context.Context, re *regexp.Regexp, args []Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			s, errObj := stringArg("replace", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			count, errObj := countArg("replace", args, 2)
+			if errObj != nil {
+				return errObj
+			}
+			return regexReplace(ctx, re, s, args[1], count)
+		},
+		// split(s, max_split=0)
+		"split": func(ctx context.Context, re *regexp.Regexp, args []Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			s, errObj := stringArg("split", args, 0)
+			if errObj != nil {
+				return errObj
+			}
+			n, errObj := countArg("split", args, 1)
+			if errObj != nil {
+				return errObj
+			}
+			if n > 0 {
+				n++
+			}
+			parts := re.Split(s, n)
+			elements := make([]Object, len(parts))
+			for i, part := range parts {
+				elements[i] = &String{Value: part}
+			}
+			return &Array{Elements: elements}
+		},
+	}
+}
+
+// regexMethod returns the bound method name of r for member access.
+func regexMethod(r *Regex, name string) Object {
+	if name == "pattern" {
+		return &String{Value: r.Source}
+	}
+	fn, ok := regexFunctions()[name]
+	if !ok {
+		return newError("REGEX has no method %s", name)
+	}
+	return callbackBuiltin(func(ctx context.Context, args ...Object) Object {
+		return fn(ctx, r.re, args)
+	})
+}
+
+func newRegexModule() *StructInstance {
+	fields := map[string]Object{}
+
+	for name, fn := range regexFunctions() {
+		name, fn := name, fn
+		fields[name] = callbackBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) == 0 {
+				return newError("wrong number of arguments. got=0, want at least 1")
+			}
+			re, errObj := regexArg(name, args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return fn(ctx, re, args[1:])
+		})
+	}
+
+	// re.compile(pattern, flags="") with flags from "imsU"
+	fields["compile"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			pattern, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `re.compile` must be STRING, got %s", args[0].Type())
+			}
+			source := pattern.Value
+			if len(args) == 2 {
+				flags, ok := args[1].(*String)
+				if !ok || strings.Trim(flags.Value, "imsU") != "" {
+					return newError("invalid regular expression flags: %s", args[1].Inspect())
+				}
+				if flags.Value != "" {
+					source = "(?" + flags.Value + ")" + source
+				}
+			}
+			re, errObj := compileRegex(source)
+			if errObj != nil {
+				return errObj
+			}
+			return &Regex{Source: source, re: re}
+		},
+	}
+
+	fields["escape"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `re.escape` must be STRING, got %s", args[0].Type())
+			}
+			return &String{Value: regexp.QuoteMeta(s.Value)}
+		},
+	}
+
+	return &StructInstance{Name: "RE", Fields: fields}
+}
@@ -0,0 +1,318 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"flowa/pkg/ast"
+)
+
+// Job is a recurring background job registered by an `every` or `cron`
+// block or the schedule module. A run that is still going when the next
+// one is due makes that run be skipped rather than overlap.
+type Job struct {
+	Name string
+
+	interval time.Duration // for `every`
+	cron     *cronSchedule // for `cron`
+	run      func(ctx context.Context) Object
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running atomic.Bool
+
+	runs, failures, skipped atomic.Int64
+	lastError               atomic.Value // string
+}
+
+func (j *Job) Type() string    { return "JOB" }
+func (j *Job) Inspect() string { return fmt.Sprintf("job(%s)", j.Name) }
+
+// nextRun returns when the job is next due after t.
+func (j *Job) nextRun(t time.Time) time.Time {
+	if j.cron != nil {
+		return j.cron.next(t)
+	}
+	return t.Add(j.interval)
+}
+
+// Scheduler owns every registered job. Stop cancels them all and waits
+// for runs in progress, so a process can shut down cleanly.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*Job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+	idle    chan struct{} // closed once no jobs are left
+	stopped bool          // set by Stop; no jobs are taken afterwards
+
+	// Failed runs are reported here
+	Log io.Writer
+}
+
+func newScheduler() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, cancel: cancel, Log: os.Stderr}
+}
+
+// DefaultScheduler runs the jobs of every script in this process.
+var DefaultScheduler = newScheduler()
+
+func (s *Scheduler) add(j *Job) Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Stop is final: a job added now would reuse the WaitGroup that a
+	// Wait after Stop may still be blocked on
+	if s.stopped {
+		return newError("cannot schedule %s: the scheduler is stopped", j.Name)
+	}
+	j.ctx, j.cancel = context.WithCancel(s.ctx)
+	if len(s.jobs) == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.jobs = append(s.jobs, j)
+	go s.loop(j)
+	return nil
+}
+
+func (s *Scheduler) loop(j *Job) {
+	defer s.remove(j)
+	for {
+		next := j.nextRun(time.Now())
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-j.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !j.running.CompareAndSwap(false, true) {
+			j.skipped.Add(1)
+			fmt.Fprintf(s.Log, "schedule: skipping %s, previous run still in progress\n", j.Name)
+			continue
+		}
+		// Registering the run under the lock means Stop either sees it
+		// or has already cancelled the job
+		s.mu.Lock()
+		if j.ctx.Err() != nil {
+			s.mu.Unlock()
+			j.running.Store(false)
+			return
+		}
+		s.running.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.running.Done()
+			defer j.running.Store(false)
+			j.execute(s.Log)
+		}()
+	}
+}
+
+// execute runs the job once, recording and logging a failure.
+func (j *Job) execute(log io.Writer) {
+	result := func() (result Object) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = newError("job panicked: %v", r)
+			}
+		}()
+		return j.run(j.ctx)
+	}()
+	j.runs.Add(1)
+	if errObj, ok := result.(*ErrorObj); ok && j.ctx.Err() == nil {
+		j.failures.Add(1)
+		j.lastError.Store(errObj.Message)
+		fmt.Fprintf(log, "schedule: %s failed: %s\n", j.Name, errObj.Message)
+	}
+}
+
+func (s *Scheduler) remove(j *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.jobs {
+		if other == j {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			if len(s.jobs) == 0 {
+				close(s.idle)
+			}
+			return
+		}
+	}
+}
+
+// Jobs reports how many jobs are registered.
+func (s *Scheduler) Jobs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+// Stop cancels all jobs and waits up to timeout for runs in progress.
+// Jobs added afterwards are refused.
+func (s *Scheduler) Stop(timeout time.Duration) {
+	s.mu.Lock()
+	s.cancel()
+	if len(s.jobs) > 0 {
+		close(s.idle)
+	}
+	s.jobs = nil
+	s.stopped = true
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+	}
+}
+
+// Wait blocks until no jobs are left, because each was stopped or Stop
+// was called, and then for the runs still in progress.
+func (s *Scheduler) Wait() {
+	s.mu.Lock()
+	if len(s.jobs) == 0 {
+		s.mu.Unlock()
+		s.running.Wait()
+		return
+	}
+	idle := s.idle
+	s.mu.Unlock()
+	<-idle
+	s.running.Wait()
+}
+
+// newJob builds a job from an interval (`every`) or cron (`cron`) spec.
+func newJob(kind string, spec Object, run func(ctx context.Context) Object) (*Job, Object) {
+	j := &Job{run: run}
+	switch kind {
+	case "every":
+		d, errObj := durationArg("every", spec)
+		if errObj != nil {
+			return nil, errObj
+		}
+		if d <= 0 {
+			return nil, newError("every: interval must be positive, got %s", d)
+		}
+		j.interval = d
+		j.Name = "every " + d.String()
+	case "cron":
+		s, ok := spec.(*String)
+		if !ok {
+			return nil, newError("cron schedule must be STRING, got %s", spec.Type())
+		}
+		c, err := parseCron(s.Value)
+		if err != nil {
+			return nil, newError("invalid cron schedule %q: %s", s.Value, err)
+		}
+		j.cron = c
+		j.Name = "cron " + s.Value
+	}
+	j.lastError.Store("")
+	return j, nil
+}
+
+func evalScheduleStatement(ss *ast.ScheduleStatement, env *Environment) Object {
+	spec := Eval(ss.Spec, env)
+	if isError(spec) {
+		return spec
+	}
+	// Runs never overlap, so they share one scope and a variable set in
+	// the block keeps its value until the next run
+	jobEnv := NewEnclosedEnvironment(env)
+	job, errObj := newJob(ss.Kind, spec, func(ctx context.Context) Object {
+		jobEnv.ctx = ctx
+		return unwrapReturnValue(Eval(ss.Body, jobEnv))
+	})
+	if errObj != nil {
+		return errObj
+	}
+	if errObj := env.Scheduler().add(job); errObj != nil {
+		return errObj
+	}
+	return NULL
+}
+
+func jobField(j *Job, name string) Object {
+	switch name {
+	case "name":
+		return &String{Value: j.Name}
+	case "runs":
+		return &Integer{Value: j.runs.Load()}
+	case "failures":
+		return &Integer{Value: j.failures.Load()}
+	case "skipped":
+		return &Integer{Value: j.skipped.Load()}
+	case "last_error":
+		if msg := j.lastError.Load().(string); msg != "" {
+			return &String{Value: msg}
+		}
+		return NULL
+	case "running":
+		return nativeBoolToBooleanObject(j.running.Load())
+	case "stop":
+		return &BuiltinFunction{Fn: func(args ...Object) Object {
+			j.cancel()
+			return NULL
+		}}
+	default:
+		return newError("JOB has no field %s", name)
+	}
+}
+
+func newScheduleModule(s *Scheduler) *StructInstance {
+	register := func(kind string) *BuiltinFunction {
+		return &BuiltinFunction{
+			Fn: func(args ...Object) Object {
+				if len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=2", len(args))
+				}
+				fn := args[1]
+				job, errObj := newJob(kind, args[0], func(ctx context.Context) Object {
+					return callFunction(ctx, fn, nil)
+				})
+				if errObj != nil {
+					return errObj
+				}
+				if errObj := s.add(job); errObj != nil {
+					return errObj
+				}
+				return job
+			},
+		}
+	}
+
+	return &StructInstance{
+		Name: "Schedule",
+		Fields: map[string]Object{
+			// schedule.every("5m", fn) / schedule.cron("0 3 * * *", fn)
+			"every": register("every"),
+			"cron":  register("cron"),
+			"jobs": &BuiltinFunction{
+				Fn: func(args ...Object) Object {
+					s.mu.Lock()
+					defer s.mu.Unlock()
+					out := make([]Object, len(s.jobs))
+					for i, j := range s.jobs {
+						out[i] = j
+					}
+					return &Array{Elements: out}
+				},
+			},
+		},
+	}
+}
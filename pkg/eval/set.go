@@ -0,0 +1,222 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"flowa/pkg/ast"
+)
+
+// Set is an unordered collection of distinct hashable values. Elements
+// keep their insertion order so printing and iteration are stable.
+type Set struct {
+	Elements []Object
+	index    map[string]bool
+}
+
+func NewSet() *Set {
+	return &Set{Elements: []Object{}, index: make(map[string]bool)}
+}
+
+func (s *Set) Type() string { return "SET" }
+func (s *Set) Inspect() string {
+	if len(s.Elements) == 0 {
+		return "set()"
+	}
+	out := make([]string, len(s.Elements))
+	for i, elem := range s.Elements {
+		out[i] = elem.Inspect()
+	}
+	return "{" + strings.Join(out, ", ") + "}"
+}
+
+// Add inserts obj unless an equal value is already present. It returns
+// an error for values that cannot be hashed, such as arrays and maps.
+func (s *Set) Add(obj Object) Object {
+	key, ok := hashKey(obj)
+	if !ok {
+		return newError("unhashable type: %s", obj.Type())
+	}
+	if !s.index[key] {
+		s.index[key] = true
+		s.Elements = append(s.Elements, obj)
+	}
+	return nil
+}
+
+func (s *Set) Contains(obj Object) bool {
+	key, ok := hashKey(obj)
+	return ok && s.index[key]
+}
+
+// hashKey returns a string that is equal for equal values. Only
+// immutable values (numbers, strings, booleans, None and tuples of
+// those) are hashable.
+func hashKey(obj Object) (string, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return fmt.Sprintf("i:%d", obj.Value), true
+	case *BigInt:
+		return "i:" + obj.Value.String(), true
+	case *Decimal:
+		// Equal numbers hash alike: 1.50 == 1.5 and 2.0 == 2
+		d := obj.stripZeros(0)
+		if d.Scale == 0 {
+			return "i:" + d.Unscaled.String(), true
+		}
+		return "d:" + d.Inspect(), true
+	case *Float:
+		if d, ok := toDecimal(obj); ok {
+			return hashKey(d)
+		}
+		return "f:" + obj.Inspect(), true
+	case *Time:
+		return "t:" + obj.Value.UTC().Format(time.RFC3339Nano), true
+	case *Duration:
+		return fmt.Sprintf("dur:%d", int64(obj.Value)), true
+	case *String:
+		return "s:" + obj.Value, true
+	case *Bytes:
+		return "y:" + string(obj.Value), true
+	case *Boolean:
+		return fmt.Sprintf("b:%t", obj.Value), true
+	case *Null:
+		return "n", true
+	case *Tuple:
+		parts := make([]string, len(obj.Elements))
+		for i, elem := range obj.Elements {
+			key, ok := hashKey(elem)
+			if !ok {
+				return "", false
+			}
+			parts[i] = fmt.Sprintf("%d:%s", len(key), key)
+		}
+		return "t(" + strings.Join(parts, ",") + ")", true
+	default:
+		return "", false
+	}
+}
+
+func (s *Set) isSubset(other *Set) bool {
+	for _, elem := range s.Elements {
+		if !other.Contains(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+func evalSetLiteral(node *ast.SetLiteral, env *Environment) Object {
+	set := NewSet()
+	for _, el := range node.Elements {
+		val := Eval(el, env)
+		if isError(val) {
+			return val
+		}
+		if errObj := set.Add(val); errObj != nil {
+			return errObj
+		}
+	}
+	return set
+}
+
+func evalSetInfixExpression(operator string, left, right *Set) Object {
+	result := NewSet()
+	switch operator {
+	case "|":
+		for _, elem := range left.Elements {
+			result.Add(elem)
+		}
+		for _, elem := range right.Elements {
+			result.Add(elem)
+		}
+	case "&":
+		for _, elem := range left.Elements {
+			if right.Contains(elem) {
+				result.Add(elem)
+			}
+		}
+	case "-":
+		for _, elem := range left.Elements {
+			if !right.Contains(elem) {
+				result.Add(elem)
+			}
+		}
+	case "^":
+		for _, elem := range left.Elements {
+			if !right.Contains(elem) {
+				result.Add(elem)
+			}
+		}
+		for _, elem := range right.Elements {
+			if !left.Contains(elem) {
+				result.Add(elem)
+			}
+		}
+	case "<=":
+		return nativeBoolToBooleanObject(left.isSubset(right))
+	case "<":
+		return nativeBoolToBooleanObject(len(left.Elements) < len(right.Elements) && left.isSubset(right))
+	case ">=":
+		return nativeBoolToBooleanObject(right.isSubset(left))
+	case ">":
+		return nativeBoolToBooleanObject(len(right.Elements) < len(left.Elements) && right.isSubset(left))
+	case "==":
+		return nativeBoolToBooleanObject(objectsEqual(left, right))
+	case "!=":
+		return nativeBoolToBooleanObject(!objectsEqual(left, right))
+	default:
+		return newError("unknown operator: SET %s SET", operator)
+	}
+	return result
+}
+
+// evalMembershipExpression implements `in` and `not in`.
+func evalMembershipExpression(operator string, item, container Object) Object {
+	var found bool
+	switch c := container.(type) {
+	case *Set:
+		found = c.Contains(item)
+	case *Array:
+		found = containsElement(c.Elements, item)
+	case *Tuple:
+		found = containsElement(c.Elements, item)
+	case *Map:
+		_, found = mapGet(c, item)
+	case *Range:
+		n, ok := item.(*Integer)
+		found = ok && c.contains(n.Value) && (n.Value-c.Start)%c.Step == 0
+	case *Bytes:
+		switch item := item.(type) {
+		case *Integer:
+			found = item.Value >= 0 && item.Value <= 255 && bytes.IndexByte(c.Value, byte(item.Value)) >= 0
+		case *Bytes:
+			found = bytes.Contains(c.Value, item.Value)
+		default:
+			return newError("left operand of `%s` BYTES must be INTEGER or BYTES, got %s", operator, item.Type())
+		}
+	case *String:
+		sub, ok := item.(*String)
+		if !ok {
+			return newError("left operand of `%s` STRING must be STRING, got %s", operator, item.Type())
+		}
+		found = strings.Contains(c.Value, sub.Value)
+	default:
+		return newError("`%s` not supported for %s", operator, container.Type())
+	}
+	if operator == "not in" {
+		found = !found
+	}
+	return nativeBoolToBooleanObject(found)
+}
+
+func containsElement(elements []Object, item Object) bool {
+	for _, elem := range elements {
+		if objectsEqual(elem, item) {
+			return true
+		}
+	}
+	return false
+}
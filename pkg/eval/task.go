@@ -0,0 +1,373 @@
+package eval
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"flowa/pkg/ast"
+)
+
+// Task is the handle of a computation running on its own goroutine,
+// created by `spawn` or async builtins. Each task carries a context that
+// is cancelled by task.cancel(), a timed-out await, or the cancellation
+// of the task that spawned it.
+type Task struct {
+	Result Object
+	done   chan struct{}
+	once   sync.Once
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cancelled atomic.Bool
+}
+
+// newTask returns a pending task whose context is derived from parent.
+func newTask(parent context.Context) *Task {
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	return &Task{done: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+func (t *Task) Type() string { return "TASK" }
+func (t *Task) Inspect() string {
+	select {
+	case <-t.done:
+		return "task(" + t.Result.Inspect() + ")"
+	default:
+		return "task(pending)"
+	}
+}
+
+// settle records the task's result and wakes everyone awaiting it. Only
+// the first call has any effect, so a cancelled task keeps its
+// cancellation error even if the body finishes later.
+func (t *Task) settle(result Object, cancelled bool) {
+	t.once.Do(func() {
+		t.Result = result
+		t.cancelled.Store(cancelled)
+		close(t.done)
+	})
+	t.cancel()
+}
+
+func (t *Task) finish(result Object) { t.settle(result, false) }
+
+// Cancel stops the task. Awaiting it returns an error right away; the
+// body notices at its next loop iteration or cancellable call.
+// Cancelling a finished task does nothing.
+func (t *Task) Cancel() { t.settle(newError("task cancelled"), true) }
+
+// start runs fn on a new goroutine and finishes the task with its
+// result. A panic in the interpreter fails the task, not the process.
+func (t *Task) start(fn func() Object) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.finish(newError("spawned task panicked: %v", r))
+			}
+		}()
+		t.finish(fn())
+	}()
+}
+
+// Await blocks until the task has completed and returns its result.
+func (t *Task) Await() Object {
+	<-t.done
+	return t.Result
+}
+
+// wait is Await bounded by the caller's context and an optional timeout.
+// A task that times out is cancelled.
+func (t *Task) wait(ctx context.Context, timeout time.Duration) Object {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var stopped <-chan struct{}
+	if ctx != nil {
+		stopped = ctx.Done()
+	}
+	select {
+	case <-t.done:
+		return t.Result
+	case <-expired:
+		t.Cancel()
+		return newError("await timed out after %s", timeout)
+	case <-stopped:
+		return contextError(ctx)
+	}
+}
+
+func taskField(t *Task, name string) Object {
+	switch name {
+	case "cancel":
+		return &BuiltinFunction{Fn: func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+			t.Cancel()
+			return NULL
+		}}
+	case "done":
+		select {
+		case <-t.done:
+			return TRUE
+		default:
+			return FALSE
+		}
+	case "cancelled":
+		return nativeBoolToBooleanObject(t.cancelled.Load())
+	default:
+		return newError("TASK has no field %s", name)
+	}
+}
+
+// contextError is returned by cancellable operations whose task was
+// cancelled.
+func contextError(ctx context.Context) Object {
+	if ctx.Err() == context.DeadlineExceeded {
+		return newError("task timed out")
+	}
+	return newError("task cancelled")
+}
+
+// checkCancelled reports a cancellation error if the task running in env
+// has been cancelled. Loops call it so a cancelled task stops spinning.
+func checkCancelled(env *Environment) Object {
+	if ctx := env.context(); ctx != nil && ctx.Err() != nil {
+		return contextError(ctx)
+	}
+	return nil
+}
+
+// contextBuiltin wraps a builtin that should stop when the calling task
+// is cancelled. Outside a task it runs with a background context.
+func contextBuiltin(fn func(ctx context.Context, args ...Object) Object) *BuiltinFunction {
+	return &BuiltinFunction{
+		Fn:    func(args ...Object) Object { return fn(context.Background(), args...) },
+		CtxFn: fn,
+	}
+}
+
+// callbackBuiltin wraps a builtin that calls a function it is given, so
+// the callee runs on behalf of the calling code. Outside a task, with no
+// hooks installed, there is no context to pass on and ctx is nil.
+func callbackBuiltin(fn func(ctx context.Context, args ...Object) Object) *BuiltinFunction {
+	return &BuiltinFunction{
+		Fn:    func(args ...Object) Object { return fn(nil, args...) },
+		CtxFn: fn,
+	}
+}
+
+// evalSpawnExpression starts the spawned call on its own goroutine. For
+// `spawn f(args)` the callee and arguments are evaluated right away, so
+// loop variables are captured by value; only the call itself runs
+// concurrently.
+func evalSpawnExpression(se *ast.SpawnExpression, env *Environment) Object {
+	task := newTask(env.context())
+
+	run := func() Object {
+		taskEnv := NewEnclosedEnvironment(env)
+		taskEnv.ctx = task.ctx
+		return Eval(se.Call, taskEnv)
+	}
+	if call, ok := se.Call.(*ast.CallExpression); ok {
+		fn := Eval(call.Function, env)
+		if isError(fn) {
+			return fn
+		}
+		args := evalExpressions(call.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		run = func() Object { return callFunction(task.ctx, fn, args) }
+	}
+
+	task.start(run)
+	return task
+}
+
+func evalAwaitExpression(ae *ast.AwaitExpression, env *Environment) Object {
+	val := Eval(ae.Value, env)
+	if isError(val) {
+		return val
+	}
+	task, ok := val.(*Task)
+	if !ok {
+		return newError("await can only be used on tasks, got %s", val.Type())
+	}
+	var timeout time.Duration
+	if ae.Timeout != nil {
+		obj := Eval(ae.Timeout, env)
+		if isError(obj) {
+			return obj
+		}
+		var errObj Object
+		if timeout, errObj = durationArg("await", obj); errObj != nil {
+			return errObj
+		}
+	}
+	return task.wait(env.context(), timeout)
+}
+
+// tasksArg returns an array of tasks passed to a combinator.
+func tasksArg(name string, args []Object) ([]*Task, Object) {
+	if len(args) != 1 {
+		return nil, newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	values, errObj := sequenceArg(name, args[0])
+	if errObj != nil {
+		return nil, errObj
+	}
+	tasks := make([]*Task, len(values))
+	for i, val := range values {
+		task, ok := val.(*Task)
+		if !ok {
+			return nil, newError("`%s` expects TASK values, got %s", name, val.Type())
+		}
+		tasks[i] = task
+	}
+	return tasks, nil
+}
+
+// completions delivers the index of each task as it finishes.
+func completions(tasks []*Task) <-chan int {
+	ch := make(chan int, len(tasks))
+	for i, task := range tasks {
+		go func() {
+			<-task.done
+			ch <- i
+		}()
+	}
+	return ch
+}
+
+func cancelAll(tasks []*Task) {
+	for _, task := range tasks {
+		task.Cancel()
+	}
+}
+
+// gatherTasks waits for all tasks and returns their results in order.
+// The first error cancels the remaining tasks and is returned.
+func gatherTasks(ctx context.Context, tasks []*Task) Object {
+	done := completions(tasks)
+	for range tasks {
+		select {
+		case i := <-done:
+			if isError(tasks[i].Result) {
+				cancelAll(tasks)
+				return tasks[i].Result
+			}
+		case <-ctx.Done():
+			cancelAll(tasks)
+			return contextError(ctx)
+		}
+	}
+	results := make([]Object, len(tasks))
+	for i, task := range tasks {
+		results[i] = task.Result
+	}
+	return &Array{Elements: results}
+}
+
+// taskBuiltins returns await_timeout and the gather/race/all_settled
+// combinators.
+func taskBuiltins() map[string]*BuiltinFunction {
+	return map[string]*BuiltinFunction{
+		// await_timeout(task, 2s) is `await task timeout 2s`
+		"await_timeout": contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			task, ok := args[0].(*Task)
+			if !ok {
+				return newError("argument to `await_timeout` must be TASK, got %s", args[0].Type())
+			}
+			timeout, errObj := durationArg("await_timeout", args[1])
+			if errObj != nil {
+				return errObj
+			}
+			return task.wait(ctx, timeout)
+		}),
+
+		// gather(tasks) returns every result in order. The first error
+		// cancels the remaining tasks and is returned.
+		"gather": contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			tasks, errObj := tasksArg("gather", args)
+			if errObj != nil {
+				return errObj
+			}
+			return gatherTasks(ctx, tasks)
+		}),
+
+		// race(tasks) returns the result (or error) of whichever task
+		// finishes first and cancels the others.
+		"race": contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			tasks, errObj := tasksArg("race", args)
+			if errObj != nil {
+				return errObj
+			}
+			if len(tasks) == 0 {
+				return newError("`race` of no tasks")
+			}
+			select {
+			case i := <-completions(tasks):
+				winner := tasks[i]
+				cancelAll(tasks)
+				return winner.Result
+			case <-ctx.Done():
+				cancelAll(tasks)
+				return contextError(ctx)
+			}
+		}),
+
+		// all_settled(tasks) waits for every task and never fails; each
+		// entry is {"status": "fulfilled", "value": v} or
+		// {"status": "rejected", "reason": message}.
+		"all_settled": contextBuiltin(func(ctx context.Context, args ...Object) Object {
+			tasks, errObj := tasksArg("all_settled", args)
+			if errObj != nil {
+				return errObj
+			}
+			done := completions(tasks)
+			for range tasks {
+				select {
+				case <-done:
+				case <-ctx.Done():
+					cancelAll(tasks)
+					return contextError(ctx)
+				}
+			}
+			results := make([]Object, len(tasks))
+			for i, task := range tasks {
+				entry := &Map{Pairs: make(map[Object]Object)}
+				if errObj, ok := task.Result.(*ErrorObj); ok {
+					mapSet(entry, &String{Value: "status"}, &String{Value: "rejected"})
+					mapSet(entry, &String{Value: "reason"}, &String{Value: errObj.Message})
+				} else {
+					mapSet(entry, &String{Value: "status"}, &String{Value: "fulfilled"})
+					mapSet(entry, &String{Value: "value"}, task.Result)
+				}
+				results[i] = entry
+			}
+			return &Array{Elements: results}
+		}),
+	}
+}
+
+// httpGet issues a GET request that is aborted when ctx is cancelled.
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,498 @@
+package eval
+
+import (
+	"context"
+	"strings"
+	"time"
+	_ "time/tzdata" // Timezone conversion must not depend on the host's zoneinfo
+
+	"flowa/pkg/parser"
+)
+
+// Time is an instant in time together with the zone it is shown in.
+type Time struct {
+	Value time.Time
+}
+
+func (t *Time) Type() string    { return "TIME" }
+func (t *Time) Inspect() string { return t.Value.Format(time.RFC3339Nano) }
+
+type Duration struct {
+	Value time.Duration
+}
+
+func (d *Duration) Type() string    { return "DURATION" }
+func (d *Duration) Inspect() string { return d.Value.String() }
+
+// namedLayouts are the layout names accepted in place of a pattern.
+var namedLayouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"rfc822":      time.RFC822,
+	"kitchen":     time.Kitchen,
+	"date":        time.DateOnly,
+	"datetime":    time.DateTime,
+	"time":        time.TimeOnly,
+}
+
+var strftimeDirectives = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'f': "000000", 'j': "002", 'b': "Jan", 'B': "January",
+	'a': "Mon", 'A': "Monday", 'z': "-0700", 'Z': "MST",
+	'F': "2006-01-02", 'T': "15:04:05", '%': "%",
+}
+
+// goLayout converts a layout argument into a Go reference layout. It
+// accepts layout names, strftime patterns ("%Y-%m-%d") and Go layouts.
+func goLayout(layout string) (string, bool) {
+	if named, ok := namedLayouts[strings.ToLower(layout)]; ok {
+		return named, true
+	}
+	if !strings.Contains(layout, "%") {
+		return layout, true
+	}
+	var out strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			out.WriteByte(layout[i])
+			continue
+		}
+		i++
+		if i >= len(layout) {
+			return "", false
+		}
+		directive, ok := strftimeDirectives[layout[i]]
+		if !ok {
+			return "", false
+		}
+		out.WriteString(directive)
+	}
+	return out.String(), true
+}
+
+func loadZone(name string) (*time.Location, Object) {
+	switch name {
+	case "local", "Local":
+		return time.Local, nil
+	case "UTC", "utc":
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, newError("unknown time zone: %s", name)
+	}
+	return loc, nil
+}
+
+func timeField(t *Time, name string) Object {
+	v := t.Value
+	switch name {
+	case "year":
+		return &Integer{Value: int64(v.Year())}
+	case "month":
+		return &Integer{Value: int64(v.Month())}
+	case "day":
+		return &Integer{Value: int64(v.Day())}
+	case "hour":
+		return &Integer{Value: int64(v.Hour())}
+	case "minute":
+		return &Integer{Value: int64(v.Minute())}
+	case "second":
+		return &Integer{Value: int64(v.Second())}
+	case "nanosecond":
+		return &Integer{Value: int64(v.Nanosecond())}
+	case "weekday":
+		return &String{Value: v.Weekday().String()}
+	case "yearday":
+		return &Integer{Value: int64(v.YearDay())}
+	case "unix":
+		return &Integer{Value: v.Unix()}
+	case "zone":
+		return &String{Value: v.Location().String()}
+	default:
+		return newError("TIME has no field %s", name)
+	}
+}
+
+func durationField(d *Duration, name string) Object {
+	switch name {
+	case "hours":
+		return &Integer{Value: int64(d.Value / time.Hour)}
+	case "minutes":
+		return &Integer{Value: int64(d.Value / time.Minute)}
+	case "seconds":
+		return &Integer{Value: int64(d.Value / time.Second)}
+	case "milliseconds":
+		return &Integer{Value: d.Value.Milliseconds()}
+	case "nanoseconds":
+		return &Integer{Value: int64(d.Value)}
+	default:
+		return newError("DURATION has no field %s", name)
+	}
+}
+
+// evalTimeInfixExpression implements arithmetic and comparisons on
+// times and durations.
+func evalTimeInfixExpression(operator string, left, right Object) Object {
+	switch l := left.(type) {
+	case *Time:
+		switch r := right.(type) {
+		case *Duration:
+			switch operator {
+			case "+":
+				return &Time{Value: l.Value.Add(r.Value)}
+			case "-":
+				return &Time{Value: l.Value.Add(-r.Value)}
+			}
+		case *Time:
+			if operator == "-" {
+				return &Duration{Value: l.Value.Sub(r.Value)}
+			}
+			if result, ok := compareResult(operator, l.Value.Compare(r.Value)); ok {
+				return result
+			}
+		}
+	case *Duration:
+		switch r := right.(type) {
+		case *Duration:
+			switch operator {
+			case "+":
+				return &Duration{Value: l.Value + r.Value}
+			case "-":
+				return &Duration{Value: l.Value - r.Value}
+			case "/":
+				if r.Value == 0 {
+					return newError("division by zero")
+				}
+				return &Integer{Value: int64(l.Value / r.Value)}
+			}
+			if result, ok := compareResult(operator, compareInt64(int64(l.Value), int64(r.Value))); ok {
+				return result
+			}
+		case *Integer:
+			switch operator {
+			case "*":
+				return &Duration{Value: l.Value * time.Duration(r.Value)}
+			case "/":
+				if r.Value == 0 {
+					return newError("division by zero")
+				}
+				return &Duration{Value: l.Value / time.Duration(r.Value)}
+			}
+		case *Time:
+			if operator == "+" {
+				return &Time{Value: r.Value.Add(l.Value)}
+			}
+		}
+	case *Integer:
+		if r, ok := right.(*Duration); ok && operator == "*" {
+			return &Duration{Value: time.Duration(l.Value) * r.Value}
+		}
+	}
+	if operator == "==" || operator == "!=" {
+		return nativeBoolToBooleanObject(objectsEqual(left, right) == (operator == "=="))
+	}
+	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+func isTimeValue(obj Object) bool {
+	switch obj.(type) {
+	case *Time, *Duration:
+		return true
+	}
+	return false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// durationArg accepts a DURATION, a duration string ("90s", "2d") or an
+// INTEGER number of milliseconds.
+func durationArg(name string, obj Object) (time.Duration, Object) {
+	switch obj := obj.(type) {
+	case *Duration:
+		return obj.Value, nil
+	case *Integer:
+		return time.Duration(obj.Value) * time.Millisecond, nil
+	case *String:
+		d, err := parser.ParseDuration(obj.Value)
+		if err != nil {
+			return 0, newError("invalid duration: %q", obj.Value)
+		}
+		return d, nil
+	default:
+		return 0, newError("argument to `%s` must be DURATION, got %s", name, obj.Type())
+	}
+}
+
+func timeArg(name string, obj Object) (*Time, Object) {
+	t, ok := obj.(*Time)
+	if !ok {
+		return nil, newError("argument to `time.%s` must be TIME, got %s", name, obj.Type())
+	}
+	return t, nil
+}
+
+func newTimeModule() *StructInstance {
+	fields := map[string]Object{}
+
+	// time.now(zone="local")
+	fields["now"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+			now := time.Now()
+			if len(args) == 1 {
+				zone, ok := args[0].(*String)
+				if !ok {
+					return newError("argument to `time.now` must be STRING, got %s", args[0].Type())
+				}
+				loc, errObj := loadZone(zone.Value)
+				if errObj != nil {
+					return errObj
+				}
+				now = now.In(loc)
+			}
+			return &Time{Value: now}
+		},
+	}
+
+	// time.parse(value, layout="rfc3339", zone="UTC")
+	fields["parse"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+			}
+			strs := make([]string, len(args))
+			for i, arg := range args {
+				s, ok := arg.(*String)
+				if !ok {
+					return newError("arguments to `time.parse` must be STRING, got %s", arg.Type())
+				}
+				strs[i] = s.Value
+			}
+			layout := time.RFC3339Nano
+			if len(strs) > 1 {
+				var ok bool
+				if layout, ok = goLayout(strs[1]); !ok {
+					return newError("invalid time layout: %q", strs[1])
+				}
+			}
+			loc := time.UTC
+			if len(strs) > 2 {
+				var errObj Object
+				if loc, errObj = loadZone(strs[2]); errObj != nil {
+					return errObj
+				}
+			}
+			t, err := time.ParseInLocation(layout, strs[0], loc)
+			if err != nil {
+				return newError("time.parse failed: %s", err)
+			}
+			return &Time{Value: t}
+		},
+	}
+
+	// time.format(t, layout="rfc3339")
+	fields["format"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			t, errObj := timeArg("format", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			layout := time.RFC3339
+			if len(args) == 2 {
+				s, ok := args[1].(*String)
+				if !ok {
+					return newError("layout for `time.format` must be STRING, got %s", args[1].Type())
+				}
+				if layout, ok = goLayout(s.Value); !ok {
+					return newError("invalid time layout: %q", s.Value)
+				}
+			}
+			return &String{Value: t.Value.Format(layout)}
+		},
+	}
+
+	// time.date(year, month, day, hour=0, minute=0, second=0, zone="UTC")
+	fields["date"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) < 3 || len(args) > 7 {
+				return newError("wrong number of arguments. got=%d, want=3 to 7", len(args))
+			}
+			parts := make([]int64, 6)
+			loc := time.UTC
+			for i, arg := range args {
+				if i == 6 {
+					zone, ok := arg.(*String)
+					if !ok {
+						return newError("zone for `time.date` must be STRING, got %s", arg.Type())
+					}
+					var errObj Object
+					if loc, errObj = loadZone(zone.Value); errObj != nil {
+						return errObj
+					}
+					continue
+				}
+				n, ok := arg.(*Integer)
+				if !ok {
+					return newError("arguments to `time.date` must be INTEGER, got %s", arg.Type())
+				}
+				parts[i] = n.Value
+			}
+			// Out-of-range fields are errors instead of rolling over into
+			// the next month, just as time.parse rejects "2024-02-30"
+			if parts[1] < 1 || parts[1] > 12 {
+				return newError("time.date month out of range: %d", parts[1])
+			}
+			days := time.Date(int(parts[0]), time.Month(parts[1])+1, 0, 0, 0, 0, 0, time.UTC).Day()
+			limits := []struct {
+				name     string
+				min, max int64
+			}{{"day", 1, int64(days)}, {"hour", 0, 23}, {"minute", 0, 59}, {"second", 0, 59}}
+			for i, limit := range limits {
+				if n := parts[i+2]; n < limit.min || n > limit.max {
+					return newError("time.date %s out of range: %d", limit.name, n)
+				}
+			}
+			return &Time{Value: time.Date(int(parts[0]), time.Month(parts[1]), int(parts[2]), int(parts[3]), int(parts[4]), int(parts[5]), 0, loc)}
+		},
+	}
+
+	// time.duration("1h30m")
+	fields["duration"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			d, errObj := durationArg("time.duration", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return &Duration{Value: d}
+		},
+	}
+
+	// time.in_zone(t, "Europe/Berlin")
+	fields["in_zone"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			t, errObj := timeArg("in_zone", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			zone, ok := args[1].(*String)
+			if !ok {
+				return newError("zone for `time.in_zone` must be STRING, got %s", args[1].Type())
+			}
+			loc, errObj := loadZone(zone.Value)
+			if errObj != nil {
+				return errObj
+			}
+			return &Time{Value: t.Value.In(loc)}
+		},
+	}
+
+	// time.unix(t) / time.unix_ms(t)
+	fields["unix"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			t, errObj := timeArg("unix", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return &Integer{Value: t.Value.Unix()}
+		},
+	}
+	fields["unix_ms"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			t, errObj := timeArg("unix_ms", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return &Integer{Value: t.Value.UnixMilli()}
+		},
+	}
+
+	// time.from_unix(seconds) -> UTC time
+	fields["from_unix"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			n, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument to `time.from_unix` must be INTEGER, got %s", args[0].Type())
+			}
+			return &Time{Value: time.Unix(n.Value, 0).UTC()}
+		},
+	}
+
+	// time.since(t) / time.until(t)
+	fields["since"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			t, errObj := timeArg("since", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return &Duration{Value: time.Since(t.Value)}
+		},
+	}
+	fields["until"] = &BuiltinFunction{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			t, errObj := timeArg("until", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return &Duration{Value: time.Until(t.Value)}
+		},
+	}
+
+	// time.sleep(500ms) or time.sleep(500) for milliseconds. A cancelled
+	// task wakes up early with an error.
+	fields["sleep"] = contextBuiltin(func(ctx context.Context, args ...Object) Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+		d, errObj := durationArg("time.sleep", args[0])
+		if errObj != nil {
+			return errObj
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return NULL
+		case <-ctx.Done():
+			return contextError(ctx)
+		}
+	})
+
+	return &StructInstance{Name: "Time", Fields: fields}
+}
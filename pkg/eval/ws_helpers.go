@@ -2,6 +2,7 @@ package eval
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/websocket"
 )
@@ -16,6 +17,17 @@ var upgrader = websocket.Upgrader{
 // WebSocketConnection wraps the gorilla websocket connection
 type WebSocketConnection struct {
 	Conn *websocket.Conn
+
+	// gorilla allows one concurrent writer; handlers and event bridges
+	// may send from different tasks
+	writeMu sync.Mutex
+}
+
+// WriteMessage sends one frame, serialised with any other writers.
+func (ws *WebSocketConnection) WriteMessage(kind int, data []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	return ws.Conn.WriteMessage(kind, data)
 }
 
 func (ws *WebSocketConnection) Type() string    { return "WEBSOCKET_CONNECTION" }
@@ -28,3 +40,12 @@ func upgradeToWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocketConne
 	}
 	return &WebSocketConnection{Conn: conn}, nil
 }
+
+// wsMessageObject converts an incoming frame: binary frames become
+// Bytes, text frames Strings.
+func wsMessageObject(kind int, message []byte) Object {
+	if kind == websocket.BinaryMessage {
+		return &Bytes{Value: message}
+	}
+	return &String{Value: string(message)}
+}
@@ -0,0 +1,505 @@
+// Package format prints Flowa source in its canonical layout: four-space
+// indentation, one space around binary operators and `|>`, no space
+// inside brackets, at most one blank line in a row, and literals that
+// don't fit on a line wrapped one element per line. Comments are kept.
+package format
+
+import (
+	"errors"
+	"strings"
+
+	"flowa/pkg/lexer"
+	"flowa/pkg/parser"
+	"flowa/pkg/token"
+)
+
+// MaxWidth is the line length beyond which bracketed literals are wrapped.
+const MaxWidth = 100
+
+const indentUnit = "    "
+
+// Source formats a Flowa program. Source that doesn't parse is returned
+// as an error listing the parser errors, and is never rewritten.
+func Source(src string) (string, error) {
+	before, err := canonical(src)
+	if err != nil {
+		return "", err
+	}
+
+	out := newPrinter(src).print()
+
+	// The layout must never change what the program means
+	after, err := canonical(out)
+	if err != nil || after != before {
+		return "", errors.New("format: internal error, formatting would change the program")
+	}
+	return out, nil
+}
+
+// canonical returns the parsed program's String form, which ignores
+// layout and comments.
+func canonical(src string) (string, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return "", errors.New(strings.Join(errs, "\n"))
+	}
+	return program.String(), nil
+}
+
+// line is one logical line of source: a statement, a block header or a
+// comment on its own.
+type line struct {
+	depth   int
+	items   []item // empty for a comment-only line
+	comment string // trailing (or whole-line) comment
+	blank   bool   // preceded by a blank line
+}
+
+// item is a token or a bracketed group.
+type item struct {
+	tok   token.Token
+	unary bool // a prefix -, ! or * (spread)
+	group *group
+}
+
+type groupKind int
+
+const (
+	parenGroup groupKind = iota // grouping or tuple
+	callGroup                   // f(...)
+	indexGroup                  // a[...], including slices
+	listGroup                   // [...]
+	braceGroup                  // {...}, map or set
+)
+
+// group is the contents of a pair of brackets, split at top-level commas.
+type group struct {
+	kind        groupKind
+	open, close string
+	elems       [][]item
+	leading     []string   // comments right after the opening bracket
+	comments    [][]string // comments following each element
+	tuple       bool       // (x,): the trailing comma makes it a tuple
+}
+
+type printer struct {
+	toks   []token.Token
+	pos    int
+	source []string // source lines, for the indentation of comments
+}
+
+func newPrinter(src string) *printer {
+	p := &printer{source: strings.Split(src, "\n")}
+	l := lexer.NewPreserving(src)
+	for {
+		tok := l.NextToken()
+		p.toks = append(p.toks, tok)
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+	return p
+}
+
+func (p *printer) print() string {
+	var out strings.Builder
+	for i, ln := range p.lines() {
+		if ln.blank && i > 0 {
+			out.WriteString("\n")
+		}
+		prefix := strings.Repeat(indentUnit, ln.depth)
+		out.WriteString(prefix)
+		if len(ln.items) > 0 {
+			text := render(ln.items, ln.depth, len(prefix), 0)
+			out.WriteString(text)
+			if ln.comment != "" {
+				out.WriteString("  ")
+			}
+		}
+		out.WriteString(ln.comment)
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// lines groups the token stream into logical lines.
+func (p *printer) lines() []line {
+	var lines []line
+	depth := 0
+	levels := []int{0} // source indentation width of each open block
+	blank := false
+	opener := false // the last code line ended with `:`
+
+	for p.pos < len(p.toks) {
+		tok := p.toks[p.pos]
+		switch tok.Type {
+		case token.EOF:
+			return lines
+		case token.INDENT:
+			depth++
+			p.pos++
+		case token.DEDENT:
+			depth--
+			p.pos++
+		case token.NEWLINE:
+			// A NEWLINE right after another one ends an empty line
+			if p.pos > 0 && p.toks[p.pos-1].Type == token.NEWLINE && len(lines) > 0 && !opener {
+				blank = true
+			}
+			p.pos++
+		case token.COMMENT:
+			// A comment on its own line sits at the block its indentation
+			// points to
+			width := indentWidth(p.sourceLine(tok.Line))
+			d := depth
+			if opener && width > levels[len(levels)-1] {
+				d++
+			} else {
+				for d > 0 && d < len(levels) && width < levels[d] {
+					d--
+				}
+			}
+			lines = append(lines, line{depth: d, comment: normalizeComment(tok.Literal), blank: blank})
+			blank = false
+			p.pos++
+		default:
+			width := indentWidth(p.sourceLine(tok.Line))
+			for len(levels) <= depth {
+				levels = append(levels, width)
+			}
+			levels = levels[:depth+1]
+			levels[depth] = width
+
+			ln := line{depth: depth, blank: blank}
+			ln.items, ln.comment = p.parseItems()
+			ln.comment = normalizeComment(ln.comment)
+			last := ln.items[len(ln.items)-1]
+			opener = last.group == nil && last.tok.Type == token.COLON
+			lines = append(lines, ln)
+			blank = false
+		}
+	}
+	return lines
+}
+
+func (p *printer) sourceLine(n int) string {
+	if n < 1 || n > len(p.source) {
+		return ""
+	}
+	return p.source[n-1]
+}
+
+func indentWidth(s string) int {
+	width := 0
+	for _, c := range s {
+		switch c {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// normalizeComment puts a space after the `#`, except in a `#!` line.
+func normalizeComment(c string) string {
+	if len(c) > 1 && c[1] != ' ' && c[1] != '!' && c[1] != '#' {
+		return "# " + c[1:]
+	}
+	return c
+}
+
+// parseItems reads the tokens of one logical line, up to its NEWLINE,
+// and returns them with any trailing comment.
+func (p *printer) parseItems() ([]item, string) {
+	var items []item
+	var comment string
+	for p.pos < len(p.toks) {
+		tok := p.toks[p.pos]
+		switch tok.Type {
+		case token.NEWLINE, token.EOF, token.INDENT, token.DEDENT:
+			return items, comment
+		case token.COMMENT:
+			comment = tok.Literal
+			p.pos++
+		default:
+			items = append(items, p.parseItem(items))
+		}
+	}
+	return items, comment
+}
+
+// parseItem reads a token, or a whole group when it is an opening bracket.
+func (p *printer) parseItem(prev []item) item {
+	tok := p.toks[p.pos]
+	p.pos++
+	after := endsOperand(prev)
+
+	switch tok.Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE:
+	case token.MINUS, token.BANG, token.ASTERISK:
+		return item{tok: tok, unary: !after}
+	default:
+		return item{tok: tok}
+	}
+
+	g := &group{open: tok.Literal}
+	switch {
+	case tok.Type == token.LPAREN && after:
+		g.kind, g.close = callGroup, ")"
+	case tok.Type == token.LPAREN:
+		g.kind, g.close = parenGroup, ")"
+	case tok.Type == token.LBRACKET && after:
+		g.kind, g.close = indexGroup, "]"
+	case tok.Type == token.LBRACKET:
+		g.kind, g.close = listGroup, "]"
+	default:
+		g.kind, g.close = braceGroup, "}"
+	}
+
+	var elem []item
+	var pending []string // comments inside the current element
+	flush := func() {
+		g.elems = append(g.elems, elem)
+		g.comments = append(g.comments, pending)
+		elem, pending = nil, nil
+	}
+	for p.pos < len(p.toks) {
+		t := p.toks[p.pos]
+		switch t.Type {
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			p.pos++
+			if len(elem) > 0 {
+				flush()
+			} else if g.kind == parenGroup && len(g.elems) == 1 {
+				g.tuple = true
+			}
+			return item{group: g}
+		case token.COMMA:
+			p.pos++
+			flush()
+		case token.COMMENT:
+			p.pos++
+			c := normalizeComment(t.Literal)
+			switch {
+			case len(elem) > 0:
+				pending = append(pending, c)
+			case len(g.elems) > 0:
+				last := len(g.comments) - 1
+				g.comments[last] = append(g.comments[last], c)
+			default:
+				g.leading = append(g.leading, c)
+			}
+		case token.EOF:
+			return item{group: g}
+		default:
+			elem = append(elem, p.parseItem(elem))
+		}
+	}
+	return item{group: g}
+}
+
+// endsOperand reports whether items end with an operand, making a
+// following `(` a call, `[` an index and `-` a binary minus.
+func endsOperand(items []item) bool {
+	if len(items) == 0 {
+		return false
+	}
+	last := items[len(items)-1]
+	if last.group != nil {
+		return true
+	}
+	// Any name after a dot is a member, keywords included: re.match(s)
+	if n := len(items); n > 1 && items[n-2].group == nil && items[n-2].tok.Type == token.DOT {
+		return true
+	}
+	switch last.tok.Type {
+	case token.IDENT, token.INT, token.FLOAT, token.STRING, token.DURATION,
+		token.TRUE, token.FALSE, token.NONE,
+		// server keywords double as names: get(url)
+		token.GET, token.POST, token.PUT, token.DELETE, token.WS, token.USE, token.ON, token.SERVICE:
+		return true
+	}
+	return false
+}
+
+// space returns the separator between two adjacent items inside a group
+// of the given kind (or at the top level of a line, with top = true).
+func space(prev, next item, kind groupKind, top bool) string {
+	if next.group != nil {
+		open := next.group.kind
+		if open == callGroup || open == indexGroup {
+			return ""
+		}
+		if prev.unary {
+			return ""
+		}
+		return " "
+	}
+	if prev.unary {
+		return ""
+	}
+	pt, nt := prev.tok.Type, next.tok.Type
+	switch {
+	case prev.group == nil && pt == token.DOT, nt == token.DOT:
+		return ""
+	case nt == token.COMMA:
+		return ""
+	case nt == token.COLON:
+		return ""
+	case prev.group == nil && pt == token.COLON && !top && kind == indexGroup:
+		return "" // slice
+	case (nt == token.ASSIGN || prev.group == nil && pt == token.ASSIGN) && !top && (kind == callGroup || kind == parenGroup):
+		return "" // keyword argument or pattern
+	}
+	return " "
+}
+
+// flat renders items on a single line.
+func flat(items []item, kind groupKind, top bool) string {
+	var b strings.Builder
+	for i, it := range items {
+		if i > 0 {
+			b.WriteString(space(items[i-1], it, kind, top))
+		}
+		if it.group == nil {
+			b.WriteString(it.tok.Literal)
+			continue
+		}
+		g := it.group
+		b.WriteString(g.open)
+		for j, elem := range g.elems {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(flat(elem, g.kind, false))
+		}
+		if g.tuple {
+			b.WriteString(",")
+		}
+		b.WriteString(g.close)
+	}
+	return b.String()
+}
+
+func hasComments(items []item) bool {
+	for _, it := range items {
+		if it.group == nil {
+			continue
+		}
+		g := it.group
+		if len(g.leading) > 0 {
+			return true
+		}
+		for i, elem := range g.elems {
+			if len(g.comments[i]) > 0 || hasComments(elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasLiteral(items []item) bool {
+	for _, it := range items {
+		if it.group == nil {
+			continue
+		}
+		if it.group.kind == listGroup || it.group.kind == braceGroup {
+			return true
+		}
+		for _, elem := range it.group.elems {
+			if hasLiteral(elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// render lays out items that start at column col of a line indented
+// depth levels; reserve is the width of what follows them on the line.
+// Items that don't fit, or that contain comments, get one group
+// wrapped: preferably the last literal, else the last group holding one.
+func render(items []item, depth, col, reserve int) string {
+	return renderIn(items, depth, col, reserve, 0, true)
+}
+
+// wrapTarget picks the group to wrap: the last one holding a comment,
+// else the last literal, else the last call or parenthesis containing a
+// literal. It returns -1 if wrapping wouldn't help.
+func wrapTarget(items []item, comments bool) int {
+	fallback := -1
+	for i := len(items) - 1; i >= 0; i-- {
+		g := items[i].group
+		switch {
+		case g == nil:
+		case comments:
+			if hasComments(items[i : i+1]) {
+				return i
+			}
+		case g.kind == listGroup || g.kind == braceGroup:
+			return i
+		case fallback < 0 && g.kind != indexGroup && hasLiteral(items[i:i+1]):
+			fallback = i
+		}
+	}
+	return fallback
+}
+
+func renderIn(items []item, depth, col, reserve int, kind groupKind, top bool) string {
+	text := flat(items, kind, top)
+	comments := hasComments(items)
+	if !comments && col+len(text)+reserve <= MaxWidth {
+		return text
+	}
+
+	wrap := wrapTarget(items, comments)
+	if wrap < 0 {
+		return text
+	}
+
+	var b strings.Builder
+	b.WriteString(flat(items[:wrap], kind, top))
+	if wrap > 0 {
+		b.WriteString(space(items[wrap-1], items[wrap], kind, top))
+	}
+
+	g := items[wrap].group
+	inner := strings.Repeat(indentUnit, depth+1)
+	b.WriteString(g.open)
+	b.WriteString("\n")
+	for _, c := range g.leading {
+		b.WriteString(inner + c + "\n")
+	}
+	for i, elem := range g.elems {
+		b.WriteString(inner)
+		sep := ""
+		if i < len(g.elems)-1 || g.tuple {
+			sep = ","
+		}
+		b.WriteString(renderIn(elem, depth+1, len(inner), len(sep), g.kind, false))
+		b.WriteString(sep)
+		for j, c := range g.comments[i] {
+			if j == 0 {
+				b.WriteString("  " + c)
+			} else {
+				b.WriteString("\n" + inner + c)
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(strings.Repeat(indentUnit, depth))
+	b.WriteString(g.close)
+
+	if rest := items[wrap+1:]; len(rest) > 0 {
+		b.WriteString(space(items[wrap], rest[0], kind, top))
+		closeCol := len(strings.Repeat(indentUnit, depth)) + len(g.close)
+		b.WriteString(renderIn(rest, depth, closeCol, reserve, kind, top))
+	}
+	return b.String()
+}
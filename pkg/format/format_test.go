@@ -0,0 +1,97 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"spacing",
+			"x=add( 1 ,-2 )|>double( )\nm = {\"a\":1,\"b\":[ -1 , *rest ]}\ns = items[1:3]\n",
+			"x = add(1, -2) |> double()\nm = {\"a\": 1, \"b\": [-1, *rest]}\ns = items[1:3]\n",
+		},
+		{
+			"indentation and blank lines",
+			"\n\ndef f(a):\n\n  if a :\n        return -a\n\n\n\n  return a\nprint(f(1))\n\n",
+			"def f(a):\n    if a:\n        return -a\n\n    return a\nprint(f(1))\n",
+		},
+		{
+			"comments",
+			"#header\ndef f():  # trailing\n    # inside\n    return 1\n# after\nf()\n",
+			"# header\ndef f():  # trailing\n    # inside\n    return 1\n# after\nf()\n",
+		},
+		{
+			"keyword patterns",
+			"match p:\n    case Point(x = 0, y = 0):\n        print( \"origin\" )\n",
+			"match p:\n    case Point(x=0, y=0):\n        print(\"origin\")\n",
+		},
+		{
+			"strings kept as written",
+			"re.match(\"\\d+\\n\",s)\n",
+			"re.match(\"\\d+\\n\", s)\n",
+		},
+		{
+			"long literal is wrapped",
+			"user = {\"name\": \"Alice Wonderland\", \"email\": \"alice@example.com\", \"roles\": [\"admin\", \"editor\"], \"active\": True}\n",
+			"user = {\n    \"name\": \"Alice Wonderland\",\n    \"email\": \"alice@example.com\",\n    \"roles\": [\"admin\", \"editor\"],\n    \"active\": True\n}\n",
+		},
+		{
+			"short literal is joined",
+			"nums = [\n    1,\n    2\n]\n",
+			"nums = [1, 2]\n",
+		},
+		{
+			"comments keep a literal wrapped",
+			"config = {\"host\": \"localhost\",  # where\n\"port\": 8080}\n",
+			"config = {\n    \"host\": \"localhost\",  # where\n    \"port\": 8080\n}\n",
+		},
+		{
+			"one-element tuple keeps its comma",
+			"t = ( 1 , )\nu = (1,2,)\nv = (1)\n",
+			"t = (1,)\nu = (1, 2)\nv = (1)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s:\nexpected=%q\ngot=     %q", tt.name, tt.expected, got)
+		}
+		again, err := Source(got)
+		if err != nil || again != got {
+			t.Errorf("%s: formatting is not idempotent, got %q", tt.name, again)
+		}
+	}
+}
+
+func TestSourceWrapsCallHoldingLiteral(t *testing.T) {
+	input := `return response.json({"message": "Hello from a rather long handler", "status": "ok", "count": 42}, 200)` + "\n"
+	got, err := Source("def h(req):\n    " + input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(got, "\n") {
+		if len(line) > MaxWidth {
+			t.Errorf("line longer than %d: %q", MaxWidth, line)
+		}
+	}
+	if !strings.Contains(got, "    return response.json(\n") {
+		t.Errorf("expected the call to be wrapped, got:\n%s", got)
+	}
+}
+
+func TestSourceRejectsInvalidCode(t *testing.T) {
+	if _, err := Source("x = (\n"); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
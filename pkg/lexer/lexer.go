@@ -15,6 +15,9 @@ type Lexer struct {
 
 	indentStack []int // Stack of indentation levels (column numbers)
 	tokenQueue  []token.Token
+	nesting     int // Open ( [ { brackets; newlines inside them are ignored
+
+	preserve bool // emit comments and raw string literals, see NewPreserving
 }
 
 func New(input string) *Lexer {
@@ -28,6 +31,16 @@ func New(input string) *Lexer {
 	return l
 }
 
+// NewPreserving returns a lexer for tools that reproduce source text,
+// such as the formatter: comments become COMMENT tokens and string
+// literals keep their quotes and escapes as written. The parser must be
+// given a lexer from New.
+func NewPreserving(input string) *Lexer {
+	l := New(input)
+	l.preserve = true
+	return l
+}
+
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
@@ -63,6 +76,13 @@ func (l *Lexer) NextToken() token.Token {
 
 	// Skip comments
 	if l.ch == '#' {
+		if l.preserve {
+			tok = token.Token{Type: token.COMMENT, Line: l.line, Column: l.column}
+			start := l.position
+			l.skipComment()
+			tok.Literal = strings.TrimRight(l.input[start:l.position], " \t\r")
+			return tok
+		}
 		l.skipComment()
 		// After skipping comment, we're at newline or EOF
 		// Continue to next token
@@ -71,12 +91,19 @@ func (l *Lexer) NextToken() token.Token {
 
 	switch l.ch {
 	case '\n':
+		if l.nesting > 0 {
+			// Inside brackets a line break is just whitespace
+			l.readChar()
+			l.line++
+			l.column = 1
+			return l.NextToken()
+		}
 		return l.handleNewline()
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column - 1}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch, l.line, l.column)
 		}
@@ -86,7 +113,7 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '>' {
 			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+			tok = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column - 1}
 		} else {
 			tok = newToken(token.MINUS, l.ch, l.line, l.column)
 		}
@@ -94,7 +121,7 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.NOT_EQ, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+			tok = token.Token{Type: token.NOT_EQ, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column - 1}
 		} else {
 			tok = newToken(token.BANG, l.ch, l.line, l.column)
 		}
@@ -106,7 +133,7 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.LTE, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+			tok = token.Token{Type: token.LTE, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column - 1}
 		} else {
 			tok = newToken(token.LT, l.ch, l.line, l.column)
 		}
@@ -114,7 +141,7 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.GTE, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+			tok = token.Token{Type: token.GTE, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column - 1}
 		} else {
 			tok = newToken(token.GT, l.ch, l.line, l.column)
 		}
@@ -122,33 +149,57 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '>' {
 			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.PIPE, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+			tok = token.Token{Type: token.PIPE, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column - 1}
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch, l.line, l.column)
+			tok = newToken(token.BIT_OR, l.ch, l.line, l.column)
 		}
+	case '&':
+		tok = newToken(token.BIT_AND, l.ch, l.line, l.column)
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch, l.line, l.column)
 	case ',':
 		tok = newToken(token.COMMA, l.ch, l.line, l.column)
 	case ':':
 		tok = newToken(token.COLON, l.ch, l.line, l.column)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch, l.line, l.column)
+		l.nesting++
 	case ')':
 		tok = newToken(token.RPAREN, l.ch, l.line, l.column)
+		if l.nesting > 0 {
+			l.nesting--
+		}
 	case '.':
 		tok = newToken(token.DOT, l.ch, l.line, l.column)
 	case '{':
 		tok = newToken(token.LBRACE, l.ch, l.line, l.column)
+		l.nesting++
 	case '}':
 		tok = newToken(token.RBRACE, l.ch, l.line, l.column)
+		if l.nesting > 0 {
+			l.nesting--
+		}
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch, l.line, l.column)
+		l.nesting++
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch, l.line, l.column)
+		if l.nesting > 0 {
+			l.nesting--
+		}
 	case '"':
+		start, col := l.position, l.column
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
+		if l.preserve {
+			end := l.position + 1
+			if end > len(l.input) {
+				end = len(l.input) // unterminated
+			}
+			tok.Literal = l.input[start:end]
+		}
 		tok.Line = l.line
-		tok.Column = l.column
+		tok.Column = col
 	case 0:
 		// Handle EOF: dedent remaining
 		if len(l.indentStack) > 1 {
@@ -161,20 +212,30 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok.Literal = ""
 			tok.Type = token.EOF
+			tok.Line = l.line
+			tok.Column = l.column
 		}
 	default:
+		col := l.column
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
 			// fmt.Printf("DEBUG: Ident=%q Type=%q\n", tok.Literal, tok.Type)
 			tok.Line = l.line
-			tok.Column = l.column
+			tok.Column = col
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			if strings.ContainsAny(tok.Literal, ".eE") {
+				tok.Type = token.FLOAT
+			}
+			if l.durationUnitLen() > 0 {
+				tok.Type = token.DURATION
+				tok.Literal += l.readDurationSuffix()
+			}
 			tok.Line = l.line
-			tok.Column = l.column
+			tok.Column = col
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch, l.line, l.column)
@@ -189,32 +250,42 @@ func (l *Lexer) handleNewline() token.Token {
 	// 1. Emit NEWLINE
 	tok := token.Token{Type: token.NEWLINE, Literal: "\n", Line: l.line, Column: l.column}
 
-	l.readChar() // Consume \n
-	l.line++
-	l.column = 0 // Reset column for new line (will be 1 after next readChar if we didn't do it here, but we are consuming manually)
-	// Actually readChar increments column. So if we just consumed \n, column is 1?
-	// Let's reset column to 0, so next char is 1.
-	l.column = 0
-
-	// 2. Check indentation
-	// Peek ahead to count spaces
+	// 2. Check the indentation of the next line that has code on it.
+	// Blank and comment-only lines don't affect indentation and are
+	// skipped, so a block may start with either.
 	indentLen := 0
-	for l.ch == ' ' || l.ch == '\t' {
-		if l.ch == '\t' {
-			indentLen += 4 // Assume tab is 4 spaces for now
-		} else {
-			indentLen++
+	for {
+		l.readChar() // Consume \n
+		l.line++
+		l.column = 1 // readChar moved onto the first char of the new line
+
+		indentLen = 0
+		for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' {
+			if l.ch == '\t' {
+				indentLen += 4 // Assume tab is 4 spaces for now
+			} else if l.ch == ' ' {
+				indentLen++
+			}
+			l.readChar()
 		}
-		l.readChar()
-	}
 
-	// If line is empty or comment, ignore indentation change
-	if l.ch == '\n' || l.ch == 0 {
-		// Just a blank line, maybe emit another newline or ignore?
-		// Usually we ignore blank lines in indentation logic.
-		// But we already emitted NEWLINE.
-		// Let's just return the NEWLINE we created.
-		return tok
+		if l.ch == 0 {
+			return tok
+		}
+		if l.preserve {
+			// The formatter sees every line, so it can keep blank lines
+			// and comments; the indentation is checked at the next code line
+			if l.ch == '\n' || l.ch == '#' {
+				return tok
+			}
+			break
+		}
+		if l.ch == '#' {
+			l.skipComment()
+		}
+		if l.ch != '\n' {
+			break
+		}
 	}
 
 	currentIndent := l.indentStack[len(l.indentStack)-1]
@@ -257,13 +328,28 @@ func (l *Lexer) readNumber() string {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	// Support float?
-	if l.ch == '.' {
+	if l.ch == '.' && isDigit(l.peekChar()) {
 		l.readChar()
 		for isDigit(l.ch) {
 			l.readChar()
 		}
 	}
+	// Exponent: 1e9, 2.5E-3
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if (next == '+' || next == '-') && l.readPosition+1 < len(l.input) {
+			next = l.input[l.readPosition+1]
+		}
+		if isDigit(next) {
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			for isDigit(l.ch) {
+				l.readChar()
+			}
+		}
+	}
 	return l.input[position:l.position]
 }
 
@@ -311,3 +397,37 @@ func (l *Lexer) skipComment() {
 		l.readChar()
 	}
 }
+
+var durationUnits = []string{"ns", "us", "ms", "s", "m", "h", "d"}
+
+// durationUnitLen returns the length of the duration unit starting at
+// the current character, or 0 if there is none. A unit must not run
+// into further letters, so `5min` is not a duration.
+func (l *Lexer) durationUnitLen() int {
+	rest := l.input[l.position:]
+	for _, unit := range durationUnits {
+		if strings.HasPrefix(rest, unit) && (len(rest) == len(unit) || !isLetter(rest[len(unit)])) {
+			return len(unit)
+		}
+	}
+	return 0
+}
+
+// readDurationSuffix reads the units (and any further number/unit
+// pairs, as in 1h30m) that follow the number of a duration literal.
+func (l *Lexer) readDurationSuffix() string {
+	position := l.position
+	for {
+		for n := l.durationUnitLen(); n > 0; n-- {
+			l.readChar()
+		}
+		if !isDigit(l.ch) {
+			break
+		}
+		l.readNumber()
+		if l.durationUnitLen() == 0 {
+			break
+		}
+	}
+	return l.input[position:l.position]
+}
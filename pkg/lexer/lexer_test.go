@@ -73,3 +73,203 @@ func TestPipeline(t *testing.T) {
 		}
 	}
 }
+
+func TestSetOperators(t *testing.T) {
+	input := `a | b & c ^ d |> f not in`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.BIT_OR, "|"},
+		{token.IDENT, "b"},
+		{token.BIT_AND, "&"},
+		{token.IDENT, "c"},
+		{token.BIT_XOR, "^"},
+		{token.IDENT, "d"},
+		{token.PIPE, "|>"},
+		{token.IDENT, "f"},
+		{token.NOT, "not"},
+		{token.IN, "in"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestDurationLiterals(t *testing.T) {
+	input := `500ms 2s 1h30m 1.5h 7d 5 min x2s`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.DURATION, "500ms"},
+		{token.DURATION, "2s"},
+		{token.DURATION, "1h30m"},
+		{token.DURATION, "1.5h"},
+		{token.DURATION, "7d"},
+		{token.INT, "5"},
+		{token.IDENT, "min"},
+		{token.IDENT, "x2s"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestFloatLiterals(t *testing.T) {
+	input := `1.5 0.25 2e10 6.02E-3 7 x.y 3.foo`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "0.25"},
+		{token.FLOAT, "2e10"},
+		{token.FLOAT, "6.02E-3"},
+		{token.INT, "7"},
+		{token.IDENT, "x"},
+		{token.DOT, "."},
+		{token.IDENT, "y"},
+		{token.INT, "3"},
+		{token.DOT, "."},
+		{token.IDENT, "foo"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNewlinesInsideBrackets(t *testing.T) {
+	input := "x = [1,\n    2]\ny = 3\n"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.NEWLINE, "\n"},
+		{token.IDENT, "y"},
+		{token.ASSIGN, "="},
+		{token.INT, "3"},
+		{token.NEWLINE, "\n"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestBlankAndCommentLinesKeepIndentation(t *testing.T) {
+	input := "if x:\n\n# note\n    y\n"
+	expected := []token.TokenType{token.IF, token.IDENT, token.COLON, token.NEWLINE, token.INDENT, token.IDENT, token.NEWLINE, token.DEDENT, token.EOF}
+
+	l := New(input)
+	for i, want := range expected {
+		if tok := l.NextToken(); tok.Type != want {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, want, tok.Type)
+		}
+	}
+}
+
+func TestPreservingLexer(t *testing.T) {
+	input := "x = \"a\\n\"  # note\n"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.STRING, `"a\n"`},
+		{token.COMMENT, "# note"},
+		{token.NEWLINE, "\n"},
+		{token.EOF, ""},
+	}
+
+	l := NewPreserving(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "name = \"hi\"\nif name == x:\n    total |> f(12)\n"
+	tests := []struct {
+		expectedLiteral string
+		line, column    int
+	}{
+		{"name", 1, 1},
+		{"=", 1, 6},
+		{"hi", 1, 8},
+		{"\n", 1, 12},
+		{"if", 2, 1},
+		{"name", 2, 4},
+		{"==", 2, 9},
+		{"x", 2, 12},
+		{":", 2, 13},
+		{"\n", 2, 14},
+		{"", 3, 5},
+		{"total", 3, 5},
+		{"|>", 3, 11},
+		{"f", 3, 14},
+		{"(", 3, 15},
+		{"12", 3, 16},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Line != tt.line || tok.Column != tt.column {
+			t.Fatalf("tests[%d] - %q at %d:%d, expected %d:%d", i, tok.Literal, tok.Line, tok.Column, tt.line, tt.column)
+		}
+	}
+}
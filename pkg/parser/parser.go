@@ -1,19 +1,26 @@
 package parser
 
 import (
+	"errors"
 	"flowa/pkg/ast"
 	"flowa/pkg/lexer"
 	"flowa/pkg/token"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	_ int = iota
 	LOWEST
 	PIPELINE    // |>
-	EQUALS      // ==
+	EQUALS      // == or in
 	LESSGREATER // > or <
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -26,6 +33,13 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LTE:      LESSGREATER,
+	token.GTE:      LESSGREATER,
+	token.IN:       EQUALS,
+	token.NOT:      EQUALS, // not in
+	token.BIT_OR:   BITOR,
+	token.BIT_XOR:  BITXOR,
+	token.BIT_AND:  BITAND,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
@@ -41,15 +55,27 @@ type (
 	infixParseFn  func(ast.Expression) ast.Expression
 )
 
+// Diagnostic is a parse error together with the position of the token
+// it was reported at.
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l           *lexer.Lexer
+	errors      []string
+	diagnostics []Diagnostic
 
 	curToken  token.Token
 	peekToken token.Token
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// yields has one entry per enclosing def, set once its body yields
+	yields []bool
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -61,9 +87,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.DURATION, p.parseDurationLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.NOT, p.parseNotExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.LBRACE, p.parseMapLiteral)     // Map literals
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral) // Array literals
@@ -71,9 +100,11 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.SPAWN, p.parseSpawnExpression)
 	p.registerPrefix(token.SPAWN, p.parseSpawnExpression)
 	p.registerPrefix(token.AWAIT, p.parseAwaitExpression)
+	p.registerPrefix(token.YIELD, p.parseYieldExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.NONE, p.parseNull)
+	p.registerPrefix(token.ASTERISK, p.parseSpreadExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -84,6 +115,13 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LTE, p.parseInfixExpression)
+	p.registerInfix(token.GTE, p.parseInfixExpression)
+	p.registerInfix(token.IN, p.parseInfixExpression)
+	p.registerInfix(token.NOT, p.parseNotInExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.PIPE, p.parsePipelineExpression)
 	p.registerInfix(token.DOT, p.parseMemberExpression)
@@ -158,6 +196,14 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseMiddlewareStatement()
 	case token.DEFER:
 		return p.parseDeferStatement()
+	case token.MATCH:
+		return p.parseMatchStatement()
+	case token.SELECT:
+		return p.parseSelectStatement()
+	case token.GLOBAL:
+		return p.parseGlobalStatement()
+	case token.NONLOCAL:
+		return p.parseNonlocalStatement()
 	case token.NEWLINE:
 		return nil
 	case token.IDENT:
@@ -165,6 +211,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		if p.peekTokenIs(token.ASSIGN) {
 			return p.parseAssignmentStatement()
 		}
+		if p.isScheduleStatement() {
+			return p.parseScheduleStatement()
+		}
+		if p.isAssertStatement() {
+			return p.parseAssertStatement()
+		}
 		fallthrough
 	default:
 		return p.parseExpressionStatement()
@@ -180,7 +232,7 @@ func (p *Parser) parseAssignmentStatement() *ast.AssignmentStatement {
 	}
 
 	p.nextToken() // move to value
-	stmt.Value = p.parseExpression(LOWEST)
+	stmt.Value = p.parseTupleOrExpression()
 
 	if p.peekTokenIs(token.NEWLINE) {
 		p.nextToken()
@@ -195,7 +247,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	p.nextToken()
 
 	if !p.curTokenIs(token.NEWLINE) && !p.curTokenIs(token.EOF) {
-		stmt.ReturnValue = p.parseExpression(LOWEST)
+		stmt.ReturnValue = p.parseTupleOrExpression()
 	}
 
 	if p.peekTokenIs(token.NEWLINE) {
@@ -224,7 +276,7 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 		return nil
 	}
 
-	stmt.Parameters = p.parseFunctionParameters()
+	stmt.Parameters, stmt.ParameterPatterns = p.parseFunctionParameters()
 
 	if !p.expectPeek(token.COLON) {
 		return nil
@@ -234,36 +286,57 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 		return nil
 	}
 
+	p.yields = append(p.yields, false)
 	stmt.Body = p.parseBlockStatement()
+	stmt.IsGenerator = p.yields[len(p.yields)-1]
+	p.yields = p.yields[:len(p.yields)-1]
 
 	return stmt
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.Pattern) {
 	identifiers := []*ast.Identifier{}
+	var patterns []ast.Pattern
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, nil
 	}
 
-	p.nextToken()
+	for {
+		p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+		switch p.curToken.Type {
+		case token.LBRACKET, token.LBRACE, token.LPAREN:
+			// Destructured parameter, e.g. def f([a, b]):
+			tok := p.curToken
+			pattern := p.parsePattern()
+			if pattern == nil || !p.checkTargetPattern(pattern) {
+				return nil, nil
+			}
+			if patterns == nil {
+				patterns = make([]ast.Pattern, len(identifiers), len(identifiers)+1)
+			}
+			identifiers = append(identifiers, &ast.Identifier{Token: tok, Value: pattern.String()})
+			patterns = append(patterns, pattern)
+		default:
+			identifiers = append(identifiers, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+			if patterns != nil {
+				patterns = append(patterns, nil)
+			}
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil
 	}
 
-	return identifiers
+	return identifiers, patterns
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
@@ -308,11 +381,18 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 func (p *Parser) parseForStatement() *ast.ForStatement {
 	stmt := &ast.ForStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
+	p.nextToken()
+	target := p.parseTargetPattern()
+	if target == nil {
+		return nil
+	}
+	if capture, ok := target.(*ast.CapturePattern); ok {
+		stmt.Iterator = capture.Name
+	} else if p.checkTargetPattern(target) {
+		stmt.Target = target
+	} else {
 		return nil
 	}
-
-	stmt.Iterator = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
 	if !p.expectPeek(token.IN) {
 		return nil
@@ -509,6 +589,39 @@ func (p *Parser) parseServiceStatement() *ast.ServiceStatement {
 	return stmt
 }
 
+// isScheduleStatement reports whether curToken starts an `every` or
+// `cron` block. Both words stay ordinary identifiers everywhere else.
+func (p *Parser) isScheduleStatement() bool {
+	switch p.curToken.Literal {
+	case "every":
+		return p.peekTokenIs(token.STRING) || p.peekTokenIs(token.DURATION)
+	case "cron":
+		return p.peekTokenIs(token.STRING)
+	}
+	return false
+}
+
+func (p *Parser) parseScheduleStatement() *ast.ScheduleStatement {
+	stmt := &ast.ScheduleStatement{Token: p.curToken, Kind: p.curToken.Literal}
+
+	p.nextToken()
+	stmt.Spec = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	if !p.expectPeek(token.NEWLINE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseRouteStatement() *ast.RouteStatement {
 	stmt := &ast.RouteStatement{Token: p.curToken, Method: p.curToken.Literal}
 
@@ -551,7 +664,7 @@ func (p *Parser) parseMiddlewareStatement() *ast.MiddlewareStatement {
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
 	// Try to parse as possible assignment
@@ -560,7 +673,12 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 		return nil // We'll handle this in parseStatement
 	}
 
-	stmt.Expression = p.parseExpression(LOWEST)
+	stmt.Expression = p.parseTupleOrExpression()
+
+	// `a, b = ...`, `[x, *rest] = ...` and `{"k": v} = ...`
+	if p.peekTokenIs(token.ASSIGN) {
+		return p.parseDestructureStatement(stmt.Token, stmt.Expression)
+	}
 
 	if p.peekTokenIs(token.NEWLINE) {
 		p.nextToken()
@@ -569,6 +687,133 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	return stmt
 }
 
+func (p *Parser) parseDestructureStatement(tok token.Token, target ast.Expression) ast.Statement {
+	stmt := &ast.DestructureStatement{Token: tok}
+	stmt.Target = p.expressionToPattern(target)
+	if stmt.Target == nil {
+		return nil
+	}
+
+	p.nextToken() // '='
+	p.nextToken()
+	stmt.Value = p.parseTupleOrExpression()
+
+	if p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseTupleOrExpression parses an expression, collecting a bare
+// comma separated list such as `1, 2` into a tuple.
+func (p *Parser) parseTupleOrExpression() ast.Expression {
+	tok := p.curToken
+	exp := p.parseExpression(LOWEST)
+	if !p.peekTokenIs(token.COMMA) {
+		return exp
+	}
+
+	tuple := &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{exp}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		// Allow a trailing comma: `x = 1,`
+		if p.peekTokenIs(token.NEWLINE) || p.peekTokenIs(token.EOF) || p.peekTokenIs(token.ASSIGN) {
+			break
+		}
+		p.nextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+	}
+	return tuple
+}
+
+// expressionToPattern converts the left-hand side of a destructuring
+// assignment into the pattern it describes.
+func (p *Parser) expressionToPattern(exp ast.Expression) ast.Pattern {
+	switch e := exp.(type) {
+	case nil:
+		return nil
+	case *ast.Identifier:
+		if e.Value == "_" {
+			return &ast.WildcardPattern{Token: e.Token}
+		}
+		return &ast.CapturePattern{Token: e.Token, Name: e}
+	case *ast.SpreadExpression:
+		if ident, ok := e.Value.(*ast.Identifier); ok {
+			star := &ast.StarPattern{Token: e.Token}
+			if ident.Value != "_" {
+				star.Name = ident
+			}
+			return star
+		}
+	case *ast.ArrayLiteral:
+		pattern := &ast.ArrayPattern{Token: e.Token}
+		for _, el := range e.Elements {
+			sub := p.expressionToPattern(el)
+			if sub == nil {
+				return nil
+			}
+			pattern.Elements = append(pattern.Elements, sub)
+		}
+		return pattern
+	case *ast.TupleLiteral:
+		pattern := &ast.TuplePattern{Token: e.Token}
+		for _, el := range e.Elements {
+			sub := p.expressionToPattern(el)
+			if sub == nil {
+				return nil
+			}
+			pattern.Elements = append(pattern.Elements, sub)
+		}
+		return pattern
+	case *ast.MapLiteral:
+		pattern := &ast.MapPattern{Token: e.Token}
+		for _, pair := range e.Pairs {
+			sub := p.expressionToPattern(pair.Value)
+			if sub == nil {
+				return nil
+			}
+			pattern.Keys = append(pattern.Keys, pair.Key)
+			pattern.Values = append(pattern.Values, sub)
+		}
+		return pattern
+	}
+	p.errorAt(p.curToken, fmt.Sprintf("cannot assign to %s", exp.String()))
+	return nil
+}
+
+// checkTargetPattern reports whether pattern only binds names, as
+// required for assignment, `for` and parameter targets.
+func (p *Parser) checkTargetPattern(pattern ast.Pattern) bool {
+	switch pat := pattern.(type) {
+	case *ast.CapturePattern, *ast.WildcardPattern, *ast.StarPattern:
+		return true
+	case *ast.ArrayPattern:
+		for _, el := range pat.Elements {
+			if !p.checkTargetPattern(el) {
+				return false
+			}
+		}
+		return true
+	case *ast.TuplePattern:
+		for _, el := range pat.Elements {
+			if !p.checkTargetPattern(el) {
+				return false
+			}
+		}
+		return true
+	case *ast.MapPattern:
+		for _, v := range pat.Values {
+			if !p.checkTargetPattern(v) {
+				return false
+			}
+		}
+		return true
+	}
+	p.errorAt(p.curToken, fmt.Sprintf("cannot assign to %s", pattern.String()))
+	return false
+}
+
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
@@ -599,9 +844,16 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// Too big for an int64; evaluates to a BigInt
+		if n, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			lit.Big = n
+			return lit
+		}
+	}
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, msg)
 		return nil
 	}
 
@@ -609,6 +861,63 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		p.errorAt(p.curToken, msg)
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseDurationLiteral() ast.Expression {
+	lit := &ast.DurationLiteral{Token: p.curToken}
+
+	value, err := ParseDuration(p.curToken.Literal)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as duration", p.curToken.Literal)
+		p.errorAt(p.curToken, msg)
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+// ParseDuration parses durations like time.ParseDuration and also
+// accepts a `d` (24h) unit, as in "7d" or "1d12h".
+func ParseDuration(s string) (time.Duration, error) {
+	days := time.Duration(0)
+	if i := strings.IndexByte(s, 'd'); i >= 0 {
+		n, err := strconv.ParseFloat(strings.TrimPrefix(s[:i], "-"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("time: invalid duration %q", s)
+		}
+		days = time.Duration(n * float64(24*time.Hour))
+		rest := s[i+1:]
+		if strings.HasPrefix(s, "-") {
+			days = -days
+			if rest != "" {
+				rest = "-" + rest
+			}
+		}
+		if rest == "" {
+			return days, nil
+		}
+		s = rest
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return days + d, nil
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -634,6 +943,37 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return expression
 }
 
+// parseNotExpression parses `not x`. Unlike `!`, it binds looser than
+// comparisons, so `not a == b` negates the whole comparison.
+func (p *Parser) parseNotExpression() ast.Expression {
+	expression := &ast.PrefixExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+	}
+
+	p.nextToken()
+
+	expression.Right = p.parseExpression(PIPELINE)
+
+	return expression
+}
+
+func (p *Parser) parseNotInExpression(left ast.Expression) ast.Expression {
+	expression := &ast.InfixExpression{
+		Token:    p.curToken,
+		Operator: "not in",
+		Left:     left,
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	expression.Right = p.parseExpression(EQUALS)
+
+	return expression
+}
+
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	expression := &ast.InfixExpression{
 		Token:    p.curToken,
@@ -649,10 +989,31 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	tok := p.curToken
+
+	// () is the empty tuple
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{}}
+	}
+
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
+	if p.peekTokenIs(token.COMMA) {
+		tuple := &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{exp}}
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			if p.peekTokenIs(token.RPAREN) {
+				break
+			}
+			p.nextToken()
+			tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+		}
+		exp = tuple
+	}
+
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
@@ -660,6 +1021,13 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseSpreadExpression() ast.Expression {
+	expression := &ast.SpreadExpression{Token: p.curToken}
+	p.nextToken()
+	expression.Value = p.parseExpression(PREFIX)
+	return expression
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseCallArguments()
@@ -703,6 +1071,464 @@ func (p *Parser) parseDeferStatement() *ast.DeferStatement {
 	return stmt
 }
 
+// isAssertStatement reports whether curToken starts `assert cond`.
+// `assert.equal(...)` and a bare `assert` still refer to the module.
+func (p *Parser) isAssertStatement() bool {
+	if p.curToken.Literal != "assert" {
+		return false
+	}
+	return !p.peekTokenIs(token.DOT) && !p.peekTokenIs(token.NEWLINE) && !p.peekTokenIs(token.EOF)
+}
+
+func (p *Parser) parseAssertStatement() *ast.AssertStatement {
+	stmt := &ast.AssertStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Message = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseMatchStatement() *ast.MatchStatement {
+	stmt := &ast.MatchStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	if !p.expectPeek(token.NEWLINE) {
+		return nil
+	}
+	if !p.expectPeek(token.INDENT) {
+		return nil
+	}
+	p.nextToken() // consume INDENT
+
+	for !p.curTokenIs(token.DEDENT) && !p.curTokenIs(token.EOF) {
+		if p.curTokenIs(token.NEWLINE) {
+			p.nextToken()
+			continue
+		}
+		if !p.curTokenIs(token.CASE) {
+			p.errorAt(p.curToken, fmt.Sprintf("expected case in match block, got %s instead", p.curToken.Type))
+			return nil
+		}
+		c := p.parseMatchCase()
+		if c == nil {
+			return nil
+		}
+		stmt.Cases = append(stmt.Cases, c)
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseMatchCase() *ast.MatchCase {
+	c := &ast.MatchCase{Token: p.curToken}
+
+	p.nextToken()
+	c.Pattern = p.parsePattern()
+	if c.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		c.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	if !p.expectPeek(token.NEWLINE) {
+		return nil
+	}
+
+	c.Body = p.parseBlockStatement()
+	if c.Body == nil {
+		return nil
+	}
+
+	return c
+}
+
+func (p *Parser) parseSelectStatement() *ast.SelectStatement {
+	stmt := &ast.SelectStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	if !p.expectPeek(token.NEWLINE) {
+		return nil
+	}
+	if !p.expectPeek(token.INDENT) {
+		return nil
+	}
+	p.nextToken() // consume INDENT
+
+	for !p.curTokenIs(token.DEDENT) && !p.curTokenIs(token.EOF) {
+		if p.curTokenIs(token.NEWLINE) {
+			p.nextToken()
+			continue
+		}
+		if !p.curTokenIs(token.CASE) {
+			p.errorAt(p.curToken, fmt.Sprintf("expected case in select block, got %s instead", p.curToken.Type))
+			return nil
+		}
+		c := p.parseSelectCase()
+		if c == nil {
+			return nil
+		}
+		stmt.Cases = append(stmt.Cases, c)
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseSelectCase parses one select arm. `timeout` is only special
+// right after `case`, so it stays usable as a variable name.
+func (p *Parser) parseSelectCase() *ast.SelectCase {
+	c := &ast.SelectCase{Token: p.curToken}
+	p.nextToken()
+
+	switch {
+	case p.curTokenIs(token.IDENT) && p.curToken.Literal == "_" && p.peekTokenIs(token.COLON):
+		c.Kind = ast.SelectDefault
+	case p.curTokenIs(token.IDENT) && p.curToken.Literal == "timeout" &&
+		!p.peekTokenIs(token.DOT) && !p.peekTokenIs(token.ASSIGN) && !p.peekTokenIs(token.COLON):
+		c.Kind = ast.SelectTimeout
+		p.nextToken()
+		c.Timeout = p.parseExpression(LOWEST)
+	default:
+		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN) {
+			c.Target = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			p.nextToken()
+			p.nextToken()
+		}
+		expr := p.parseExpression(LOWEST)
+		call, ok := expr.(*ast.CallExpression)
+		var member *ast.MemberExpression
+		if ok {
+			member, ok = call.Function.(*ast.MemberExpression)
+		}
+		switch {
+		case ok && member.Property.Value == "recv" && len(call.Arguments) == 0:
+			c.Kind = ast.SelectRecv
+			c.Channel = member.Object
+		case ok && member.Property.Value == "send" && len(call.Arguments) == 1 && c.Target == nil:
+			c.Kind = ast.SelectSend
+			c.Channel = member.Object
+			c.Value = call.Arguments[0]
+		default:
+			p.errorAt(c.Token, fmt.Sprintf("select case must be ch.recv(), ch.send(value), timeout or _, got %s", c.Token.Literal))
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	if !p.expectPeek(token.NEWLINE) {
+		return nil
+	}
+
+	c.Body = p.parseBlockStatement()
+	if c.Body == nil {
+		return nil
+	}
+
+	return c
+}
+
+// parsePattern parses a single `case` pattern starting at curToken.
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		if p.peekTokenIs(token.LPAREN) {
+			return p.parseTypePattern()
+		}
+		if p.peekTokenIs(token.DOT) {
+			// Dotted names are constants, not captures
+			var value ast.Expression = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			tok := p.curToken
+			for p.peekTokenIs(token.DOT) {
+				p.nextToken()
+				value = p.parseMemberExpression(value)
+			}
+			return &ast.ValuePattern{Token: tok, Value: value}
+		}
+		return &ast.CapturePattern{
+			Token: p.curToken,
+			Name:  &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		}
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE, token.NONE, token.MINUS:
+		tok := p.curToken
+		value := p.parseExpression(PREFIX)
+		if value == nil {
+			return nil
+		}
+		return &ast.ValuePattern{Token: tok, Value: value}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseMapPattern()
+	case token.LPAREN:
+		tok := p.curToken
+		if p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+			return &ast.TuplePattern{Token: tok}
+		}
+		p.nextToken()
+		pattern := p.parseSequenceElementPattern()
+		if pattern == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.COMMA) {
+			tuple := &ast.TuplePattern{Token: tok, Elements: []ast.Pattern{pattern}}
+			for p.peekTokenIs(token.COMMA) {
+				p.nextToken()
+				if p.peekTokenIs(token.RPAREN) {
+					break
+				}
+				p.nextToken()
+				element := p.parseSequenceElementPattern()
+				if element == nil {
+					return nil
+				}
+				tuple.Elements = append(tuple.Elements, element)
+			}
+			pattern = tuple
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return pattern
+	default:
+		p.errorAt(p.curToken, fmt.Sprintf("invalid pattern starting with %s", p.curToken.Type))
+		return nil
+	}
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	if p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		return pattern
+	}
+
+	for {
+		p.nextToken()
+		element := p.parseSequenceElementPattern()
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return pattern
+}
+
+// parseSequenceElementPattern parses an element of an array or tuple
+// pattern, which may be a starred capture.
+func (p *Parser) parseSequenceElementPattern() ast.Pattern {
+	if p.curTokenIs(token.ASTERISK) {
+		return p.parseStarPattern()
+	}
+	return p.parsePattern()
+}
+
+// parseTargetPattern parses a `for` target, collecting `k, v` into a
+// tuple pattern.
+func (p *Parser) parseTargetPattern() ast.Pattern {
+	tok := p.curToken
+	first := p.parseSequenceElementPattern()
+	if first == nil || !p.peekTokenIs(token.COMMA) {
+		return first
+	}
+
+	tuple := &ast.TuplePattern{Token: tok, Elements: []ast.Pattern{first}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		element := p.parseSequenceElementPattern()
+		if element == nil {
+			return nil
+		}
+		tuple.Elements = append(tuple.Elements, element)
+	}
+	return tuple
+}
+
+func (p *Parser) parseStarPattern() ast.Pattern {
+	star := &ast.StarPattern{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	if p.curToken.Literal != "_" {
+		star.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	return star
+}
+
+func (p *Parser) parseMapPattern() ast.Pattern {
+	pattern := &ast.MapPattern{Token: p.curToken}
+
+	if p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		return pattern
+	}
+
+	for {
+		p.nextToken()
+		key := p.parseExpression(PREFIX)
+		if key == nil {
+			return nil
+		}
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parsePattern()
+		if value == nil {
+			return nil
+		}
+
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return pattern
+}
+
+func (p *Parser) parseTypePattern() ast.Pattern {
+	pattern := &ast.TypePattern{
+		Token: p.curToken,
+		Name:  &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+	}
+
+	p.nextToken() // consume the type name, curToken is '('
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return pattern
+	}
+
+	for {
+		p.nextToken()
+		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN) {
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			p.nextToken()
+			p.nextToken()
+			sub := p.parsePattern()
+			if sub == nil {
+				return nil
+			}
+			pattern.Keywords = append(pattern.Keywords, &ast.KeywordPattern{Name: name, Pattern: sub})
+		} else {
+			if len(pattern.Keywords) > 0 {
+				p.errorAt(p.curToken, "positional patterns must come before keyword patterns")
+				return nil
+			}
+			sub := p.parsePattern()
+			if sub == nil {
+				return nil
+			}
+			pattern.Args = append(pattern.Args, sub)
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return pattern
+}
+
+func (p *Parser) parseGlobalStatement() *ast.GlobalStatement {
+	stmt := &ast.GlobalStatement{Token: p.curToken}
+	stmt.Names = p.parseDeclaredNames()
+	if stmt.Names == nil {
+		return nil
+	}
+	return stmt
+}
+
+func (p *Parser) parseNonlocalStatement() *ast.NonlocalStatement {
+	stmt := &ast.NonlocalStatement{Token: p.curToken}
+	stmt.Names = p.parseDeclaredNames()
+	if stmt.Names == nil {
+		return nil
+	}
+	return stmt
+}
+
+// parseDeclaredNames parses the comma separated names following
+// `global` or `nonlocal`.
+func (p *Parser) parseDeclaredNames() []*ast.Identifier {
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	names := []*ast.Identifier{{Token: p.curToken, Value: p.curToken.Literal}}
+
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		names = append(names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	if p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return names
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
@@ -773,15 +1599,96 @@ func (p *Parser) parseAwaitExpression() ast.Expression {
 	expression := &ast.AwaitExpression{Token: p.curToken}
 	p.nextToken()
 	expression.Value = p.parseExpression(LOWEST)
+	// `timeout` is contextual, so it remains usable as a name elsewhere
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "timeout" {
+		p.nextToken()
+		p.nextToken()
+		expression.Timeout = p.parseExpression(LOWEST)
+	}
+	return expression
+}
+
+func (p *Parser) parseYieldExpression() ast.Expression {
+	expression := &ast.YieldExpression{Token: p.curToken}
+	if len(p.yields) == 0 {
+		p.errorAt(p.curToken, "'yield' outside function")
+	} else {
+		p.yields[len(p.yields)-1] = true
+	}
+	switch p.peekToken.Type {
+	case token.NEWLINE, token.EOF, token.RPAREN, token.RBRACKET, token.RBRACE, token.COMMA:
+		return expression
+	}
+	p.nextToken()
+	expression.Value = p.parseTupleOrExpression()
 	return expression
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
-	array := &ast.ArrayLiteral{Token: p.curToken}
-	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array := &ast.ArrayLiteral{Token: p.curToken, Elements: []ast.Expression{}}
+
+	if p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		return array
+	}
+
+	p.nextToken()
+	first := p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.FOR) {
+		clauses := p.parseComprehensionClauses(token.RBRACKET)
+		if clauses == nil {
+			return nil
+		}
+		return &ast.ListComprehension{Token: array.Token, Element: first, Clauses: clauses}
+	}
+	array.Elements = append(array.Elements, first)
+
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		array.Elements = append(array.Elements, p.parseExpression(LOWEST))
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
 	return array
 }
 
+// parseComprehensionClauses parses the `for ... in ... if ...` clauses
+// of a comprehension up to and including the closing end token.
+func (p *Parser) parseComprehensionClauses(end token.TokenType) []*ast.ComprehensionClause {
+	var clauses []*ast.ComprehensionClause
+
+	for p.peekTokenIs(token.FOR) {
+		p.nextToken()
+		clause := &ast.ComprehensionClause{Token: p.curToken}
+
+		p.nextToken()
+		clause.Target = p.parseTargetPattern()
+		if clause.Target == nil || !p.checkTargetPattern(clause.Target) {
+			return nil
+		}
+		if !p.expectPeek(token.IN) {
+			return nil
+		}
+		p.nextToken()
+		clause.Iterable = p.parseExpression(LOWEST)
+
+		for p.peekTokenIs(token.IF) {
+			p.nextToken()
+			p.nextToken()
+			clause.Conditions = append(clause.Conditions, p.parseExpression(LOWEST))
+		}
+		clauses = append(clauses, clause)
+	}
+
+	if !p.expectPeek(end) {
+		return nil
+	}
+	return clauses
+}
+
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
@@ -821,6 +1728,17 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 			return nil
 		}
 
+		if len(mapLiteral.Pairs) == 0 && p.peekTokenIs(token.FOR) {
+			clauses := p.parseComprehensionClauses(token.RBRACE)
+			if clauses == nil {
+				return nil
+			}
+			return &ast.SetComprehension{Token: mapLiteral.Token, Element: key, Clauses: clauses}
+		}
+		if len(mapLiteral.Pairs) == 0 && (p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE)) {
+			return p.parseSetLiteral(mapLiteral.Token, key)
+		}
+
 		if !p.expectPeek(token.COLON) {
 			return nil
 		}
@@ -831,6 +1749,14 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 			return nil
 		}
 
+		if len(mapLiteral.Pairs) == 0 && p.peekTokenIs(token.FOR) {
+			clauses := p.parseComprehensionClauses(token.RBRACE)
+			if clauses == nil {
+				return nil
+			}
+			return &ast.MapComprehension{Token: mapLiteral.Token, Key: key, Value: value, Clauses: clauses}
+		}
+
 		mapLiteral.Pairs = append(mapLiteral.Pairs, ast.MapPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.COMMA) {
@@ -846,6 +1772,24 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 	return mapLiteral
 }
 
+// parseSetLiteral finishes a `{a, b}` literal whose first element has
+// already been parsed.
+func (p *Parser) parseSetLiteral(tok token.Token, first ast.Expression) ast.Expression {
+	set := &ast.SetLiteral{Token: tok, Elements: []ast.Expression{first}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if p.peekTokenIs(token.RBRACE) {
+			break
+		}
+		p.nextToken()
+		set.Elements = append(set.Elements, p.parseExpression(LOWEST))
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return set
+}
+
 func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
 	expression := &ast.MemberExpression{Token: p.curToken, Object: object}
 
@@ -859,10 +1803,35 @@ func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	expression := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 
-	p.nextToken()
-	expression.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		return p.parseSliceExpression(tok, left, index)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+}
+
+// parseSliceExpression parses the rest of `left[start:end]` once the
+// colon is the peek token.
+func (p *Parser) parseSliceExpression(tok token.Token, left, start ast.Expression) ast.Expression {
+	expression := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+	p.nextToken() // ':'
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		expression.End = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -892,15 +1861,25 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Diagnostics returns the errors from Errors with their positions.
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+func (p *Parser) errorAt(tok token.Token, msg string) {
+	p.errors = append(p.errors, msg)
+	p.diagnostics = append(p.diagnostics, Diagnostic{Line: tok.Line, Column: tok.Column, Message: msg})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.peekToken, msg)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.curToken, msg)
 }
 
 func (p *Parser) peekPrecedence() int {
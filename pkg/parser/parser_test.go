@@ -3,6 +3,7 @@ package parser
 import (
 	"flowa/pkg/ast"
 	"flowa/pkg/lexer"
+	"strings"
 	"testing"
 )
 
@@ -132,6 +133,261 @@ func TestPipelineExpression(t *testing.T) {
 	}
 }
 
+func TestScopeDeclarations(t *testing.T) {
+	input := `
+def f():
+    global a, b
+    nonlocal c
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	fn, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.FunctionStatement. got=%T",
+			program.Statements[0])
+	}
+	if len(fn.Body.Statements) != 2 {
+		t.Fatalf("function body has wrong statements count. got=%d",
+			len(fn.Body.Statements))
+	}
+
+	global, ok := fn.Body.Statements[0].(*ast.GlobalStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.GlobalStatement. got=%T", fn.Body.Statements[0])
+	}
+	if global.String() != "global a, b" {
+		t.Errorf("global.String() wrong. got=%q", global.String())
+	}
+
+	nonlocal, ok := fn.Body.Statements[1].(*ast.NonlocalStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.NonlocalStatement. got=%T", fn.Body.Statements[1])
+	}
+	if len(nonlocal.Names) != 1 || nonlocal.Names[0].Value != "c" {
+		t.Errorf("nonlocal names wrong. got=%v", nonlocal.Names)
+	}
+}
+
+func TestMatchStatement(t *testing.T) {
+	input := `
+match value:
+    case [a, *rest]:
+        print(a)
+    case {"name": n} if n != "":
+        print(n)
+    case Point(x, y=0):
+        print(x)
+    case _:
+        print("other")
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.MatchStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.MatchStatement. got=%T",
+			program.Statements[0])
+	}
+
+	expected := []string{`[a, *rest]`, `{"name": n}`, `Point(x, y=0)`, `_`}
+	if len(stmt.Cases) != len(expected) {
+		t.Fatalf("match has wrong number of cases. got=%d", len(stmt.Cases))
+	}
+	for i, want := range expected {
+		if got := stmt.Cases[i].Pattern.String(); got != want {
+			t.Errorf("cases[%d] pattern wrong. expected=%q, got=%q", i, want, got)
+		}
+	}
+	if stmt.Cases[1].Guard == nil {
+		t.Errorf("cases[1] guard missing")
+	}
+}
+
+func TestDestructuringTargets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a, b = b, a", "(a, b) = (b, a)"},
+		{"[x, *rest] = arr", "[x, *rest] = arr"},
+		{`{"name": n} = user`, `{"name": n} = user`},
+		{"(a, _) = pair", "(a, _) = pair"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.DestructureStatement)
+		if !ok {
+			t.Fatalf("%q: stmt is not ast.DestructureStatement. got=%T", tt.input, program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestForDestructuring(t *testing.T) {
+	input := `
+for k, v in pairs:
+    print(k)
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ForStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Iterator != nil {
+		t.Fatalf("stmt.Iterator should be nil for destructuring targets")
+	}
+	if stmt.Target.String() != "(k, v)" {
+		t.Errorf("stmt.Target wrong. got=%q", stmt.Target.String())
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := lexer.New("f(x), y = 1, 2")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected parser error for invalid assignment target")
+	}
+}
+
+func TestBigIntegerLiteral(t *testing.T) {
+	l := lexer.New("123456789012345678901234567890")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IntegerLiteral. got=%T", stmt.Expression)
+	}
+	if lit.Big == nil || lit.Big.String() != "123456789012345678901234567890" {
+		t.Errorf("expected a big literal, got Value=%d Big=%v", lit.Value, lit.Big)
+	}
+}
+
+func TestYieldMarksGenerator(t *testing.T) {
+	input := `
+def gen():
+    def helper():
+        return 1
+    yield helper()
+    yield
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	fn, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.FunctionStatement. got=%T",
+			program.Statements[0])
+	}
+	if !fn.IsGenerator {
+		t.Errorf("gen should be a generator")
+	}
+	helper := fn.Body.Statements[0].(*ast.FunctionStatement)
+	if helper.IsGenerator {
+		t.Errorf("helper should not be a generator")
+	}
+
+	stmt := fn.Body.Statements[2].(*ast.ExpressionStatement)
+	yield, ok := stmt.Expression.(*ast.YieldExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.YieldExpression. got=%T", stmt.Expression)
+	}
+	if yield.Value != nil {
+		t.Errorf("bare yield should have no value. got=%s", yield.Value)
+	}
+}
+
+func TestYieldOutsideFunction(t *testing.T) {
+	l := lexer.New("yield 1")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected parser error for yield outside function")
+	}
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[f(x) for x in xs if x > 0]", "[f(x) for x in xs if (x > 0)]"},
+		{"[(a, b) for a in xs for b in ys]", "[(a, b) for a in xs for b in ys]"},
+		{"{k: v for k, v in m}", "{k: v for (k, v) in m}"},
+		{"{x for x in xs}", "{x for x in xs}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("%q: stmt is not ast.ExpressionStatement. got=%T", tt.input, program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestSetAndMembershipPrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{1, 2, 3}", "{1, 2, 3}"},
+		{"{x,}", "{x}"},
+		{"a | b & c", "(a | (b & c))"},
+		{"a - b ^ c", "((a - b) ^ c)"},
+		{"x in a | b", "(x in (a | b))"},
+		{"x not in s == True", "((x not in s) == True)"},
+		{"not x in s", "(not (x in s))"},
+		{"!x in s", "((!x) in s)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
 func checkParserErrors(t *testing.T, p *Parser) {
 	errors := p.Errors()
 	if len(errors) == 0 {
@@ -144,3 +400,167 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestSelectStatement(t *testing.T) {
+	input := `
+select:
+    case msg = inbox.recv():
+        print(msg)
+    case outbox.send(1 + 2):
+        print("sent")
+    case timeout 500ms:
+        print("slow")
+    case _:
+        print("idle")
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.SelectStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.SelectStatement. got=%T",
+			program.Statements[0])
+	}
+
+	expected := []struct {
+		kind   string
+		prefix string
+	}{
+		{ast.SelectRecv, "case msg = inbox.recv():"},
+		{ast.SelectSend, "case outbox.send((1 + 2)):"},
+		{ast.SelectTimeout, "case timeout 500ms:"},
+		{ast.SelectDefault, "case _:"},
+	}
+	if len(stmt.Cases) != len(expected) {
+		t.Fatalf("select has wrong number of cases. got=%d", len(stmt.Cases))
+	}
+	for i, want := range expected {
+		c := stmt.Cases[i]
+		if c.Kind != want.kind {
+			t.Errorf("cases[%d] kind wrong. expected=%q, got=%q", i, want.kind, c.Kind)
+		}
+		if got := c.String(); !strings.HasPrefix(got, want.prefix) {
+			t.Errorf("cases[%d] wrong. expected prefix %q, got=%q", i, want.prefix, got)
+		}
+	}
+}
+
+func TestSelectRejectsOtherCalls(t *testing.T) {
+	input := `
+select:
+    case x = ch.send(1):
+        print(x)
+`
+	p := New(lexer.New(input))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected a parse error for a send with a target")
+	}
+}
+
+func TestAwaitTimeout(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"await task", "await task"},
+		{"await task timeout 2s", "await task timeout 2s"},
+		{"await fetch(url) timeout limit", "await fetch(url) timeout limit"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestScheduleStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"every \"5m\":\n    cleanup()\n", "every \"5m\":\n\tcleanup()\n"},
+		{"every 30s:\n    ping()\n", "every 30s:\n\tping()\n"},
+		{"cron \"0 3 * * *\":\n    digest()\n", "cron \"0 3 * * *\":\n\tdigest()\n"},
+		// Outside a block header both words are plain identifiers
+		{"every = 5", "every = 5"},
+		{"cron(x)", "cron(x)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestAssertStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"assert x == 1", "assert (x == 1)"},
+		{"assert ok, \"not ok\"\n", "assert ok, \"not ok\""},
+		{"assert (a, b)", "assert (a, b)"},
+		// The module keeps working as an ordinary identifier
+		{"assert.equal(a, b)", "assert.equal(a, b)"},
+		{"assert = 5", "assert = 5"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs[1:3]", "xs[1:3]"},
+		{"xs[:n + 1]", "xs[:(n + 1)]"},
+		{"xs[2:]", "xs[2:]"},
+		{"xs[:]", "xs[:]"},
+		{"xs[i]", "xs[i]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("%q: stmt is not ast.ExpressionStatement. got=%T", tt.input, program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
@@ -11,11 +11,14 @@ const (
 	INDENT  = "INDENT"
 	DEDENT  = "DEDENT"
 	NEWLINE = "NEWLINE"
+	COMMENT = "COMMENT" // only from lexer.NewPreserving
 
 	// Identifiers & Literals
-	IDENT  = "IDENT"
-	INT    = "INT"
-	STRING = "STRING"
+	IDENT    = "IDENT"
+	INT      = "INT"
+	FLOAT    = "FLOAT" // 1.5, 2e10
+	STRING   = "STRING"
+	DURATION = "DURATION" // 500ms, 2s, 1h30m
 
 	// Operators
 	ASSIGN   = "="
@@ -25,6 +28,9 @@ const (
 	ASTERISK = "*"
 	SLASH    = "/"
 	PIPE     = "|>" // Pipeline operator
+	BIT_OR   = "|"
+	BIT_AND  = "&"
+	BIT_XOR  = "^"
 
 	LT     = "<"
 	GT     = ">"
@@ -65,6 +71,15 @@ const (
 	FROM   = "FROM"
 	TYPE   = "TYPE"
 	DEFER  = "DEFER"
+	MATCH  = "MATCH"
+	CASE   = "CASE"
+	SELECT = "SELECT"
+	YIELD  = "YIELD"
+	NOT    = "NOT"
+
+	// Scope declarations
+	GLOBAL   = "GLOBAL"
+	NONLOCAL = "NONLOCAL"
 
 	// Server
 	SERVICE = "SERVICE"
@@ -108,6 +123,11 @@ var keywords = map[string]TokenType{
 	"from":    FROM,
 	"type":    TYPE,
 	"defer":   DEFER,
+	"match":   MATCH,
+	"case":    CASE,
+	"select":  SELECT,
+	"yield":   YIELD,
+	"not":     NOT,
 	"service": SERVICE,
 	"on":      ON,
 	"get":     GET,
@@ -116,6 +136,18 @@ var keywords = map[string]TokenType{
 	"delete":  DELETE,
 	"ws":      WS,
 	"use":     USE,
+
+	"global":   GLOBAL,
+	"nonlocal": NONLOCAL,
+}
+
+// Keywords returns every reserved word, for editor and REPL completion.
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	return words
 }
 
 func LookupIdent(ident string) TokenType {